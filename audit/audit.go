@@ -0,0 +1,113 @@
+package audit
+
+// Records every destructive action deathnode takes as JSON lines, so it can be proved why each instance
+// was terminated
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/alanbover/deathnode/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionMark                        = "mark"
+	ActionUnmark                      = "unmark"
+	ActionRemoveInstanceProtection    = "removeInstanceProtection"
+	ActionSetMaintenance              = "setMaintenance"
+	ActionDeregisterFromLoadBalancers = "deregisterFromLoadBalancers"
+	ActionCompleteLifecycleAction     = "completeLifecycleAction"
+)
+
+var writeErrorsCounter = metrics.NewCounter("deathnode_audit_write_errors_total",
+	"Number of audit records that couldn't be written to an output")
+
+// Record is an action taken by deathnode. Records about a single instance carry the Mesos tasks running
+// on it, while maintenance calls carry all the hosts set in maintenance
+// hosts: map[hostname]ipAddress
+type Record struct {
+	Time             time.Time         `json:"time"`
+	Action           string            `json:"action"`
+	InstanceID       string            `json:"instanceId,omitempty"`
+	AutoscalingGroup string            `json:"autoscalingGroup,omitempty"`
+	RequestedBy      string            `json:"requestedBy,omitempty"`
+	Decision         *Decision         `json:"decision,omitempty"`
+	MesosTasks       []Task            `json:"mesosTasks,omitempty"`
+	InstanceIDs      []string          `json:"instanceIds,omitempty"`
+	Hosts            map[string]string `json:"hosts,omitempty"`
+	Error            string            `json:"error,omitempty"`
+	DryRun           bool              `json:"dryRun,omitempty"`
+	Version          string            `json:"version"`
+	Leader           string            `json:"leader"`
+}
+
+// Decision is how deathnode chose the instance to mark: the instances allowed by the constraint, and the
+// one picked by the recommender among them
+type Decision struct {
+	Constraint       string   `json:"constraint"`
+	AllowedInstances []string `json:"allowedInstances"`
+	Recommender      string   `json:"recommender"`
+}
+
+// Task is a Mesos task running on the instance when the action was taken. The framework name is only
+// known for the protected frameworks
+type Task struct {
+	Name        string `json:"name"`
+	FrameworkID string `json:"frameworkId"`
+	Framework   string `json:"framework,omitempty"`
+	Protected   bool   `json:"protected"`
+}
+
+// Output writes audit records somewhere. Outputs must be safe for concurrent use
+type Output interface {
+	// Write appends a record, encoded as a single line of JSON without the line break
+	Write(record []byte) error
+}
+
+// Logger writes the audit records to all it's outputs. A Logger without outputs discards them
+type Logger struct {
+	version string
+	leader  string
+	dryRun  bool
+	outputs []Output
+}
+
+// NewLogger returns a Logger that stamps every record with the deathnode version and the identity of the
+// leader replica. In dry run mode records are flagged, as the actions weren't executed
+func NewLogger(version, leader string, dryRun bool, outputs ...Output) *Logger {
+	return &Logger{
+		version: version,
+		leader:  leader,
+		dryRun:  dryRun,
+		outputs: outputs,
+	}
+}
+
+// Log writes the record to all the outputs. Errors are logged, as the action has already been taken
+func (l *Logger) Log(record *Record) {
+
+	if l == nil || len(l.outputs) == 0 {
+		return
+	}
+
+	record.Time = time.Now()
+	record.Version = l.version
+	record.Leader = l.leader
+	record.DryRun = l.dryRun
+
+	content, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("Unable to encode audit record: %v", err)
+		writeErrorsCounter.Inc()
+		return
+	}
+
+	for _, output := range l.outputs {
+		if err := output.Write(content); err != nil {
+			log.Errorf("Unable to write audit record %s: %v", content, err)
+			writeErrorsCounter.Inc()
+		}
+	}
+}
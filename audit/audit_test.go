@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogger(t *testing.T) {
+
+	Convey("When logging an audit record", t, func() {
+		buffer := &bytes.Buffer{}
+		logger := NewLogger("1.0.0", "replica-1", false, NewWriterOutput(buffer))
+		logger.Log(&Record{
+			Action:     ActionMark,
+			InstanceID: "i-34719eb8",
+			Decision: &Decision{
+				Constraint:       "noContraint",
+				AllowedInstances: []string{"i-34719eb8"},
+				Recommender:      "firstAvailableAgent",
+			},
+		})
+		logger.Log(&Record{Action: ActionCompleteLifecycleAction, InstanceID: "i-34719eb8"})
+
+		lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+		Convey("every record should be written as a line of JSON", func() {
+			So(len(lines), ShouldEqual, 2)
+			record := &Record{}
+			So(json.Unmarshal([]byte(lines[0]), record), ShouldBeNil)
+			So(record.Action, ShouldEqual, ActionMark)
+			So(record.Decision.Recommender, ShouldEqual, "firstAvailableAgent")
+		})
+		Convey("records should carry the time, version and leader", func() {
+			record := &Record{}
+			json.Unmarshal([]byte(lines[1]), record)
+			So(record.Time.IsZero(), ShouldBeFalse)
+			So(record.Version, ShouldEqual, "1.0.0")
+			So(record.Leader, ShouldEqual, "replica-1")
+			So(record.DryRun, ShouldBeFalse)
+		})
+	})
+
+	Convey("When logging without outputs, it should do nothing", t, func() {
+		NewLogger("1.0.0", "replica-1", false).Log(&Record{Action: ActionMark})
+		var logger *Logger
+		logger.Log(&Record{Action: ActionMark})
+	})
+}
+
+func TestFileOutput(t *testing.T) {
+
+	Convey("When writing records to a file", t, func() {
+		dir, _ := ioutil.TempDir("", "deathnode")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "audit.log")
+
+		for _, record := range []string{`{"action":"mark"}`, `{"action":"unmark"}`} {
+			output, err := NewFileOutput(path)
+			So(err, ShouldBeNil)
+			So(output.Write([]byte(record)), ShouldBeNil)
+			So(output.Close(), ShouldBeNil)
+		}
+
+		Convey("records should be appended to the existing ones", func() {
+			content, _ := ioutil.ReadFile(path)
+			So(string(content), ShouldEqual, "{\"action\":\"mark\"}\n{\"action\":\"unmark\"}\n")
+		})
+	})
+}
+
+func TestWebhookOutput(t *testing.T) {
+
+	Convey("When writing records to a webhook", t, func() {
+		statusCode := http.StatusOK
+		bodies := make(chan string, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			w.WriteHeader(statusCode)
+			bodies <- string(body)
+		}))
+		defer server.Close()
+		output := NewWebhookOutput(server.URL, time.Second)
+
+		Convey("the record should be queued and posted by Run", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go output.Run(ctx)
+
+			So(output.Write([]byte(`{"action":"mark"}`)), ShouldBeNil)
+			select {
+			case body := <-bodies:
+				So(body, ShouldEqual, `{"action":"mark"}`)
+			case <-time.After(5 * time.Second):
+				So("record not posted", ShouldBeEmpty)
+			}
+		})
+		Convey("it should fail if the webhook doesn't accept the record", func() {
+			statusCode = http.StatusInternalServerError
+			So(output.post(context.Background(), []byte(`{"action":"mark"}`)), ShouldNotBeNil)
+		})
+		Convey("records should be dropped and counted while the queue is full", func() {
+			dropped := droppedRecordsCounter.Value()
+			for i := 0; i < webhookQueueSize; i++ {
+				So(output.Write([]byte(`{"action":"mark"}`)), ShouldBeNil)
+			}
+			So(output.Write([]byte(`{"action":"unmark"}`)), ShouldNotBeNil)
+			So(droppedRecordsCounter.Value(), ShouldEqual, dropped+1)
+		})
+	})
+}
@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alanbover/deathnode/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// WriterOutput writes the records as JSON lines to an io.Writer, like os.Stdout
+type WriterOutput struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+// NewWriterOutput returns a WriterOutput for the writer
+func NewWriterOutput(writer io.Writer) *WriterOutput {
+	return &WriterOutput{
+		writer: writer,
+	}
+}
+
+// Write writes the record followed by a line break
+func (o *WriterOutput) Write(record []byte) error {
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	_, err := o.writer.Write(append(record, '\n'))
+	return err
+}
+
+// FileOutput appends the records as JSON lines to a file. Every record is synced to disk before
+// returning, so it's not lost if deathnode crashes
+type FileOutput struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewFileOutput opens the file in append mode, creating it if it doesn't exist
+func NewFileOutput(path string) (*FileOutput, error) {
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &FileOutput{
+		file: file,
+	}, nil
+}
+
+// Write appends the record followed by a line break
+func (o *FileOutput) Write(record []byte) error {
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if _, err := o.file.Write(append(record, '\n')); err != nil {
+		return err
+	}
+	return o.file.Sync()
+}
+
+// Close closes the file
+func (o *FileOutput) Close() error {
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.file.Close()
+}
+
+// webhookQueueSize is the number of records waiting to be posted before new ones are dropped
+const webhookQueueSize = 100
+
+var droppedRecordsCounter = metrics.NewCounter("deathnode_audit_dropped_records_total",
+	"Number of audit records dropped as the webhook queue was full")
+
+// WebhookOutput sends every record as the JSON body of a POST request. Records are queued and posted in
+// background by Run, so a slow webhook never blocks the actions being recorded
+type WebhookOutput struct {
+	url     string
+	client  *http.Client
+	records chan []byte
+}
+
+// NewWebhookOutput returns a WebhookOutput posting the records to url, failing if the request takes
+// longer than timeout
+func NewWebhookOutput(url string, timeout time.Duration) *WebhookOutput {
+	return &WebhookOutput{
+		url: url,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+		records: make(chan []byte, webhookQueueSize),
+	}
+}
+
+// Write queues the record. If the queue is full, as the webhook is failing, the record is dropped
+func (o *WebhookOutput) Write(record []byte) error {
+
+	select {
+	case o.records <- record:
+		return nil
+	default:
+		droppedRecordsCounter.Inc()
+		return errors.New("audit webhook queue full. Dropping record")
+	}
+}
+
+// Run posts the queued records until the context is cancelled. Records that can't be posted are logged
+func (o *WebhookOutput) Run(ctx context.Context) {
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case record := <-o.records:
+			if err := o.post(ctx, record); err != nil {
+				log.Errorf("Unable to post audit record %s: %v", record, err)
+				writeErrorsCounter.Inc()
+			}
+		}
+	}
+}
+
+// post sends the record. Responses without a 2xx status code are errors
+func (o *WebhookOutput) post(ctx context.Context, record []byte) error {
+
+	request, err := http.NewRequest(http.MethodPost, o.url, bytes.NewReader(record))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := o.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook returned %s", response.Status)
+	}
+	return nil
+}
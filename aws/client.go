@@ -7,6 +7,9 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	log "github.com/sirupsen/logrus"
 	"strings"
 )
 
@@ -19,45 +22,73 @@ const (
 
 // Client holds the AWS SDK objects for call AWS API
 type Client struct {
+	name        string
 	ec2         *ec2.EC2
 	autoscaling *autoscaling.AutoScaling
+	elb         *elb.ELB
+	elbv2       *elbv2.ELBV2
 }
 
 // ClientInterface implements a client with all required operations against AWS API
 type ClientInterface interface {
+	Name() string
 	DescribeInstanceByID(instanceID string) (*ec2.Instance, error)
 	DescribeInstancesByTag(tagKey string) ([]*ec2.Instance, error)
 	DescribeAGByName(autoscalingGroupName string) ([]*autoscaling.Group, error)
 	RemoveASGInstanceProtection(autoscalingGroupName *string, instanceIDs []*string) error
 	SetASGInstanceProtection(autoscalingGroupName *string, instanceIDs []*string) error
 	SetInstanceTag(key, value, instanceID string) error
+	RemoveInstanceTag(key, instanceID string) error
 	HasLifeCycleHook(autoscalingGroupName *string) (bool, error)
 	PutLifeCycleHook(autoscalingGroupName *string, heartbeatTimeout *int64) error
+	HasActiveInstanceRefresh(autoscalingGroupName *string) (bool, error)
 	CompleteLifecycleAction(autoscalingGroupName, instanceID *string) error
+	RecordLifecycleActionHeartbeat(autoscalingGroupName, instanceID *string) error
+	DeregisterInstanceFromLoadBalancers(loadBalancerNames, targetGroupARNs []*string, instanceID *string) error
+	IsInstanceDrainedFromLoadBalancers(loadBalancerNames, targetGroupARNs []*string, instanceID *string) (bool, error)
 }
 
-// NewClient returns a new aws.client
-func NewClient(accessKey, secretKey, region, iamRole, iamSession string) (*Client, error) {
-
-	session, err := newAwsSession(&sessionParameters{
-		accessKey:  accessKey,
-		secretKey:  secretKey,
-		region:     region,
-		iamRole:    iamRole,
-		iamSession: iamSession,
-	})
+// NewClient returns a new aws.client, failing if the credentials can't be used against AWS API
+func NewClient(config *ClientConfig) (*Client, error) {
 
+	session, err := newAwsSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create AWS session: %v", err)
+	}
 
+	identity, err := validateCredentials(session, config)
 	if err != nil {
-		fmt.Print("Error trying to create AWS session. ", err)
+		return nil, err
 	}
+	log.Infof("Connected to AWS region %s as %s", config.Region, identity)
 
 	return &Client{
-		ec2:         ec2.New(session),
-		autoscaling: autoscaling.New(session),
+		name:        connectionName(config),
+		ec2:         ec2.New(session, config.Endpoints.forService(config.Endpoints.EC2)),
+		autoscaling: autoscaling.New(session, config.Endpoints.forService(config.Endpoints.Autoscaling)),
+		elb:         elb.New(session, config.Endpoints.forService(config.Endpoints.ELB)),
+		elbv2:       elbv2.New(session, config.Endpoints.forService(config.Endpoints.ELB)),
 	}, nil
 }
 
+// connectionName identifies the connection by it's region, and the profile and role assumed, if any
+func connectionName(config *ClientConfig) string {
+
+	name := config.Region
+	if config.Profile != "" {
+		name += "/" + config.Profile
+	}
+	if config.IAMRole != "" {
+		name += "/" + config.IAMRole
+	}
+	return name
+}
+
+// Name returns the region, profile and role assumed by the connection, to tell connections apart in logs and metrics
+func (c *Client) Name() string {
+	return c.name
+}
+
 // CompleteLifecycleAction completes a lifecycle event for an instance pending to be deleted
 func (c* Client) CompleteLifecycleAction(autoscalingGroupName, instanceID *string) error {
 
@@ -72,6 +103,20 @@ func (c* Client) CompleteLifecycleAction(autoscalingGroupName, instanceID *strin
 	return err
 }
 
+// RecordLifecycleActionHeartbeat extends the timeout of the lifecycle event of an instance pending to be
+// deleted, so AWS doesn't terminate it while it's still being drained
+func (c *Client) RecordLifecycleActionHeartbeat(autoscalingGroupName, instanceID *string) error {
+
+	recordLifecycleActionHeartbeatInput := &autoscaling.RecordLifecycleActionHeartbeatInput{
+		AutoScalingGroupName: autoscalingGroupName,
+		InstanceId:           instanceID,
+		LifecycleHookName:    aws.String(lifecycleHookName),
+	}
+
+	_, err := c.autoscaling.RecordLifecycleActionHeartbeat(recordLifecycleActionHeartbeatInput)
+	return err
+}
+
 // HasLifeCycleHook checks if deathnode lifecyclehook is enabled for an autoscalingGroup
 func (c *Client) HasLifeCycleHook(autoscalingGroupName *string) (bool, error) {
 
@@ -270,3 +315,14 @@ func (c *Client) SetInstanceTag(key, value, instanceID string) error {
 
 	return err
 }
+
+// RemoveInstanceTag removes the tag with the key from an AWS instance
+func (c *Client) RemoveInstanceTag(key, instanceID string) error {
+
+	_, err := c.ec2.DeleteTags(&ec2.DeleteTagsInput{
+		Resources: []*string{aws.String(instanceID)},
+		Tags:      []*ec2.Tag{{Key: aws.String(key)}},
+	})
+
+	return err
+}
@@ -0,0 +1,149 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alanbover/deathnode/dryrun"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const dryRunService = "aws"
+
+// DryRunClient decorates a ClientInterface, executing the read only calls and recording the mutating
+// ones in a dry run plan instead of executing them
+type DryRunClient struct {
+	client ClientInterface
+	plan   *dryrun.Plan
+}
+
+// NewDryRunClient returns a new DryRunClient
+func NewDryRunClient(client ClientInterface, plan *dryrun.Plan) *DryRunClient {
+	return &DryRunClient{
+		client: client,
+		plan:   plan,
+	}
+}
+
+// DescribeInstanceByID calls the decorated client
+func (c *DryRunClient) DescribeInstanceByID(instanceID string) (*ec2.Instance, error) {
+	return c.client.DescribeInstanceByID(instanceID)
+}
+
+// Name returns the name of the decorated client
+func (c *DryRunClient) Name() string {
+	return c.client.Name()
+}
+
+// DescribeInstancesByTag calls the decorated client
+func (c *DryRunClient) DescribeInstancesByTag(tagKey string) ([]*ec2.Instance, error) {
+	return c.client.DescribeInstancesByTag(tagKey)
+}
+
+// DescribeAGByName calls the decorated client
+func (c *DryRunClient) DescribeAGByName(autoscalingGroupName string) ([]*autoscaling.Group, error) {
+	return c.client.DescribeAGByName(autoscalingGroupName)
+}
+
+// HasLifeCycleHook calls the decorated client
+func (c *DryRunClient) HasLifeCycleHook(autoscalingGroupName *string) (bool, error) {
+	return c.client.HasLifeCycleHook(autoscalingGroupName)
+}
+
+// HasActiveInstanceRefresh calls the decorated client
+func (c *DryRunClient) HasActiveInstanceRefresh(autoscalingGroupName *string) (bool, error) {
+	return c.client.HasActiveInstanceRefresh(autoscalingGroupName)
+}
+
+// IsInstanceDrainedFromLoadBalancers calls the decorated client
+func (c *DryRunClient) IsInstanceDrainedFromLoadBalancers(loadBalancerNames, targetGroupARNs []*string, instanceID *string) (bool, error) {
+	return c.client.IsInstanceDrainedFromLoadBalancers(loadBalancerNames, targetGroupARNs, instanceID)
+}
+
+// RemoveASGInstanceProtection records the call in the dry run plan
+func (c *DryRunClient) RemoveASGInstanceProtection(autoscalingGroupName *string, instanceIDs []*string) error {
+
+	c.plan.Record(dryRunService, "RemoveASGInstanceProtection", map[string]string{
+		"autoscalingGroup": aws.StringValue(autoscalingGroupName),
+		"instances":        joinStrings(instanceIDs),
+	})
+	return nil
+}
+
+// SetASGInstanceProtection records the call in the dry run plan
+func (c *DryRunClient) SetASGInstanceProtection(autoscalingGroupName *string, instanceIDs []*string) error {
+
+	c.plan.Record(dryRunService, "SetASGInstanceProtection", map[string]string{
+		"autoscalingGroup": aws.StringValue(autoscalingGroupName),
+		"instances":        joinStrings(instanceIDs),
+	})
+	return nil
+}
+
+// SetInstanceTag records the call in the dry run plan
+func (c *DryRunClient) SetInstanceTag(key, value, instanceID string) error {
+
+	c.plan.Record(dryRunService, "SetInstanceTag", map[string]string{
+		"key":      key,
+		"value":    value,
+		"instance": instanceID,
+	})
+	return nil
+}
+
+// RemoveInstanceTag records the call in the dry run plan
+func (c *DryRunClient) RemoveInstanceTag(key, instanceID string) error {
+
+	c.plan.Record(dryRunService, "RemoveInstanceTag", map[string]string{
+		"key":      key,
+		"instance": instanceID,
+	})
+	return nil
+}
+
+// PutLifeCycleHook records the call in the dry run plan
+func (c *DryRunClient) PutLifeCycleHook(autoscalingGroupName *string, heartbeatTimeout *int64) error {
+
+	c.plan.Record(dryRunService, "PutLifeCycleHook", map[string]string{
+		"autoscalingGroup": aws.StringValue(autoscalingGroupName),
+		"heartbeatTimeout": fmt.Sprintf("%d", aws.Int64Value(heartbeatTimeout)),
+	})
+	return nil
+}
+
+// CompleteLifecycleAction records the call in the dry run plan
+func (c *DryRunClient) CompleteLifecycleAction(autoscalingGroupName, instanceID *string) error {
+
+	c.plan.Record(dryRunService, "CompleteLifecycleAction", map[string]string{
+		"autoscalingGroup": aws.StringValue(autoscalingGroupName),
+		"instance":         aws.StringValue(instanceID),
+	})
+	return nil
+}
+
+// RecordLifecycleActionHeartbeat records the call in the dry run plan
+func (c *DryRunClient) RecordLifecycleActionHeartbeat(autoscalingGroupName, instanceID *string) error {
+
+	c.plan.Record(dryRunService, "RecordLifecycleActionHeartbeat", map[string]string{
+		"autoscalingGroup": aws.StringValue(autoscalingGroupName),
+		"instance":         aws.StringValue(instanceID),
+	})
+	return nil
+}
+
+// DeregisterInstanceFromLoadBalancers records the call in the dry run plan
+func (c *DryRunClient) DeregisterInstanceFromLoadBalancers(loadBalancerNames, targetGroupARNs []*string, instanceID *string) error {
+
+	c.plan.Record(dryRunService, "DeregisterInstanceFromLoadBalancers", map[string]string{
+		"loadBalancers": joinStrings(loadBalancerNames),
+		"targetGroups":  joinStrings(targetGroupARNs),
+		"instance":      aws.StringValue(instanceID),
+	})
+	return nil
+}
+
+func joinStrings(values []*string) string {
+	return strings.Join(aws.StringValueSlice(values), ",")
+}
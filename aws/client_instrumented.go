@@ -0,0 +1,140 @@
+package aws
+
+import (
+	"time"
+
+	"github.com/alanbover/deathnode/metrics"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const metricsService = "aws"
+
+// InstrumentedClient decorates a ClientInterface, recording the latency and errors of every call
+type InstrumentedClient struct {
+	client ClientInterface
+}
+
+// NewInstrumentedClient returns a new InstrumentedClient
+func NewInstrumentedClient(client ClientInterface) *InstrumentedClient {
+	return &InstrumentedClient{
+		client: client,
+	}
+}
+
+// DescribeInstanceByID calls the decorated client, recording metrics
+func (c *InstrumentedClient) DescribeInstanceByID(instanceID string) (*ec2.Instance, error) {
+	start := time.Now()
+	instance, err := c.client.DescribeInstanceByID(instanceID)
+	metrics.ObserveAPIRequest(metricsService, "DescribeInstanceByID", start, err)
+	return instance, err
+}
+
+// Name returns the name of the decorated client
+func (c *InstrumentedClient) Name() string {
+	return c.client.Name()
+}
+
+// DescribeInstancesByTag calls the decorated client, recording metrics
+func (c *InstrumentedClient) DescribeInstancesByTag(tagKey string) ([]*ec2.Instance, error) {
+	start := time.Now()
+	instances, err := c.client.DescribeInstancesByTag(tagKey)
+	metrics.ObserveAPIRequest(metricsService, "DescribeInstancesByTag", start, err)
+	return instances, err
+}
+
+// DescribeAGByName calls the decorated client, recording metrics
+func (c *InstrumentedClient) DescribeAGByName(autoscalingGroupName string) ([]*autoscaling.Group, error) {
+	start := time.Now()
+	autoscalingGroups, err := c.client.DescribeAGByName(autoscalingGroupName)
+	metrics.ObserveAPIRequest(metricsService, "DescribeAGByName", start, err)
+	return autoscalingGroups, err
+}
+
+// RemoveASGInstanceProtection calls the decorated client, recording metrics
+func (c *InstrumentedClient) RemoveASGInstanceProtection(autoscalingGroupName *string, instanceIDs []*string) error {
+	start := time.Now()
+	err := c.client.RemoveASGInstanceProtection(autoscalingGroupName, instanceIDs)
+	metrics.ObserveAPIRequest(metricsService, "RemoveASGInstanceProtection", start, err)
+	return err
+}
+
+// SetASGInstanceProtection calls the decorated client, recording metrics
+func (c *InstrumentedClient) SetASGInstanceProtection(autoscalingGroupName *string, instanceIDs []*string) error {
+	start := time.Now()
+	err := c.client.SetASGInstanceProtection(autoscalingGroupName, instanceIDs)
+	metrics.ObserveAPIRequest(metricsService, "SetASGInstanceProtection", start, err)
+	return err
+}
+
+// SetInstanceTag calls the decorated client, recording metrics
+func (c *InstrumentedClient) SetInstanceTag(key, value, instanceID string) error {
+	start := time.Now()
+	err := c.client.SetInstanceTag(key, value, instanceID)
+	metrics.ObserveAPIRequest(metricsService, "SetInstanceTag", start, err)
+	return err
+}
+
+// RemoveInstanceTag calls the decorated client, recording metrics
+func (c *InstrumentedClient) RemoveInstanceTag(key, instanceID string) error {
+	start := time.Now()
+	err := c.client.RemoveInstanceTag(key, instanceID)
+	metrics.ObserveAPIRequest(metricsService, "RemoveInstanceTag", start, err)
+	return err
+}
+
+// HasLifeCycleHook calls the decorated client, recording metrics
+func (c *InstrumentedClient) HasLifeCycleHook(autoscalingGroupName *string) (bool, error) {
+	start := time.Now()
+	hasLifeCycleHook, err := c.client.HasLifeCycleHook(autoscalingGroupName)
+	metrics.ObserveAPIRequest(metricsService, "HasLifeCycleHook", start, err)
+	return hasLifeCycleHook, err
+}
+
+// PutLifeCycleHook calls the decorated client, recording metrics
+func (c *InstrumentedClient) PutLifeCycleHook(autoscalingGroupName *string, heartbeatTimeout *int64) error {
+	start := time.Now()
+	err := c.client.PutLifeCycleHook(autoscalingGroupName, heartbeatTimeout)
+	metrics.ObserveAPIRequest(metricsService, "PutLifeCycleHook", start, err)
+	return err
+}
+
+// HasActiveInstanceRefresh calls the decorated client, recording metrics
+func (c *InstrumentedClient) HasActiveInstanceRefresh(autoscalingGroupName *string) (bool, error) {
+	start := time.Now()
+	hasActiveInstanceRefresh, err := c.client.HasActiveInstanceRefresh(autoscalingGroupName)
+	metrics.ObserveAPIRequest(metricsService, "HasActiveInstanceRefresh", start, err)
+	return hasActiveInstanceRefresh, err
+}
+
+// CompleteLifecycleAction calls the decorated client, recording metrics
+func (c *InstrumentedClient) CompleteLifecycleAction(autoscalingGroupName, instanceID *string) error {
+	start := time.Now()
+	err := c.client.CompleteLifecycleAction(autoscalingGroupName, instanceID)
+	metrics.ObserveAPIRequest(metricsService, "CompleteLifecycleAction", start, err)
+	return err
+}
+
+// RecordLifecycleActionHeartbeat calls the decorated client, recording metrics
+func (c *InstrumentedClient) RecordLifecycleActionHeartbeat(autoscalingGroupName, instanceID *string) error {
+	start := time.Now()
+	err := c.client.RecordLifecycleActionHeartbeat(autoscalingGroupName, instanceID)
+	metrics.ObserveAPIRequest(metricsService, "RecordLifecycleActionHeartbeat", start, err)
+	return err
+}
+
+// DeregisterInstanceFromLoadBalancers calls the decorated client, recording metrics
+func (c *InstrumentedClient) DeregisterInstanceFromLoadBalancers(loadBalancerNames, targetGroupARNs []*string, instanceID *string) error {
+	start := time.Now()
+	err := c.client.DeregisterInstanceFromLoadBalancers(loadBalancerNames, targetGroupARNs, instanceID)
+	metrics.ObserveAPIRequest(metricsService, "DeregisterInstanceFromLoadBalancers", start, err)
+	return err
+}
+
+// IsInstanceDrainedFromLoadBalancers calls the decorated client, recording metrics
+func (c *InstrumentedClient) IsInstanceDrainedFromLoadBalancers(loadBalancerNames, targetGroupARNs []*string, instanceID *string) (bool, error) {
+	start := time.Now()
+	drained, err := c.client.IsInstanceDrainedFromLoadBalancers(loadBalancerNames, targetGroupARNs, instanceID)
+	metrics.ObserveAPIRequest(metricsService, "IsInstanceDrainedFromLoadBalancers", start, err)
+	return drained, err
+}
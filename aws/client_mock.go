@@ -8,12 +8,14 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 // ConnectionMock is a aws mock client for testing purposes
 type ConnectionMock struct {
 	Records  map[string]*[]string
 	Requests map[string][][]string
+	mutex    sync.Mutex
 }
 
 // DescribeInstanceByID is a mock call for testing purposes
@@ -23,6 +25,11 @@ func (c *ConnectionMock) DescribeInstanceByID(instanceID string) (*ec2.Instance,
 	return mockResponse.(*ec2.Instance), nil
 }
 
+// Name is a mock call for testing purposes
+func (c *ConnectionMock) Name() string {
+	return "mock"
+}
+
 // DescribeInstancesByTag is a mock call for testing purposes
 func (c *ConnectionMock) DescribeInstancesByTag(tagKey string) ([]*ec2.Instance, error) {
 
@@ -69,9 +76,20 @@ func (c *ConnectionMock) SetInstanceTag(key, value, instanceID string) error {
 	return nil
 }
 
+// RemoveInstanceTag is a mock call for testing purposes
+func (c *ConnectionMock) RemoveInstanceTag(key, instanceID string) error {
+
+	inputValues := []string{key, instanceID}
+	c.addRequests("RemoveInstanceTag", inputValues)
+	return nil
+}
+
 // HasLifeCycleHook is a mock call for testing purposes
 func (c *ConnectionMock) HasLifeCycleHook(autoscalingGroupName *string) (bool, error) {
 
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	records, ok := c.Records["HasLifeCycleHook"]
 	if !ok {
 		return false, nil
@@ -82,6 +100,22 @@ func (c *ConnectionMock) HasLifeCycleHook(autoscalingGroupName *string) (bool, e
 	return hasLifeCycleHook == "true", nil
 }
 
+// HasActiveInstanceRefresh is a mock call for testing purposes
+func (c *ConnectionMock) HasActiveInstanceRefresh(autoscalingGroupName *string) (bool, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	records, ok := c.Records["HasActiveInstanceRefresh"]
+	if !ok || len(*records) == 0 {
+		return false, nil
+	}
+
+	hasActiveInstanceRefresh := (*records)[0]
+	*records = (*records)[1:]
+	return hasActiveInstanceRefresh == "true", nil
+}
+
 // PutLifeCycleHook is a mock call for testing purposes
 func (c *ConnectionMock) PutLifeCycleHook(autoscalingGroupName *string, heartbeatTimeout *int64) error {
 
@@ -96,8 +130,49 @@ func (c* ConnectionMock) CompleteLifecycleAction(autoscalingGroupName, instanceI
 	return nil
 }
 
+// RecordLifecycleActionHeartbeat is a mock call for testing purposes
+func (c *ConnectionMock) RecordLifecycleActionHeartbeat(autoscalingGroupName, instanceID *string) error {
+
+	c.addRequests("RecordLifecycleActionHeartbeat", []string{*autoscalingGroupName, *instanceID})
+	return nil
+}
+
+// DeregisterInstanceFromLoadBalancers is a mock call for testing purposes
+func (c *ConnectionMock) DeregisterInstanceFromLoadBalancers(loadBalancerNames, targetGroupARNs []*string, instanceID *string) error {
+
+	inputValues := []string{*instanceID}
+	for _, loadBalancerName := range loadBalancerNames {
+		inputValues = append(inputValues, *loadBalancerName)
+	}
+	for _, targetGroupARN := range targetGroupARNs {
+		inputValues = append(inputValues, *targetGroupARN)
+	}
+
+	c.addRequests("DeregisterInstanceFromLoadBalancers", inputValues)
+	return nil
+}
+
+// IsInstanceDrainedFromLoadBalancers is a mock call for testing purposes
+func (c *ConnectionMock) IsInstanceDrainedFromLoadBalancers(loadBalancerNames, targetGroupARNs []*string, instanceID *string) (bool, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	records, ok := c.Records["IsInstanceDrainedFromLoadBalancers"]
+	if !ok || len(*records) == 0 {
+		return true, nil
+	}
+
+	isDrained := (*records)[0]
+	*records = (*records)[1:]
+	return isDrained == "true", nil
+}
+
 func (c* ConnectionMock) addRequests(funcName string, parameters []string) {
 
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	if c.Requests == nil {
 		c.Requests = map[string][][]string{}
 	}
@@ -107,6 +182,9 @@ func (c* ConnectionMock) addRequests(funcName string, parameters []string) {
 
 func (c *ConnectionMock) replay(mockResponse interface{}, templateFileName string) (interface{}, error) {
 
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
 	records := c.getRecords(templateFileName)
 	currentRecord := (*records)[0]
 
@@ -0,0 +1,22 @@
+// +build !test
+
+package aws
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// NewCloudWatchPublisher returns a CloudWatchPublisher pushing the metrics to namespace every interval
+func NewCloudWatchPublisher(config *ClientConfig, namespace string, interval time.Duration) (*CloudWatchPublisher, error) {
+
+	session, err := newAwsSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create AWS session: %v", err)
+	}
+
+	client := cloudwatch.New(session, config.Endpoints.forService(config.Endpoints.CloudWatch))
+	return newCloudWatchPublisher(client, namespace, interval), nil
+}
@@ -0,0 +1,152 @@
+package aws
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alanbover/deathnode/metrics"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxMetricDataPerRequest is the maximum number of datapoints PutMetricData accepts in a request
+const maxMetricDataPerRequest = 20
+
+var cloudWatchDatapointsCounter = metrics.NewCounter("deathnode_cloudwatch_datapoints_total",
+	"Number of datapoints pushed to CloudWatch by result", "result")
+
+// metricDataPutter is the CloudWatch API used by the CloudWatchPublisher
+type metricDataPutter interface {
+	PutMetricData(input *cloudwatch.PutMetricDataInput) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+// CloudWatchPublisher pushes metrics to CloudWatch. The values recorded are aggregated in a statistic
+// set for every metric and dimensions, and pushed every interval with as few PutMetricData requests as
+// possible
+type CloudWatchPublisher struct {
+	mutex      sync.Mutex
+	cloudwatch metricDataPutter
+	namespace  string
+	interval   time.Duration
+	datapoints map[string]*cloudwatch.MetricDatum
+}
+
+func newCloudWatchPublisher(client metricDataPutter, namespace string, interval time.Duration) *CloudWatchPublisher {
+	return &CloudWatchPublisher{
+		cloudwatch: client,
+		namespace:  namespace,
+		interval:   interval,
+		datapoints: map[string]*cloudwatch.MetricDatum{},
+	}
+}
+
+// Record adds a value of the metric, to be pushed on the next interval
+// dimensions: map[dimensionName]value
+func (p *CloudWatchPublisher) Record(name, unit string, dimensions map[string]string, value float64) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key := datapointKey(name, dimensions)
+	datapoint, ok := p.datapoints[key]
+	if !ok {
+		datapoint = &cloudwatch.MetricDatum{
+			MetricName: aws.String(name),
+			Unit:       aws.String(unit),
+			StatisticValues: &cloudwatch.StatisticSet{
+				Maximum:     aws.Float64(value),
+				Minimum:     aws.Float64(value),
+				SampleCount: aws.Float64(0),
+				Sum:         aws.Float64(0),
+			},
+		}
+		for _, dimensionName := range sortedDimensionNames(dimensions) {
+			datapoint.Dimensions = append(datapoint.Dimensions, &cloudwatch.Dimension{
+				Name:  aws.String(dimensionName),
+				Value: aws.String(dimensions[dimensionName]),
+			})
+		}
+		p.datapoints[key] = datapoint
+	}
+
+	statistics := datapoint.StatisticValues
+	if value > *statistics.Maximum {
+		statistics.Maximum = aws.Float64(value)
+	}
+	if value < *statistics.Minimum {
+		statistics.Minimum = aws.Float64(value)
+	}
+	statistics.SampleCount = aws.Float64(*statistics.SampleCount + 1)
+	statistics.Sum = aws.Float64(*statistics.Sum + value)
+}
+
+// Run pushes the metrics recorded every interval until the context is cancelled, pushing the pending
+// ones before returning
+func (p *CloudWatchPublisher) Run(ctx context.Context) {
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			p.Flush()
+			return
+		case <-ticker.C:
+			p.Flush()
+		}
+	}
+}
+
+// Flush pushes the metrics recorded since the last flush. Datapoints that can't be pushed are dropped,
+// as newer ones will be recorded
+func (p *CloudWatchPublisher) Flush() {
+
+	p.mutex.Lock()
+	datapoints := make([]*cloudwatch.MetricDatum, 0, len(p.datapoints))
+	for _, datapoint := range p.datapoints {
+		datapoint.Timestamp = aws.Time(time.Now())
+		datapoints = append(datapoints, datapoint)
+	}
+	p.datapoints = map[string]*cloudwatch.MetricDatum{}
+	p.mutex.Unlock()
+
+	for start := 0; start < len(datapoints); start += maxMetricDataPerRequest {
+		end := start + maxMetricDataPerRequest
+		if end > len(datapoints) {
+			end = len(datapoints)
+		}
+		_, err := p.cloudwatch.PutMetricData(&cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(p.namespace),
+			MetricData: datapoints[start:end],
+		})
+		if err != nil {
+			log.Errorf("Unable to push %d datapoints to CloudWatch: %v", end-start, err)
+			cloudWatchDatapointsCounter.Add(float64(end-start), "failed")
+			continue
+		}
+		cloudWatchDatapointsCounter.Add(float64(end-start), "sent")
+	}
+}
+
+func datapointKey(name string, dimensions map[string]string) string {
+
+	parts := []string{name}
+	for _, dimensionName := range sortedDimensionNames(dimensions) {
+		parts = append(parts, dimensionName+"="+dimensions[dimensionName])
+	}
+	return strings.Join(parts, "\xff")
+}
+
+func sortedDimensionNames(dimensions map[string]string) []string {
+
+	names := []string{}
+	for name := range dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,87 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// metricDataPutterMock records the PutMetricData requests, failing the ones listed in failures
+type metricDataPutterMock struct {
+	requests []*cloudwatch.PutMetricDataInput
+	failures map[int]bool
+}
+
+func (m *metricDataPutterMock) PutMetricData(input *cloudwatch.PutMetricDataInput) (*cloudwatch.PutMetricDataOutput, error) {
+
+	m.requests = append(m.requests, input)
+	if m.failures[len(m.requests)] {
+		return nil, errors.New("cloudwatch unavailable")
+	}
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func TestCloudWatchPublisher(t *testing.T) {
+
+	Convey("When pushing metrics to CloudWatch", t, func() {
+		client := &metricDataPutterMock{}
+		publisher := newCloudWatchPublisher(client, "deathnode", time.Minute)
+
+		Convey("the values of a metric and dimensions should be aggregated in a statistic set", func() {
+			dimensions := map[string]string{"AutoscalingGroup": "asg1", "Cluster": "mesos"}
+			for _, value := range []float64{3, 1, 5} {
+				publisher.Record("DrainingInstances", "Count", dimensions, value)
+			}
+			publisher.Record("DrainingInstances", "Count", map[string]string{"AutoscalingGroup": "asg2"}, 7)
+			publisher.Flush()
+
+			So(client.requests, ShouldHaveLength, 1)
+			So(*client.requests[0].Namespace, ShouldEqual, "deathnode")
+			So(client.requests[0].MetricData, ShouldHaveLength, 2)
+			for _, datapoint := range client.requests[0].MetricData {
+				if *datapoint.Dimensions[0].Value != "asg1" {
+					continue
+				}
+				So(*datapoint.Dimensions[1].Name, ShouldEqual, "Cluster")
+				So(*datapoint.StatisticValues.SampleCount, ShouldEqual, 3)
+				So(*datapoint.StatisticValues.Sum, ShouldEqual, 9)
+				So(*datapoint.StatisticValues.Minimum, ShouldEqual, 1)
+				So(*datapoint.StatisticValues.Maximum, ShouldEqual, 5)
+				So(datapoint.Timestamp, ShouldNotBeNil)
+			}
+		})
+		Convey("more than 20 datapoints should be pushed in requests of up to 20", func() {
+			for i := 0; i < 45; i++ {
+				publisher.Record("DrainingInstances", "Count", map[string]string{"AutoscalingGroup": fmt.Sprintf("asg%d", i)}, 1)
+			}
+			publisher.Flush()
+
+			So(client.requests, ShouldHaveLength, 3)
+			So(client.requests[0].MetricData, ShouldHaveLength, maxMetricDataPerRequest)
+			So(client.requests[1].MetricData, ShouldHaveLength, maxMetricDataPerRequest)
+			So(client.requests[2].MetricData, ShouldHaveLength, 5)
+
+			Convey("and the datapoints pushed should be forgotten", func() {
+				publisher.Flush()
+				So(client.requests, ShouldHaveLength, 3)
+			})
+		})
+		Convey("a failed request should not stop the next ones", func() {
+			client.failures = map[int]bool{1: true}
+			sent := cloudWatchDatapointsCounter.Value("sent")
+			failed := cloudWatchDatapointsCounter.Value("failed")
+			for i := 0; i < 25; i++ {
+				publisher.Record("DrainingInstances", "Count", map[string]string{"AutoscalingGroup": fmt.Sprintf("asg%d", i)}, 1)
+			}
+			publisher.Flush()
+
+			So(client.requests, ShouldHaveLength, 2)
+			So(cloudWatchDatapointsCounter.Value("failed"), ShouldEqual, failed+maxMetricDataPerRequest)
+			So(cloudWatchDatapointsCounter.Value("sent"), ShouldEqual, sent+5)
+		})
+	})
+}
@@ -0,0 +1,132 @@
+// +build !test
+
+package aws
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const conditionalCheckFailed = "ConditionalCheckFailedException"
+
+// DynamoDBLock is a lease stored as an item of a DynamoDB table, updated with conditional writes.
+// The table must have a string hash key named LockName. Expiration times are compared using the
+// clock of the replicas, so they should be kept in sync
+type DynamoDBLock struct {
+	dynamodb *dynamodb.DynamoDB
+	table    string
+	name     string
+}
+
+// NewDynamoDBLock returns a DynamoDBLock stored in the item name of table
+func NewDynamoDBLock(config *ClientConfig, table, name string) (*DynamoDBLock, error) {
+
+	session, err := newAwsSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create AWS session: %v", err)
+	}
+
+	return &DynamoDBLock{
+		dynamodb: dynamodb.New(session, config.Endpoints.forService(config.Endpoints.DynamoDB)),
+		table:    table,
+		name:     name,
+	}, nil
+}
+
+// TryAcquire acquires or renews the lease, if it doesn't exist, it's expired or it's already held by
+// the holder. The fencing token is increased when the lease is acquired by a different holder
+func (l *DynamoDBLock) TryAcquire(holder string, leaseDuration time.Duration) (int64, bool, error) {
+
+	now := time.Now()
+	expires := toMillis(now.Add(leaseDuration))
+
+	// Renew the lease, if it's already held by the holder
+	output, err := l.dynamodb.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(l.table),
+		Key:                 l.key(),
+		UpdateExpression:    aws.String("SET Expires = :expires"),
+		ConditionExpression: aws.String("Holder = :holder"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder":  {S: aws.String(holder)},
+			":expires": {N: aws.String(expires)},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if err == nil {
+		token, err := fencingToken(output.Attributes)
+		return token, err == nil, err
+	}
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != conditionalCheckFailed {
+		return 0, false, err
+	}
+
+	// Acquire the lease, if it doesn't exist or it's expired
+	output, err = l.dynamodb.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(l.table),
+		Key:                 l.key(),
+		UpdateExpression:    aws.String("SET Holder = :holder, Expires = :expires ADD FencingToken :one"),
+		ConditionExpression: aws.String("attribute_not_exists(LockName) OR Expires < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder":  {S: aws.String(holder)},
+			":expires": {N: aws.String(expires)},
+			":now":     {N: aws.String(toMillis(now))},
+			":one":     {N: aws.String("1")},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == conditionalCheckFailed {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	token, err := fencingToken(output.Attributes)
+	return token, err == nil, err
+}
+
+// Release expires the lease, if it's held by the holder. The item is kept, so the fencing token keeps
+// growing
+func (l *DynamoDBLock) Release(holder string) error {
+
+	_, err := l.dynamodb.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(l.table),
+		Key:                 l.key(),
+		UpdateExpression:    aws.String("SET Expires = :expired"),
+		ConditionExpression: aws.String("Holder = :holder"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder":  {S: aws.String(holder)},
+			":expired": {N: aws.String("0")},
+		},
+	})
+
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == conditionalCheckFailed {
+		return nil
+	}
+	return err
+}
+
+func (l *DynamoDBLock) key() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"LockName": {S: aws.String(l.name)},
+	}
+}
+
+// fencingToken returns the fencing token of the lease item. Leases written before fencing tokens were
+// used don't have it, so it's 0 until they change of holder
+func fencingToken(item map[string]*dynamodb.AttributeValue) (int64, error) {
+
+	value, ok := item["FencingToken"]
+	if !ok || value.N == nil {
+		return 0, nil
+	}
+	return strconv.ParseInt(*value.N, 10, 64)
+}
+
+func toMillis(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
@@ -0,0 +1,96 @@
+// +build !test
+
+package aws
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/alanbover/deathnode/store"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DynamoDBStore saves the deathnode state as JSON in an item of a DynamoDB table. The table must have
+// a string hash key named StateName. With leader election, the item is fenced with the token of the
+// leadership lease, so a deposed leader can't overwrite the state saved by a newer one
+type DynamoDBStore struct {
+	dynamodb *dynamodb.DynamoDB
+	table    string
+	name     string
+	fencing  store.FencingTokenSource
+}
+
+// NewDynamoDBStore returns a DynamoDBStore saving the state in the item name of table
+func NewDynamoDBStore(config *ClientConfig, table, name string) (*DynamoDBStore, error) {
+
+	session, err := newAwsSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create AWS session: %v", err)
+	}
+
+	return &DynamoDBStore{
+		dynamodb: dynamodb.New(session, config.Endpoints.forService(config.Endpoints.DynamoDB)),
+		table:    table,
+		name:     name,
+	}, nil
+}
+
+// Load reads the state from the item, returning an empty one if it doesn't exist
+func (s *DynamoDBStore) Load() (*store.State, error) {
+
+	output, err := s.dynamodb.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"StateName": {S: aws.String(s.name)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	content, ok := output.Item["State"]
+	if !ok || content.S == nil {
+		return store.New(), nil
+	}
+	return store.Unmarshal([]byte(*content.S))
+}
+
+// SetFencing makes every save carry the fencing token of the leadership lease, failing with
+// store.ErrLeadershipLost if the state was saved with a greater one
+func (s *DynamoDBStore) SetFencing(fencing store.FencingTokenSource) {
+	s.fencing = fencing
+}
+
+// Save replaces the item with the state
+func (s *DynamoDBStore) Save(state *store.State) error {
+
+	content, err := store.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"StateName": {S: aws.String(s.name)},
+			"State":     {S: aws.String(string(content))},
+		},
+	}
+	if s.fencing != nil {
+		token := aws.String(strconv.FormatInt(s.fencing.FencingToken(), 10))
+		input.Item["FencingToken"] = &dynamodb.AttributeValue{N: token}
+		input.ConditionExpression = aws.String("attribute_not_exists(FencingToken) OR FencingToken <= :token")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":token": {N: token},
+		}
+	}
+
+	_, err = s.dynamodb.PutItem(input)
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == conditionalCheckFailed {
+		return store.ErrLeadershipLost
+	}
+	return err
+}
@@ -0,0 +1,301 @@
+// +build !test
+
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alanbover/deathnode/ecs"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	ecsapi "github.com/aws/aws-sdk-go/service/ecs"
+)
+
+// Maximum number of items the ECS API accepts in a request
+const (
+	maxDescribePerRequest          = 100
+	maxContainerInstancesPerUpdate = 10
+)
+
+const targetTypeContainerInstance = "container-instance"
+
+// ECSClient implements ecs.ClientInterface for a cluster with the AWS SDK. The private IP addresses of the
+// container instances are read from EC2
+type ECSClient struct {
+	ecs     *ecsapi.ECS
+	ec2     *ec2.EC2
+	cluster string
+}
+
+// updateContainerInstancesStateInput is the payload of the UpdateContainerInstancesState call. It, and the
+// PutAttributes and DeleteAttributes calls, are not implemented by the vendored SDK
+type updateContainerInstancesStateInput struct {
+	_                  struct{}  `type:"structure"`
+	Cluster            *string   `locationName:"cluster" type:"string"`
+	ContainerInstances []*string `locationName:"containerInstances" type:"list" required:"true"`
+	Status             *string   `locationName:"status" type:"string" required:"true"`
+}
+
+type updateContainerInstancesStateOutput struct {
+	_        struct{}          `type:"structure"`
+	Failures []*ecsapi.Failure `locationName:"failures" type:"list"`
+}
+
+// attributesInput is the payload of the PutAttributes and DeleteAttributes calls
+type attributesInput struct {
+	_          struct{}           `type:"structure"`
+	Cluster    *string            `locationName:"cluster" type:"string"`
+	Attributes []*targetAttribute `locationName:"attributes" type:"list" required:"true"`
+}
+
+type targetAttribute struct {
+	_          struct{} `type:"structure"`
+	Name       *string  `locationName:"name" type:"string" required:"true"`
+	Value      *string  `locationName:"value" type:"string"`
+	TargetType *string  `locationName:"targetType" type:"string"`
+	TargetID   *string  `locationName:"targetId" type:"string"`
+}
+
+type attributesOutput struct {
+	_          struct{}           `type:"structure"`
+	Attributes []*targetAttribute `locationName:"attributes" type:"list"`
+}
+
+// NewECSClient returns an ECSClient for the cluster
+func NewECSClient(config *ClientConfig, cluster string) (*ECSClient, error) {
+
+	session, err := newAwsSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create AWS session: %v", err)
+	}
+
+	return &ECSClient{
+		ecs:     ecsapi.New(session, config.Endpoints.forService(config.Endpoints.ECS)),
+		ec2:     ec2.New(session, config.Endpoints.forService(config.Endpoints.EC2)),
+		cluster: cluster,
+	}, nil
+}
+
+// GetContainerInstances returns the container instances of the cluster, with their private IP address
+func (c *ECSClient) GetContainerInstances() ([]ecs.ContainerInstance, error) {
+
+	arns := []*string{}
+	err := c.ecs.ListContainerInstancesPages(&ecsapi.ListContainerInstancesInput{
+		Cluster: aws.String(c.cluster),
+	}, func(page *ecsapi.ListContainerInstancesOutput, lastPage bool) bool {
+		arns = append(arns, page.ContainerInstanceArns...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	containerInstances := []ecs.ContainerInstance{}
+	for _, chunk := range chunkStrings(arns, maxDescribePerRequest) {
+		response, err := c.ecs.DescribeContainerInstances(&ecsapi.DescribeContainerInstancesInput{
+			Cluster:            aws.String(c.cluster),
+			ContainerInstances: chunk,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, containerInstance := range response.ContainerInstances {
+			attributes := map[string]string{}
+			for _, attribute := range containerInstance.Attributes {
+				attributes[aws.StringValue(attribute.Name)] = aws.StringValue(attribute.Value)
+			}
+			containerInstances = append(containerInstances, ecs.ContainerInstance{
+				ARN:               aws.StringValue(containerInstance.ContainerInstanceArn),
+				EC2InstanceID:     aws.StringValue(containerInstance.Ec2InstanceId),
+				Status:            aws.StringValue(containerInstance.Status),
+				RunningTasksCount: aws.Int64Value(containerInstance.RunningTasksCount),
+				Attributes:        attributes,
+			})
+		}
+	}
+
+	return containerInstances, c.setPrivateIPAddresses(containerInstances)
+}
+
+func (c *ECSClient) setPrivateIPAddresses(containerInstances []ecs.ContainerInstance) error {
+
+	instanceIDs := []*string{}
+	for _, containerInstance := range containerInstances {
+		instanceIDs = append(instanceIDs, aws.String(containerInstance.EC2InstanceID))
+	}
+
+	ipAddresses := map[string]string{}
+	for _, chunk := range chunkStrings(instanceIDs, maxDescribePerRequest) {
+		response, err := c.ec2.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: chunk})
+		if err != nil {
+			return err
+		}
+		for _, reservation := range response.Reservations {
+			for _, instance := range reservation.Instances {
+				ipAddresses[aws.StringValue(instance.InstanceId)] = aws.StringValue(instance.PrivateIpAddress)
+			}
+		}
+	}
+
+	for i := range containerInstances {
+		containerInstances[i].PrivateIPAddress = ipAddresses[containerInstances[i].EC2InstanceID]
+	}
+	return nil
+}
+
+// GetTasks returns the tasks of the cluster, including the ones desired to be stopped, with the service they belong to if it's one
+// of the given services
+func (c *ECSClient) GetTasks(services []string) ([]ecs.Task, error) {
+
+	arns, err := c.listTasks(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	taskServices := map[string]string{}
+	for _, service := range services {
+		serviceARNs, err := c.listTasks(aws.String(service))
+		if err != nil {
+			return nil, err
+		}
+		for _, arn := range serviceARNs {
+			taskServices[aws.StringValue(arn)] = service
+		}
+	}
+
+	tasks := []ecs.Task{}
+	for _, chunk := range chunkStrings(arns, maxDescribePerRequest) {
+		response, err := c.ecs.DescribeTasks(&ecsapi.DescribeTasksInput{
+			Cluster: aws.String(c.cluster),
+			Tasks:   chunk,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range response.Tasks {
+			tasks = append(tasks, ecs.Task{
+				ARN:                  aws.StringValue(task.TaskArn),
+				ContainerInstanceARN: aws.StringValue(task.ContainerInstanceArn),
+				TaskDefinitionARN:    aws.StringValue(task.TaskDefinitionArn),
+				LastStatus:           aws.StringValue(task.LastStatus),
+				DesiredStatus:        aws.StringValue(task.DesiredStatus),
+				Service:              taskServices[aws.StringValue(task.TaskArn)],
+			})
+		}
+	}
+	return tasks, nil
+}
+
+// listTasks returns the ARNs of the tasks of the cluster, or of the service if it's not nil. ECS only lists
+// the tasks desired to be running by default, but the ones stopped by a drain keep running until their
+// stop timeout, so the tasks desired to be stopped are listed too
+func (c *ECSClient) listTasks(service *string) ([]*string, error) {
+
+	arns := []*string{}
+	for _, desiredStatus := range []string{ecsapi.DesiredStatusRunning, ecsapi.DesiredStatusStopped} {
+		err := c.ecs.ListTasksPages(&ecsapi.ListTasksInput{
+			Cluster:       aws.String(c.cluster),
+			ServiceName:   service,
+			DesiredStatus: aws.String(desiredStatus),
+		}, func(page *ecsapi.ListTasksOutput, lastPage bool) bool {
+			arns = append(arns, page.TaskArns...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return arns, nil
+}
+
+// DrainContainerInstances marks the container instances as drained by deathnode, and moves them to
+// DRAINING, so ECS replaces the tasks of their services in other container instances. The mark is set
+// first, so container instances drained by deathnode are never left unmarked
+func (c *ECSClient) DrainContainerInstances(containerInstanceARNs []string) error {
+
+	for _, chunk := range chunkStrings(aws.StringSlice(containerInstanceARNs), maxContainerInstancesPerUpdate) {
+		if err := c.send("PutAttributes", c.drainedAttributes(chunk), &attributesOutput{}); err != nil {
+			return err
+		}
+		if err := c.setContainerInstancesState(chunk, ecs.StatusDraining); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ActivateContainerInstances moves the container instances to ACTIVE, and removes their drained by
+// deathnode mark
+func (c *ECSClient) ActivateContainerInstances(containerInstanceARNs []string) error {
+
+	for _, chunk := range chunkStrings(aws.StringSlice(containerInstanceARNs), maxContainerInstancesPerUpdate) {
+		if err := c.setContainerInstancesState(chunk, ecs.StatusActive); err != nil {
+			return err
+		}
+		if err := c.send("DeleteAttributes", c.drainedAttributes(chunk), &attributesOutput{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ECSClient) drainedAttributes(containerInstanceARNs []*string) *attributesInput {
+
+	input := &attributesInput{Cluster: aws.String(c.cluster)}
+	for _, arn := range containerInstanceARNs {
+		input.Attributes = append(input.Attributes, &targetAttribute{
+			Name:       aws.String(ecs.DrainedAttribute),
+			Value:      aws.String("true"),
+			TargetType: aws.String(targetTypeContainerInstance),
+			TargetID:   arn,
+		})
+	}
+	return input
+}
+
+func (c *ECSClient) setContainerInstancesState(containerInstanceARNs []*string, status string) error {
+
+	output := &updateContainerInstancesStateOutput{}
+	err := c.send("UpdateContainerInstancesState", &updateContainerInstancesStateInput{
+		Cluster:            aws.String(c.cluster),
+		ContainerInstances: containerInstanceARNs,
+		Status:             aws.String(status),
+	}, output)
+	if err != nil {
+		return err
+	}
+	if len(output.Failures) > 0 {
+		failures := []string{}
+		for _, failure := range output.Failures {
+			failures = append(failures, fmt.Sprintf("%s: %s", aws.StringValue(failure.Arn), aws.StringValue(failure.Reason)))
+		}
+		return fmt.Errorf("unable to set container instances %s: %s", status, strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// send calls an ECS operation not implemented by the vendored SDK
+func (c *ECSClient) send(operation string, input, output interface{}) error {
+
+	req := c.ecs.NewRequest(&request.Operation{
+		Name:       operation,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}, input, output)
+	return req.Send()
+}
+
+func chunkStrings(values []*string, size int) [][]*string {
+
+	chunks := [][]*string{}
+	for len(values) > size {
+		chunks = append(chunks, values[:size])
+		values = values[size:]
+	}
+	if len(values) > 0 {
+		chunks = append(chunks, values)
+	}
+	return chunks
+}
@@ -0,0 +1,73 @@
+// +build !test
+
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const opDescribeInstanceRefreshes = "DescribeInstanceRefreshes"
+
+// The vendored AWS SDK predates Instance Refresh, so the DescribeInstanceRefreshes operation and it's
+// payloads are defined here, following the same conventions as the SDK autoscaling service
+type describeInstanceRefreshesInput struct {
+	_ struct{} `type:"structure"`
+
+	AutoScalingGroupName *string `min:"1" type:"string" required:"true"`
+	NextToken            *string `type:"string"`
+}
+
+type describeInstanceRefreshesOutput struct {
+	_ struct{} `type:"structure"`
+
+	InstanceRefreshes []*instanceRefresh `type:"list"`
+	NextToken         *string            `type:"string"`
+}
+
+type instanceRefresh struct {
+	_ struct{} `type:"structure"`
+
+	InstanceRefreshID *string `locationName:"InstanceRefreshId" min:"1" type:"string"`
+	Status            *string `type:"string"`
+}
+
+// activeInstanceRefreshStatus holds the instance refresh states on which instances may be replaced
+var activeInstanceRefreshStatus = map[string]bool{
+	"Pending":    true,
+	"InProgress": true,
+	"Cancelling": true,
+}
+
+// HasActiveInstanceRefresh checks if an autoscalingGroup has an instance refresh replacing it's instances
+func (c *Client) HasActiveInstanceRefresh(autoscalingGroupName *string) (bool, error) {
+
+	op := &request.Operation{
+		Name:       opDescribeInstanceRefreshes,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+
+	input := &describeInstanceRefreshesInput{
+		AutoScalingGroupName: autoscalingGroupName,
+	}
+
+	for {
+		output := &describeInstanceRefreshesOutput{}
+		err := c.autoscaling.NewRequest(op, input, output).Send()
+		if err != nil {
+			return false, err
+		}
+
+		for _, refresh := range output.InstanceRefreshes {
+			if activeInstanceRefreshStatus[aws.StringValue(refresh.Status)] {
+				return true, nil
+			}
+		}
+
+		if output.NextToken == nil {
+			return false, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
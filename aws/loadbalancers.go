@@ -0,0 +1,98 @@
+// +build !test
+
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+const elbInvalidInstanceErrorCode = "InvalidInstance"
+
+// DeregisterInstanceFromLoadBalancers removes an instance from the classic load balancers and target groups given
+func (c *Client) DeregisterInstanceFromLoadBalancers(loadBalancerNames, targetGroupARNs []*string, instanceID *string) error {
+
+	for _, loadBalancerName := range loadBalancerNames {
+		_, err := c.elb.DeregisterInstancesFromLoadBalancer(&elb.DeregisterInstancesFromLoadBalancerInput{
+			LoadBalancerName: loadBalancerName,
+			Instances:        []*elb.Instance{{InstanceId: instanceID}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, targetGroupARN := range targetGroupARNs {
+		_, err := c.elbv2.DeregisterTargets(&elbv2.DeregisterTargetsInput{
+			TargetGroupArn: targetGroupARN,
+			Targets:        []*elbv2.TargetDescription{{Id: instanceID}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IsInstanceDrainedFromLoadBalancers returns true if connection draining has finished for an instance in all the
+// classic load balancers and target groups given
+func (c *Client) IsInstanceDrainedFromLoadBalancers(loadBalancerNames, targetGroupARNs []*string, instanceID *string) (bool, error) {
+
+	for _, loadBalancerName := range loadBalancerNames {
+		drained, err := c.isInstanceDrainedFromLoadBalancer(loadBalancerName, instanceID)
+		if err != nil || !drained {
+			return false, err
+		}
+	}
+
+	for _, targetGroupARN := range targetGroupARNs {
+		drained, err := c.isInstanceDrainedFromTargetGroup(targetGroupARN, instanceID)
+		if err != nil || !drained {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// isInstanceDrainedFromLoadBalancer returns true once the instance is no longer part of the classic load
+// balancer. It's reported OutOfService while connection draining is still in progress, so it's state is
+// not enough
+func (c *Client) isInstanceDrainedFromLoadBalancer(loadBalancerName, instanceID *string) (bool, error) {
+
+	_, err := c.elb.DescribeInstanceHealth(&elb.DescribeInstanceHealthInput{
+		LoadBalancerName: loadBalancerName,
+		Instances:        []*elb.Instance{{InstanceId: instanceID}},
+	})
+	if err != nil {
+		// Once connection draining finishes the instance is no longer part of the load balancer
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == elbInvalidInstanceErrorCode {
+			return true, nil
+		}
+		return false, err
+	}
+
+	return false, nil
+}
+
+func (c *Client) isInstanceDrainedFromTargetGroup(targetGroupARN, instanceID *string) (bool, error) {
+
+	response, err := c.elbv2.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: targetGroupARN,
+		Targets:        []*elbv2.TargetDescription{{Id: instanceID}},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, targetHealthDescription := range response.TargetHealthDescriptions {
+		if aws.StringValue(targetHealthDescription.TargetHealth.State) != elbv2.TargetHealthStateEnumUnused {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -3,55 +3,74 @@
 package aws
 
 import (
-	"errors"
+	"fmt"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
 )
 
-type sessionParameters struct {
-	accessKey  string
-	secretKey  string
-	region     string
-	iamRole    string
-	iamSession string
-}
+func newAwsSession(config *ClientConfig) (*session.Session, error) {
+	if err := validateClientConfig(config); err != nil {
+		return nil, err
+	}
 
-func newAwsSession(parameters *sessionParameters) (*session.Session, error) {
-	if parameters.region == "" {
-		return nil, errors.New("missing aws region (required)")
+	awsConfig := aws.Config{
+		Region:           aws.String(config.Region),
+		DisableSSL:       aws.Bool(config.Endpoints.DisableSSL),
+		S3ForcePathStyle: aws.Bool(config.Endpoints.ForcePathStyle),
 	}
 
-	sess := session.New(&aws.Config{Region: aws.String(parameters.region)})
+	if config.AccessKey != "" && config.SecretKey != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(config.AccessKey, config.SecretKey, "")
+	}
 
-	if parameters.accessKey != "" && parameters.secretKey != "" {
-		sess = session.New(&aws.Config{
-			Region:      aws.String(parameters.region),
-			Credentials: credentials.NewStaticCredentials(parameters.accessKey, parameters.secretKey, ""),
-		})
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsConfig,
+		Profile:           config.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if parameters.iamRole != "" {
-		creds := assumeRoleCredentials(sess, parameters.iamRole, parameters.iamSession)
-		sess.Config.Credentials = creds
+	stsClient := sts.New(sess, config.Endpoints.forService(config.Endpoints.STS))
+	if config.WebIdentityTokenFile != "" {
+		sess.Config.Credentials = webIdentityCredentials(stsClient, config)
+	} else if config.IAMRole != "" {
+		sess.Config.Credentials = assumeRoleCredentials(stsClient, config)
 	}
 
 	return sess, nil
 }
 
-func assumeRoleCredentials(sess *session.Session, iamRole, iamSession string) *credentials.Credentials {
+// validateCredentials checks that the session credentials are valid, returning the ARN of the identity used
+func validateCredentials(sess *session.Session, config *ClientConfig) (string, error) {
 
-	if iamSession == "" {
-		iamSession = "default"
+	stsClient := sts.New(sess, config.Endpoints.forService(config.Endpoints.STS))
+	response, err := stsClient.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("unable to validate AWS credentials: %v", err)
 	}
 
-	creds := stscreds.NewCredentials(sess, iamRole, func(o *stscreds.AssumeRoleProvider) {
-		o.Duration = time.Hour
-		o.ExpiryWindow = 5 * time.Minute
-		o.RoleSessionName = iamSession
+	return *response.Arn, nil
+}
+
+func assumeRoleCredentials(stsClient *sts.STS, config *ClientConfig) *credentials.Credentials {
+
+	return credentials.NewCredentials(assumeRoleProvider(stsClient, config))
+}
+
+func webIdentityCredentials(stsClient *sts.STS, config *ClientConfig) *credentials.Credentials {
+
+	return credentials.NewCredentials(&webIdentityRoleProvider{
+		client:          stsClient,
+		roleARN:         webIdentityRoleARN(config),
+		roleSessionName: sessionName(config),
+		tokenFile:       config.WebIdentityTokenFile,
+		duration:        sessionDuration(config),
+		expiryWindow:    5 * time.Minute,
 	})
-	return creds
 }
@@ -0,0 +1,221 @@
+package aws
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+)
+
+const defaultSessionDuration = time.Hour
+
+// tokenCodeTimeout bounds the wait for a token code on renewals, so an unanswered prompt doesn't block
+// deathnode for ever
+const tokenCodeTimeout = time.Minute
+
+// ClientConfig holds the parameters needed for create a session against AWS API
+// If no static keys are provided, credentials are resolved using the standard AWS credential chain
+// (environment, shared config/credentials files with the selected Profile, instance role)
+type ClientConfig struct {
+	AccessKey            string
+	SecretKey            string
+	Region               string
+	Profile              string
+	IAMRole              string
+	IAMSession           string
+	ExternalID           string
+	MFASerial            string
+	MFATokenProvider     TokenProvider
+	WebIdentityTokenFile string
+	SessionDuration      time.Duration
+	Endpoints            Endpoints
+}
+
+// TokenProvider returns the MFA token code used for assuming the role. It's called every time the
+// role session is renewed, as a token code can't be used twice
+type TokenProvider func() (string, error)
+
+// Endpoints allows to override the AWS API endpoints, so deathnode can run against a local AWS emulator
+// Default is the endpoint used for every service that doesn't have an explicit override
+type Endpoints struct {
+	Default        string
+	EC2            string
+	Autoscaling    string
+	ELB            string
+	STS            string
+	DynamoDB       string
+	SNS            string
+	CloudWatch     string
+	ECS            string
+	DisableSSL     bool
+	ForcePathStyle bool
+}
+
+// forService returns the aws config needed for a service client to use the overridden endpoint, if any
+func (e *Endpoints) forService(endpoint string) *aws.Config {
+
+	if endpoint == "" {
+		endpoint = e.Default
+	}
+
+	if endpoint == "" {
+		return &aws.Config{}
+	}
+
+	return &aws.Config{Endpoint: aws.String(endpoint)}
+}
+
+// validateClientConfig checks the session options before creating the session
+func validateClientConfig(config *ClientConfig) error {
+
+	if config.Region == "" {
+		return errors.New("missing aws region (required)")
+	}
+
+	if config.WebIdentityTokenFile != "" && webIdentityRoleARN(config) == "" {
+		return errors.New("an iamRole is required when using a web identity token file")
+	}
+
+	if config.MFASerial != "" {
+		if config.IAMRole == "" || config.WebIdentityTokenFile != "" {
+			return errors.New("an MFA device can only be used when assuming an iamRole")
+		}
+		if config.MFATokenProvider == nil {
+			return errors.New("an MFA token provider is required when using an MFA device")
+		}
+	}
+
+	return nil
+}
+
+// assumeRoleProvider returns the provider of the credentials of the assumed role. With an MFA device,
+// a new token code is asked for every time the role is assumed
+func assumeRoleProvider(client stscreds.AssumeRoler, config *ClientConfig) credentials.Provider {
+
+	provider := &stscreds.AssumeRoleProvider{
+		Client:          client,
+		RoleARN:         config.IAMRole,
+		RoleSessionName: sessionName(config),
+		Duration:        sessionDuration(config),
+		ExpiryWindow:    5 * time.Minute,
+	}
+	if config.ExternalID != "" {
+		provider.ExternalID = aws.String(config.ExternalID)
+	}
+	if config.MFASerial == "" {
+		return provider
+	}
+
+	provider.SerialNumber = aws.String(config.MFASerial)
+	return &mfaAssumeRoleProvider{AssumeRoleProvider: provider, tokenProvider: config.MFATokenProvider}
+}
+
+// mfaAssumeRoleProvider assumes a role with a fresh MFA token code on every retrieval
+type mfaAssumeRoleProvider struct {
+	*stscreds.AssumeRoleProvider
+	tokenProvider TokenProvider
+}
+
+// Retrieve asks for a new token code, and generates a new set of temporary credentials using STS AssumeRole
+func (p *mfaAssumeRoleProvider) Retrieve() (credentials.Value, error) {
+
+	tokenCode, err := p.tokenProvider()
+	if err != nil {
+		return credentials.Value{ProviderName: stscreds.ProviderName},
+			fmt.Errorf("unable to get the MFA token code: %v", err)
+	}
+
+	p.TokenCode = aws.String(tokenCode)
+	return p.AssumeRoleProvider.Retrieve()
+}
+
+// StdinTokenProvider returns a TokenProvider using the given token code for the first session, if any,
+// and asking for a new one on stdin every time the session is renewed. It fails if stdin is not a
+// terminal, as nobody would be there to type the token codes
+func StdinTokenProvider(tokenCode string) (TokenProvider, error) {
+
+	stat, err := os.Stdin.Stat()
+	if err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return nil, errors.New("stdin is not an interactive terminal, so no MFA token codes can be asked for")
+	}
+	return newReaderTokenProvider(tokenCode, os.Stdin, os.Stderr, tokenCodeTimeout), nil
+}
+
+// tokenCodeLine is the result of reading a token code from the input
+type tokenCodeLine struct {
+	line string
+	err  error
+}
+
+// newReaderTokenProvider returns a TokenProvider reading the token codes from in. A read not answered
+// within timeout fails, and the line read later is used for the next token code
+func newReaderTokenProvider(tokenCode string, in io.Reader, out io.Writer, timeout time.Duration) TokenProvider {
+
+	reader := bufio.NewReader(in)
+	var pending chan tokenCodeLine
+	return func() (string, error) {
+
+		if tokenCode != "" {
+			code := tokenCode
+			tokenCode = ""
+			return code, nil
+		}
+
+		if pending == nil {
+			fmt.Fprint(out, "Assume Role MFA token code: ")
+			pending = make(chan tokenCodeLine, 1)
+			go func(lines chan<- tokenCodeLine) {
+				line, err := reader.ReadString('\n')
+				lines <- tokenCodeLine{line: line, err: err}
+			}(pending)
+		}
+
+		select {
+		case read := <-pending:
+			pending = nil
+			code := strings.TrimSpace(read.line)
+			if code == "" {
+				if read.err == nil {
+					read.err = errors.New("empty token code")
+				}
+				return "", read.err
+			}
+			return code, nil
+		case <-time.After(timeout):
+			return "", fmt.Errorf("no token code entered in %s", timeout)
+		}
+	}
+}
+
+// webIdentityRoleARN returns the role to assume with a web identity token, defaulting to the
+// one provided through AWS_ROLE_ARN environment variable
+func webIdentityRoleARN(config *ClientConfig) string {
+
+	if config.IAMRole == "" {
+		return os.Getenv("AWS_ROLE_ARN")
+	}
+	return config.IAMRole
+}
+
+func sessionName(config *ClientConfig) string {
+
+	if config.IAMSession == "" {
+		return "default"
+	}
+	return config.IAMSession
+}
+
+func sessionDuration(config *ClientConfig) time.Duration {
+
+	if config.SessionDuration == 0 {
+		return defaultSessionDuration
+	}
+	return config.SessionDuration
+}
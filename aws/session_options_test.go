@@ -0,0 +1,158 @@
+package aws
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/sts"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// assumeRolerMock records the AssumeRole requests, returning credentials already expired
+type assumeRolerMock struct {
+	requests []*sts.AssumeRoleInput
+}
+
+func (a *assumeRolerMock) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+
+	a.requests = append(a.requests, input)
+	return &sts.AssumeRoleOutput{Credentials: &sts.Credentials{
+		AccessKeyId:     aws.String("accessKey"),
+		SecretAccessKey: aws.String("secretKey"),
+		SessionToken:    aws.String("sessionToken"),
+		Expiration:      aws.Time(time.Now()),
+	}}, nil
+}
+
+func TestValidateClientConfig(t *testing.T) {
+
+	Convey("When validating the session options", t, func() {
+		tokenProvider := func() (string, error) { return "123456", nil }
+
+		Convey("the region is required", func() {
+			So(validateClientConfig(&ClientConfig{}), ShouldNotBeNil)
+			So(validateClientConfig(&ClientConfig{Region: "eu-west-1"}), ShouldBeNil)
+		})
+		Convey("a web identity token file requires a role", func() {
+			config := &ClientConfig{Region: "eu-west-1", WebIdentityTokenFile: "/var/run/token"}
+			os.Unsetenv("AWS_ROLE_ARN")
+			So(validateClientConfig(config), ShouldNotBeNil)
+
+			os.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/env")
+			defer os.Unsetenv("AWS_ROLE_ARN")
+			So(validateClientConfig(config), ShouldBeNil)
+			So(webIdentityRoleARN(config), ShouldEqual, "arn:aws:iam::123456789012:role/env")
+		})
+		Convey("an MFA device requires a role to assume and a token provider", func() {
+			config := &ClientConfig{Region: "eu-west-1", MFASerial: "arn:aws:iam::123456789012:mfa/user"}
+			So(validateClientConfig(config), ShouldNotBeNil)
+
+			config.IAMRole = "arn:aws:iam::123456789012:role/deathnode"
+			So(validateClientConfig(config), ShouldNotBeNil)
+
+			config.MFATokenProvider = tokenProvider
+			So(validateClientConfig(config), ShouldBeNil)
+
+			config.WebIdentityTokenFile = "/var/run/token"
+			So(validateClientConfig(config), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestAssumeRoleProvider(t *testing.T) {
+
+	Convey("When assuming a role", t, func() {
+		client := &assumeRolerMock{}
+		config := &ClientConfig{
+			Region:     "eu-west-1",
+			IAMRole:    "arn:aws:iam::123456789012:role/deathnode",
+			ExternalID: "external",
+		}
+
+		Convey("the session name and duration should default if not set", func() {
+			creds := credentials.NewCredentials(assumeRoleProvider(client, config))
+			_, err := creds.Get()
+			So(err, ShouldBeNil)
+			So(*client.requests[0].RoleSessionName, ShouldEqual, "default")
+			So(*client.requests[0].DurationSeconds, ShouldEqual, int64(time.Hour/time.Second))
+			So(*client.requests[0].ExternalId, ShouldEqual, "external")
+			So(client.requests[0].TokenCode, ShouldBeNil)
+		})
+		Convey("the session name and duration should be the ones provided", func() {
+			config.IAMSession = "deathnode"
+			config.SessionDuration = 15 * time.Minute
+			creds := credentials.NewCredentials(assumeRoleProvider(client, config))
+			_, err := creds.Get()
+			So(err, ShouldBeNil)
+			So(*client.requests[0].RoleSessionName, ShouldEqual, "deathnode")
+			So(*client.requests[0].DurationSeconds, ShouldEqual, int64(15*time.Minute/time.Second))
+		})
+		Convey("with an MFA device, every renewal should use a new token code", func() {
+			config.MFASerial = "arn:aws:iam::123456789012:mfa/user"
+			config.MFATokenProvider = newReaderTokenProvider("111111", strings.NewReader("222222\n"), ioutil.Discard, time.Second)
+			creds := credentials.NewCredentials(assumeRoleProvider(client, config))
+
+			_, err := creds.Get()
+			So(err, ShouldBeNil)
+			_, err = creds.Get()
+			So(err, ShouldBeNil)
+			So(client.requests, ShouldHaveLength, 2)
+			So(*client.requests[0].SerialNumber, ShouldEqual, "arn:aws:iam::123456789012:mfa/user")
+			So(*client.requests[0].TokenCode, ShouldEqual, "111111")
+			So(*client.requests[1].TokenCode, ShouldEqual, "222222")
+
+			Convey("and fail without calling AWS if no token code can be obtained", func() {
+				_, err = creds.Get()
+				So(err, ShouldNotBeNil)
+				So(client.requests, ShouldHaveLength, 2)
+			})
+		})
+		Convey("with an MFA device, token provider errors should be returned", func() {
+			config.MFASerial = "arn:aws:iam::123456789012:mfa/user"
+			config.MFATokenProvider = func() (string, error) { return "", errors.New("no terminal") }
+			_, err := credentials.NewCredentials(assumeRoleProvider(client, config)).Get()
+			So(err.Error(), ShouldContainSubstring, "no terminal")
+			So(client.requests, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestReaderTokenProvider(t *testing.T) {
+
+	Convey("When asking for MFA token codes", t, func() {
+		Convey("without an initial token code, it should be read from the input", func() {
+			var prompt strings.Builder
+			tokenProvider := newReaderTokenProvider("", strings.NewReader(" 123456 \n\n"), &prompt, time.Second)
+			code, err := tokenProvider()
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, "123456")
+			So(prompt.String(), ShouldContainSubstring, "MFA token code")
+
+			Convey("and empty lines should fail", func() {
+				_, err = tokenProvider()
+				So(err, ShouldNotBeNil)
+			})
+		})
+		Convey("an unanswered prompt should fail after the timeout", func() {
+			in, input := io.Pipe()
+			defer input.Close()
+			tokenProvider := newReaderTokenProvider("", in, ioutil.Discard, 10*time.Millisecond)
+			_, err := tokenProvider()
+			So(err.Error(), ShouldContainSubstring, "no token code entered")
+
+			Convey("and the code entered later should be used for the next one", func() {
+				input.Write([]byte("654321\n"))
+				code, err := tokenProvider()
+				So(err, ShouldBeNil)
+				So(code, ShouldEqual, "654321")
+			})
+		})
+	})
+}
@@ -0,0 +1,90 @@
+// +build !test
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alanbover/deathnode/notifier"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// Drain events published to SNS, keyed by the notifier event they are published for
+var snsEventTypes = map[string]string{
+	notifier.EventDrainStarted:         "instance-marked",
+	notifier.EventMaintenanceScheduled: "maintenance-scheduled",
+	notifier.EventDrainComplete:        "drain-complete",
+	notifier.EventLifecycleCompleted:   "lifecycle-completed",
+	notifier.EventDrainTimeout:         "drain-timeout",
+}
+
+// SNSPublisher publishes the drain events to an SNS topic, as JSON messages. The event type is also
+// sent in the eventType message attribute, so subscribers can filter them
+type SNSPublisher struct {
+	sns      *sns.SNS
+	topicARN string
+}
+
+// snsMessage is the event published, with the SNS event type replacing the notifier one
+type snsMessage struct {
+	*notifier.Event
+	Type string `json:"type"`
+}
+
+// NewSNSPublisher returns an SNSPublisher publishing to the topic
+func NewSNSPublisher(config *ClientConfig, topicARN string) (*SNSPublisher, error) {
+
+	session, err := newAwsSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create AWS session: %v", err)
+	}
+
+	return &SNSPublisher{
+		sns:      sns.New(session, config.Endpoints.forService(config.Endpoints.SNS)),
+		topicARN: topicARN,
+	}, nil
+}
+
+// SNSEventTypes returns the notifier events published to SNS
+func SNSEventTypes() []string {
+
+	eventTypes := []string{}
+	for eventType := range snsEventTypes {
+		eventTypes = append(eventTypes, eventType)
+	}
+	return eventTypes
+}
+
+// Send publishes the event. Events not published to SNS are ignored
+func (p *SNSPublisher) Send(ctx context.Context, event *notifier.Event) error {
+
+	eventType, ok := snsEventTypes[event.Type]
+	if !ok {
+		return nil
+	}
+
+	message, err := json.Marshal(&snsMessage{Event: event, Type: eventType})
+	if err != nil {
+		return err
+	}
+
+	_, err = p.sns.Publish(&sns.PublishInput{
+		TopicArn: aws.String(p.topicARN),
+		Subject:  aws.String(fmt.Sprintf("deathnode %s %s", eventType, event.InstanceID)),
+		Message:  aws.String(string(message)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"eventType": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(eventType),
+			},
+			"autoscalingGroup": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(event.AutoscalingGroup),
+			},
+		},
+	})
+	return err
+}
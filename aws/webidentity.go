@@ -0,0 +1,58 @@
+// +build !test
+
+package aws
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const webIdentityProviderName = "WebIdentityRoleProvider"
+
+// webIdentityRoleProvider retrieves credentials assuming a role with a web identity token (ex: OIDC tokens
+// projected by Kubernetes). The token file is read on every retrieval, as it's rotated by the issuer
+type webIdentityRoleProvider struct {
+	credentials.Expiry
+
+	client          *sts.STS
+	roleARN         string
+	roleSessionName string
+	tokenFile       string
+	duration        time.Duration
+	expiryWindow    time.Duration
+}
+
+// Retrieve generates a new set of temporary credentials using STS AssumeRoleWithWebIdentity
+func (p *webIdentityRoleProvider) Retrieve() (credentials.Value, error) {
+
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials.Value{ProviderName: webIdentityProviderName},
+			fmt.Errorf("unable to read web identity token file %s: %v", p.tokenFile, err)
+	}
+
+	response, err := p.client.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		DurationSeconds:  aws.Int64(int64(p.duration / time.Second)),
+		RoleArn:          aws.String(p.roleARN),
+		RoleSessionName:  aws.String(p.roleSessionName),
+		WebIdentityToken: aws.String(strings.TrimSpace(string(token))),
+	})
+	if err != nil {
+		return credentials.Value{ProviderName: webIdentityProviderName}, err
+	}
+
+	p.SetExpiration(*response.Credentials.Expiration, p.expiryWindow)
+
+	return credentials.Value{
+		AccessKeyID:     *response.Credentials.AccessKeyId,
+		SecretAccessKey: *response.Credentials.SecretAccessKey,
+		SessionToken:    *response.Credentials.SessionToken,
+		ProviderName:    webIdentityProviderName,
+	}, nil
+}
@@ -0,0 +1,362 @@
+package config
+
+// Loads and validates the deathnode configuration file, which allows to set a different policy for
+// every autoscaling group prefix
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Default values for the settings not present in the configuration file
+const (
+	DefaultPolicyName      = "default"
+	DefaultConstraintsType = "noContraint"
+	DefaultRecommenderType = "firstAvailableAgent"
+	DefaultDeathNodeMark   = "DEATH_NODE_MARK"
+	DefaultPollingSeconds  = 60
+	DefaultScheduler       = SchedulerMesos
+)
+
+// Schedulers deathnode can drain the instances from
+const (
+	SchedulerMesos      = "mesos"
+	SchedulerKubernetes = "kubernetes"
+	SchedulerNomad      = "nomad"
+	SchedulerECS        = "ecs"
+)
+
+// Config holds the deathnode configuration, with the policies of every autoscaling group already resolved
+type Config struct {
+	Scheduler                   string
+	MesosURL                    string
+	MarathonURL                 string
+	KubernetesURL               string
+	NomadURL                    string
+	ECSCluster                  string
+	DeathNodeMark               string
+	PollingSeconds              int
+	DeregisterFromLoadBalancers bool
+	Defaults                    *Policy
+	AutoscalingGroups           []*AutoscalingGroup
+}
+
+// AutoscalingGroup holds an autoscaling group prefix to monitor, the AWS region and role used to
+// monitor it and the policy to apply to it's instances
+type AutoscalingGroup struct {
+	Prefix  string
+	Region  string
+	IAMRole string
+	Policy  *Policy
+}
+
+// Policy holds how instances of an autoscaling group are chosen and destroyed
+// Name identifies the policy, so the state related to it (ex: the last destroy time) can be tracked
+// DelayDeleteSeconds is the time to wait between destroys of instances under the same policy
+// ProtectedFrameworks are the Mesos frameworks, the Kubernetes namespaces and key=value pod labels, or
+// the Nomad jobs and namespaces, or the ECS services, whose tasks are waited for before destroying an instance
+// DrainTimeoutSeconds is the time to wait for the protected frameworks tasks to finish before destroying
+// an instance anyway. If 0, it waits forever
+// MaxConcurrentDrains is the maximum number of instances of an autoscaling group being drained at the
+// same time. If 0, there is no limit
+type Policy struct {
+	Name                string
+	ProtectedFrameworks []string
+	ConstraintsType     string
+	RecommenderType     string
+	DelayDeleteSeconds  int
+	DrainTimeoutSeconds int
+	MaxConcurrentDrains int
+}
+
+// file is the format of the configuration file. Pointers are used to distinguish the values not set,
+// which are inherited from the defaults
+type file struct {
+	Scheduler                   *string                 `yaml:"scheduler" json:"scheduler"`
+	MesosURL                    *string                 `yaml:"mesosUrl" json:"mesosUrl"`
+	MarathonURL                 *string                 `yaml:"marathonUrl" json:"marathonUrl"`
+	KubernetesURL               *string                 `yaml:"kubernetesUrl" json:"kubernetesUrl"`
+	NomadURL                    *string                 `yaml:"nomadUrl" json:"nomadUrl"`
+	ECSCluster                  *string                 `yaml:"ecsCluster" json:"ecsCluster"`
+	DeathNodeMark               *string                 `yaml:"deathNodeMark" json:"deathNodeMark"`
+	PollingSeconds              *int                    `yaml:"polling" json:"polling"`
+	DeregisterFromLoadBalancers *bool                   `yaml:"deregisterFromLoadBalancers" json:"deregisterFromLoadBalancers"`
+	Defaults                    filePolicy              `yaml:"defaults" json:"defaults"`
+	AutoscalingGroups           []*fileAutoscalingGroup `yaml:"autoscalingGroups" json:"autoscalingGroups"`
+}
+
+type fileAutoscalingGroup struct {
+	Prefix     string `yaml:"prefix" json:"prefix"`
+	Region     string `yaml:"region" json:"region"`
+	IAMRole    string `yaml:"iamRole" json:"iamRole"`
+	filePolicy `yaml:",inline"`
+}
+
+type filePolicy struct {
+	ProtectedFrameworks []string `yaml:"protectedFrameworks" json:"protectedFrameworks"`
+	ConstraintsType     *string  `yaml:"constraintsType" json:"constraintsType"`
+	RecommenderType     *string  `yaml:"recommenderType" json:"recommenderType"`
+	DelayDeleteSeconds  *int     `yaml:"delayDelete" json:"delayDelete"`
+	DrainTimeoutSeconds *int     `yaml:"drainTimeout" json:"drainTimeout"`
+	MaxConcurrentDrains *int     `yaml:"maxConcurrentDrains" json:"maxConcurrentDrains"`
+}
+
+// New returns a Config with the default values and no autoscaling groups
+func New() *Config {
+
+	return &Config{
+		Scheduler:      DefaultScheduler,
+		DeathNodeMark:  DefaultDeathNodeMark,
+		PollingSeconds: DefaultPollingSeconds,
+		Defaults: &Policy{
+			Name:                DefaultPolicyName,
+			ProtectedFrameworks: []string{},
+			ConstraintsType:     DefaultConstraintsType,
+			RecommenderType:     DefaultRecommenderType,
+		},
+		AutoscalingGroups: []*AutoscalingGroup{},
+	}
+}
+
+// Load reads a YAML or JSON configuration file. Files with .json extension are parsed as JSON, the
+// rest as YAML. Unknown fields are reported as errors
+func Load(path string) (*Config, error) {
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read configuration file %s: %v", path, err)
+	}
+
+	config, err := Parse(content, strings.ToLower(filepath.Ext(path)) == ".json")
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// Parse returns the Config stored in a YAML or JSON document
+func Parse(content []byte, isJSON bool) (*Config, error) {
+
+	parsed := &file{}
+	if isJSON {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(parsed); err != nil {
+			return nil, err
+		}
+	} else if err := yaml.UnmarshalStrict(content, parsed); err != nil {
+		return nil, err
+	}
+
+	config := New()
+	if parsed.Scheduler != nil {
+		config.Scheduler = *parsed.Scheduler
+	}
+	if parsed.MesosURL != nil {
+		config.MesosURL = *parsed.MesosURL
+	}
+	if parsed.MarathonURL != nil {
+		config.MarathonURL = *parsed.MarathonURL
+	}
+	if parsed.KubernetesURL != nil {
+		config.KubernetesURL = *parsed.KubernetesURL
+	}
+	if parsed.NomadURL != nil {
+		config.NomadURL = *parsed.NomadURL
+	}
+	if parsed.ECSCluster != nil {
+		config.ECSCluster = *parsed.ECSCluster
+	}
+	if parsed.DeathNodeMark != nil {
+		config.DeathNodeMark = *parsed.DeathNodeMark
+	}
+	if parsed.PollingSeconds != nil {
+		config.PollingSeconds = *parsed.PollingSeconds
+	}
+	if parsed.DeregisterFromLoadBalancers != nil {
+		config.DeregisterFromLoadBalancers = *parsed.DeregisterFromLoadBalancers
+	}
+
+	config.Defaults = parsed.Defaults.merge(config.Defaults, DefaultPolicyName)
+	for i, autoscalingGroup := range parsed.AutoscalingGroups {
+		if autoscalingGroup == nil {
+			return nil, fmt.Errorf("autoscalingGroups[%d] is empty", i)
+		}
+		config.AddAutoscalingGroup(&AutoscalingGroup{
+			Prefix:  autoscalingGroup.Prefix,
+			Region:  autoscalingGroup.Region,
+			IAMRole: autoscalingGroup.IAMRole,
+			Policy:  autoscalingGroup.merge(config.Defaults, ""),
+		})
+	}
+
+	return config, nil
+}
+
+// merge returns a new policy with the values set in the filePolicy, and the ones from the parent otherwise
+func (p *filePolicy) merge(parent *Policy, name string) *Policy {
+
+	policy := *parent
+	policy.Name = name
+	if p.ProtectedFrameworks != nil {
+		policy.ProtectedFrameworks = p.ProtectedFrameworks
+	}
+	if p.ConstraintsType != nil {
+		policy.ConstraintsType = *p.ConstraintsType
+	}
+	if p.RecommenderType != nil {
+		policy.RecommenderType = *p.RecommenderType
+	}
+	if p.DelayDeleteSeconds != nil {
+		policy.DelayDeleteSeconds = *p.DelayDeleteSeconds
+	}
+	if p.DrainTimeoutSeconds != nil {
+		policy.DrainTimeoutSeconds = *p.DrainTimeoutSeconds
+	}
+	if p.MaxConcurrentDrains != nil {
+		policy.MaxConcurrentDrains = *p.MaxConcurrentDrains
+	}
+	return &policy
+}
+
+// AddAutoscalingGroup adds an autoscaling group to the config. If it has no policy, the default one is
+// used. If the policy has no name, it's named after the autoscaling group
+func (c *Config) AddAutoscalingGroup(autoscalingGroup *AutoscalingGroup) {
+
+	if autoscalingGroup.Policy == nil {
+		autoscalingGroup.Policy = c.Defaults
+	}
+	if autoscalingGroup.Policy.Name == "" {
+		autoscalingGroup.Policy.Name = autoscalingGroup.String()
+	}
+	c.AutoscalingGroups = append(c.AutoscalingGroups, autoscalingGroup)
+}
+
+// Policies returns the distinct policies used by the autoscaling groups
+func (c *Config) Policies() []*Policy {
+
+	policies := []*Policy{}
+	found := map[*Policy]bool{}
+	for _, autoscalingGroup := range c.AutoscalingGroups {
+		if !found[autoscalingGroup.Policy] {
+			found[autoscalingGroup.Policy] = true
+			policies = append(policies, autoscalingGroup.Policy)
+		}
+	}
+	return policies
+}
+
+// ProtectedFrameworks returns the frameworks protected by any of the autoscaling groups policies
+func (c *Config) ProtectedFrameworks() []string {
+
+	frameworks := []string{}
+	found := map[string]bool{}
+	for _, policy := range c.Policies() {
+		for _, framework := range policy.ProtectedFrameworks {
+			if !found[framework] {
+				found[framework] = true
+				frameworks = append(frameworks, framework)
+			}
+		}
+	}
+	return frameworks
+}
+
+// Validate checks that the configuration has all the required values, returning an error describing
+// the first problem found
+func (c *Config) Validate() error {
+
+	switch c.Scheduler {
+	case SchedulerMesos:
+		if c.MesosURL == "" {
+			return errors.New("mesosUrl is required")
+		}
+	case SchedulerKubernetes:
+		// Without kubernetesUrl, the in cluster configuration is used
+	case SchedulerNomad:
+		if c.NomadURL == "" {
+			return errors.New("nomadUrl is required")
+		}
+	case SchedulerECS:
+		if c.ECSCluster == "" {
+			return errors.New("ecsCluster is required")
+		}
+	default:
+		return fmt.Errorf("unknown scheduler %s", c.Scheduler)
+	}
+	if c.MarathonURL != "" && c.Scheduler != SchedulerMesos {
+		return fmt.Errorf("marathonUrl can't be used with the %s scheduler", c.Scheduler)
+	}
+	if c.DeathNodeMark == "" {
+		return errors.New("deathNodeMark can't be empty")
+	}
+	if c.PollingSeconds <= 0 {
+		return fmt.Errorf("polling must be greater than 0, found %d", c.PollingSeconds)
+	}
+	if len(c.AutoscalingGroups) == 0 {
+		return errors.New("at least one autoscaling group is required")
+	}
+
+	names := map[string]bool{}
+	for i, autoscalingGroup := range c.AutoscalingGroups {
+		if autoscalingGroup.Prefix == "" {
+			return fmt.Errorf("autoscalingGroups[%d]: prefix is required", i)
+		}
+		if names[autoscalingGroup.String()] {
+			return fmt.Errorf("autoscalingGroups[%d]: %s is duplicated", i, autoscalingGroup)
+		}
+		names[autoscalingGroup.String()] = true
+		if err := autoscalingGroup.Policy.Validate(); err != nil {
+			return fmt.Errorf("autoscalingGroups[%d] (%s): %v", i, autoscalingGroup, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks that the policy values are valid. The constraints and recommender types are validated
+// by the deathnode package, which implements them
+func (p *Policy) Validate() error {
+
+	if len(p.ProtectedFrameworks) == 0 {
+		return errors.New("at least one protected framework is required")
+	}
+	if p.ConstraintsType == "" {
+		return errors.New("constraintsType can't be empty")
+	}
+	if p.RecommenderType == "" {
+		return errors.New("recommenderType can't be empty")
+	}
+	if p.DelayDeleteSeconds < 0 {
+		return fmt.Errorf("delayDelete can't be negative, found %d", p.DelayDeleteSeconds)
+	}
+	if p.DrainTimeoutSeconds < 0 {
+		return fmt.Errorf("drainTimeout can't be negative, found %d", p.DrainTimeoutSeconds)
+	}
+	if p.MaxConcurrentDrains < 0 {
+		return fmt.Errorf("maxConcurrentDrains can't be negative, found %d", p.MaxConcurrentDrains)
+	}
+	return nil
+}
+
+// String returns the autoscaling group selector in the same format used by the autoscalingGroupName flag:
+// prefix[,region=<region>][,iamRole=<role>]
+func (a *AutoscalingGroup) String() string {
+
+	name := a.Prefix
+	if a.Region != "" {
+		name += ",region=" + a.Region
+	}
+	if a.IAMRole != "" {
+		name += ",iamRole=" + a.IAMRole
+	}
+	return name
+}
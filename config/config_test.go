@@ -0,0 +1,117 @@
+package config
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const yamlConfig = `
+mesosUrl: http://mesos:5050
+polling: 30
+defaults:
+  protectedFrameworks: [frameworkName1]
+  delayDelete: 300
+autoscalingGroups:
+  - prefix: some-Autoscaling-Group
+  - prefix: other-Autoscaling-Group
+    region: us-east-1
+    protectedFrameworks: [frameworkName2]
+    recommenderType: smallestInstanceId
+    delayDelete: 0
+    drainTimeout: 3600
+    maxConcurrentDrains: 2
+`
+
+const jsonConfig = `{
+	"mesosUrl": "http://mesos:5050",
+	"defaults": {"protectedFrameworks": ["frameworkName1"]},
+	"autoscalingGroups": [{"prefix": "some-Autoscaling-Group", "maxConcurrentDrains": 1}]
+}`
+
+func TestParse(t *testing.T) {
+
+	Convey("When parsing a YAML configuration", t, func() {
+		config, err := Parse([]byte(yamlConfig), false)
+		So(err, ShouldBeNil)
+		So(config.Validate(), ShouldBeNil)
+
+		Convey("global values should be set, keeping the defaults for the missing ones", func() {
+			So(config.MesosURL, ShouldEqual, "http://mesos:5050")
+			So(config.PollingSeconds, ShouldEqual, 30)
+			So(config.DeathNodeMark, ShouldEqual, DefaultDeathNodeMark)
+			So(config.Scheduler, ShouldEqual, SchedulerMesos)
+		})
+		Convey("autoscaling groups without policy values should inherit the defaults", func() {
+			policy := config.AutoscalingGroups[0].Policy
+			So(policy.Name, ShouldEqual, "some-Autoscaling-Group")
+			So(policy.ProtectedFrameworks, ShouldResemble, []string{"frameworkName1"})
+			So(policy.RecommenderType, ShouldEqual, DefaultRecommenderType)
+			So(policy.DelayDeleteSeconds, ShouldEqual, 300)
+		})
+		Convey("autoscaling groups policy values should override the defaults, even with zero values", func() {
+			autoscalingGroup := config.AutoscalingGroups[1]
+			So(autoscalingGroup.String(), ShouldEqual, "other-Autoscaling-Group,region=us-east-1")
+			So(autoscalingGroup.Policy.ProtectedFrameworks, ShouldResemble, []string{"frameworkName2"})
+			So(autoscalingGroup.Policy.RecommenderType, ShouldEqual, "smallestInstanceId")
+			So(autoscalingGroup.Policy.DelayDeleteSeconds, ShouldEqual, 0)
+			So(autoscalingGroup.Policy.DrainTimeoutSeconds, ShouldEqual, 3600)
+			So(autoscalingGroup.Policy.MaxConcurrentDrains, ShouldEqual, 2)
+		})
+		Convey("protected frameworks from all policies should be returned", func() {
+			So(config.ProtectedFrameworks(), ShouldResemble, []string{"frameworkName1", "frameworkName2"})
+		})
+	})
+
+	Convey("When parsing a JSON configuration", t, func() {
+		config, err := Parse([]byte(jsonConfig), true)
+		So(err, ShouldBeNil)
+		So(config.Validate(), ShouldBeNil)
+		So(config.AutoscalingGroups[0].Policy.MaxConcurrentDrains, ShouldEqual, 1)
+		So(config.AutoscalingGroups[0].Policy.ProtectedFrameworks, ShouldResemble, []string{"frameworkName1"})
+	})
+
+	Convey("When parsing an invalid configuration", t, func() {
+		Convey("unknown fields should return an error", func() {
+			_, err := Parse([]byte("mesosURL: http://mesos:5050\n"), false)
+			So(err, ShouldNotBeNil)
+			_, err = Parse([]byte(`{"autoscalingGroups": [{"prefixes": "foo"}]}`), true)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("missing required values should fail validation", func() {
+			config, _ := Parse([]byte("mesosUrl: http://mesos:5050\nautoscalingGroups:\n  - prefix: foo\n"), false)
+			So(config.Validate(), ShouldNotBeNil)
+			So(config.Validate().Error(), ShouldContainSubstring, "protected framework")
+		})
+		Convey("unknown schedulers should fail validation", func() {
+			config, _ := Parse([]byte("scheduler: swarm\n"+yamlConfig), false)
+			So(config.Validate().Error(), ShouldContainSubstring, "unknown scheduler")
+		})
+		Convey("the mesos url should only be required by the mesos scheduler", func() {
+			config, _ := Parse([]byte(yamlConfig), false)
+			config.MesosURL = ""
+			So(config.Validate().Error(), ShouldContainSubstring, "mesosUrl")
+			config.Scheduler = SchedulerKubernetes
+			So(config.Validate(), ShouldBeNil)
+			config.Scheduler = SchedulerNomad
+			So(config.Validate().Error(), ShouldContainSubstring, "nomadUrl")
+			config.Scheduler = SchedulerECS
+			So(config.Validate().Error(), ShouldContainSubstring, "ecsCluster")
+		})
+		Convey("the marathon url should only be allowed with the mesos scheduler", func() {
+			config, _ := Parse([]byte("marathonUrl: http://marathon:8080\n"+yamlConfig), false)
+			So(config.MarathonURL, ShouldEqual, "http://marathon:8080")
+			So(config.Validate(), ShouldBeNil)
+			config.Scheduler = SchedulerKubernetes
+			So(config.Validate().Error(), ShouldContainSubstring, "marathonUrl")
+		})
+		Convey("negative values should fail validation", func() {
+			config, _ := Parse([]byte(yamlConfig+"  - prefix: foo\n    drainTimeout: -1\n"), false)
+			So(config.Validate().Error(), ShouldContainSubstring, "drainTimeout")
+		})
+		Convey("duplicated autoscaling groups should fail validation", func() {
+			config, _ := Parse([]byte(yamlConfig+"  - prefix: some-Autoscaling-Group\n"), false)
+			So(config.Validate().Error(), ShouldContainSubstring, "duplicated")
+		})
+	})
+}
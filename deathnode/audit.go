@@ -0,0 +1,89 @@
+package deathnode
+
+// Records the destructive actions taken on instances in the audit log, with the Mesos tasks running on
+// them at the time
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/alanbover/deathnode/audit"
+	"github.com/alanbover/deathnode/monitor"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// SetAuditLogger makes the Notebook, and the Watcher using it, record every destructive action in the
+// audit log
+func (n *Notebook) SetAuditLogger(auditLogger *audit.Logger) {
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.auditLogger = auditLogger
+}
+
+// auditInstance records an action taken by the Watcher on an instance
+func (n *Notebook) auditInstance(action string, instance *monitor.InstanceMonitor, requestedBy string, decision *audit.Decision, err error) {
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.recordInstanceAudit(action, instance, requestedBy, decision, err)
+}
+
+// recordInstanceAudit records an action taken on an instance. If requestedBy is empty, it's taken from
+// the drain of the instance. It expects the caller to hold the Notebook lock
+func (n *Notebook) recordInstanceAudit(action string, instance *monitor.InstanceMonitor, requestedBy string, decision *audit.Decision, err error) {
+
+	if storedDrain, ok := n.state.Drains[*instance.GetInstanceID()]; ok && requestedBy == "" {
+		requestedBy = storedDrain.RequestedBy
+	}
+
+	record := &audit.Record{
+		Action:           action,
+		InstanceID:       *instance.GetInstanceID(),
+		AutoscalingGroup: *instance.GetAutoscalingGroupID(),
+		RequestedBy:      requestedBy,
+		Decision:         decision,
+		MesosTasks:       []audit.Task{},
+	}
+	for _, task := range n.scheduler.GetTasks(instance.GetIP()) {
+		record.MesosTasks = append(record.MesosTasks, audit.Task{
+			Name:        task.Name,
+			FrameworkID: task.OwnerID,
+			Framework:   task.OwnerName,
+			Protected:   task.Protected,
+		})
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	n.auditLogger.Log(record)
+}
+
+// recordMaintenanceAudit records a call replacing the Mesos maintenance schedule. As the schedule is set
+// on every check, calls with the same hosts and result than the previous one are not recorded. It
+// expects the caller to hold the Notebook lock
+func (n *Notebook) recordMaintenanceAudit(instances []*ec2.Instance, hosts map[string]string, err error) {
+
+	failed := err != nil
+	if n.maintenanceHosts != nil && reflect.DeepEqual(hosts, n.maintenanceHosts) && failed == n.maintenanceFailed {
+		return
+	}
+	n.maintenanceHosts = hosts
+	n.maintenanceFailed = failed
+
+	record := &audit.Record{
+		Action:      audit.ActionSetMaintenance,
+		InstanceIDs: []string{},
+		Hosts:       hosts,
+	}
+	for _, instance := range instances {
+		record.InstanceIDs = append(record.InstanceIDs, *instance.InstanceId)
+	}
+	sort.Strings(record.InstanceIDs)
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	n.auditLogger.Log(record)
+}
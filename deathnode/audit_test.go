@@ -0,0 +1,85 @@
+package deathnode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/alanbover/deathnode/audit"
+	"github.com/alanbover/deathnode/aws"
+	"github.com/alanbover/deathnode/mesos"
+	"github.com/alanbover/deathnode/store"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestAuditLog(t *testing.T) {
+
+	log.SetLevel(log.DebugLevel)
+
+	awsConn := &aws.ConnectionMock{
+		Records: map[string]*[]string{
+			"DescribeInstanceById": {
+				"node1", "node2", "node3",
+			},
+			"DescribeInstancesByTag": {"default", "one_undesired_host", "one_undesired_host"},
+			"DescribeAGByName":       {"one_undesired_host", "one_undesired_host_one_terminating", "one_undesired_host_one_terminating"},
+		},
+	}
+
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": {"default", "default", "default"},
+			"GetMesosSlaves":     {"default", "default", "default"},
+			"GetMesosTasks":      {"default", "notasks", "notasks"},
+		},
+	}
+
+	buffer := &bytes.Buffer{}
+	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
+	deathNodeWatcher.notebook.SetAuditLogger(audit.NewLogger("1.0.0", "replica-1", false, audit.NewWriterOutput(buffer)))
+	deathNodeWatcher.Run(context.Background())
+	deathNodeWatcher.Run(context.Background())
+	deathNodeWatcher.Run(context.Background())
+
+	records := []*audit.Record{}
+	for _, line := range strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n") {
+		record := &audit.Record{}
+		if err := json.Unmarshal([]byte(line), record); err != nil {
+			t.Fatalf("Audit records should be JSON lines: %v", err)
+		}
+		records = append(records, record)
+	}
+
+	actions := []string{}
+	for _, record := range records {
+		actions = append(actions, record.Action)
+		if record.Version != "1.0.0" || record.Leader != "replica-1" {
+			t.Fatalf("Audit records should carry the version and leader. Actual: %+v", record)
+		}
+	}
+	// The maintenance schedule is recorded when it's first set, and when it changes
+	expectedActions := []string{audit.ActionMark, audit.ActionSetMaintenance, audit.ActionSetMaintenance,
+		audit.ActionRemoveInstanceProtection, audit.ActionCompleteLifecycleAction}
+	if strings.Join(actions, ",") != strings.Join(expectedActions, ",") {
+		t.Fatalf("Audit log should record %v. Actual: %v", expectedActions, actions)
+	}
+
+	mark := records[0]
+	if mark.InstanceID != "i-34719eb8" || mark.RequestedBy != store.RequestedByDeathnode {
+		t.Fatalf("Mark should be recorded as requested by deathnode. Actual: %+v", mark)
+	}
+	if mark.Decision == nil || mark.Decision.Constraint != "noContraint" || mark.Decision.Recommender != "smallestInstanceId" ||
+		len(mark.Decision.AllowedInstances) != 3 {
+		t.Fatalf("Mark should record the constraint and recommender decisions. Actual: %+v", mark.Decision)
+	}
+	if len(mark.MesosTasks) != 1 || mark.MesosTasks[0].Framework != "frameworkName1" || !mark.MesosTasks[0].Protected {
+		t.Fatalf("Mark should record the Mesos tasks running on the instance. Actual: %+v", mark.MesosTasks)
+	}
+
+	maintenance := records[2]
+	if len(maintenance.InstanceIDs) != 1 || len(maintenance.Hosts) != 1 {
+		t.Fatalf("Maintenance call should record the instances set in maintenance. Actual: %+v", maintenance)
+	}
+}
@@ -7,10 +7,11 @@ import (
 	"github.com/alanbover/deathnode/monitor"
 )
 
+// newConstraint returns the constraint of the given type. Pinned instances are never allowed by any of them
 func newConstraint(constraintType string) (constraint, error) {
 	switch constraintType {
 	case "noContraint":
-		return &noConstraint{}, nil
+		return &pinnedInstancesConstraint{&noConstraint{}}, nil
 	default:
 		return nil, fmt.Errorf("Contraint type %v not found", constraintType)
 	}
@@ -20,6 +21,22 @@ type constraint interface {
 	filter([]*monitor.InstanceMonitor) []*monitor.InstanceMonitor
 }
 
+// pinnedInstancesConstraint removes the pinned instances before applying the decorated constraint
+type pinnedInstancesConstraint struct {
+	constraint constraint
+}
+
+func (c *pinnedInstancesConstraint) filter(instanceMonitors []*monitor.InstanceMonitor) []*monitor.InstanceMonitor {
+
+	unpinnedInstances := []*monitor.InstanceMonitor{}
+	for _, instanceMonitor := range instanceMonitors {
+		if !instanceMonitor.IsPinned() {
+			unpinnedInstances = append(unpinnedInstances, instanceMonitor)
+		}
+	}
+	return c.constraint.filter(unpinnedInstances)
+}
+
 type noConstraint struct{}
 
 func (c *noConstraint) filter(instanceMonitors []*monitor.InstanceMonitor) []*monitor.InstanceMonitor {
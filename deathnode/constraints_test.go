@@ -26,6 +26,15 @@ func TestConstraints(t *testing.T) {
 			instances := constraint.filter(monitor.GetInstances())
 			So(len(monitor.GetInstances()), ShouldEqual, len(instances))
 		})
+		Convey("pinned instances should never be returned", func() {
+			constraint, _ := newConstraint("noContraint")
+			monitor.GetInstances()[0].Pin()
+			instances := constraint.filter(monitor.GetInstances())
+			So(len(instances), ShouldEqual, len(monitor.GetInstances())-1)
+			for _, instance := range instances {
+				So(instance.IsPinned(), ShouldBeFalse)
+			}
+		})
 	})
 }
 
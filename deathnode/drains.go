@@ -0,0 +1,252 @@
+package deathnode
+
+// Keeps track of the instances being drained in the persisted state, reconciling it with the deathnode
+// marks found in EC2
+
+import (
+	"strings"
+	"time"
+
+	"github.com/alanbover/deathnode/monitor"
+	"github.com/alanbover/deathnode/notifier"
+	"github.com/alanbover/deathnode/store"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+)
+
+// SetStore makes the Notebook persist it's state in the store, loading the state saved in it
+func (n *Notebook) SetStore(stateStore store.Store) error {
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.store = stateStore
+	return n.loadState()
+}
+
+// loadState expects the caller to hold the Notebook lock
+func (n *Notebook) loadState() error {
+
+	state, err := n.store.Load()
+	if err != nil {
+		return err
+	}
+	n.state = state
+	return nil
+}
+
+// saveState expects the caller to hold the Notebook lock. Errors are logged, as the state is saved
+// again on the next change. Replicas that lost the leadership don't save it, as the new leader owns it
+func (n *Notebook) saveState() {
+
+	if !n.isLeader() {
+		log.Warn("Leadership lost. Not saving deathnode state")
+		return
+	}
+
+	err := n.store.Save(n.state)
+	if err == store.ErrLeadershipLost {
+		log.Warnf("Not saving deathnode state: %v", err)
+	} else if err != nil {
+		log.Errorf("Unable to save deathnode state: %v", err)
+	}
+}
+
+// reconcileDrains adds the instances marked in EC2 without a drain, as if the mark was set by other
+// tool, and finishes the drains of the instances not marked anymore, as they are terminated or unmarked.
+// The drains saved are restored in the instance monitors, as other replica may have moved them while
+// it was the leader. Instances whose drain was cancelled are skipped, as AWS may still return them as
+// marked. If the instances are not complete, as some AWS connections failed, no drain is finished. It
+// expects the caller to hold the Notebook lock
+func (n *Notebook) reconcileDrains(instances []*ec2.Instance, complete bool) {
+
+	changed := false
+	markedInstances := map[string]bool{}
+	for _, instance := range instances {
+		markedInstances[*instance.InstanceId] = true
+		instanceMonitor, err := n.autoscalingGroups.GetInstanceByID(*instance.InstanceId)
+		if err == nil && instanceMonitor.GetDrainState() == monitor.DrainCancelled {
+			continue
+		}
+
+		storedDrain, ok := n.state.Drains[*instance.InstanceId]
+		if !ok {
+			log.Infof("Found instance %s marked to be removed without a drain. Tracking it", *instance.InstanceId)
+			storedDrain = &store.Drain{
+				InstanceID:  *instance.InstanceId,
+				MarkTime:    n.markTime(instance),
+				RequestedBy: store.RequestedByUnknown,
+			}
+			if err == nil {
+				storedDrain.AutoscalingGroup = *instanceMonitor.GetAutoscalingGroupID()
+			}
+			n.state.Drains[*instance.InstanceId] = storedDrain
+			changed = true
+		}
+		if err != nil {
+			continue
+		}
+
+		if storedDrain.State != "" {
+			instanceMonitor.TrackDrain(drainFromStore(storedDrain))
+			continue
+		}
+		drain, ok := instanceMonitor.GetDrain()
+		if !ok || drain.IsFinished() {
+			drain = monitor.NewDrain(storedDrain.MarkTime)
+			instanceMonitor.TrackDrain(drain)
+		}
+		updateStoredDrain(storedDrain, drain)
+		changed = true
+	}
+
+	for instanceID, storedDrain := range n.state.Drains {
+		if markedInstances[instanceID] || !complete {
+			continue
+		}
+		if state, finished := n.finishedState(storedDrain); finished {
+			n.finishDrain(storedDrain, state)
+			changed = true
+		}
+	}
+
+	if changed {
+		n.saveState()
+	}
+}
+
+// finishedState returns the state of a drain whose instance is not found with the deathnode mark. It's
+// terminated if the instance is gone or being terminated, and cancelled if the mark was removed. Returns
+// false if the instance is still marked, as AWS may not return the instances just marked yet
+func (n *Notebook) finishedState(storedDrain *store.Drain) (monitor.DrainState, bool) {
+
+	if storedDrain.State == string(monitor.DrainLifecycleCompleted) {
+		return monitor.DrainTerminated, true
+	}
+	instanceMonitor, err := n.autoscalingGroups.GetInstanceByID(storedDrain.InstanceID)
+	if err != nil || strings.HasPrefix(instanceMonitor.GetLifecycleState(), "Terminat") {
+		return monitor.DrainTerminated, true
+	}
+	if instanceMonitor.IsMarkedToBeRemoved() {
+		return "", false
+	}
+	return monitor.DrainCancelled, true
+}
+
+// finishDrain forgets the drain of an instance, as it's terminated or cancelled. It expects the caller
+// to hold the Notebook lock, and to save the state
+func (n *Notebook) finishDrain(storedDrain *store.Drain, state monitor.DrainState) {
+
+	log.Infof("Drain of instance %s finished: %s", storedDrain.InstanceID, state)
+	if instanceMonitor, err := n.autoscalingGroups.GetInstanceByID(storedDrain.InstanceID); err == nil {
+		if drain, ok := instanceMonitor.GetDrain(); ok && !drain.IsFinished() {
+			instanceMonitor.TransitionDrain(state)
+		}
+	}
+	drainTransitionsCounter.Inc(storedDrain.AutoscalingGroup, string(state))
+	delete(n.state.Drains, storedDrain.InstanceID)
+	if state == monitor.DrainTerminated {
+		event := n.drainEvent(notifier.EventInstanceTerminated, storedDrain)
+		event.DrainState = string(state)
+		event.Since = time.Now()
+		n.notify(event)
+	}
+}
+
+// recordDrain saves the drain of an instance that has just been marked to be removed
+func (n *Notebook) recordDrain(instance *monitor.InstanceMonitor, requestedBy string) {
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	markTime := instance.GetMarkTime()
+	if markTime.IsZero() {
+		markTime = time.Now()
+	}
+	n.state.Drains[*instance.GetInstanceID()] = &store.Drain{
+		InstanceID:       *instance.GetInstanceID(),
+		AutoscalingGroup: *instance.GetAutoscalingGroupID(),
+		MarkTime:         markTime,
+		RequestedBy:      requestedBy,
+	}
+	drainTransitionsCounter.Inc(*instance.GetAutoscalingGroupID(), string(monitor.DrainMarked))
+	n.saveDrain(instance)
+	n.notifyDrain(notifier.EventDrainStarted, n.state.Drains[*instance.GetInstanceID()])
+}
+
+// cancelDrain finishes the drain of an instance whose mark has just been removed
+func (n *Notebook) cancelDrain(instanceID string) {
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if storedDrain, ok := n.state.Drains[instanceID]; ok {
+		n.finishDrain(storedDrain, monitor.DrainCancelled)
+		n.saveState()
+	}
+}
+
+// saveDrain copies the drain of the instance to the state, and saves it. It expects the caller to hold
+// the Notebook lock
+func (n *Notebook) saveDrain(instance *monitor.InstanceMonitor) {
+
+	drain, ok := instance.GetDrain()
+	if !ok {
+		return
+	}
+
+	storedDrain, ok := n.state.Drains[*instance.GetInstanceID()]
+	if !ok {
+		storedDrain = &store.Drain{
+			InstanceID:       *instance.GetInstanceID(),
+			AutoscalingGroup: *instance.GetAutoscalingGroupID(),
+			MarkTime:         instance.GetMarkTime(),
+			RequestedBy:      store.RequestedByUnknown,
+		}
+		n.state.Drains[*instance.GetInstanceID()] = storedDrain
+	}
+	updateStoredDrain(storedDrain, drain)
+	n.saveState()
+}
+
+func updateStoredDrain(storedDrain *store.Drain, drain monitor.Drain) {
+
+	storedDrain.State = string(drain.State)
+	storedDrain.Transitions = map[string]time.Time{}
+	for state, transitionTime := range drain.Transitions {
+		storedDrain.Transitions[string(state)] = transitionTime
+	}
+	storedDrain.Attempts = drain.Attempts
+	storedDrain.LastError = drain.LastError
+	storedDrain.FailedStep = string(drain.FailedStep)
+	storedDrain.RetryAt = nil
+	if !drain.RetryAt.IsZero() {
+		retryAt := drain.RetryAt
+		storedDrain.RetryAt = &retryAt
+	}
+	storedDrain.Heartbeats = drain.Heartbeats
+	storedDrain.LastHeartbeat = nil
+	if !drain.LastHeartbeat.IsZero() {
+		lastHeartbeat := drain.LastHeartbeat
+		storedDrain.LastHeartbeat = &lastHeartbeat
+	}
+}
+
+func drainFromStore(storedDrain *store.Drain) monitor.Drain {
+
+	drain := monitor.NewDrain(storedDrain.MarkTime)
+	drain.State = monitor.DrainState(storedDrain.State)
+	for state, transitionTime := range storedDrain.Transitions {
+		drain.Transitions[monitor.DrainState(state)] = transitionTime
+	}
+	drain.Attempts = storedDrain.Attempts
+	drain.LastError = storedDrain.LastError
+	drain.FailedStep = monitor.DrainState(storedDrain.FailedStep)
+	if storedDrain.RetryAt != nil {
+		drain.RetryAt = *storedDrain.RetryAt
+	}
+	drain.Heartbeats = storedDrain.Heartbeats
+	if storedDrain.LastHeartbeat != nil {
+		drain.LastHeartbeat = *storedDrain.LastHeartbeat
+	}
+	return drain
+}
@@ -0,0 +1,42 @@
+package deathnode
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alanbover/deathnode/monitor"
+	"github.com/alanbover/deathnode/store"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDrainFromStore(t *testing.T) {
+
+	Convey("When restoring a drain from the state", t, func() {
+		markTime := time.Unix(12345678, 0)
+
+		Convey("the failed attempts and the retry time should be kept", func() {
+			drain := monitor.NewDrain(markTime)
+			drain.RecordFailure(errors.New("aws unavailable"))
+			storedDrain := &store.Drain{MarkTime: markTime}
+			updateStoredDrain(storedDrain, drain)
+
+			restored := drainFromStore(storedDrain)
+			So(restored.Attempts, ShouldEqual, 1)
+			So(restored.RetryAt, ShouldResemble, drain.RetryAt)
+			So(restored.IsWaitingRetry(), ShouldBeTrue)
+		})
+		Convey("failed drains should keep the step they failed at", func() {
+			drain := monitor.NewDrain(markTime)
+			for attempt := 0; attempt < monitor.MaxDrainAttempts; attempt++ {
+				drain.RecordFailure(errors.New("aws unavailable"))
+			}
+			storedDrain := &store.Drain{MarkTime: markTime}
+			updateStoredDrain(storedDrain, drain)
+
+			restored := drainFromStore(storedDrain)
+			So(restored.State, ShouldEqual, monitor.DrainFailed)
+			So(restored.Step(), ShouldEqual, monitor.DrainMarked)
+		})
+	})
+}
@@ -0,0 +1,106 @@
+package deathnode
+
+// Liveness and readiness checks, so orchestrators can restart deathnode when it's not working
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// health stores when the Watcher last made progress. It's guarded by the Watcher stateMutex
+type health struct {
+	started                time.Time
+	lastRunFinished        time.Time
+	lastAutoscalingRefresh time.Time
+	lastSchedulerRefresh   time.Time
+}
+
+func (y *Watcher) recordHealth(timestamp *time.Time) {
+
+	now := time.Now()
+	y.stateMutex.Lock()
+	defer y.stateMutex.Unlock()
+	*timestamp = now
+}
+
+// CheckLiveness returns an error if no execution finished in the last maxDelay, meaning the control loop
+// is stuck. Executions skipped because the replica is not the leader are taken into account
+func (y *Watcher) CheckLiveness(maxDelay time.Duration) error {
+
+	y.stateMutex.RLock()
+	defer y.stateMutex.RUnlock()
+
+	lastProgress := y.health.lastRunFinished
+	if lastProgress.IsZero() {
+		lastProgress = y.health.started
+	}
+	if elapsed := time.Since(lastProgress); elapsed > maxDelay {
+		return fmt.Errorf("No execution finished in the last %v", elapsed.Truncate(time.Second))
+	}
+	return nil
+}
+
+// CheckReadiness returns an error if the autoscaling groups or the scheduler state weren't refreshed in the
+// last maxDelay. Replicas that are not the leader don't refresh them, so they are ready while live
+func (y *Watcher) CheckReadiness(maxDelay time.Duration) error {
+
+	if !y.isLeader() {
+		return y.CheckLiveness(maxDelay)
+	}
+
+	y.stateMutex.RLock()
+	defer y.stateMutex.RUnlock()
+
+	if y.health.lastAutoscalingRefresh.IsZero() || time.Since(y.health.lastAutoscalingRefresh) > maxDelay {
+		return fmt.Errorf("Autoscaling groups not refreshed since %s", formatHealthTime(y.health.lastAutoscalingRefresh))
+	}
+	if y.health.lastSchedulerRefresh.IsZero() || time.Since(y.health.lastSchedulerRefresh) > maxDelay {
+		return fmt.Errorf("Scheduler state not refreshed since %s", formatHealthTime(y.health.lastSchedulerRefresh))
+	}
+	return nil
+}
+
+func formatHealthTime(timestamp time.Time) string {
+
+	if timestamp.IsZero() {
+		return "startup"
+	}
+	return timestamp.Format(time.RFC3339)
+}
+
+// HealthHandler exposes a health check through HTTP, returning 503 if it fails
+type HealthHandler struct {
+	check    func(time.Duration) error
+	maxDelay time.Duration
+}
+
+// NewLivenessHandler returns a HealthHandler for the Watcher liveness
+func NewLivenessHandler(watcher *Watcher, maxDelay time.Duration) *HealthHandler {
+	return &HealthHandler{
+		check:    watcher.CheckLiveness,
+		maxDelay: maxDelay,
+	}
+}
+
+// NewReadinessHandler returns a HealthHandler for the Watcher readiness
+func NewReadinessHandler(watcher *Watcher, maxDelay time.Duration) *HealthHandler {
+	return &HealthHandler{
+		check:    watcher.CheckReadiness,
+		maxDelay: maxDelay,
+	}
+}
+
+// ServeHTTP runs the health check
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	err := h.check(h.maxDelay)
+	if err != nil {
+		log.Warnf("Health check %s failed: %v", req.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,84 @@
+package deathnode
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alanbover/deathnode/aws"
+	"github.com/alanbover/deathnode/mesos"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestHealthChecks(t *testing.T) {
+
+	log.SetLevel(log.DebugLevel)
+
+	awsConn := &aws.ConnectionMock{
+		Records: map[string]*[]string{
+			"DescribeInstanceById": {
+				"node1", "node2", "node3",
+			},
+			"DescribeInstancesByTag": {"default"},
+			"DescribeAGByName":       {"default", "default"},
+		},
+	}
+
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": {"default"},
+			"GetMesosSlaves":     {"default"},
+			"GetMesosTasks":      {"default"},
+		},
+		Errors: map[string]error{
+			"GetMesosTasks": errors.New("mesos unavailable"),
+		},
+	}
+
+	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
+	if deathNodeWatcher.CheckLiveness(time.Minute) != nil {
+		t.Fatal("Watcher should be live after being created")
+	}
+	if deathNodeWatcher.CheckLiveness(0) == nil {
+		t.Fatal("Watcher should not be live if no execution finished")
+	}
+	if deathNodeWatcher.CheckReadiness(time.Minute) == nil {
+		t.Fatal("Watcher should not be ready before refreshing the monitors")
+	}
+
+	deathNodeWatcher.Run(context.Background())
+	err := deathNodeWatcher.CheckReadiness(time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "Scheduler") {
+		t.Fatalf("Watcher should not be ready if Mesos refresh failed. Actual: %v", err)
+	}
+	if mesosConn.Requests["SetHostInMaintenance"] != nil {
+		t.Fatal("Instances should not be destroyed without the Mesos state")
+	}
+
+	mesosConn.Errors = nil
+	deathNodeWatcher.Run(context.Background())
+	if err := deathNodeWatcher.CheckReadiness(time.Minute); err != nil {
+		t.Fatalf("Watcher should be ready after refreshing the monitors. Actual: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	NewReadinessHandler(deathNodeWatcher, time.Minute).ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	if recorder.Code != 200 {
+		t.Fatalf("/readyz should return 200. Actual: %d", recorder.Code)
+	}
+	recorder = httptest.NewRecorder()
+	NewLivenessHandler(deathNodeWatcher, 0).ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	if recorder.Code != 503 {
+		t.Fatalf("/healthz should return 503 if the control loop is stuck. Actual: %d", recorder.Code)
+	}
+
+	// Replicas that are not the leader are ready while live
+	deathNodeWatcher.SetLeadership(&leadershipMock{})
+	deathNodeWatcher.Run(context.Background())
+	if deathNodeWatcher.CheckReadiness(time.Minute) != nil {
+		t.Fatal("Replica that is not the leader should be ready")
+	}
+}
@@ -0,0 +1,143 @@
+package deathnode
+
+// Metrics about the autoscaling groups monitored and the instances being drained
+
+import (
+	"time"
+
+	"github.com/alanbover/deathnode/metrics"
+)
+
+var (
+	autoscalingGroupsGauge = metrics.NewGauge("deathnode_autoscaling_groups",
+		"Number of autoscaling groups monitored")
+	instancesGauge = metrics.NewGauge("deathnode_instances",
+		"Number of instances by autoscaling group and lifecycle state", "autoscaling_group", "lifecycle_state")
+	instancesMarkedGauge = metrics.NewGauge("deathnode_instances_marked",
+		"Number of instances marked to be removed by autoscaling group", "autoscaling_group")
+	instancesInMaintenanceGauge = metrics.NewGauge("deathnode_instances_in_maintenance",
+		"Number of Mesos agents set in maintenance")
+	protectedTasksGauge = metrics.NewGauge("deathnode_protected_tasks_blocking_drains",
+		"Number of protected frameworks tasks running on instances marked to be removed", "autoscaling_group")
+	drainDurationHistogram = metrics.NewHistogram("deathnode_drain_duration_seconds",
+		"Time since an instance is marked to be removed until it's destroyed",
+		[]float64{60, 300, 600, 1800, 3600, 7200, 14400, 28800, 86400}, "autoscaling_group")
+	lifecycleActionsCounter = metrics.NewCounter("deathnode_lifecycle_actions_total",
+		"Number of lifecycle actions completed by autoscaling group and result", "autoscaling_group", "result")
+	lifecycleHeartbeatsCounter = metrics.NewCounter("deathnode_lifecycle_heartbeats_total",
+		"Number of lifecycle action heartbeats sent by autoscaling group and result", "autoscaling_group", "result")
+	drainsGauge = metrics.NewGauge("deathnode_drains",
+		"Number of instances being drained by autoscaling group and drain state", "autoscaling_group", "state")
+	drainTransitionsCounter = metrics.NewCounter("deathnode_drain_transitions_total",
+		"Number of drains moved to each state by autoscaling group", "autoscaling_group", "state")
+	drainStepFailuresCounter = metrics.NewCounter("deathnode_drain_step_failures_total",
+		"Number of failed drain steps by autoscaling group and drain state", "autoscaling_group", "state")
+	markedInstancesLookupFailuresCounter = metrics.NewCounter("deathnode_marked_instances_lookup_failures_total",
+		"Number of failed lookups of the instances marked to be removed by AWS connection", "connection")
+	maintenanceFailuresCounter = metrics.NewCounter("deathnode_maintenance_failures_total",
+		"Number of failed calls setting the instances in maintenance in the scheduler")
+	drainingInstancesGauge = metrics.NewGauge("deathnode_draining_instances",
+		"Number of instances being drained by autoscaling group", "autoscaling_group")
+	oldestDrainAgeGauge = metrics.NewGauge("deathnode_oldest_drain_age_seconds",
+		"Seconds since the oldest instance being drained was marked, by autoscaling group", "autoscaling_group")
+	blockedDrainsGauge = metrics.NewGauge("deathnode_blocked_drains",
+		"Number of drains waiting for protected tasks or failed, by autoscaling group", "autoscaling_group")
+	lastSuccessfulRunGauge = metrics.NewGauge("deathnode_last_successful_run_timestamp_seconds",
+		"Time of the last successful check, as unix timestamp")
+	_ = metrics.NewGaugeFunc("deathnode_seconds_since_last_successful_run",
+		"Seconds since the last successful check, or since deathnode started if there was none", secondsSinceLastSuccessfulRun)
+)
+
+var processStart = time.Now()
+
+func secondsSinceLastSuccessfulRun() float64 {
+
+	lastSuccessfulRun := lastSuccessfulRunGauge.Value()
+	if lastSuccessfulRun == 0 {
+		return time.Since(processStart).Seconds()
+	}
+	return float64(time.Now().UnixNano())/float64(time.Second) - lastSuccessfulRun
+}
+
+// updateMetrics updates the metrics about the autoscaling groups monitored and their instances
+func (y *Watcher) updateMetrics() {
+
+	autoscalingMonitors := y.autoscalingGroups.GetAllMonitors()
+	autoscalingGroupsGauge.Set(float64(len(autoscalingMonitors)))
+
+	instances := instancesGauge.NewValues()
+	instancesMarked := instancesMarkedGauge.NewValues()
+	for _, autoscalingMonitor := range autoscalingMonitors {
+		autoscalingGroupName := autoscalingMonitor.GetAutoscalingGroupName()
+		lifecycleStates := map[string]int{}
+		for _, instance := range autoscalingMonitor.GetAllInstances() {
+			lifecycleStates[instance.GetLifecycleState()]++
+		}
+		for lifecycleState, count := range lifecycleStates {
+			instances.Set(float64(count), autoscalingGroupName, lifecycleState)
+		}
+		instancesMarked.Set(float64(autoscalingMonitor.NumInstancesMarkedToBeRemoved()), autoscalingGroupName)
+	}
+	instancesGauge.Replace(instances)
+	instancesMarkedGauge.Replace(instancesMarked)
+}
+
+// MetricsPublisher pushes metrics to a monitoring system other than Prometheus, like CloudWatch
+// dimensions: map[dimensionName]value
+type MetricsPublisher interface {
+	Record(name, unit string, dimensions map[string]string, value float64)
+}
+
+// drainStats summarizes the drains of an autoscaling group
+type drainStats struct {
+	draining       int
+	blocked        int
+	oldestDrainAge time.Duration
+}
+
+// SetMetricsPublisher makes the Notebook push the drain metrics of every autoscaling group to the
+// publisher on every attempt
+func (n *Notebook) SetMetricsPublisher(publisher MetricsPublisher) {
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.metricsPublisher = publisher
+}
+
+// newDrainStats returns empty stats for all the autoscaling groups monitored, so groups without drains
+// report zeros instead of missing values
+func (n *Notebook) newDrainStats() map[string]*drainStats {
+
+	stats := map[string]*drainStats{}
+	for _, autoscalingMonitor := range n.autoscalingGroups.GetAllMonitors() {
+		stats[autoscalingMonitor.GetAutoscalingGroupName()] = &drainStats{}
+	}
+	return stats
+}
+
+// updateDrainMetrics sets the drain gauges, and pushes them to the metrics publisher, if any. It
+// expects the caller to hold the Notebook lock
+func (n *Notebook) updateDrainMetrics(stats map[string]*drainStats) {
+
+	drainingInstances := drainingInstancesGauge.NewValues()
+	oldestDrainAge := oldestDrainAgeGauge.NewValues()
+	blockedDrains := blockedDrainsGauge.NewValues()
+	defer func() {
+		drainingInstancesGauge.Replace(drainingInstances)
+		oldestDrainAgeGauge.Replace(oldestDrainAge)
+		blockedDrainsGauge.Replace(blockedDrains)
+	}()
+	for autoscalingGroupName, groupStats := range stats {
+		drainingInstances.Set(float64(groupStats.draining), autoscalingGroupName)
+		oldestDrainAge.Set(groupStats.oldestDrainAge.Seconds(), autoscalingGroupName)
+		blockedDrains.Set(float64(groupStats.blocked), autoscalingGroupName)
+
+		if n.metricsPublisher == nil {
+			continue
+		}
+		dimensions := map[string]string{"AutoScalingGroupName": autoscalingGroupName}
+		n.metricsPublisher.Record("DrainingInstances", "Count", dimensions, float64(groupStats.draining))
+		n.metricsPublisher.Record("OldestDrainAge", "Seconds", dimensions, groupStats.oldestDrainAge.Seconds())
+		n.metricsPublisher.Record("BlockedDrains", "Count", dimensions, float64(groupStats.blocked))
+	}
+}
@@ -4,36 +4,86 @@ package deathnode
 // they are not running any tasks
 
 import (
-	"github.com/alanbover/deathnode/aws"
+	"errors"
+	"fmt"
+	"github.com/alanbover/deathnode/audit"
+	"github.com/alanbover/deathnode/config"
 	"github.com/alanbover/deathnode/monitor"
+	"github.com/alanbover/deathnode/notifier"
+	"github.com/alanbover/deathnode/store"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 // Notebook stores the necessary information for deal with instances that should be deleted
+// delayDeleteSeconds is used for the autoscaling groups without a policy. The last destroy time is
+// tracked by policy name, so the delay applies to all the autoscaling groups sharing a policy. It's
+// kept, with the drains, in the state persisted in the store. maintenanceHosts is the last Mesos
+// maintenance schedule recorded in the audit log. Drains staying in the same state longer than
+// stuckAfter are notified as stuck
 type Notebook struct {
-	mesosMonitor        *monitor.MesosMonitor
-	awsConnection       aws.ClientInterface
-	autoscalingGroups   *monitor.AutoscalingGroupsMonitor
-	delayDeleteSeconds  int
-	lastDeleteTimestamp time.Time
-	deathNodeMark       string
+	mutex              sync.Mutex
+	scheduler          monitor.Scheduler
+	autoscalingGroups  *monitor.AutoscalingGroupsMonitor
+	delayDeleteSeconds int
+	store              store.Store
+	state              *store.State
+	deathNodeMark      string
+	deregisterFromLBs  bool
+	auditLogger        *audit.Logger
+	maintenanceHosts   map[string]string
+	maintenanceFailed  bool
+	notifier           notifier.Notifier
+	stuckAfter         time.Duration
+	metricsPublisher   MetricsPublisher
+	leadership         Leadership
 }
 
+// errLeadershipLost aborts the changes of a replica that is not the leader anymore
+var errLeadershipLost = errors.New("leadership lost. Aborting the changes in progress")
+
 // NewNotebook creates a notebook object, which is in charge of monitoring and delete instances marked to be deleted
-func NewNotebook(autoscalingGroups *monitor.AutoscalingGroupsMonitor, awsConn aws.ClientInterface, mesosMonitor *monitor.MesosMonitor, delayDeleteSeconds int, deathNodeMark string) *Notebook {
+func NewNotebook(autoscalingGroups *monitor.AutoscalingGroupsMonitor, scheduler monitor.Scheduler, delayDeleteSeconds int, deathNodeMark string, deregisterFromLBs bool) *Notebook {
 
 	return &Notebook{
-		mesosMonitor:        mesosMonitor,
-		awsConnection:       awsConn,
-		autoscalingGroups:   autoscalingGroups,
-		delayDeleteSeconds:  delayDeleteSeconds,
-		lastDeleteTimestamp: time.Time{},
-		deathNodeMark:       deathNodeMark,
+		scheduler:          scheduler,
+		autoscalingGroups:  autoscalingGroups,
+		delayDeleteSeconds: delayDeleteSeconds,
+		store:              store.NewMemoryStore(),
+		state:              store.New(),
+		deathNodeMark:      deathNodeMark,
+		deregisterFromLBs:  deregisterFromLBs,
 	}
 }
 
+// setLeadership makes the drains and the state saves stop as soon as the replica is not the leader
+func (n *Notebook) setLeadership(leadership Leadership) {
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.leadership = leadership
+}
+
+// isLeader returns true if this replica is the leader, or if leader election is disabled. It expects
+// the caller to hold the Notebook lock
+func (n *Notebook) isLeader() bool {
+	return n.leadership == nil || n.leadership.IsLeader()
+}
+
+// setDefaults replaces the values used for the autoscaling groups without a policy, and whether instances
+// are deregistered from their load balancers. The last destroy times are kept
+func (n *Notebook) setDefaults(delayDeleteSeconds int, deregisterFromLBs bool) {
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.delayDeleteSeconds = delayDeleteSeconds
+	n.deregisterFromLBs = deregisterFromLBs
+}
+
 func (n *Notebook) setAgentsInMaintenance(instances []*ec2.Instance) error {
 
 	hosts := map[string]string{}
@@ -41,71 +91,506 @@ func (n *Notebook) setAgentsInMaintenance(instances []*ec2.Instance) error {
 		hosts[*instance.PrivateDnsName] = *instance.PrivateIpAddress
 	}
 
-	return n.mesosMonitor.SetMesosAgentsInMaintenance(hosts)
+	err := n.scheduler.SetAgentsInMaintenance(hosts)
+	n.recordMaintenanceAudit(instances, hosts, err)
+	return err
+}
+
+// removeFromMaintenance replaces the Mesos maintenance schedule with the instances marked to be removed,
+// except the given one. It's used when the mark has just been removed, as it may still be returned by AWS
+func (n *Notebook) removeFromMaintenance(instanceID string) error {
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	instances, failedConnections := n.getInstancesMarkedToBeRemoved()
+	if err := lookupError(failedConnections); err != nil {
+		return err
+	}
+
+	remainingInstances := []*ec2.Instance{}
+	for _, instance := range instances {
+		if *instance.InstanceId != instanceID {
+			remainingInstances = append(remainingInstances, instance)
+		}
+	}
+
+	err := n.setAgentsInMaintenance(remainingInstances)
+	if err == nil {
+		instancesInMaintenanceGauge.Set(float64(len(remainingInstances)))
+	}
+	return err
+}
+
+// getInstancesMarkedToBeRemoved returns the instances with the deathnode mark for all the AWS connections
+// used to monitor the autoscaling groups. Connections failing are logged, counted and skipped, so they
+// don't stop the drains of the other ones, and returned as failed
+func (n *Notebook) getInstancesMarkedToBeRemoved() ([]*ec2.Instance, []string) {
+
+	instances := []*ec2.Instance{}
+	failedConnections := []string{}
+	for _, awsConnection := range n.autoscalingGroups.GetAWSConnections() {
+		response, err := awsConnection.DescribeInstancesByTag(n.deathNodeMark)
+		if err != nil {
+			log.Errorf("Unable to find instances with %s tag in AWS connection %s: %v", n.deathNodeMark, awsConnection.Name(), err)
+			markedInstancesLookupFailuresCounter.Inc(awsConnection.Name())
+			failedConnections = append(failedConnections, awsConnection.Name())
+			continue
+		}
+		instances = append(instances, response...)
+	}
+
+	return instances, failedConnections
+}
+
+// lookupError returns an error if the instances marked of any AWS connection couldn't be found
+func lookupError(failedConnections []string) error {
+
+	if len(failedConnections) == 0 {
+		return nil
+	}
+	return fmt.Errorf("unable to find the instances marked to be removed in AWS connections %s",
+		strings.Join(failedConnections, ", "))
 }
 
-// DestroyInstancesAttempt iterates around all instances marked to be deleted, and:
-// - set them in maintenance
-// - remove instance protection
-// - complete lifecycle action if there is no tasks running from the protected frameworks
+// DestroyInstancesAttempt iterates around all instances marked to be deleted, moving their drains as far as
+// possible:
+// - Marked: remove instance protection
+// - Unprotected: set them in maintenance
+// - InMaintenance: wait for the protected frameworks tasks to finish, and for the termination lifecycle
+// - Draining: deregister them from their load balancers, if enabled, waiting for connection draining
+// - Drained: complete lifecycle action
+// A failed step is retried with backoff. After monitor.MaxDrainAttempts failed attempts in a row, the
+// drain is flagged as failed, but it's still retried
 func (n *Notebook) DestroyInstancesAttempt() error {
 
-	// Get instances marked for removal
-	instances, err := n.awsConnection.DescribeInstancesByTag(n.deathNodeMark)
-	if err != nil {
-		log.Debugf("Unable to find instances with %s tag", n.deathNodeMark)
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	// The state may have been saved by other replica, while it was the leader
+	if err := n.loadState(); err != nil {
 		return err
 	}
+	if !n.isLeader() {
+		return errLeadershipLost
+	}
+
+	// Get instances marked for removal. If some AWS connections fail, the drains of their instances are
+	// left as they are, and the drains of the other ones go on
+	instances, failedConnections := n.getInstancesMarkedToBeRemoved()
+	lookupErr := lookupError(failedConnections)
+	n.reconcileDrains(instances, lookupErr == nil)
 
-	// Set instances in maintenance
-	n.setAgentsInMaintenance(instances)
+	// Set instances from all AWS accounts and regions in maintenance at once. The maintenance schedule is
+	// replaced, so it's kept as it is while the instances of any connection are unknown
+	var maintenanceErr error
+	if lookupErr != nil {
+		maintenanceErr = lookupErr
+		log.Warnf("Not updating the maintenance schedule: %v", lookupErr)
+	} else if maintenanceErr = n.setAgentsInMaintenance(instances); maintenanceErr != nil {
+		log.Errorf("Unable to set instances in maintenance: %v", maintenanceErr)
+		maintenanceFailuresCounter.Inc()
+	} else {
+		instancesInMaintenanceGauge.Set(float64(len(instances)))
+	}
+
+	protectedTasks := map[string]int{}
+	drainStates := map[string]map[monitor.DrainState]int{}
+	stats := n.newDrainStats()
+	defer func() {
+		n.updateDrainMetrics(stats)
+		protectedTasksValues := protectedTasksGauge.NewValues()
+		for autoscalingGroupName, count := range protectedTasks {
+			protectedTasksValues.Set(float64(count), autoscalingGroupName)
+		}
+		protectedTasksGauge.Replace(protectedTasksValues)
+		drainsValues := drainsGauge.NewValues()
+		for autoscalingGroupName, states := range drainStates {
+			for state, count := range states {
+				drainsValues.Set(float64(count), autoscalingGroupName, string(state))
+			}
+		}
+		drainsGauge.Replace(drainsValues)
+	}()
 
 	for _, instance := range instances {
 
+		// The lease may expire while the drains are moved, as AWS and the scheduler are called
+		if !n.isLeader() {
+			return errLeadershipLost
+		}
 		log.Debugf("Starting process to delete instance %s", *instance.InstanceId)
 
 		instanceMonitor, err := n.autoscalingGroups.GetInstanceByID(*instance.InstanceId)
 		if err != nil {
 			return err
 		}
+		if instanceMonitor.GetDrainState() == monitor.DrainCancelled {
+			log.Debugf("Instance %s has just been unmarked. Skipping it", *instance.InstanceId)
+			continue
+		}
+		policy := n.policyFor(*instance.InstanceId)
 
-		// If the instance is protected, remove instance protection
-		n.removeInstanceProtection(instanceMonitor)
+		tasks := n.getProtectedFrameworksTasks(policy, *instance.PrivateIpAddress)
+		autoscalingGroupName := *instanceMonitor.GetAutoscalingGroupID()
+		protectedTasks[autoscalingGroupName] += len(tasks)
 
-		// Next iteration if an instance was previously deleted before delayDeleteSeconds
-		if n.delayDeleteSeconds != 0 && time.Since(n.lastDeleteTimestamp).Seconds() < float64(n.delayDeleteSeconds) {
-			log.Debugf("Seconds since last destroy: %v. No instances will be destroyed", time.Since(n.lastDeleteTimestamp).Seconds())
-			continue
+		markTime := n.markTime(instance)
+		n.advanceDrain(policy, instanceMonitor, tasks, markTime, maintenanceErr)
+		n.sendLifecycleHeartbeat(instanceMonitor)
+		n.notifyStuckDrain(instanceMonitor)
+		n.addDrainStats(stats, policy, instanceMonitor, tasks, markTime)
+
+		if drainStates[autoscalingGroupName] == nil {
+			drainStates[autoscalingGroupName] = map[monitor.DrainState]int{}
 		}
+		drainStates[autoscalingGroupName][instanceMonitor.GetDrainState()]++
+	}
+
+	return lookupErr
+}
+
+// addDrainStats adds the drain of the instance to the stats of it's autoscaling group. Drains are
+// blocked if they wait for protected tasks, or failed
+func (n *Notebook) addDrainStats(stats map[string]*drainStats, policy *config.Policy, instance *monitor.InstanceMonitor, tasks []monitor.Task, markTime time.Time) {
+
+	groupStats, ok := stats[*instance.GetAutoscalingGroupID()]
+	if !ok {
+		groupStats = &drainStats{}
+		stats[*instance.GetAutoscalingGroupID()] = groupStats
+	}
+
+	groupStats.draining++
+	if !markTime.IsZero() && time.Since(markTime) > groupStats.oldestDrainAge {
+		groupStats.oldestDrainAge = time.Since(markTime)
+	}
+	state := instance.GetDrainState()
+	if state == monitor.DrainFailed ||
+		(state == monitor.DrainInMaintenance && len(tasks) > 0 && !n.isDrainTimeoutExpired(policy, markTime)) {
+		groupStats.blocked++
+	}
+}
+
+// advanceDrain runs the steps of the drain of an instance until one of them has to wait or fails. A
+// failed step is not retried until it's backoff expires, and Failed drains retry the step they failed at.
+// It expects the caller to hold the Notebook lock
+func (n *Notebook) advanceDrain(policy *config.Policy, instance *monitor.InstanceMonitor, tasks []monitor.Task, markTime time.Time, maintenanceErr error) {
+
+	if drain, ok := instance.GetDrain(); ok && drain.IsWaitingRetry() {
+		log.Debugf("Drain of instance %s waiting until %s to retry %s", *instance.GetInstanceID(), drain.RetryAt, drain.Step())
+		return
+	}
 
-		// If the instance have no tasks from protected frameworks, delete it
-		hasFrameworks := n.mesosMonitor.HasProtectedFrameworksTasks(*instance.PrivateIpAddress)
-		if !hasFrameworks {
-			if instanceMonitor.GetLifecycleState() == "Terminating:Wait" {
-				log.Infof("Destroy instance %s", *instanceMonitor.GetInstanceID())
-				err := n.awsConnection.CompleteLifecycleAction(instanceMonitor.GetAutoscalingGroupID(), instanceMonitor.GetInstanceID())
-				if err != nil {
-					log.Errorf("Unable to complete lifecycle action on instance %s", *instance.InstanceId)
-				}
-				if n.delayDeleteSeconds != 0 {
-					n.lastDeleteTimestamp = time.Now()
-					continue
-				}
-			} else {
-				log.Debugf("Instance %s waiting for AWS to start termination lifecycle", *instance.InstanceId)
+	for {
+		state := instance.GetDrainState()
+		step := instance.GetDrainStep()
+		nextState, err := n.drainStep(step, policy, instance, tasks, markTime, maintenanceErr)
+		if err != nil {
+			n.drainStepFailed(instance, step, err)
+			return
+		}
+		if nextState == step {
+			return
+		}
+
+		err = instance.TransitionDrain(nextState)
+		if err != nil {
+			log.Errorf("Unable to move drain of instance %s: %v", *instance.GetInstanceID(), err)
+			return
+		}
+		log.Debugf("Drain of instance %s moved from %s to %s", *instance.GetInstanceID(), state, nextState)
+		drainTransitionsCounter.Inc(*instance.GetAutoscalingGroupID(), string(nextState))
+		n.saveDrain(instance)
+		n.notifyTransition(instance, nextState)
+	}
+}
+
+// drainStep runs the step of the drain for the instance state, returning the state it has to be moved
+// to. The same state is returned if the step has to wait
+func (n *Notebook) drainStep(state monitor.DrainState, policy *config.Policy, instance *monitor.InstanceMonitor, tasks []monitor.Task, markTime time.Time, maintenanceErr error) (monitor.DrainState, error) {
+
+	switch state {
+	case monitor.DrainMarked:
+		if err := n.removeInstanceProtection(instance); err != nil {
+			return state, err
+		}
+		return monitor.DrainUnprotected, nil
+	case monitor.DrainUnprotected:
+		// The maintenance call is shared by all the instances, so it's failures are not charged to them
+		if maintenanceErr != nil {
+			log.Debugf("Instance %s waiting for the scheduler maintenance to be set", *instance.GetInstanceID())
+			return state, nil
+		}
+		return monitor.DrainInMaintenance, nil
+	case monitor.DrainInMaintenance:
+		switch n.waitReason(policy, instance, tasks, markTime) {
+		case NextActionWaitDelayDelete:
+			log.Debugf("Seconds since last destroy: %v. No instances will be destroyed", time.Since(n.state.LastDeleteTimestamps[policy.Name]).Seconds())
+		case NextActionWaitProtectedTasks:
+			log.Debugf("Instance %s can't be deleted. It contains tasks from protected frameworks", *instance.GetInstanceID())
+		case NextActionWaitTerminationLifecycle:
+			log.Debugf("Instance %s waiting for AWS to start termination lifecycle", *instance.GetInstanceID())
+		default:
+			// Tasks are only left when the drain timeout expired
+			if len(tasks) > 0 {
+				n.notifyDrain(notifier.EventDrainTimeout, n.state.Drains[*instance.GetInstanceID()])
 			}
-		} else {
-			log.Debugf("Instance %s can't be deleted. It contains tasks from protected frameworks", *instance.InstanceId)
+			return monitor.DrainDraining, nil
+		}
+		return state, nil
+	case monitor.DrainDraining:
+		if !n.deregisterFromLBs {
+			return monitor.DrainDrained, nil
+		}
+		drained, err := n.drainFromLoadBalancers(instance)
+		if err != nil || !drained {
+			return state, err
+		}
+		return monitor.DrainDrained, nil
+	case monitor.DrainDrained:
+		// Other instance under the same policy may have been deleted while this one was draining
+		if n.isWaitingDelayDelete(policy) {
+			log.Debugf("Instance %s drained, but waiting for the delay between deletes", *instance.GetInstanceID())
+			return state, nil
 		}
+		if err := n.completeLifecycleAction(policy, instance, tasks, markTime); err != nil {
+			return state, err
+		}
+		return monitor.DrainLifecycleCompleted, nil
 	}
 
+	// LifecycleCompleted drains wait for AWS to terminate the instance
+	return state, nil
+}
+
+// sendLifecycleHeartbeat extends the termination lifecycle action of an instance still being drained every
+// monitor.LifecycleHeartbeatInterval, so AWS doesn't terminate it once the lifecycle hook times out. It
+// expects the caller to hold the Notebook lock
+func (n *Notebook) sendLifecycleHeartbeat(instance *monitor.InstanceMonitor) {
+
+	drain, ok := instance.GetDrain()
+	if !ok || drain.IsFinished() || drain.State == monitor.DrainLifecycleCompleted ||
+		instance.GetLifecycleState() != "Terminating:Wait" || time.Since(drain.LastHeartbeat) < monitor.LifecycleHeartbeatInterval {
+		return
+	}
+
+	err := instance.RecordLifecycleActionHeartbeat()
+	if err != nil {
+		log.Warnf("Unable to extend the lifecycle action of instance %s: %v", *instance.GetInstanceID(), err)
+		lifecycleHeartbeatsCounter.Inc(*instance.GetAutoscalingGroupID(), "failed")
+		return
+	}
+	log.Debugf("Lifecycle action of instance %s extended", *instance.GetInstanceID())
+	lifecycleHeartbeatsCounter.Inc(*instance.GetAutoscalingGroupID(), "sent")
+	n.saveDrain(instance)
+}
+
+// drainStepFailed records a failed attempt of the current step of the drain. It expects the caller to
+// hold the Notebook lock
+func (n *Notebook) drainStepFailed(instance *monitor.InstanceMonitor, state monitor.DrainState, err error) {
+
+	log.Errorf("Drain of instance %s failed at %s: %v", *instance.GetInstanceID(), state, err)
+	drainStepFailuresCounter.Inc(*instance.GetAutoscalingGroupID(), string(state))
+	failed := instance.RecordDrainFailure(err)
+	if failed {
+		log.Errorf("Drain of instance %s failed %d times in a row at %s. Moved to %s, it keeps being retried",
+			*instance.GetInstanceID(), monitor.MaxDrainAttempts, state, monitor.DrainFailed)
+		drainTransitionsCounter.Inc(*instance.GetAutoscalingGroupID(), string(monitor.DrainFailed))
+	}
+	n.saveDrain(instance)
+	if failed {
+		n.notifyDrain(notifier.EventDrainFailed, n.state.Drains[*instance.GetInstanceID()])
+	}
+}
+
+// Actions the Notebook takes for an instance marked to be removed, in order
+const (
+	NextActionRemoveInstanceProtection = "RemoveInstanceProtection"
+	NextActionSetInMaintenance         = "SetInMaintenance"
+	NextActionWaitDelayDelete          = "WaitDelayDelete"
+	NextActionWaitProtectedTasks       = "WaitProtectedTasks"
+	NextActionWaitTerminationLifecycle = "WaitTerminationLifecycle"
+	NextActionDrainFromLoadBalancers   = "DrainFromLoadBalancers"
+	NextActionCompleteLifecycleAction  = "CompleteLifecycleAction"
+	NextActionWaitTermination          = "WaitTermination"
+	NextActionWaitRetry                = "WaitRetry"
+	NextActionNone                     = "None"
+)
+
+// nextAction returns the next action the Notebook will take for an instance marked to be removed, given
+// the state of it's drain and the tasks from protected frameworks running on it. It expects the caller
+// to hold the Notebook lock
+func (n *Notebook) nextAction(policy *config.Policy, instance *monitor.InstanceMonitor, tasks []monitor.Task, markTime time.Time) string {
+
+	if drain, ok := instance.GetDrain(); ok && drain.IsWaitingRetry() {
+		return NextActionWaitRetry
+	}
+
+	switch instance.GetDrainStep() {
+	case monitor.DrainMarked:
+		return NextActionRemoveInstanceProtection
+	case monitor.DrainUnprotected:
+		return NextActionSetInMaintenance
+	case monitor.DrainInMaintenance:
+		if waitReason := n.waitReason(policy, instance, tasks, markTime); waitReason != "" {
+			return waitReason
+		}
+		if n.deregisterFromLBs {
+			return NextActionDrainFromLoadBalancers
+		}
+		return NextActionCompleteLifecycleAction
+	case monitor.DrainDraining:
+		return NextActionDrainFromLoadBalancers
+	case monitor.DrainDrained:
+		if n.isWaitingDelayDelete(policy) {
+			return NextActionWaitDelayDelete
+		}
+		return NextActionCompleteLifecycleAction
+	case monitor.DrainLifecycleCompleted:
+		return NextActionWaitTermination
+	}
+	return NextActionNone
+}
+
+// waitReason returns why an instance in maintenance can't start to be removed yet, or an empty string if
+// it can. It expects the caller to hold the Notebook lock
+func (n *Notebook) waitReason(policy *config.Policy, instance *monitor.InstanceMonitor, tasks []monitor.Task, markTime time.Time) string {
+
+	if n.isWaitingDelayDelete(policy) {
+		return NextActionWaitDelayDelete
+	}
+
+	// Tasks from protected frameworks are ignored once the drain timeout expires
+	if len(tasks) > 0 && !n.isDrainTimeoutExpired(policy, markTime) {
+		return NextActionWaitProtectedTasks
+	}
+
+	if instance.GetLifecycleState() != "Terminating:Wait" {
+		return NextActionWaitTerminationLifecycle
+	}
+	return ""
+}
+
+// isWaitingDelayDelete returns true if an instance under the same policy was deleted less than
+// delayDeleteSeconds ago
+func (n *Notebook) isWaitingDelayDelete(policy *config.Policy) bool {
+
+	lastDeleteTimestamp := n.state.LastDeleteTimestamps[policy.Name]
+	return policy.DelayDeleteSeconds != 0 && time.Since(lastDeleteTimestamp).Seconds() < float64(policy.DelayDeleteSeconds)
+}
+
+// completeLifecycleAction lets AWS terminate the instance
+func (n *Notebook) completeLifecycleAction(policy *config.Policy, instance *monitor.InstanceMonitor, tasks []monitor.Task, markTime time.Time) error {
+
+	if len(tasks) > 0 {
+		log.Warnf("Instance %s drain timeout (%d seconds) expired. Destroying it with tasks from protected frameworks",
+			*instance.GetInstanceID(), policy.DrainTimeoutSeconds)
+	}
+
+	log.Infof("Destroy instance %s", *instance.GetInstanceID())
+	err := instance.CompleteLifecycleAction()
+	n.recordInstanceAudit(audit.ActionCompleteLifecycleAction, instance, "", nil, err)
+	if err != nil {
+		lifecycleActionsCounter.Inc(*instance.GetAutoscalingGroupID(), "failed")
+		return err
+	}
+
+	lifecycleActionsCounter.Inc(*instance.GetAutoscalingGroupID(), "completed")
+	if !markTime.IsZero() {
+		drainDurationHistogram.Observe(time.Since(markTime).Seconds(), *instance.GetAutoscalingGroupID())
+	}
+	if policy.DelayDeleteSeconds != 0 {
+		n.state.LastDeleteTimestamps[policy.Name] = time.Now()
+		n.saveState()
+	}
 	return nil
 }
 
+// policyFor returns the policy of the autoscaling group the instance belongs to. If it has no policy, one
+// is built from the Notebook defaults
+func (n *Notebook) policyFor(instanceID string) *config.Policy {
+
+	autoscalingMonitor, err := n.autoscalingGroups.GetAutoscalingGroupByInstanceID(instanceID)
+	if err == nil && autoscalingMonitor.GetPolicy() != nil {
+		return autoscalingMonitor.GetPolicy()
+	}
+
+	return &config.Policy{
+		Name:               config.DefaultPolicyName,
+		DelayDeleteSeconds: n.delayDeleteSeconds,
+	}
+}
+
+// getProtectedFrameworksTasks returns the tasks from the policy protected frameworks, or from all the
+// protected frameworks if the policy doesn't set them
+func (n *Notebook) getProtectedFrameworksTasks(policy *config.Policy, ipAddress string) []monitor.Task {
+
+	if len(policy.ProtectedFrameworks) == 0 {
+		return n.scheduler.GetBlockingTasks(ipAddress, nil)
+	}
+	return n.scheduler.GetBlockingTasks(ipAddress, policy.ProtectedFrameworks)
+}
+
+// isDrainTimeoutExpired returns true if the instance was marked to be removed more than the policy
+// drain timeout ago. It's never expired if the mark time is unknown
+func (n *Notebook) isDrainTimeoutExpired(policy *config.Policy, markTime time.Time) bool {
+
+	if policy.DrainTimeoutSeconds == 0 || markTime.IsZero() {
+		return false
+	}
+
+	return time.Since(markTime) > time.Duration(policy.DrainTimeoutSeconds)*time.Second
+}
+
+// markTime returns the time the instance was marked to be removed, which is the value of the deathnode
+// mark tag. Returns zero if it's not found
+func (n *Notebook) markTime(instance *ec2.Instance) time.Time {
+
+	for _, tag := range instance.Tags {
+		if *tag.Key != n.deathNodeMark {
+			continue
+		}
+		markTimestamp, err := strconv.ParseInt(*tag.Value, 10, 64)
+		if err != nil {
+			log.Warnf("Unable to parse %s tag value %s for instance %s", n.deathNodeMark, *tag.Value, *instance.InstanceId)
+			return time.Time{}
+		}
+		return time.Unix(markTimestamp, 0)
+	}
+
+	return time.Time{}
+}
+
+// drainFromLoadBalancers deregisters the instance from it's load balancers, returning true once
+// connection draining has finished
+func (n *Notebook) drainFromLoadBalancers(instance *monitor.InstanceMonitor) (bool, error) {
+
+	if !instance.IsDeregisteredFromLoadBalancers() {
+		log.Infof("Deregister instance %s from load balancers", *instance.GetInstanceID())
+		err := instance.DeregisterFromLoadBalancers()
+		n.recordInstanceAudit(audit.ActionDeregisterFromLoadBalancers, instance, "", nil, err)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	drained, err := instance.IsDrainedFromLoadBalancers()
+	if err != nil {
+		return false, err
+	}
+	if !drained {
+		log.Debugf("Instance %s waiting for load balancers connection draining", *instance.GetInstanceID())
+	}
+
+	return drained, nil
+}
+
 func (n *Notebook) removeInstanceProtection(instance *monitor.InstanceMonitor) error {
 
 	if instance.IsProtected() {
-		return instance.RemoveInstanceProtection()
+		err := instance.RemoveInstanceProtection()
+		n.recordInstanceAudit(audit.ActionRemoveInstanceProtection, instance, "", nil, err)
+		return err
 	}
 	return nil
 }
@@ -1,10 +1,17 @@
 package deathnode
 
 import (
+	"errors"
 	"github.com/alanbover/deathnode/aws"
+	"github.com/alanbover/deathnode/config"
 	"testing"
+	"time"
 	"github.com/alanbover/deathnode/monitor"
+	"github.com/alanbover/deathnode/notifier"
+	"github.com/alanbover/deathnode/store"
 	"github.com/alanbover/deathnode/mesos"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -69,7 +76,7 @@ func TestDestroyInstanceAttempt(t *testing.T) {
 						"GetMesosSlaves":     {"default"},
 						"GetMesosTasks":      {"notasks"},
 					}
-					notebook.mesosMonitor.Refresh()
+					notebook.scheduler.Refresh()
 					notebook.DestroyInstancesAttempt()
 					Convey("completeLifeCycle should not be called if instance lifeCycleState is not in waiting state", func() {
 						So(awsConn.Requests["CompleteLifecycleAction"], ShouldBeNil)
@@ -89,6 +96,38 @@ func TestDestroyInstanceAttempt(t *testing.T) {
 				})
 			})
 		})
+		Convey("if there is a instance marked to be removed and load balancers deregistration is enabled", func() {
+			notebook.deregisterFromLBs = true
+			awsConn.Records = map[string]*[]string{
+				"DescribeInstanceById": {
+					"node1", "node2", "node3",
+				},
+				"DescribeInstancesByTag":             {"one_undesired_host", "one_undesired_host"},
+				"DescribeAGByName":                   {"one_undesired_host_one_terminating_load_balancers"},
+				"IsInstanceDrainedFromLoadBalancers": {"false", "true"},
+			}
+			mesosConn.Records = map[string]*[]string{
+				"GetMesosFrameworks": {"default"},
+				"GetMesosSlaves":     {"default"},
+				"GetMesosTasks":      {"notasks"},
+			}
+			notebook.autoscalingGroups.Refresh()
+			notebook.scheduler.Refresh()
+			notebook.DestroyInstancesAttempt()
+			Convey("it should be deregistered from all it's load balancers", func() {
+				callArguments := awsConn.Requests["DeregisterInstanceFromLoadBalancers"]
+				So(len(callArguments), ShouldEqual, 1)
+				So(callArguments[0][0], ShouldEqual, "i-34719eb8")
+				So(callArguments[0][1], ShouldEqual, "some-Load-Balancer")
+				So(len(callArguments[0]), ShouldEqual, 3)
+			})
+			Convey("completeLifeCycle should not be called until connection draining finishes", func() {
+				So(awsConn.Requests["CompleteLifecycleAction"], ShouldBeNil)
+				notebook.DestroyInstancesAttempt()
+				So(len(awsConn.Requests["CompleteLifecycleAction"]), ShouldEqual, 1)
+				So(len(awsConn.Requests["DeregisterInstanceFromLoadBalancers"]), ShouldEqual, 1)
+			})
+		})
 		Convey("if there is two instances marked to be removed", func() {
 			awsConn.Records = map[string]*[]string{
 				"DescribeInstanceById": {
@@ -104,7 +143,7 @@ func TestDestroyInstanceAttempt(t *testing.T) {
 					"GetMesosSlaves":     {"default"},
 					"GetMesosTasks":      {"notasks"},
 				}
-				notebook.mesosMonitor.Refresh()
+				notebook.scheduler.Refresh()
 				notebook.DestroyInstancesAttempt()
 				So(awsConn.Requests["CompleteLifecycleAction"], ShouldNotBeNil)
 				So(len(awsConn.Requests["CompleteLifecycleAction"]), ShouldEqual, 2)
@@ -116,7 +155,7 @@ func TestDestroyInstanceAttempt(t *testing.T) {
 					"GetMesosSlaves":     {"default"},
 					"GetMesosTasks":      {"notasks"},
 				}
-				notebook.mesosMonitor.Refresh()
+				notebook.scheduler.Refresh()
 				notebook.DestroyInstancesAttempt()
 				So(awsConn.Requests["CompleteLifecycleAction"], ShouldNotBeNil)
 				So(len(awsConn.Requests["CompleteLifecycleAction"]), ShouldEqual, 1)
@@ -125,6 +164,352 @@ func TestDestroyInstanceAttempt(t *testing.T) {
 	})
 }
 
+func TestDestroyInstanceAttemptWithPolicy(t *testing.T) {
+
+	Convey("When running DestroyInstancesAttempt for an autoscaling group with a policy", t, func() {
+		awsConn := &aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {
+					"node1", "node2", "node3",
+				},
+				"DescribeInstancesByTag": {"one_undesired_host"},
+				"DescribeAGByName":       {"one_undesired_host_one_terminating"},
+			},
+		}
+
+		mesosConn := &mesos.ClientMock{
+			Records: map[string]*[]string{
+				"GetMesosFrameworks": {"default"},
+				"GetMesosSlaves":     {"default"},
+				"GetMesosTasks":      {"default"},
+			},
+		}
+		policy := &config.Policy{
+			Name:                "some-Autoscaling-Group",
+			ProtectedFrameworks: []string{"frameworkName1"},
+		}
+		notebook := newNotebookWithPolicy(awsConn, mesosConn, policy)
+
+		Convey("if the instance has tasks from the policy protected frameworks, completeLifeCycle should not be called", func() {
+			notebook.DestroyInstancesAttempt()
+			So(awsConn.Requests["CompleteLifecycleAction"], ShouldBeNil)
+		})
+		Convey("if the instance waits for the policy protected frameworks, it's lifecycle action should be extended", func() {
+			awsConn.Records["DescribeInstancesByTag"] = &[]string{"one_undesired_host", "one_undesired_host"}
+			notebook.DestroyInstancesAttempt()
+			notebook.DestroyInstancesAttempt()
+			So(awsConn.Requests["RecordLifecycleActionHeartbeat"], ShouldResemble, [][]string{{"some-Autoscaling-Group", "i-34719eb8"}})
+			So(notebook.state.Drains["i-34719eb8"].Heartbeats, ShouldEqual, 1)
+		})
+		Convey("if the instance has tasks only from frameworks not protected by the policy, completeLifeCycle should be called", func() {
+			policy.ProtectedFrameworks = []string{"frameworkName2"}
+			notebook.DestroyInstancesAttempt()
+			So(len(awsConn.Requests["CompleteLifecycleAction"]), ShouldEqual, 1)
+		})
+		Convey("if the policy drain timeout has not expired, completeLifeCycle should not be called", func() {
+			policy.DrainTimeoutSeconds = 3600
+			notebook.DestroyInstancesAttempt()
+			So(awsConn.Requests["CompleteLifecycleAction"], ShouldBeNil)
+		})
+		Convey("if the policy drain timeout has expired, completeLifeCycle should be called", func() {
+			policy.DrainTimeoutSeconds = 3600
+			awsConn.Records["DescribeInstancesByTag"] = &[]string{"one_undesired_host_marked_long_ago"}
+			eventNotifier := &notifierMock{}
+			notebook.SetNotifier(eventNotifier, 0)
+			notebook.DestroyInstancesAttempt()
+			So(len(awsConn.Requests["CompleteLifecycleAction"]), ShouldEqual, 1)
+			So(eventNotifier.types(), ShouldContain, notifier.EventDrainTimeout)
+		})
+	})
+}
+
+func TestDestroyInstanceAttemptWithStore(t *testing.T) {
+
+	Convey("When running DestroyInstancesAttempt with a store", t, func() {
+		newConnections := func() (*aws.ConnectionMock, *mesos.ClientMock) {
+			awsConn := &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById": {
+						"node1", "node2", "node3",
+					},
+					"DescribeInstancesByTag": {"one_undesired_host"},
+					"DescribeAGByName":       {"one_undesired_host_one_terminating"},
+				},
+			}
+			mesosConn := &mesos.ClientMock{
+				Records: map[string]*[]string{
+					"GetMesosFrameworks": {"default"},
+					"GetMesosSlaves":     {"default"},
+					"GetMesosTasks":      {"notasks"},
+				},
+			}
+			return awsConn, mesosConn
+		}
+		policy := &config.Policy{
+			Name:               "some-Autoscaling-Group",
+			DelayDeleteSeconds: 100,
+		}
+
+		stateStore := store.NewMemoryStore()
+		state := store.New()
+		state.Drains["i-00000000"] = &store.Drain{InstanceID: "i-00000000", RequestedBy: store.RequestedByOperator}
+		stateStore.Save(state)
+
+		awsConn, mesosConn := newConnections()
+		notebook := newNotebookWithPolicy(awsConn, mesosConn, policy)
+		So(notebook.SetStore(stateStore), ShouldBeNil)
+		notebook.DestroyInstancesAttempt()
+		So(len(awsConn.Requests["CompleteLifecycleAction"]), ShouldEqual, 1)
+
+		Convey("the drains should be reconciled with the instances marked in EC2", func() {
+			state, _ := stateStore.Load()
+			So(state.Drains, ShouldNotContainKey, "i-00000000")
+			So(state.Drains, ShouldContainKey, "i-34719eb8")
+			So(state.Drains["i-34719eb8"].RequestedBy, ShouldEqual, store.RequestedByUnknown)
+			So(state.Drains["i-34719eb8"].AutoscalingGroup, ShouldEqual, "some-Autoscaling-Group")
+		})
+		Convey("the drain state should be saved with the time of every transition", func() {
+			state, _ := stateStore.Load()
+			So(state.Drains["i-34719eb8"].State, ShouldEqual, string(monitor.DrainLifecycleCompleted))
+			So(state.Drains["i-34719eb8"].Transitions, ShouldContainKey, string(monitor.DrainInMaintenance))
+		})
+		Convey("the drain state should be restored after a restart", func() {
+			awsConn, mesosConn := newConnections()
+			notebook := newNotebookWithPolicy(awsConn, mesosConn, policy)
+			So(notebook.SetStore(stateStore), ShouldBeNil)
+			notebook.DestroyInstancesAttempt()
+			instanceMonitor, _ := notebook.autoscalingGroups.GetInstanceByID("i-34719eb8")
+			So(instanceMonitor.GetDrainState(), ShouldEqual, monitor.DrainLifecycleCompleted)
+			So(awsConn.Requests["CompleteLifecycleAction"], ShouldBeNil)
+		})
+		Convey("the delay between deletes should be kept after a restart", func() {
+			state, _ := stateStore.Load()
+			state.Drains = map[string]*store.Drain{}
+			stateStore.Save(state)
+
+			awsConn, mesosConn := newConnections()
+			notebook := newNotebookWithPolicy(awsConn, mesosConn, policy)
+			So(notebook.SetStore(stateStore), ShouldBeNil)
+			notebook.DestroyInstancesAttempt()
+			instanceMonitor, _ := notebook.autoscalingGroups.GetInstanceByID("i-34719eb8")
+			So(instanceMonitor.GetDrainState(), ShouldEqual, monitor.DrainInMaintenance)
+			So(awsConn.Requests["CompleteLifecycleAction"], ShouldBeNil)
+		})
+	})
+}
+
+func TestDestroyInstanceAttemptRetries(t *testing.T) {
+
+	Convey("When running DestroyInstancesAttempt and setting instances in maintenance fails", t, func() {
+		awsConn := &aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {
+					"node1", "node2", "node3",
+				},
+				"DescribeInstancesByTag": {"one_undesired_host", "one_undesired_host", "one_undesired_host",
+					"one_undesired_host", "one_undesired_host", "one_undesired_host"},
+				"DescribeAGByName": {"one_undesired_host_one_terminating"},
+			},
+		}
+		mesosConn := &mesos.ClientMock{
+			Records: map[string]*[]string{
+				"GetMesosFrameworks": {"default"},
+				"GetMesosSlaves":     {"default"},
+				"GetMesosTasks":      {"notasks"},
+			},
+			Errors: map[string]error{
+				"SetHostInMaintenance": errors.New("mesos unavailable"),
+			},
+		}
+		notebook := newNotebook(awsConn, mesosConn, 0)
+		instanceMonitor, _ := notebook.autoscalingGroups.GetInstanceByID("i-34719eb8")
+		notebook.DestroyInstancesAttempt()
+
+		Convey("the drain should wait in Unprotected state, without counting it as a failed attempt", func() {
+			drain, _ := instanceMonitor.GetDrain()
+			So(drain.State, ShouldEqual, monitor.DrainUnprotected)
+			So(drain.Attempts, ShouldEqual, 0)
+			So(maintenanceFailuresCounter.Value(), ShouldBeGreaterThan, 0)
+		})
+		Convey("the drain should continue once the maintenance is set", func() {
+			delete(mesosConn.Errors, "SetHostInMaintenance")
+			notebook.DestroyInstancesAttempt()
+			So(instanceMonitor.GetDrainState(), ShouldEqual, monitor.DrainLifecycleCompleted)
+			So(len(awsConn.Requests["CompleteLifecycleAction"]), ShouldEqual, 1)
+		})
+	})
+
+	Convey("When running DestroyInstancesAttempt and completing the lifecycle action fails", t, func() {
+		awsConn := &failingLifecycleConnection{
+			ConnectionMock: &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById": {
+						"node1", "node2", "node3",
+					},
+					"DescribeInstancesByTag": {"one_undesired_host", "one_undesired_host", "one_undesired_host",
+						"one_undesired_host", "one_undesired_host", "one_undesired_host", "one_undesired_host",
+						"one_undesired_host"},
+					"DescribeAGByName": {"one_undesired_host_one_terminating"},
+				},
+			},
+			err: errors.New("aws unavailable"),
+		}
+		mesosConn := &mesos.ClientMock{
+			Records: map[string]*[]string{
+				"GetMesosFrameworks": {"default"},
+				"GetMesosSlaves":     {"default"},
+				"GetMesosTasks":      {"notasks"},
+			},
+		}
+		notebook := newNotebook(awsConn, mesosConn, 0)
+		instanceMonitor, _ := notebook.autoscalingGroups.GetInstanceByID("i-34719eb8")
+
+		Convey("the failed attempt should be counted, and not retried until the backoff expires", func() {
+			notebook.DestroyInstancesAttempt()
+			drain, _ := instanceMonitor.GetDrain()
+			So(drain.State, ShouldEqual, monitor.DrainDrained)
+			So(drain.Attempts, ShouldEqual, 1)
+			So(drain.LastError, ShouldEqual, "aws unavailable")
+			notebook.DestroyInstancesAttempt()
+			So(awsConn.attempts, ShouldEqual, 1)
+		})
+		Convey("the drain should fail after monitor.MaxDrainAttempts, and keep retrying the step", func() {
+			defer func(backoff time.Duration) { monitor.DrainRetryBackoff = backoff }(monitor.DrainRetryBackoff)
+			monitor.DrainRetryBackoff = 0
+			for attempt := 0; attempt < monitor.MaxDrainAttempts; attempt++ {
+				notebook.DestroyInstancesAttempt()
+			}
+			drain, _ := instanceMonitor.GetDrain()
+			So(drain.State, ShouldEqual, monitor.DrainFailed)
+			So(drain.FailedStep, ShouldEqual, monitor.DrainDrained)
+
+			awsConn.err = nil
+			notebook.DestroyInstancesAttempt()
+			So(instanceMonitor.GetDrainState(), ShouldEqual, monitor.DrainLifecycleCompleted)
+			drain, _ = instanceMonitor.GetDrain()
+			So(drain.FailedStep, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestDestroyInstanceAttemptWithFailingConnection(t *testing.T) {
+
+	Convey("When running DestroyInstancesAttempt and finding the marked instances of an AWS connection fails", t, func() {
+		awsConn := &aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {
+					"node1", "node2", "node3",
+				},
+				"DescribeInstancesByTag": {"one_undesired_host", "one_undesired_host"},
+				"DescribeAGByName":       {"one_undesired_host_one_terminating"},
+			},
+		}
+		failingConn := &failingTagConnection{
+			ConnectionMock: &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstancesByTag": {"default"},
+				},
+			},
+			err: errors.New("aws unavailable"),
+		}
+		mesosConn := &mesos.ClientMock{
+			Records: map[string]*[]string{
+				"GetMesosFrameworks": {"default"},
+				"GetMesosSlaves":     {"default"},
+				"GetMesosTasks":      {"notasks"},
+			},
+		}
+		mesosMonitor := monitor.NewMesosMonitor(mesosConn, []string{"frameworkName1"})
+		autoscalingGroups, _ := monitor.NewAutoscalingGroupMonitorsFromSelectors([]monitor.AutoscalingGroupSelector{
+			{Prefix: "some-Autoscaling-Group", AWSConnection: awsConn},
+			{Prefix: "other-Autoscaling-Group", AWSConnection: failingConn},
+		}, "DEATH_NODE_MARK")
+		mesosMonitor.Refresh()
+		autoscalingGroups.Refresh()
+		notebook := NewNotebook(autoscalingGroups, mesosMonitor, 0, "DEATH_NODE_MARK", false)
+		notebook.state.Drains["i-other"] = &store.Drain{InstanceID: "i-other", State: string(monitor.DrainInMaintenance)}
+		notebook.saveState()
+		instanceMonitor, _ := notebook.autoscalingGroups.GetInstanceByID("i-34719eb8")
+		lookupFailures := markedInstancesLookupFailuresCounter.Value("failing")
+
+		err := notebook.DestroyInstancesAttempt()
+
+		Convey("the failure should be returned, and counted for the connection", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "failing")
+			So(markedInstancesLookupFailuresCounter.Value("failing"), ShouldEqual, lookupFailures+1)
+		})
+		Convey("the drains of the other connections should go on, without replacing the maintenance schedule", func() {
+			So(len(awsConn.Requests["RemoveASGInstanceProtection"]), ShouldEqual, 1)
+			So(instanceMonitor.GetDrainState(), ShouldEqual, monitor.DrainUnprotected)
+			So(mesosConn.Requests["SetHostInMaintenance"], ShouldBeNil)
+		})
+		Convey("the drains of the instances not found shouldn't be finished", func() {
+			So(notebook.state.Drains, ShouldContainKey, "i-other")
+		})
+		Convey("once the connection answers, the drains should be reconciled and set in maintenance", func() {
+			failingConn.err = nil
+			So(notebook.DestroyInstancesAttempt(), ShouldBeNil)
+			So(notebook.state.Drains, ShouldNotContainKey, "i-other")
+			So(mesosConn.Requests["SetHostInMaintenance"], ShouldNotBeNil)
+			So(instanceMonitor.GetDrainState(), ShouldEqual, monitor.DrainLifecycleCompleted)
+		})
+	})
+}
+
+// failingTagConnection fails to find the instances marked while err is set. It has no autoscaling groups
+type failingTagConnection struct {
+	*aws.ConnectionMock
+	err error
+}
+
+func (c *failingTagConnection) Name() string {
+	return "failing"
+}
+
+func (c *failingTagConnection) DescribeInstancesByTag(tagKey string) ([]*ec2.Instance, error) {
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.ConnectionMock.DescribeInstancesByTag(tagKey)
+}
+
+func (c *failingTagConnection) DescribeAGByName(autoscalingGroupName string) ([]*autoscaling.Group, error) {
+	return []*autoscaling.Group{}, nil
+}
+
+// failingLifecycleConnection fails to complete the lifecycle actions while err is set
+type failingLifecycleConnection struct {
+	*aws.ConnectionMock
+	err      error
+	attempts int
+}
+
+func (c *failingLifecycleConnection) CompleteLifecycleAction(autoscalingGroupName, instanceID *string) error {
+
+	c.attempts++
+	if c.err != nil {
+		return c.err
+	}
+	return c.ConnectionMock.CompleteLifecycleAction(autoscalingGroupName, instanceID)
+}
+
+func newNotebookWithPolicy(awsConn aws.ClientInterface, mesosConn mesos.ClientInterface, policy *config.Policy) *Notebook {
+
+	mesosMonitor := monitor.NewMesosMonitor(mesosConn, []string{"frameworkName1"})
+	autoscalingGroups, _ := monitor.NewAutoscalingGroupMonitorsFromSelectors([]monitor.AutoscalingGroupSelector{{
+		Prefix:        "some-Autoscaling-Group",
+		AWSConnection: awsConn,
+		Policy:        policy,
+	}}, "DEATH_NODE_MARK")
+
+	mesosMonitor.Refresh()
+	autoscalingGroups.Refresh()
+
+	return NewNotebook(autoscalingGroups, mesosMonitor, 0, "DEATH_NODE_MARK", false)
+}
+
 func newNotebook(awsConn aws.ClientInterface, mesosConn mesos.ClientInterface, delayDeleteSeconds int) *Notebook {
 
 	protectedFrameworks := []string{"frameworkName1"}
@@ -135,6 +520,6 @@ func newNotebook(awsConn aws.ClientInterface, mesosConn mesos.ClientInterface, d
 	mesosMonitor.Refresh()
 	autoscalingGroups.Refresh()
 
-	notebook := NewNotebook(autoscalingGroups, awsConn, mesosMonitor, delayDeleteSeconds, "DEATH_NODE_MARK")
+	notebook := NewNotebook(autoscalingGroups, mesosMonitor, delayDeleteSeconds, "DEATH_NODE_MARK", false)
 	return notebook
 }
@@ -0,0 +1,109 @@
+package deathnode
+
+// Notifies the lifecycle events of the drains: when they start, progress, get stuck or fail, and when
+// the instance is terminated
+
+import (
+	"time"
+
+	"github.com/alanbover/deathnode/monitor"
+	"github.com/alanbover/deathnode/notifier"
+	"github.com/alanbover/deathnode/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// SetNotifier makes the Notebook, and the Watcher using it, notify the drain lifecycle events. A drain
+// staying in the same state longer than stuckAfter is notified once for that state. A zero stuckAfter
+// disables the stuck notifications
+func (n *Notebook) SetNotifier(eventNotifier notifier.Notifier, stuckAfter time.Duration) {
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.notifier = eventNotifier
+	n.stuckAfter = stuckAfter
+}
+
+// notifyStuckDrain notifies the drain of the instance if it has been in the same state for too long. The
+// state notified is saved, so other replica doesn't notify it again. Failed drains are notified when
+// they fail. It expects the caller to hold the Notebook lock
+func (n *Notebook) notifyStuckDrain(instance *monitor.InstanceMonitor) {
+
+	if n.notifier == nil || n.stuckAfter == 0 {
+		return
+	}
+	drain, ok := instance.GetDrain()
+	if !ok || drain.IsFinished() || drain.State == monitor.DrainFailed {
+		return
+	}
+	since, ok := drain.Transitions[drain.State]
+	if !ok || time.Since(since) < n.stuckAfter {
+		return
+	}
+	storedDrain, ok := n.state.Drains[*instance.GetInstanceID()]
+	if !ok || storedDrain.StuckNotified == string(drain.State) {
+		return
+	}
+
+	log.Warnf("Drain of instance %s stuck at %s since %s", *instance.GetInstanceID(), drain.State, since)
+	storedDrain.StuckNotified = string(drain.State)
+	n.saveState()
+	n.notifyDrain(notifier.EventDrainStuck, storedDrain)
+}
+
+// notifyDrain notifies an event of a saved drain. It expects the caller to hold the Notebook lock
+func (n *Notebook) notifyDrain(eventType string, storedDrain *store.Drain) {
+
+	if storedDrain == nil {
+		return
+	}
+	n.notify(n.drainEvent(eventType, storedDrain))
+}
+
+// transitionEvents are the events notified when a drain enters a state
+var transitionEvents = map[monitor.DrainState]string{
+	monitor.DrainInMaintenance:      notifier.EventMaintenanceScheduled,
+	monitor.DrainDrained:            notifier.EventDrainComplete,
+	monitor.DrainLifecycleCompleted: notifier.EventLifecycleCompleted,
+}
+
+// notifyTransition notifies the drain of the instance entering a state, if it has an event. It expects
+// the caller to hold the Notebook lock
+func (n *Notebook) notifyTransition(instance *monitor.InstanceMonitor, state monitor.DrainState) {
+
+	if eventType, ok := transitionEvents[state]; ok {
+		n.notifyDrain(eventType, n.state.Drains[*instance.GetInstanceID()])
+	}
+}
+
+// drainEvent returns the event for a saved drain, with the scheduler agent of the instance if it's still
+// known
+func (n *Notebook) drainEvent(eventType string, storedDrain *store.Drain) *notifier.Event {
+
+	event := &notifier.Event{
+		Type:             eventType,
+		Time:             time.Now(),
+		InstanceID:       storedDrain.InstanceID,
+		AutoscalingGroup: storedDrain.AutoscalingGroup,
+		RequestedBy:      storedDrain.RequestedBy,
+		DrainState:       storedDrain.State,
+		Since:            storedDrain.Transitions[storedDrain.State],
+		Error:            storedDrain.LastError,
+	}
+	if instance, err := n.autoscalingGroups.GetInstanceByID(storedDrain.InstanceID); err == nil {
+		if agent, ok := n.scheduler.GetAgent(instance.GetIP()); ok {
+			event.Agent = &notifier.Agent{
+				ID:       agent.ID,
+				Hostname: agent.Hostname,
+				IP:       instance.GetIP(),
+			}
+		}
+	}
+	return event
+}
+
+func (n *Notebook) notify(event *notifier.Event) {
+
+	if n.notifier != nil {
+		n.notifier.Notify(event)
+	}
+}
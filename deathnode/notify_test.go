@@ -0,0 +1,101 @@
+package deathnode
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alanbover/deathnode/aws"
+	"github.com/alanbover/deathnode/mesos"
+	"github.com/alanbover/deathnode/monitor"
+	"github.com/alanbover/deathnode/notifier"
+	"github.com/alanbover/deathnode/store"
+	log "github.com/sirupsen/logrus"
+)
+
+type notifierMock struct {
+	mutex  sync.Mutex
+	events []*notifier.Event
+}
+
+func (m *notifierMock) Notify(event *notifier.Event) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.events = append(m.events, event)
+}
+
+func (m *notifierMock) types() []string {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	types := []string{}
+	for _, event := range m.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestNotifyDrainEvents(t *testing.T) {
+
+	log.SetLevel(log.DebugLevel)
+
+	awsConn := &aws.ConnectionMock{
+		Records: map[string]*[]string{
+			"DescribeInstanceById": {
+				"node1", "node2", "node3",
+			},
+			"DescribeInstancesByTag": {"default", "one_undesired_host", "one_undesired_host", "default"},
+			"DescribeAGByName": {"one_undesired_host", "one_undesired_host_one_terminating",
+				"one_undesired_host_one_terminating", "default"},
+		},
+	}
+
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": {"default", "default", "default", "default"},
+			"GetMesosSlaves":     {"default", "default", "default", "default"},
+			"GetMesosTasks":      {"default", "default", "notasks", "notasks"},
+		},
+	}
+
+	eventNotifier := &notifierMock{}
+	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
+	deathNodeWatcher.notebook.SetNotifier(eventNotifier, time.Nanosecond)
+	for i := 0; i < 4; i++ {
+		deathNodeWatcher.Run(context.Background())
+	}
+
+	events := []string{}
+	for _, event := range eventNotifier.events {
+		events = append(events, event.Type+":"+event.DrainState)
+		if event.InstanceID != "i-34719eb8" {
+			t.Fatalf("Events should be about the instance drained. Actual: %+v", event)
+		}
+	}
+	// The drain waiting for the protected tasks is notified as stuck only once
+	expectedEvents := []string{
+		notifier.EventDrainStarted + ":" + string(monitor.DrainMarked),
+		notifier.EventMaintenanceScheduled + ":" + string(monitor.DrainInMaintenance),
+		notifier.EventDrainStuck + ":" + string(monitor.DrainInMaintenance),
+		notifier.EventDrainComplete + ":" + string(monitor.DrainDrained),
+		notifier.EventLifecycleCompleted + ":" + string(monitor.DrainLifecycleCompleted),
+		notifier.EventDrainStuck + ":" + string(monitor.DrainLifecycleCompleted),
+		notifier.EventInstanceTerminated + ":" + string(monitor.DrainTerminated),
+	}
+	if len(events) != len(expectedEvents) {
+		t.Fatalf("Drain events should be %v. Actual: %v", expectedEvents, events)
+	}
+	for i := range events {
+		if events[i] != expectedEvents[i] {
+			t.Fatalf("Drain events should be %v. Actual: %v", expectedEvents, events)
+		}
+	}
+	if eventNotifier.events[0].RequestedBy != store.RequestedByDeathnode {
+		t.Fatalf("Drain started event should be requested by deathnode. Actual: %+v", eventNotifier.events[0])
+	}
+	if agent := eventNotifier.events[1].Agent; agent == nil || agent.Hostname != "mesosslave1hostname" || agent.IP != "10.0.0.2" {
+		t.Fatalf("Maintenance scheduled event should carry the Mesos agent. Actual: %+v", agent)
+	}
+}
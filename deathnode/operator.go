@@ -0,0 +1,208 @@
+package deathnode
+
+// Commands for operators to mark, unmark and pin instances, overriding the decisions of deathnode
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/alanbover/deathnode/audit"
+	"github.com/alanbover/deathnode/monitor"
+	"github.com/alanbover/deathnode/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// CommandError is returned when an operator command can't be applied to an instance in it's current state
+type CommandError struct {
+	message string
+}
+
+func (e *CommandError) Error() string {
+	return e.message
+}
+
+func newCommandError(format string, args ...interface{}) *CommandError {
+	return &CommandError{message: fmt.Sprintf(format, args...)}
+}
+
+// MarkInstance marks an instance to be removed, so it's drained as if deathnode had chosen it. It will be
+// removed the next time it's autoscaling group scales in, as it's instance protection is removed
+func (y *Watcher) MarkInstance(instanceID string) error {
+
+	y.mutex.Lock()
+	defer y.mutex.Unlock()
+
+	instance, err := y.autoscalingGroups.GetInstanceByID(instanceID)
+	if err != nil {
+		return err
+	}
+	if instance.IsPinned() {
+		return newCommandError("Instance %s is pinned. Unpin it before marking it", instanceID)
+	}
+	if instance.IsMarkedToBeRemoved() {
+		return newCommandError("Instance %s is already marked to be removed", instanceID)
+	}
+
+	log.Infof("Mark instance %s for removal, as requested by an operator", instanceID)
+	err = instance.MarkToBeRemoved()
+	y.notebook.auditInstance(audit.ActionMark, instance, store.RequestedByOperator, nil, err)
+	if err != nil {
+		return err
+	}
+	y.notebook.recordDrain(instance, store.RequestedByOperator)
+	return nil
+}
+
+// UnmarkInstance removes the deathnode mark from an instance, protects it from scale in again and removes
+// it from the Mesos maintenance schedule. Instances already being terminated by AWS can't be unmarked
+func (y *Watcher) UnmarkInstance(instanceID string) error {
+
+	y.mutex.Lock()
+	defer y.mutex.Unlock()
+
+	instance, err := y.autoscalingGroups.GetInstanceByID(instanceID)
+	if err != nil {
+		return err
+	}
+	if !instance.IsMarkedToBeRemoved() {
+		return newCommandError("Instance %s is not marked to be removed", instanceID)
+	}
+	if strings.HasPrefix(instance.GetLifecycleState(), "Terminating") {
+		return newCommandError("Instance %s is already being terminated", instanceID)
+	}
+	if state := instance.GetDrainState(); !monitor.CanTransition(state, monitor.DrainCancelled) {
+		return newCommandError("Drain of instance %s can't be cancelled in %s state", instanceID, state)
+	}
+
+	log.Infof("Remove mark from instance %s, as requested by an operator", instanceID)
+	err = instance.RemoveMark()
+	y.notebook.auditInstance(audit.ActionUnmark, instance, store.RequestedByOperator, nil, err)
+	if err != nil {
+		return err
+	}
+	y.notebook.cancelDrain(instanceID)
+	return y.notebook.removeFromMaintenance(instanceID)
+}
+
+// PinInstance tags an instance so it's never chosen to be removed
+func (y *Watcher) PinInstance(instanceID string) error {
+
+	y.mutex.Lock()
+	defer y.mutex.Unlock()
+
+	instance, err := y.autoscalingGroups.GetInstanceByID(instanceID)
+	if err != nil {
+		return err
+	}
+	if instance.IsMarkedToBeRemoved() {
+		return newCommandError("Instance %s is marked to be removed. Unmark it before pinning it", instanceID)
+	}
+
+	log.Infof("Pin instance %s, as requested by an operator", instanceID)
+	return instance.Pin()
+}
+
+// UnpinInstance removes the pin from an instance, so it can be chosen to be removed again
+func (y *Watcher) UnpinInstance(instanceID string) error {
+
+	y.mutex.Lock()
+	defer y.mutex.Unlock()
+
+	instance, err := y.autoscalingGroups.GetInstanceByID(instanceID)
+	if err != nil {
+		return err
+	}
+	if !instance.IsPinned() {
+		return newCommandError("Instance %s is not pinned", instanceID)
+	}
+
+	log.Infof("Unpin instance %s, as requested by an operator", instanceID)
+	return instance.Unpin()
+}
+
+// OperatorHandler exposes the operator commands through HTTP, as POST requests to
+// /instances/<instanceId>/<mark|unmark|pin|unpin> authenticated with a bearer token
+type OperatorHandler struct {
+	watcher *Watcher
+	token   string
+}
+
+// NewOperatorHandler returns a new OperatorHandler for the Watcher. Commands are rejected unless they carry
+// the token in the Authorization header, so no command is accepted if it's empty
+func NewOperatorHandler(watcher *Watcher, token string) *OperatorHandler {
+	return &OperatorHandler{
+		watcher: watcher,
+		token:   token,
+	}
+}
+
+// ServeHTTP runs the operator command. Only the leader accepts commands, as the other replicas don't
+// keep their monitors up to date
+func (h *OperatorHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	if !h.isAuthorized(req) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(path) != 3 || path[0] != "instances" {
+		http.NotFound(w, req)
+		return
+	}
+	instanceID, command := path[1], path[2]
+
+	commands := map[string]func(string) error{
+		"mark":   h.watcher.MarkInstance,
+		"unmark": h.watcher.UnmarkInstance,
+		"pin":    h.watcher.PinInstance,
+		"unpin":  h.watcher.UnpinInstance,
+	}
+	run, ok := commands[command]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	if !h.watcher.isLeader() {
+		http.Error(w, "Not the leader", http.StatusServiceUnavailable)
+		return
+	}
+
+	if _, err := h.watcher.autoscalingGroups.GetInstanceByID(instanceID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	err := run(instanceID)
+	if err != nil {
+		if _, ok := err.(*CommandError); ok {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Errorf("Unable to %s instance %s: %v", command, instanceID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(w, "Instance %s: %s done\n", instanceID, command)
+}
+
+// isAuthorized returns true if the request carries the operator token as a bearer token
+func (h *OperatorHandler) isAuthorized(req *http.Request) bool {
+
+	authorization := req.Header.Get("Authorization")
+	if h.token == "" || !strings.HasPrefix(authorization, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
@@ -0,0 +1,103 @@
+package deathnode
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alanbover/deathnode/aws"
+	"github.com/alanbover/deathnode/mesos"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestOperatorCommands(t *testing.T) {
+
+	log.SetLevel(log.DebugLevel)
+
+	awsConn := &aws.ConnectionMock{
+		Records: map[string]*[]string{
+			"DescribeInstanceById": {
+				"node1", "node2", "node3",
+			},
+			"DescribeInstancesByTag": {"one_undesired_host", "one_undesired_host", "one_undesired_host"},
+			"DescribeAGByName":       {"one_undesired_host", "one_undesired_host"},
+		},
+	}
+
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": {"default", "default"},
+			"GetMesosSlaves":     {"default", "default"},
+			"GetMesosTasks":      {"default", "default"},
+		},
+	}
+
+	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
+	deathNodeWatcher.Run(context.Background())
+
+	// Unmarking the instance marked by deathnode protects it again and removes it from maintenance
+	err := deathNodeWatcher.UnmarkInstance("i-34719eb8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, _ := deathNodeWatcher.autoscalingGroups.GetInstanceByID("i-34719eb8")
+	if instance.IsMarkedToBeRemoved() || !instance.IsProtected() {
+		t.Fatal("Unmarked instance should be protected and not marked to be removed")
+	}
+	if len(awsConn.Requests["RemoveInstanceTag"]) != 1 || awsConn.Requests["RemoveInstanceTag"][0][0] != "DEATH_NODE_MARK" {
+		t.Fatal("Deathnode mark should have been removed")
+	}
+	if len(*mesosConn.Requests["SetHostInMaintenance"]) != 0 {
+		t.Fatal("Unmarked instance should have been removed from maintenance")
+	}
+
+	// Pinned instances are never chosen to be removed
+	err = deathNodeWatcher.PinInstance("i-34719eb8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deathNodeWatcher.Run(context.Background())
+	if instance.IsMarkedToBeRemoved() {
+		t.Fatal("Pinned instance should not be marked to be removed")
+	}
+	if deathNodeWatcher.autoscalingGroups.GetAllMonitors()[0].NumInstancesMarkedToBeRemoved() != 1 {
+		t.Fatal("Other instance should be marked to be removed instead of the pinned one")
+	}
+
+	handler := NewOperatorHandler(deathNodeWatcher, "secret")
+	for _, testCase := range []struct {
+		method, path, authorization string
+		code                        int
+	}{
+		{"POST", "/instances/i-34719eb8/unpin", "", 401},
+		{"POST", "/instances/i-34719eb8/unpin", "Bearer wrong", 401},
+		{"POST", "/instances/i-34719eb8/unpin", "secret", 401},
+		{"POST", "/instances/i-34719eb8/mark", "Bearer secret", 409},
+		{"POST", "/instances/i-34719eb8/unpin", "Bearer secret", 200},
+		{"POST", "/instances/i-34719eb8/mark", "Bearer secret", 200},
+		{"POST", "/instances/i-00000000/mark", "Bearer secret", 404},
+		{"POST", "/instances/i-34719eb8/destroy", "Bearer secret", 404},
+		{"GET", "/instances/i-34719eb8/mark", "Bearer secret", 405},
+	} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(testCase.method, testCase.path, nil)
+		request.Header.Set("Authorization", testCase.authorization)
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != testCase.code {
+			t.Fatalf("%s %s (%q) should return %d. Actual: %d", testCase.method, testCase.path, testCase.authorization,
+				testCase.code, recorder.Code)
+		}
+	}
+	if !instance.IsMarkedToBeRemoved() {
+		t.Fatal("Instance should be marked to be removed after the mark command")
+	}
+
+	deathNodeWatcher.SetLeadership(&leadershipMock{})
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", "/instances/i-34719eb8/unmark", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != 503 {
+		t.Fatalf("Commands should be rejected when not the leader. Actual: %d", recorder.Code)
+	}
+}
@@ -0,0 +1,230 @@
+package deathnode
+
+// Exposes the autoscaling groups monitored and the instances being drained, so operators can find out
+// why an instance hasn't been removed yet
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alanbover/deathnode/config"
+	"github.com/alanbover/deathnode/monitor"
+	log "github.com/sirupsen/logrus"
+)
+
+// Status is a snapshot of the autoscaling groups monitored and their instances marked to be removed
+type Status struct {
+	LastSuccessfulRun *time.Time               `json:"lastSuccessfulRun,omitempty"`
+	AutoscalingGroups []AutoscalingGroupStatus `json:"autoscalingGroups"`
+}
+
+// AutoscalingGroupStatus is the status of an autoscaling group. The actual capacity includes the instances
+// marked to be removed
+type AutoscalingGroupStatus struct {
+	Name            string           `json:"name"`
+	Policy          string           `json:"policy"`
+	DesiredCapacity int64            `json:"desiredCapacity"`
+	ActualCapacity  int              `json:"actualCapacity"`
+	MarkedInstances []InstanceStatus `json:"markedInstances"`
+}
+
+// InstanceStatus is the status of an instance marked to be removed, with the state of it's drain and
+// the next action the Notebook will take for it
+// drainTransitions: map[drainState]time the state was entered
+type InstanceStatus struct {
+	InstanceID       string               `json:"instanceId"`
+	IPAddress        string               `json:"ipAddress"`
+	MarkTime         *time.Time           `json:"markTime,omitempty"`
+	RequestedBy      string               `json:"requestedBy,omitempty"`
+	LifecycleState   string               `json:"lifecycleState"`
+	Protected        bool                 `json:"protected"`
+	MesosAgentID     string               `json:"mesosAgentId,omitempty"`
+	BlockingTasks    []TaskStatus         `json:"blockingTasks"`
+	DrainState       string               `json:"drainState"`
+	DrainTransitions map[string]time.Time `json:"drainTransitions,omitempty"`
+	DrainAttempts    int                  `json:"drainAttempts,omitempty"`
+	DrainError       string               `json:"drainError,omitempty"`
+	DrainFailedStep  string               `json:"drainFailedStep,omitempty"`
+	DrainRetryAt     *time.Time           `json:"drainRetryAt,omitempty"`
+	DrainHeartbeats  int                  `json:"drainHeartbeats,omitempty"`
+	NextAction       string               `json:"nextAction"`
+}
+
+// TaskStatus is a task from a protected framework preventing an instance from being removed
+type TaskStatus struct {
+	Name      string `json:"name"`
+	Framework string `json:"framework"`
+}
+
+// Status returns the current status of the autoscaling groups monitored, sorted by name. It doesn't wait
+// for the execution in progress, so it's built from the last refresh of the monitors
+func (y *Watcher) Status() *Status {
+
+	status := &Status{
+		AutoscalingGroups: []AutoscalingGroupStatus{},
+	}
+	if lastSuccessfulRun := y.LastSuccessfulRun(); !lastSuccessfulRun.IsZero() {
+		status.LastSuccessfulRun = &lastSuccessfulRun
+	}
+
+	for _, autoscalingMonitor := range y.autoscalingGroups.GetAllMonitors() {
+		instances := autoscalingMonitor.GetAllInstances()
+		autoscalingGroupStatus := AutoscalingGroupStatus{
+			Name:            autoscalingMonitor.GetAutoscalingGroupName(),
+			Policy:          config.DefaultPolicyName,
+			DesiredCapacity: autoscalingMonitor.GetDesiredCapacity(),
+			ActualCapacity:  len(instances),
+			MarkedInstances: []InstanceStatus{},
+		}
+		if policy := autoscalingMonitor.GetPolicy(); policy != nil {
+			autoscalingGroupStatus.Policy = policy.Name
+		}
+
+		for _, instance := range instances {
+			if instance.IsMarkedToBeRemoved() {
+				autoscalingGroupStatus.MarkedInstances = append(autoscalingGroupStatus.MarkedInstances, y.notebook.instanceStatus(instance))
+			}
+		}
+		sort.Slice(autoscalingGroupStatus.MarkedInstances, func(i, j int) bool {
+			return autoscalingGroupStatus.MarkedInstances[i].InstanceID < autoscalingGroupStatus.MarkedInstances[j].InstanceID
+		})
+		status.AutoscalingGroups = append(status.AutoscalingGroups, autoscalingGroupStatus)
+	}
+
+	sort.Slice(status.AutoscalingGroups, func(i, j int) bool {
+		return status.AutoscalingGroups[i].Name < status.AutoscalingGroups[j].Name
+	})
+	return status
+}
+
+// instanceStatus returns the status of an instance marked to be removed, waiting for the Notebook to
+// finish the destroy attempt in progress
+func (n *Notebook) instanceStatus(instance *monitor.InstanceMonitor) InstanceStatus {
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	policy := n.policyFor(*instance.GetInstanceID())
+	tasks := n.getProtectedFrameworksTasks(policy, instance.GetIP())
+	markTime := instance.GetMarkTime()
+
+	instanceStatus := InstanceStatus{
+		InstanceID:     *instance.GetInstanceID(),
+		IPAddress:      instance.GetIP(),
+		LifecycleState: instance.GetLifecycleState(),
+		Protected:      instance.IsProtected(),
+		BlockingTasks:  []TaskStatus{},
+		NextAction:     n.nextAction(policy, instance, tasks, markTime),
+	}
+	if !markTime.IsZero() {
+		instanceStatus.MarkTime = &markTime
+	}
+	if agent, ok := n.scheduler.GetAgent(instance.GetIP()); ok {
+		instanceStatus.MesosAgentID = agent.ID
+	}
+	if storedDrain, ok := n.state.Drains[instanceStatus.InstanceID]; ok {
+		instanceStatus.RequestedBy = storedDrain.RequestedBy
+	}
+	if drain, ok := instance.GetDrain(); ok {
+		instanceStatus.DrainState = string(drain.State)
+		instanceStatus.DrainTransitions = map[string]time.Time{}
+		for state, transitionTime := range drain.Transitions {
+			instanceStatus.DrainTransitions[string(state)] = transitionTime
+		}
+		instanceStatus.DrainAttempts = drain.Attempts
+		instanceStatus.DrainError = drain.LastError
+		instanceStatus.DrainFailedStep = string(drain.FailedStep)
+		instanceStatus.DrainHeartbeats = drain.Heartbeats
+		if drain.IsWaitingRetry() {
+			retryAt := drain.RetryAt
+			instanceStatus.DrainRetryAt = &retryAt
+		}
+	}
+	for _, task := range tasks {
+		instanceStatus.BlockingTasks = append(instanceStatus.BlockingTasks, TaskStatus{
+			Name:      task.Name,
+			Framework: task.OwnerName,
+		})
+	}
+
+	return instanceStatus
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><title>deathnode status</title></head>
+<body>
+<h1>deathnode status</h1>
+<p>Last successful run: {{if .LastSuccessfulRun}}{{.LastSuccessfulRun}}{{else}}never{{end}}</p>
+{{range .AutoscalingGroups}}
+<h2>{{.Name}}</h2>
+<p>Policy: {{.Policy}}. Desired capacity: {{.DesiredCapacity}}. Actual capacity: {{.ActualCapacity}}</p>
+{{if .MarkedInstances}}
+<table border="1">
+<tr><th>Instance</th><th>IP address</th><th>Marked at</th><th>Requested by</th><th>Lifecycle state</th><th>Protected</th><th>Mesos agent</th><th>Blocking tasks</th><th>Drain state</th><th>Next action</th></tr>
+{{range .MarkedInstances}}
+<tr>
+<td>{{.InstanceID}}</td><td>{{.IPAddress}}</td><td>{{if .MarkTime}}{{.MarkTime}}{{end}}</td><td>{{.RequestedBy}}</td><td>{{.LifecycleState}}</td>
+<td>{{.Protected}}</td><td>{{.MesosAgentID}}</td>
+<td>{{range .BlockingTasks}}{{.Name}} ({{.Framework}})<br>{{end}}</td>
+<td>{{.DrainState}}{{if .DrainFailedStep}} at {{.DrainFailedStep}}{{end}}{{if .DrainError}} ({{.DrainAttempts}} failed attempts: {{.DrainError}}){{end}}</td><td>{{.NextAction}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p>No instances marked to be removed</p>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// StatusHandler exposes the Watcher status through HTTP, as JSON or as HTML if the client accepts it
+type StatusHandler struct {
+	watcher *Watcher
+}
+
+// NewStatusHandler returns a new StatusHandler for the Watcher
+func NewStatusHandler(watcher *Watcher) *StatusHandler {
+	return &StatusHandler{
+		watcher: watcher,
+	}
+}
+
+// ServeHTTP writes the Watcher status. The format can be chosen with the format query parameter
+// (json or html), falling back to the Accept header
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := h.watcher.Status()
+
+	var err error
+	if wantsHTML(req) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = statusTemplate.Execute(w, status)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(status)
+	}
+	if err != nil {
+		log.Errorf("Unable to write status: %v", err)
+	}
+}
+
+func wantsHTML(req *http.Request) bool {
+
+	if format := req.URL.Query().Get("format"); format != "" {
+		return format == "html"
+	}
+	return strings.Contains(req.Header.Get("Accept"), "text/html")
+}
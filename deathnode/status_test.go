@@ -0,0 +1,87 @@
+package deathnode
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alanbover/deathnode/aws"
+	"github.com/alanbover/deathnode/mesos"
+	"github.com/alanbover/deathnode/monitor"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestStatus(t *testing.T) {
+
+	log.SetLevel(log.DebugLevel)
+
+	awsConn := &aws.ConnectionMock{
+		Records: map[string]*[]string{
+			"DescribeInstanceById": {
+				"node1", "node2", "node3",
+			},
+			"DescribeInstancesByTag": {"one_undesired_host"},
+			"DescribeAGByName":       {"one_undesired_host"},
+		},
+	}
+
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": {"default"},
+			"GetMesosSlaves":     {"default"},
+			"GetMesosTasks":      {"default"},
+		},
+	}
+
+	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
+	deathNodeWatcher.Run(context.Background())
+
+	status := deathNodeWatcher.Status()
+	if len(status.AutoscalingGroups) != 1 {
+		t.Fatalf("One autoscaling group should be listed. Actual: %d", len(status.AutoscalingGroups))
+	}
+	autoscalingGroupStatus := status.AutoscalingGroups[0]
+	if autoscalingGroupStatus.DesiredCapacity != 2 || autoscalingGroupStatus.ActualCapacity != 3 {
+		t.Fatalf("Desired and actual capacity should be 2 and 3. Actual: %d and %d",
+			autoscalingGroupStatus.DesiredCapacity, autoscalingGroupStatus.ActualCapacity)
+	}
+	if len(autoscalingGroupStatus.MarkedInstances) != 1 {
+		t.Fatalf("One marked instance should be listed. Actual: %d", len(autoscalingGroupStatus.MarkedInstances))
+	}
+
+	instanceStatus := autoscalingGroupStatus.MarkedInstances[0]
+	if instanceStatus.MarkTime == nil || instanceStatus.MesosAgentID == "" {
+		t.Fatalf("Marked instance should have mark time and mesos agent. Actual: %+v", instanceStatus)
+	}
+	if len(instanceStatus.BlockingTasks) != 1 || instanceStatus.BlockingTasks[0].Framework != "frameworkName1" {
+		t.Fatalf("Marked instance should be blocked by one frameworkName1 task. Actual: %+v", instanceStatus.BlockingTasks)
+	}
+	if instanceStatus.DrainState != string(monitor.DrainInMaintenance) {
+		t.Fatalf("Drain state should be %s. Actual: %s", monitor.DrainInMaintenance, instanceStatus.DrainState)
+	}
+	if instanceStatus.NextAction != NextActionWaitProtectedTasks {
+		t.Fatalf("Next action should be %s. Actual: %s", NextActionWaitProtectedTasks, instanceStatus.NextAction)
+	}
+
+	handler := NewStatusHandler(deathNodeWatcher)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/status", nil))
+	decodedStatus := &Status{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), decodedStatus); err != nil {
+		t.Fatalf("Status should be returned as JSON: %v", err)
+	}
+	if decodedStatus.AutoscalingGroups[0].MarkedInstances[0].InstanceID != instanceStatus.InstanceID {
+		t.Fatal("JSON status should list the marked instance")
+	}
+
+	recorder = httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/status", nil)
+	request.Header.Set("Accept", "text/html")
+	handler.ServeHTTP(recorder, request)
+	if !strings.Contains(recorder.Body.String(), "<td>"+instanceStatus.InstanceID+"</td>") {
+		t.Fatal("HTML status should list the marked instance")
+	}
+}
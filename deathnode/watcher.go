@@ -3,21 +3,41 @@ package deathnode
 // Given an autoscaling group, decides which is/are the best agent/s to kill
 
 import (
+	"context"
+	"github.com/alanbover/deathnode/audit"
+	"github.com/alanbover/deathnode/config"
+	"github.com/alanbover/deathnode/dryrun"
 	"github.com/alanbover/deathnode/monitor"
+	"github.com/alanbover/deathnode/store"
 	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
 )
 
 // Watcher stores the enough information for decide, if instances need to be removed, which ones are the best
 type Watcher struct {
+	mutex             sync.Mutex
 	notebook          *Notebook
-	mesosMonitor      *monitor.MesosMonitor
+	scheduler         monitor.Scheduler
 	constraints       constraint
 	recommender       recommender
+	constraintsType   string
+	recommenderType   string
 	autoscalingGroups *monitor.AutoscalingGroupsMonitor
+	dryRunPlan        *dryrun.Plan
+	stateMutex        sync.RWMutex
+	leadership        Leadership
+	lastSuccessfulRun time.Time
+	health            health
+}
+
+// Leadership tells if this deathnode replica is the leader, being the only one allowed to run the checks
+type Leadership interface {
+	IsLeader() bool
 }
 
 // NewWatcher returns a new Watcher object
-func NewWatcher(notebook *Notebook, mesosMonitor *monitor.MesosMonitor, autoscalingGroups *monitor.AutoscalingGroupsMonitor, constraintType, recommenderType string) *Watcher {
+func NewWatcher(notebook *Notebook, scheduler monitor.Scheduler, autoscalingGroups *monitor.AutoscalingGroupsMonitor, constraintType, recommenderType string) *Watcher {
 
 	contrainsts, err := newConstraint(constraintType)
 	if err != nil {
@@ -30,33 +50,133 @@ func NewWatcher(notebook *Notebook, mesosMonitor *monitor.MesosMonitor, autoscal
 	}
 
 	return &Watcher{
-		notebook:     notebook,
-		mesosMonitor: mesosMonitor,
-		constraints:  contrainsts,
-		recommender:  recommender,
+		notebook:          notebook,
+		scheduler:         scheduler,
+		constraints:       contrainsts,
+		recommender:       recommender,
+		constraintsType:   constraintType,
+		recommenderType:   recommenderType,
 		autoscalingGroups: autoscalingGroups,
+		health: health{
+			started: time.Now(),
+		},
+	}
+}
+
+// Reload applies a new configuration, waiting for the execution in progress to finish. The autoscaling
+// groups already monitored, and their instances being drained, are kept. The scheduler, the deathnode
+// mark and the polling interval can't be changed without a restart
+func (y *Watcher) Reload(selectors []monitor.AutoscalingGroupSelector, deathNodeConfig *config.Config) error {
+
+	constraints, recommender, err := y.strategiesFor(deathNodeConfig.Defaults)
+	if err != nil {
+		return err
+	}
+
+	y.mutex.Lock()
+	defer y.mutex.Unlock()
+
+	err = y.autoscalingGroups.SetSelectors(selectors)
+	if err != nil {
+		return err
 	}
+
+	y.scheduler.SetProtected(deathNodeConfig.ProtectedFrameworks())
+	y.notebook.setDefaults(deathNodeConfig.Defaults.DelayDeleteSeconds, deathNodeConfig.DeregisterFromLoadBalancers)
+	y.constraints = constraints
+	y.recommender = recommender
+	y.constraintsType = deathNodeConfig.Defaults.ConstraintsType
+	y.recommenderType = deathNodeConfig.Defaults.RecommenderType
+
+	return nil
+}
+
+// SetLeadership makes every execution be skipped unless the replica is the leader
+func (y *Watcher) SetLeadership(leadership Leadership) {
+
+	y.stateMutex.Lock()
+	defer y.stateMutex.Unlock()
+	y.leadership = leadership
+	y.notebook.setLeadership(leadership)
+}
+
+// isLeader returns true if this replica is the leader, or if leader election is disabled
+func (y *Watcher) isLeader() bool {
+
+	y.stateMutex.RLock()
+	defer y.stateMutex.RUnlock()
+	return y.leadership == nil || y.leadership.IsLeader()
+}
+
+// SetDryRunPlan makes every execution end with a summary of the actions recorded in the dry run plan
+func (y *Watcher) SetDryRunPlan(plan *dryrun.Plan) {
+
+	y.mutex.Lock()
+	defer y.mutex.Unlock()
+	y.dryRunPlan = plan
 }
 
 // TagInstancesToBeRemoved finds, if any instances to be removed for an autoscaling group, the best instances to
-// kill and marks them to be removed
+// kill and marks them to be removed. No instances are marked on stale autoscaling groups, as their data
+// may be outdated
 func (y *Watcher) TagInstancesToBeRemoved(autoscalingMonitor *monitor.AutoscalingGroupMonitor) error {
 
+	if autoscalingMonitor.IsStale() {
+		log.Warnf("Autoscaling %s couldn't be refreshed. Not marking instances on it", autoscalingMonitor.GetAutoscalingGroupName())
+		return nil
+	}
+
+	// Instances being replaced by an instance refresh are drained the same way as the ones deathnode chooses
+	for _, instance := range autoscalingMonitor.GetInstancesTargetedByRefresh() {
+		log.Infof("Mark instance %s for removal, as it's being replaced by an instance refresh", *instance.GetInstanceID())
+		err := instance.MarkToBeRemoved()
+		y.notebook.auditInstance(audit.ActionMark, instance, store.RequestedByInstanceRefresh, nil, err)
+		if err != nil {
+			log.Errorf("Unable to mark instance %s for removal", instance.GetIP())
+			log.Error(err)
+			continue
+		}
+		y.notebook.recordDrain(instance, store.RequestedByInstanceRefresh)
+	}
+
+	constraints, recommender, err := y.strategiesFor(autoscalingMonitor.GetPolicy())
+	if err != nil {
+		return err
+	}
+
 	numUndesiredInstances := autoscalingMonitor.NumUndesiredInstances()
 	log.Debugf("Undesired Mesos Agents: %d", numUndesiredInstances)
 
+	// Limit the instances being drained at the same time, if the policy sets it
+	if policy := autoscalingMonitor.GetPolicy(); policy != nil && policy.MaxConcurrentDrains > 0 {
+		availableDrains := policy.MaxConcurrentDrains - autoscalingMonitor.NumInstancesMarkedToBeRemoved()
+		if availableDrains < numUndesiredInstances {
+			log.Debugf("Autoscaling %s reached it's maximum concurrent drains (%d). Marking %d instances instead of %d",
+				autoscalingMonitor.GetAutoscalingGroupName(), policy.MaxConcurrentDrains, availableDrains, numUndesiredInstances)
+			numUndesiredInstances = availableDrains
+		}
+	}
+
 	removedInstances := 0
 
 	for removedInstances < numUndesiredInstances {
-		allowedInstancesToKill := y.constraints.filter(autoscalingMonitor.GetInstances())
-		bestInstanceToKill := y.recommender.find(allowedInstancesToKill)
+		allowedInstancesToKill := constraints.filter(autoscalingMonitor.GetInstances())
+		if len(allowedInstancesToKill) == 0 {
+			log.Warnf("Autoscaling %s has no instances allowed to be removed. Marking %d instances instead of %d",
+				autoscalingMonitor.GetAutoscalingGroupName(), removedInstances, numUndesiredInstances)
+			break
+		}
+		bestInstanceToKill := recommender.find(allowedInstancesToKill)
 		log.Debugf("Mark instance %s for removal", *bestInstanceToKill.GetInstanceID())
 		err := bestInstanceToKill.MarkToBeRemoved()
+		y.notebook.auditInstance(audit.ActionMark, bestInstanceToKill, store.RequestedByDeathnode,
+			y.decision(autoscalingMonitor.GetPolicy(), allowedInstancesToKill), err)
 		if err != nil {
 			log.Errorf("Unable to mark instance %s for removal", bestInstanceToKill.GetIP())
 			log.Error(err)
 			break
 		}
+		y.notebook.recordDrain(bestInstanceToKill, store.RequestedByDeathnode)
 
 		removedInstances++
 	}
@@ -64,28 +184,171 @@ func (y *Watcher) TagInstancesToBeRemoved(autoscalingMonitor *monitor.Autoscalin
 	return nil
 }
 
+// strategiesFor returns the constraint and recommender of a policy, or the Watcher ones if it's nil
+func (y *Watcher) strategiesFor(policy *config.Policy) (constraint, recommender, error) {
+
+	if policy == nil {
+		return y.constraints, y.recommender, nil
+	}
+
+	constraints, err := newConstraint(policy.ConstraintsType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recommender, err := newRecommender(policy.RecommenderType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return constraints, recommender, nil
+}
+
+// decision returns the constraint and recommender types of a policy, or the Watcher ones if it's nil,
+// with the instances allowed by the constraint
+func (y *Watcher) decision(policy *config.Policy, allowedInstances []*monitor.InstanceMonitor) *audit.Decision {
+
+	decision := &audit.Decision{
+		Constraint:       y.constraintsType,
+		AllowedInstances: []string{},
+		Recommender:      y.recommenderType,
+	}
+	if policy != nil {
+		decision.Constraint = policy.ConstraintsType
+		decision.Recommender = policy.RecommenderType
+	}
+	for _, instance := range allowedInstances {
+		decision.AllowedInstances = append(decision.AllowedInstances, *instance.GetInstanceID())
+	}
+	return decision
+}
+
+// ValidatePolicy checks that the constraints and recommender types of the policy exist
+func ValidatePolicy(policy *config.Policy) error {
+
+	if _, err := newConstraint(policy.ConstraintsType); err != nil {
+		return err
+	}
+
+	_, err := newRecommender(policy.RecommenderType)
+	return err
+}
+
 // DestroyInstancesAttempt try for those instances marked to be deleted to delete them
-func (y *Watcher) DestroyInstancesAttempt() {
+func (y *Watcher) DestroyInstancesAttempt() error {
 
 	err := y.notebook.DestroyInstancesAttempt()
 	if err != nil {
 		log.Error(err)
 	}
+	return err
+}
+
+// LastSuccessfulRun returns the time the last execution finished without errors, or zero if none did.
+// It doesn't wait for the execution in progress
+func (y *Watcher) LastSuccessfulRun() time.Time {
+
+	y.stateMutex.RLock()
+	defer y.stateMutex.RUnlock()
+	return y.lastSuccessfulRun
 }
 
-// Run starts the process of check instances to be killed and try to kill them for all Autoscalings
-func (y *Watcher) Run() {
+// Run starts the process of check instances to be killed and try to kill them for all Autoscalings.
+// If the context is cancelled, the steps not started yet are skipped, while the running one is completed
+func (y *Watcher) Run(ctx context.Context) {
+
+	// Only one execution can be running at the same time
+	y.mutex.Lock()
+	defer y.mutex.Unlock()
+	defer y.recordHealth(&y.health.lastRunFinished)
+
+	if !y.isLeader() {
+		log.Debug("Not the leader. Skipping check")
+		return
+	}
+
+	if y.dryRunPlan != nil {
+		defer y.dryRunPlan.LogSummary()
+	}
 
 	log.Debug("New check triggered")
-	// Refresh autoscaling monitors and mesos monitor
-	y.autoscalingGroups.Refresh()
-	y.mesosMonitor.Refresh()
+	// Refresh autoscaling monitors and the scheduler state
+	err := y.autoscalingGroups.Refresh()
+	if err != nil {
+		log.Errorf("Unable to refresh autoscaling groups: %v", err)
+	} else {
+		y.recordHealth(&y.health.lastAutoscalingRefresh)
+	}
+	schedulerErr := y.scheduler.Refresh()
+	if schedulerErr != nil {
+		log.Errorf("Unable to refresh scheduler state: %v", schedulerErr)
+	} else {
+		y.recordHealth(&y.health.lastSchedulerRefresh)
+	}
+	y.updateMetrics()
+
+	if ctx.Err() != nil {
+		log.Info("Shutdown requested. Skipping instances check")
+		return
+	}
 
-	// For each autoscaling monitor, check if any instances needs to be removed
+	// For each autoscaling monitor, check if any instances needs to be removed. The lease may expire
+	// meanwhile, so the leadership is checked again before every change
 	for _, autoscalingGroup := range y.autoscalingGroups.GetAllMonitors() {
-		y.TagInstancesToBeRemoved(autoscalingGroup)
+		if !y.isLeader() {
+			log.Warn("Leadership lost. Aborting check")
+			return
+		}
+		if err := y.TagInstancesToBeRemoved(autoscalingGroup); err != nil {
+			log.Error(err)
+		}
+	}
+
+	if ctx.Err() != nil {
+		log.Info("Shutdown requested. Skipping instances destroy")
+		return
+	}
+
+	// Without the scheduler state, running tasks can't be checked
+	if schedulerErr != nil {
+		log.Info("Scheduler state is unknown. Skipping instances destroy")
+		return
+	}
+
+	if !y.isLeader() {
+		log.Warn("Leadership lost. Aborting check")
+		return
 	}
 
 	// Check if any agents are drained, so we can remove them from AWS
-	y.DestroyInstancesAttempt()
+	if y.DestroyInstancesAttempt() == nil && err == nil {
+		now := time.Now()
+		y.stateMutex.Lock()
+		y.lastSuccessfulRun = now
+		y.stateMutex.Unlock()
+		lastSuccessfulRunGauge.Set(float64(now.UnixNano()) / float64(time.Second))
+	}
+}
+
+// Loop executes Run every pollingInterval until the context is cancelled. Executions never overlap: if
+// one of them takes longer than pollingInterval, the ticks missed meanwhile are skipped
+func (y *Watcher) Loop(ctx context.Context, pollingInterval time.Duration) {
+
+	ticker := time.NewTicker(pollingInterval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		y.Run(ctx)
+		if elapsed := time.Since(start); elapsed > pollingInterval {
+			log.Warnf("Check took %v, longer than the polling interval (%v). Skipping missed executions", elapsed, pollingInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info("Watcher stopped")
+			return
+		case <-ticker.C:
+		}
+	}
 }
@@ -1,10 +1,14 @@
 package deathnode
 
 import (
+	"context"
 	"github.com/alanbover/deathnode/aws"
+	"github.com/alanbover/deathnode/config"
+	"github.com/alanbover/deathnode/dryrun"
 	"github.com/alanbover/deathnode/monitor"
 	"github.com/alanbover/deathnode/mesos"
 	log "github.com/sirupsen/logrus"
+	"sync"
 	"testing"
 	"time"
 )
@@ -34,8 +38,8 @@ func TestOneInstanceRemovalWithoutDestroy(t *testing.T) {
 
 	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
 
-	deathNodeWatcher.Run()
-	deathNodeWatcher.Run()
+	deathNodeWatcher.Run(context.Background())
+	deathNodeWatcher.Run(context.Background())
 
 	removeInstanceProtectionCall := awsConn.Requests["RemoveASGInstanceProtection"]
 	if removeInstanceProtectionCall == nil {
@@ -76,8 +80,8 @@ func TestTwoInstanceRemovalWithoutDestroy(t *testing.T) {
 
 	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
 
-	deathNodeWatcher.Run()
-	deathNodeWatcher.Run()
+	deathNodeWatcher.Run(context.Background())
+	deathNodeWatcher.Run(context.Background())
 
 	removeInstanceProtectionCall := awsConn.Requests["RemoveASGInstanceProtection"]
 	if removeInstanceProtectionCall == nil {
@@ -96,6 +100,112 @@ func TestTwoInstanceRemovalWithoutDestroy(t *testing.T) {
 	}
 }
 
+func TestMaxConcurrentDrainsPolicy(t *testing.T) {
+
+	log.SetLevel(log.DebugLevel)
+
+	awsConn := &aws.ConnectionMock{
+		Records: map[string]*[]string{
+			"DescribeInstanceById": {
+				"node1", "node2", "node3",
+			},
+			"DescribeInstancesByTag": {"default", "two_undesired_hosts", "two_undesired_hosts"},
+			"DescribeAGByName":       {"two_undesired_hosts", "two_undesired_hosts"},
+		},
+	}
+
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": {"default", "default"},
+			"GetMesosSlaves":     {"default", "default"},
+			"GetMesosTasks":      {"default", "default"},
+		},
+	}
+
+	policy := &config.Policy{
+		Name:                "some-Autoscaling-Group",
+		ProtectedFrameworks: []string{"frameworkName1"},
+		ConstraintsType:     "noContraint",
+		RecommenderType:     "smallestInstanceId",
+		MaxConcurrentDrains: 1,
+	}
+	mesosMonitor := monitor.NewMesosMonitor(mesosConn, policy.ProtectedFrameworks)
+	autoscalingGroups, _ := monitor.NewAutoscalingGroupMonitorsFromSelectors([]monitor.AutoscalingGroupSelector{{
+		Prefix:        "some-Autoscaling-Group",
+		AWSConnection: awsConn,
+		Policy:        policy,
+	}}, "DEATH_NODE_MARK")
+	notebook := NewNotebook(autoscalingGroups, mesosMonitor, 0, "DEATH_NODE_MARK", false)
+	deathNodeWatcher := NewWatcher(notebook, mesosMonitor, autoscalingGroups, "noContraint", "firstAvailableAgent")
+
+	deathNodeWatcher.Run(context.Background())
+	deathNodeWatcher.Run(context.Background())
+
+	setInstanceTagCall := awsConn.Requests["SetInstanceTag"]
+	if len(setInstanceTagCall) != 1 {
+		t.Fatalf("Only one instance should be marked to be removed. Actual: %d, Expected: 1", len(setInstanceTagCall))
+	}
+	if setInstanceTagCall[0][2] != "i-34719eb8" {
+		t.Fatalf("The policy recommender should be used. Actual: %s, Expected: i-34719eb8", setInstanceTagCall[0][2])
+	}
+}
+
+func TestReloadKeepsInstancesBeingDrained(t *testing.T) {
+
+	log.SetLevel(log.DebugLevel)
+
+	awsConn := &aws.ConnectionMock{
+		Records: map[string]*[]string{
+			"DescribeInstanceById": {
+				"node1", "node2", "node3",
+			},
+			"DescribeInstancesByTag": {"default", "one_undesired_host", "one_undesired_host"},
+			"DescribeAGByName":       {"one_undesired_host", "one_undesired_host_one_terminating"},
+		},
+	}
+
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": {"default", "default"},
+			"GetMesosSlaves":     {"default", "default"},
+			"GetMesosTasks":      {"default", "default"},
+		},
+	}
+
+	deathNodeWatcher := newWatcher(awsConn, mesosConn, 100)
+	deathNodeWatcher.Run(context.Background())
+
+	// Move the monitored autoscaling group to a policy, protecting other frameworks
+	deathNodeConfig := config.New()
+	deathNodeConfig.Defaults.ProtectedFrameworks = []string{"frameworkName2"}
+	deathNodeConfig.Defaults.DelayDeleteSeconds = 0
+	deathNodeConfig.AddAutoscalingGroup(&config.AutoscalingGroup{Prefix: "some-Autoscaling-Group"})
+	err := deathNodeWatcher.Reload([]monitor.AutoscalingGroupSelector{{
+		Prefix:        "some-Autoscaling-Group",
+		AWSConnection: awsConn,
+		Policy:        deathNodeConfig.AutoscalingGroups[0].Policy,
+	}}, deathNodeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deathNodeWatcher.Run(context.Background())
+
+	instanceMonitor, err := deathNodeWatcher.autoscalingGroups.GetInstanceByID("i-34719eb8")
+	if err != nil || !instanceMonitor.IsMarkedToBeRemoved() {
+		t.Fatal("Instance marked before the reload should still be tracked")
+	}
+	if len(awsConn.Requests["CompleteLifecycleAction"]) != 1 {
+		t.Fatalf("Instance should be destroyed using the reloaded policy. Actual: %d, Expected: 1", len(awsConn.Requests["CompleteLifecycleAction"]))
+	}
+
+	invalidConfig := config.New()
+	invalidConfig.Defaults.RecommenderType = "unknown"
+	if deathNodeWatcher.Reload([]monitor.AutoscalingGroupSelector{}, invalidConfig) == nil {
+		t.Fatal("Reloading a configuration with an unknown recommender should fail")
+	}
+}
+
 func TestTwoInstanceRemovalWithDestroy(t *testing.T) {
 
 	log.SetLevel(log.DebugLevel)
@@ -122,9 +232,9 @@ func TestTwoInstanceRemovalWithDestroy(t *testing.T) {
 
 	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
 
-	deathNodeWatcher.Run()
-	deathNodeWatcher.Run()
-	deathNodeWatcher.Run()
+	deathNodeWatcher.Run(context.Background())
+	deathNodeWatcher.Run(context.Background())
+	deathNodeWatcher.Run(context.Background())
 
 	destroyInstanceCall := awsConn.Requests["CompleteLifecycleAction"]
 	if destroyInstanceCall == nil {
@@ -139,6 +249,130 @@ func TestTwoInstanceRemovalWithDestroy(t *testing.T) {
 	}
 }
 
+func TestDryRunRecordsActionsWithoutExecutingThem(t *testing.T) {
+
+	log.SetLevel(log.DebugLevel)
+
+	awsConn := &aws.ConnectionMock{
+		Records: map[string]*[]string{
+			"DescribeInstanceById": {
+				"node1", "node2", "node3",
+				"node1", "node2", "node3",
+				"node1", "node2", "node3",
+			},
+			"DescribeInstancesByTag": {"default", "two_undesired_hosts", "two_undesired_hosts", "two_undesired_hosts"},
+			"DescribeAGByName":       {"default", "two_undesired_hosts", "two_undesired_hosts_two_terminating"},
+		},
+	}
+
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": {"default", "default", "default"},
+			"GetMesosSlaves":     {"default", "default", "default"},
+			"GetMesosTasks":      {"default", "notasks", "notasks"},
+		},
+	}
+
+	plan := dryrun.NewPlan()
+	deathNodeWatcher := newWatcher(aws.NewDryRunClient(awsConn, plan), mesos.NewDryRunClient(mesosConn, plan), 0)
+
+	deathNodeWatcher.Run(context.Background())
+	deathNodeWatcher.Run(context.Background())
+	deathNodeWatcher.Run(context.Background())
+
+	for _, operation := range []string{"SetInstanceTag", "RemoveASGInstanceProtection", "PutLifeCycleHook", "CompleteLifecycleAction"} {
+		if awsConn.Requests[operation] != nil {
+			t.Fatalf("%s should not be called on dry run mode", operation)
+		}
+	}
+	if mesosConn.Requests["SetHostInMaintenance"] != nil {
+		t.Fatal("Hosts should not be set in maintenance on dry run mode")
+	}
+
+	recorded := map[string]int{}
+	for _, action := range plan.Flush() {
+		recorded[action.Operation]++
+	}
+	if recorded["CompleteLifecycleAction"] != 2 {
+		t.Fatalf("Two instance destroys should have been recorded. Actual: %d", recorded["CompleteLifecycleAction"])
+	}
+	if recorded["SetHostsInMaintenance"] == 0 {
+		t.Fatal("Setting hosts in maintenance should have been recorded")
+	}
+}
+
+func TestRunMetrics(t *testing.T) {
+
+	log.SetLevel(log.DebugLevel)
+
+	awsConn := &aws.ConnectionMock{
+		Records: map[string]*[]string{
+			"DescribeInstanceById": {
+				"node1", "node2", "node3",
+			},
+			"DescribeInstancesByTag": {"one_undesired_host_marked_long_ago", "one_undesired_host_marked_long_ago"},
+			"DescribeAGByName":       {"one_undesired_host", "one_undesired_host_one_terminating"},
+		},
+	}
+
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": {"default", "default"},
+			"GetMesosSlaves":     {"default", "default"},
+			"GetMesosTasks":      {"default", "notasks"},
+		},
+	}
+
+	completed := lifecycleActionsCounter.Value("some-Autoscaling-Group", "completed")
+	drains := drainDurationHistogram.Count("some-Autoscaling-Group")
+	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
+	publisher := &metricsPublisherMock{values: map[string]float64{}}
+	deathNodeWatcher.notebook.SetMetricsPublisher(publisher)
+
+	deathNodeWatcher.Run(context.Background())
+	if autoscalingGroupsGauge.Value() != 1 {
+		t.Fatalf("One autoscaling group should be monitored. Actual: %v", autoscalingGroupsGauge.Value())
+	}
+	if instancesGauge.Value("some-Autoscaling-Group", "InService") != 3 {
+		t.Fatalf("Three instances should be InService. Actual: %v", instancesGauge.Value("some-Autoscaling-Group", "InService"))
+	}
+	if protectedTasksGauge.Value("some-Autoscaling-Group") == 0 {
+		t.Fatal("Protected tasks blocking the drain should be counted")
+	}
+	if deathNodeWatcher.LastSuccessfulRun().IsZero() || lastSuccessfulRunGauge.Value() == 0 {
+		t.Fatal("Last successful run should be recorded")
+	}
+	if drainingInstancesGauge.Value("some-Autoscaling-Group") != 1 || blockedDrainsGauge.Value("some-Autoscaling-Group") != 1 ||
+		oldestDrainAgeGauge.Value("some-Autoscaling-Group") == 0 {
+		t.Fatal("Drain waiting for protected tasks should be counted as draining and blocked")
+	}
+	if publisher.values["BlockedDrains:some-Autoscaling-Group"] != 1 || publisher.values["OldestDrainAge:some-Autoscaling-Group"] == 0 {
+		t.Fatalf("Drain metrics should be pushed to the publisher. Actual: %v", publisher.values)
+	}
+
+	deathNodeWatcher.Run(context.Background())
+	if instancesMarkedGauge.Value("some-Autoscaling-Group") != 1 {
+		t.Fatalf("One instance should be marked. Actual: %v", instancesMarkedGauge.Value("some-Autoscaling-Group"))
+	}
+	if lifecycleActionsCounter.Value("some-Autoscaling-Group", "completed") != completed+1 {
+		t.Fatal("Completed lifecycle action should be counted")
+	}
+	if drainDurationHistogram.Count("some-Autoscaling-Group") != drains+1 {
+		t.Fatal("Drain duration should be observed")
+	}
+	if blockedDrainsGauge.Value("some-Autoscaling-Group") != 0 || publisher.values["BlockedDrains:some-Autoscaling-Group"] != 0 {
+		t.Fatal("Drain not waiting for protected tasks should not be blocked")
+	}
+}
+
+type metricsPublisherMock struct {
+	values map[string]float64
+}
+
+func (m *metricsPublisherMock) Record(name, unit string, dimensions map[string]string, value float64) {
+	m.values[name+":"+dimensions["AutoScalingGroupName"]] = value
+}
+
 func TestInstanceDeleteIfDelayDeleteIsSet(t *testing.T) {
 
 	log.SetLevel(log.DebugLevel)
@@ -169,9 +403,9 @@ func TestInstanceDeleteIfDelayDeleteIsSet(t *testing.T) {
 
 	deathNodeWatcher := newWatcher(awsConn, mesosConn, 1)
 
-	deathNodeWatcher.Run()
-	deathNodeWatcher.Run()
-	deathNodeWatcher.Run()
+	deathNodeWatcher.Run(context.Background())
+	deathNodeWatcher.Run(context.Background())
+	deathNodeWatcher.Run(context.Background())
 
 	detachInstanceCall := awsConn.Requests["RemoveASGInstanceProtection"]
 	if len(detachInstanceCall) != 2 {
@@ -194,7 +428,7 @@ func TestInstanceDeleteIfDelayDeleteIsSet(t *testing.T) {
 		t.Fatalf("Incorrect number of destroy calls. Actual: %s, Expected: 1", len(destroyInstanceCall))
 	}
 
-	deathNodeWatcher.Run()
+	deathNodeWatcher.Run(context.Background())
 	detachInstanceCall = awsConn.Requests["RemoveASGInstanceProtection"]
 	if len(detachInstanceCall) != 2 {
 		t.Fatalf("Incorrect number of detachInstance calls. Actual: %s, Expected: 2", len(detachInstanceCall))
@@ -206,7 +440,7 @@ func TestInstanceDeleteIfDelayDeleteIsSet(t *testing.T) {
 	}
 
 	time.Sleep(time.Second * 2)
-	deathNodeWatcher.Run()
+	deathNodeWatcher.Run(context.Background())
 
 	destroyInstanceCall = awsConn.Requests["CompleteLifecycleAction"]
 	if len(destroyInstanceCall) != 2 {
@@ -239,8 +473,8 @@ func TestNoInstancesBeingRemovedFromASG(t *testing.T) {
 
 	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
 
-	deathNodeWatcher.Run()
-	deathNodeWatcher.Run()
+	deathNodeWatcher.Run(context.Background())
+	deathNodeWatcher.Run(context.Background())
 
 	detachInstanceCall := awsConn.Requests["DetachInstance"]
 	if detachInstanceCall != nil {
@@ -248,6 +482,221 @@ func TestNoInstancesBeingRemovedFromASG(t *testing.T) {
 	}
 }
 
+func TestRunSkipsStepsAfterShutdown(t *testing.T) {
+
+	log.SetLevel(log.DebugLevel)
+
+	awsConn := &aws.ConnectionMock{
+		Records: map[string]*[]string{
+			"DescribeInstanceById": {
+				"node1", "node2", "node3",
+			},
+			"DescribeAGByName": {"one_undesired_host"},
+		},
+	}
+
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": {"default"},
+			"GetMesosSlaves":     {"default"},
+			"GetMesosTasks":      {"default"},
+		},
+	}
+
+	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	deathNodeWatcher.Run(ctx)
+
+	setTagInstanceCall := awsConn.Requests["SetInstanceTag"]
+	if setTagInstanceCall != nil {
+		t.Fatal("No instance should have been marked after shutdown was requested")
+	}
+}
+
+type leadershipMock struct {
+	isLeader bool
+}
+
+func (l *leadershipMock) IsLeader() bool {
+	return l.isLeader
+}
+
+func TestRunOnlyAsLeader(t *testing.T) {
+
+	log.SetLevel(log.DebugLevel)
+
+	awsConn := &aws.ConnectionMock{
+		Records: map[string]*[]string{
+			"DescribeInstanceById": {
+				"node1", "node2", "node3",
+			},
+			"DescribeInstancesByTag": {"one_undesired_host"},
+			"DescribeAGByName":       {"one_undesired_host"},
+		},
+	}
+
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": {"default"},
+			"GetMesosSlaves":     {"default"},
+			"GetMesosTasks":      {"default"},
+		},
+	}
+
+	leadership := &leadershipMock{}
+	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
+	deathNodeWatcher.SetLeadership(leadership)
+
+	deathNodeWatcher.Run(context.Background())
+	if len(*awsConn.Records["DescribeAGByName"]) != 1 || awsConn.Requests["SetInstanceTag"] != nil {
+		t.Fatal("Nothing should be done if the replica is not the leader")
+	}
+
+	leadership.isLeader = true
+	deathNodeWatcher.Run(context.Background())
+	if len(awsConn.Requests["SetInstanceTag"]) != 1 {
+		t.Fatalf("One instance should be marked once the replica is the leader. Actual: %d, Expected: 1", len(awsConn.Requests["SetInstanceTag"]))
+	}
+}
+
+// expiringLeadershipMock is the leader for the given number of leadership checks only
+type expiringLeadershipMock struct {
+	leaderChecks int
+}
+
+func (l *expiringLeadershipMock) IsLeader() bool {
+	l.leaderChecks--
+	return l.leaderChecks >= 0
+}
+
+func TestRunStopsWhenLeadershipIsLost(t *testing.T) {
+
+	log.SetLevel(log.DebugLevel)
+
+	awsConn := &aws.ConnectionMock{
+		Records: map[string]*[]string{
+			"DescribeInstanceById": {
+				"node1", "node2", "node3",
+			},
+			"DescribeInstancesByTag": {"one_undesired_host"},
+			"DescribeAGByName":       {"one_undesired_host"},
+		},
+	}
+
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": {"default"},
+			"GetMesosSlaves":     {"default"},
+			"GetMesosTasks":      {"default"},
+		},
+	}
+
+	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
+	deathNodeWatcher.SetLeadership(&expiringLeadershipMock{leaderChecks: 1})
+
+	deathNodeWatcher.Run(context.Background())
+	if len(*awsConn.Records["DescribeAGByName"]) != 0 || awsConn.Requests["SetInstanceTag"] != nil {
+		t.Fatal("No instance should be marked once the lease expires during the check")
+	}
+
+	if err := deathNodeWatcher.notebook.DestroyInstancesAttempt(); err != errLeadershipLost {
+		t.Fatalf("Drains should not be moved without the leadership. Actual: %v, Expected: %v", err, errLeadershipLost)
+	}
+	if awsConn.Requests["RemoveASGInstanceProtection"] != nil {
+		t.Fatal("Instance protection should not be removed without the leadership")
+	}
+}
+
+func TestLoopStopsWhenContextIsCancelled(t *testing.T) {
+
+	log.SetLevel(log.DebugLevel)
+
+	awsConn := &aws.ConnectionMock{
+		Records: map[string]*[]string{
+			"DescribeInstanceById": {
+				"node1", "node2", "node3",
+			},
+			"DescribeInstancesByTag": {"default"},
+			"DescribeAGByName":       {"default"},
+		},
+	}
+
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": {"default"},
+			"GetMesosSlaves":     {"default"},
+			"GetMesosTasks":      {"default"},
+		},
+	}
+
+	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		deathNodeWatcher.Loop(ctx, time.Hour)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Loop should have stopped after the context was cancelled")
+	}
+
+	if len(*awsConn.Records["DescribeAGByName"]) != 0 {
+		t.Fatal("Loop should have run once before stopping")
+	}
+}
+
+func TestConcurrentRuns(t *testing.T) {
+
+	log.SetLevel(log.DebugLevel)
+
+	awsConn := &aws.ConnectionMock{
+		Records: map[string]*[]string{
+			"DescribeInstanceById": {
+				"node1", "node2", "node3",
+			},
+			"DescribeInstancesByTag": {"one_undesired_host", "one_undesired_host"},
+			"DescribeAGByName":       {"one_undesired_host", "one_undesired_host"},
+		},
+	}
+
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": {"default", "default"},
+			"GetMesosSlaves":     {"default", "default"},
+			"GetMesosTasks":      {"default", "default"},
+		},
+	}
+
+	deathNodeWatcher := newWatcher(awsConn, mesosConn, 0)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			deathNodeWatcher.Run(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	setTagInstanceCall := awsConn.Requests["SetInstanceTag"]
+	if len(setTagInstanceCall) != 1 {
+		t.Fatalf("Concurrent runs should mark only one instance. Actual: %d, Expected: 1", len(setTagInstanceCall))
+	}
+
+	removeInstanceProtectionCall := awsConn.Requests["RemoveASGInstanceProtection"]
+	if len(removeInstanceProtectionCall) != 1 {
+		t.Fatalf("Instance protection should be removed only once. Actual: %d, Expected: 1", len(removeInstanceProtectionCall))
+	}
+}
+
 func newWatcher(awsConn aws.ClientInterface, mesosConn mesos.ClientInterface, delayDeleteSeconds int) *Watcher {
 
 	protectedFrameworks := []string{"frameworkName1"}
@@ -258,7 +707,7 @@ func newWatcher(awsConn aws.ClientInterface, mesosConn mesos.ClientInterface, de
 
 	mesosMonitor := monitor.NewMesosMonitor(mesosConn, protectedFrameworks)
 	autoscalingGroups, _ := monitor.NewAutoscalingGroupMonitors(awsConn, autoscalingGroupsNames, "DEATH_NODE_MARK")
-	notebook := NewNotebook(autoscalingGroups, awsConn, mesosMonitor, delayDeleteSeconds, "DEATH_NODE_MARK")
+	notebook := NewNotebook(autoscalingGroups, mesosMonitor, delayDeleteSeconds, "DEATH_NODE_MARK", false)
 	deathNodeWatcher := NewWatcher(notebook, mesosMonitor, autoscalingGroups, constraintsType, recommenderType)
 	return deathNodeWatcher
 }
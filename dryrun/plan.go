@@ -0,0 +1,89 @@
+package dryrun
+
+// Records the mutating calls against AWS and Mesos that deathnode would do, without executing them
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Action is a mutating call that has been skipped because of dry run mode
+type Action struct {
+	Service   string
+	Operation string
+	Arguments map[string]string
+}
+
+// String returns a human readable representation of the action
+func (a Action) String() string {
+
+	keys := []string{}
+	for key := range a.Arguments {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	arguments := []string{}
+	for _, key := range keys {
+		arguments = append(arguments, fmt.Sprintf("%s=%s", key, a.Arguments[key]))
+	}
+
+	return fmt.Sprintf("%s %s(%s)", a.Service, a.Operation, strings.Join(arguments, ", "))
+}
+
+// Plan stores the actions recorded since the last summary. It's safe for concurrent use
+type Plan struct {
+	mutex   sync.Mutex
+	actions []Action
+}
+
+// NewPlan returns an empty Plan
+func NewPlan() *Plan {
+	return &Plan{
+		actions: []Action{},
+	}
+}
+
+// Record stores an action, logging it
+func (p *Plan) Record(service, operation string, arguments map[string]string) {
+
+	action := Action{
+		Service:   service,
+		Operation: operation,
+		Arguments: arguments,
+	}
+	log.Infof("[DRY RUN] Skipping %s", action)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.actions = append(p.actions, action)
+}
+
+// Flush returns the actions recorded since the last call, removing them from the plan
+func (p *Plan) Flush() []Action {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	actions := p.actions
+	p.actions = []Action{}
+	return actions
+}
+
+// LogSummary logs all the actions recorded since the last summary
+func (p *Plan) LogSummary() {
+
+	actions := p.Flush()
+	if len(actions) == 0 {
+		log.Info("[DRY RUN] Plan: no actions would be executed")
+		return
+	}
+
+	log.Infof("[DRY RUN] Plan: %d actions would be executed", len(actions))
+	for i, action := range actions {
+		log.Infof("[DRY RUN]   %d. %s", i+1, action)
+	}
+}
@@ -0,0 +1,30 @@
+package dryrun
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPlan(t *testing.T) {
+
+	Convey("When recording actions in a plan", t, func() {
+		plan := NewPlan()
+		plan.Record("aws", "SetInstanceTag", map[string]string{"key": "DEATH_NODE_MARK", "instanceId": "i-34719eb8"})
+		plan.Record("mesos", "SetHostsInMaintenance", map[string]string{"myprivatedns": "10.0.0.2"})
+
+		Convey("they should be returned in order when flushed", func() {
+			actions := plan.Flush()
+			So(len(actions), ShouldEqual, 2)
+			So(actions[0].Operation, ShouldEqual, "SetInstanceTag")
+			So(actions[1].Service, ShouldEqual, "mesos")
+		})
+		Convey("they should be removed after being flushed", func() {
+			plan.Flush()
+			So(len(plan.Flush()), ShouldEqual, 0)
+		})
+		Convey("they should be printed with sorted arguments", func() {
+			So(plan.Flush()[0].String(), ShouldEqual, "aws SetInstanceTag(instanceId=i-34719eb8, key=DEATH_NODE_MARK)")
+		})
+	})
+}
@@ -0,0 +1,53 @@
+package ecs
+
+// Container instances states
+const (
+	StatusActive   = "ACTIVE"
+	StatusDraining = "DRAINING"
+)
+
+// DrainedAttribute is set on the container instances drained by deathnode, so only those are made ACTIVE
+// again, even after a restart
+const DrainedAttribute = "deathnode.drained"
+
+// ClientInterface is an interface for ECS api clients, bound to a cluster. It's implemented with the AWS
+// SDK by aws.ECSClient
+type ClientInterface interface {
+	GetContainerInstances() ([]ContainerInstance, error)
+	GetTasks(services []string) ([]Task, error)
+	DrainContainerInstances(containerInstanceARNs []string) error
+	ActivateContainerInstances(containerInstanceARNs []string) error
+}
+
+// ContainerInstance is an EC2 instance registered in the cluster, with it's private IP address
+type ContainerInstance struct {
+	ARN               string
+	EC2InstanceID     string
+	PrivateIPAddress  string
+	Status            string
+	RunningTasksCount int64
+	Attributes        map[string]string
+}
+
+// IsDrainedByDeathnode returns true if the container instance was drained by deathnode
+func (c *ContainerInstance) IsDrainedByDeathnode() bool {
+
+	_, ok := c.Attributes[DrainedAttribute]
+	return ok
+}
+
+// Task is a task running in the cluster. Service is the name of the service the task belongs to, if it's
+// one of the services requested
+type Task struct {
+	ARN                  string
+	ContainerInstanceARN string
+	TaskDefinitionARN    string
+	LastStatus           string
+	DesiredStatus        string
+	Service              string
+}
+
+// IsActive returns true if the task didn't stop yet, even if it's desired to be stopped
+func (t *Task) IsActive() bool {
+	return t.LastStatus != "STOPPED"
+}
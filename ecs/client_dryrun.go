@@ -0,0 +1,52 @@
+package ecs
+
+import (
+	"strings"
+
+	"github.com/alanbover/deathnode/dryrun"
+)
+
+const dryRunService = "ecs"
+
+// DryRunClient decorates a ClientInterface, executing the read only calls and recording the mutating
+// ones in a dry run plan instead of executing them
+type DryRunClient struct {
+	client ClientInterface
+	plan   *dryrun.Plan
+}
+
+// NewDryRunClient returns a new DryRunClient
+func NewDryRunClient(client ClientInterface, plan *dryrun.Plan) *DryRunClient {
+	return &DryRunClient{
+		client: client,
+		plan:   plan,
+	}
+}
+
+// GetContainerInstances calls the decorated client
+func (c *DryRunClient) GetContainerInstances() ([]ContainerInstance, error) {
+	return c.client.GetContainerInstances()
+}
+
+// GetTasks calls the decorated client
+func (c *DryRunClient) GetTasks(services []string) ([]Task, error) {
+	return c.client.GetTasks(services)
+}
+
+// DrainContainerInstances records the call in the dry run plan
+func (c *DryRunClient) DrainContainerInstances(containerInstanceARNs []string) error {
+
+	c.plan.Record(dryRunService, "DrainContainerInstances", map[string]string{
+		"containerInstances": strings.Join(containerInstanceARNs, ","),
+	})
+	return nil
+}
+
+// ActivateContainerInstances records the call in the dry run plan
+func (c *DryRunClient) ActivateContainerInstances(containerInstanceARNs []string) error {
+
+	c.plan.Record(dryRunService, "ActivateContainerInstances", map[string]string{
+		"containerInstances": strings.Join(containerInstanceARNs, ","),
+	})
+	return nil
+}
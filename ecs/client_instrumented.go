@@ -0,0 +1,53 @@
+package ecs
+
+import (
+	"time"
+
+	"github.com/alanbover/deathnode/metrics"
+)
+
+const metricsService = "ecs"
+
+// InstrumentedClient decorates a ClientInterface, recording the latency and errors of every call
+type InstrumentedClient struct {
+	client ClientInterface
+}
+
+// NewInstrumentedClient returns a new InstrumentedClient
+func NewInstrumentedClient(client ClientInterface) *InstrumentedClient {
+	return &InstrumentedClient{
+		client: client,
+	}
+}
+
+// GetContainerInstances calls the decorated client, recording metrics
+func (c *InstrumentedClient) GetContainerInstances() ([]ContainerInstance, error) {
+	start := time.Now()
+	containerInstances, err := c.client.GetContainerInstances()
+	metrics.ObserveAPIRequest(metricsService, "GetContainerInstances", start, err)
+	return containerInstances, err
+}
+
+// GetTasks calls the decorated client, recording metrics
+func (c *InstrumentedClient) GetTasks(services []string) ([]Task, error) {
+	start := time.Now()
+	tasks, err := c.client.GetTasks(services)
+	metrics.ObserveAPIRequest(metricsService, "GetTasks", start, err)
+	return tasks, err
+}
+
+// DrainContainerInstances calls the decorated client, recording metrics
+func (c *InstrumentedClient) DrainContainerInstances(containerInstanceARNs []string) error {
+	start := time.Now()
+	err := c.client.DrainContainerInstances(containerInstanceARNs)
+	metrics.ObserveAPIRequest(metricsService, "DrainContainerInstances", start, err)
+	return err
+}
+
+// ActivateContainerInstances calls the decorated client, recording metrics
+func (c *InstrumentedClient) ActivateContainerInstances(containerInstanceARNs []string) error {
+	start := time.Now()
+	err := c.client.ActivateContainerInstances(containerInstanceARNs)
+	metrics.ObserveAPIRequest(metricsService, "ActivateContainerInstances", start, err)
+	return err
+}
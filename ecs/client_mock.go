@@ -0,0 +1,134 @@
+package ecs
+
+import (
+	"sync"
+)
+
+// ClientMock implements ecs.ClientInterface for testing purposes. Container instances state changes are
+// reflected in the container instances returned. As ECS does, the tasks of the container instances moved
+// to DRAINING are desired to be stopped, but they keep running until StopTasks
+type ClientMock struct {
+	ContainerInstances []ContainerInstance
+	Tasks              []Task
+	Requests           map[string][]string
+	Errors             map[string]error
+	mutex              sync.Mutex
+}
+
+// GetContainerInstances mocked for testing purposes
+func (c *ClientMock) GetContainerInstances() ([]ContainerInstance, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err, ok := c.Errors["GetContainerInstances"]; ok {
+		return nil, err
+	}
+
+	containerInstances := []ContainerInstance{}
+	for _, containerInstance := range c.ContainerInstances {
+		attributes := map[string]string{}
+		for name, value := range containerInstance.Attributes {
+			attributes[name] = value
+		}
+		containerInstance.Attributes = attributes
+		containerInstances = append(containerInstances, containerInstance)
+	}
+	return containerInstances, nil
+}
+
+// GetTasks mocked for testing purposes. The service of the tasks not in services is cleared
+func (c *ClientMock) GetTasks(services []string) ([]Task, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err, ok := c.Errors["GetTasks"]; ok {
+		return nil, err
+	}
+
+	tasks := []Task{}
+	for _, task := range c.Tasks {
+		requested := false
+		for _, service := range services {
+			requested = requested || service == task.Service
+		}
+		if !requested {
+			task.Service = ""
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// DrainContainerInstances mocked for testing purposes
+func (c *ClientMock) DrainContainerInstances(containerInstanceARNs []string) error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.record("DrainContainerInstances", containerInstanceARNs); err != nil {
+		return err
+	}
+
+	for i := range c.ContainerInstances {
+		for _, arn := range containerInstanceARNs {
+			if c.ContainerInstances[i].ARN == arn {
+				c.ContainerInstances[i].Status = StatusDraining
+				if c.ContainerInstances[i].Attributes == nil {
+					c.ContainerInstances[i].Attributes = map[string]string{}
+				}
+				c.ContainerInstances[i].Attributes[DrainedAttribute] = "true"
+			}
+		}
+	}
+	for i := range c.Tasks {
+		for _, arn := range containerInstanceARNs {
+			if c.Tasks[i].ContainerInstanceARN == arn && c.Tasks[i].Service != "" {
+				c.Tasks[i].DesiredStatus = "STOPPED"
+			}
+		}
+	}
+	return nil
+}
+
+// ActivateContainerInstances mocked for testing purposes
+func (c *ClientMock) ActivateContainerInstances(containerInstanceARNs []string) error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.record("ActivateContainerInstances", containerInstanceARNs); err != nil {
+		return err
+	}
+
+	for i := range c.ContainerInstances {
+		for _, arn := range containerInstanceARNs {
+			if c.ContainerInstances[i].ARN == arn {
+				c.ContainerInstances[i].Status = StatusActive
+				delete(c.ContainerInstances[i].Attributes, DrainedAttribute)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *ClientMock) record(operation string, containerInstanceARNs []string) error {
+
+	if err, ok := c.Errors[operation]; ok {
+		return err
+	}
+	if c.Requests == nil {
+		c.Requests = map[string][]string{}
+	}
+	c.Requests[operation] = append(c.Requests[operation], containerInstanceARNs...)
+	return nil
+}
+
+// StopTasks finishes the tasks desired to be stopped
+func (c *ClientMock) StopTasks() {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i := range c.Tasks {
+		if c.Tasks[i].DesiredStatus == "STOPPED" {
+			c.Tasks[i].LastStatus = "STOPPED"
+		}
+	}
+}
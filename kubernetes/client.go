@@ -0,0 +1,354 @@
+package kubernetes
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// CordonedAnnotation is set on the nodes cordoned by deathnode, so only those are uncordoned
+const CordonedAnnotation = "deathnode/cordoned"
+
+// Paths of the service account credentials mounted in the pods
+const (
+	inClusterTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	inClusterCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// pageSize is the number of objects requested on every list call
+var pageSize = 500
+
+// ErrEvictionBlocked is returned when evicting a pod would violate it's PodDisruptionBudget
+var ErrEvictionBlocked = errors.New("eviction blocked by a PodDisruptionBudget")
+
+// ClientInterface is an interface for kubernetes api clients
+type ClientInterface interface {
+	GetNodes() ([]Node, error)
+	GetPods() ([]Pod, error)
+	CordonNode(name string) error
+	UncordonNode(name string) error
+	EvictPod(namespace, name string) error
+}
+
+// Client implements a client for the kubernetes api, authenticated with a service account token
+type Client struct {
+	url       string
+	tokenFile string
+	client    *http.Client
+}
+
+// ObjectMeta is part of every kubernetes object
+type ObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+	OwnerReferences []OwnerReference  `json:"ownerReferences,omitempty"`
+}
+
+// OwnerReference is part of the kubernetes objects metadata
+type OwnerReference struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// ListMeta is part of the kubernetes list responses
+type ListMeta struct {
+	Continue string `json:"continue,omitempty"`
+}
+
+// NodeList is the kubernetes nodes list response
+type NodeList struct {
+	Metadata ListMeta `json:"metadata"`
+	Items    []Node   `json:"items"`
+}
+
+// Node is part of the kubernetes nodes list response
+type Node struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     NodeSpec   `json:"spec"`
+	Status   NodeStatus `json:"status"`
+}
+
+// NodeSpec is part of the kubernetes nodes list response
+type NodeSpec struct {
+	Unschedulable bool `json:"unschedulable,omitempty"`
+}
+
+// NodeStatus is part of the kubernetes nodes list response
+type NodeStatus struct {
+	Addresses []NodeAddress `json:"addresses"`
+}
+
+// NodeAddress is part of the kubernetes nodes list response
+type NodeAddress struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+// PodList is the kubernetes pods list response
+type PodList struct {
+	Metadata ListMeta `json:"metadata"`
+	Items    []Pod    `json:"items"`
+}
+
+// Pod is part of the kubernetes pods list response
+type Pod struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     PodSpec    `json:"spec"`
+	Status   PodStatus  `json:"status"`
+}
+
+// PodSpec is part of the kubernetes pods list response
+type PodSpec struct {
+	NodeName string `json:"nodeName"`
+}
+
+// PodStatus is part of the kubernetes pods list response
+type PodStatus struct {
+	Phase string `json:"phase"`
+}
+
+// Eviction is the payload of the pods eviction API call
+type Eviction struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   ObjectMeta `json:"metadata"`
+}
+
+// InternalIP returns the internal IP address of the node, or empty if it has none
+func (n *Node) InternalIP() string {
+
+	for _, address := range n.Status.Addresses {
+		if address.Type == "InternalIP" {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+// IsCordonedByDeathnode returns true if the node was cordoned by deathnode
+func (n *Node) IsCordonedByDeathnode() bool {
+
+	_, ok := n.Metadata.Annotations[CordonedAnnotation]
+	return ok
+}
+
+// IsActive returns true if the pod is pending or running
+func (p *Pod) IsActive() bool {
+	return p.Status.Phase == "Pending" || p.Status.Phase == "Running"
+}
+
+// IsEvictable returns false for the pods that are not evicted when draining a node: the ones managed by
+// a DaemonSet, that would be scheduled again in the node, and the mirror pods of the static pods
+func (p *Pod) IsEvictable() bool {
+
+	if _, ok := p.Metadata.Annotations["kubernetes.io/config.mirror"]; ok {
+		return false
+	}
+	for _, owner := range p.Metadata.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return false
+		}
+	}
+	return true
+}
+
+// NewClient returns a Client for the kubernetes api at apiURL. If apiURL is empty, the in cluster
+// configuration of the pod service account is used. The token is read on every request, so rotated
+// tokens are picked up. If caFile is empty, the system CAs are trusted
+func NewClient(apiURL, tokenFile, caFile string) (*Client, error) {
+
+	if apiURL == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("kubernetesUrl is required when not running inside a kubernetes cluster")
+		}
+		apiURL = "https://" + net.JoinHostPort(host, port)
+		if tokenFile == "" {
+			tokenFile = inClusterTokenFile
+		}
+		if caFile == "" {
+			caFile = inClusterCAFile
+		}
+	}
+
+	transport := &http.Transport{}
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read kubernetes CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in kubernetes CA file %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &Client{
+		url:       strings.TrimSuffix(apiURL, "/"),
+		tokenFile: tokenFile,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   30 * time.Second,
+		},
+	}, nil
+}
+
+// GetNodes returns the nodes of the kubernetes cluster
+func (c *Client) GetNodes() ([]Node, error) {
+
+	nodes := []Node{}
+	continueToken := ""
+	for {
+		var nodeList NodeList
+		if _, err := c.apiCall("GET", listPath("/api/v1/nodes", "", continueToken), "", nil, &nodeList); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, nodeList.Items...)
+		if nodeList.Metadata.Continue == "" {
+			return nodes, nil
+		}
+		continueToken = nodeList.Metadata.Continue
+	}
+}
+
+// GetPods returns the pods of all the namespaces that didn't finish yet
+func (c *Client) GetPods() ([]Pod, error) {
+
+	pods := []Pod{}
+	continueToken := ""
+	for {
+		var podList PodList
+		path := listPath("/api/v1/pods", "status.phase!=Succeeded,status.phase!=Failed", continueToken)
+		if _, err := c.apiCall("GET", path, "", nil, &podList); err != nil {
+			return nil, err
+		}
+		pods = append(pods, podList.Items...)
+		if podList.Metadata.Continue == "" {
+			return pods, nil
+		}
+		continueToken = podList.Metadata.Continue
+	}
+}
+
+// CordonNode marks the node as unschedulable, annotating it as cordoned by deathnode
+func (c *Client) CordonNode(name string) error {
+
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{"unschedulable": true},
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{CordonedAnnotation: "true"},
+		},
+	}
+	_, err := c.apiCall("PATCH", "/api/v1/nodes/"+url.PathEscape(name), "application/merge-patch+json", patch, nil)
+	return err
+}
+
+// UncordonNode marks the node as schedulable again, removing the deathnode annotation
+func (c *Client) UncordonNode(name string) error {
+
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{"unschedulable": false},
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{CordonedAnnotation: nil},
+		},
+	}
+	_, err := c.apiCall("PATCH", "/api/v1/nodes/"+url.PathEscape(name), "application/merge-patch+json", patch, nil)
+	return err
+}
+
+// EvictPod evicts the pod through the eviction API, so it's PodDisruptionBudget is respected. It returns
+// ErrEvictionBlocked if the budget doesn't allow it now, and nil if the pod is already gone
+func (c *Client) EvictPod(namespace, name string) error {
+
+	eviction := &Eviction{
+		APIVersion: "policy/v1",
+		Kind:       "Eviction",
+		Metadata: ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/eviction", url.PathEscape(namespace), url.PathEscape(name))
+	statusCode, err := c.apiCall("POST", path, "application/json", eviction, nil)
+	switch statusCode {
+	case http.StatusTooManyRequests:
+		return ErrEvictionBlocked
+	case http.StatusNotFound:
+		return nil
+	}
+	return err
+}
+
+func listPath(path, fieldSelector, continueToken string) string {
+
+	query := url.Values{}
+	query.Set("limit", fmt.Sprintf("%d", pageSize))
+	if fieldSelector != "" {
+		query.Set("fieldSelector", fieldSelector)
+	}
+	if continueToken != "" {
+		query.Set("continue", continueToken)
+	}
+	return path + "?" + query.Encode()
+}
+
+// apiCall sends the payload, if any, encoded as JSON, and decodes the response, if any. It returns the
+// status code of the response, and an error if it's not successful
+func (c *Client) apiCall(method, path, contentType string, payload, response interface{}) (int, error) {
+
+	var body *bytes.Buffer
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewBuffer(encoded)
+	} else {
+		body = &bytes.Buffer{}
+	}
+
+	req, err := http.NewRequest(method, c.url+path, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.tokenFile != "" {
+		token, err := ioutil.ReadFile(c.tokenFile)
+		if err != nil {
+			return 0, fmt.Errorf("unable to read kubernetes token file: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("kubernetes API returned %s for %s %s", resp.Status, method, path)
+	}
+	if response != nil {
+		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+			return resp.StatusCode, fmt.Errorf("unable to decode kubernetes API response: %v", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,53 @@
+package kubernetes
+
+import (
+	"github.com/alanbover/deathnode/dryrun"
+)
+
+const dryRunService = "kubernetes"
+
+// DryRunClient decorates a ClientInterface, executing the read only calls and recording the mutating
+// ones in a dry run plan instead of executing them
+type DryRunClient struct {
+	client ClientInterface
+	plan   *dryrun.Plan
+}
+
+// NewDryRunClient returns a new DryRunClient
+func NewDryRunClient(client ClientInterface, plan *dryrun.Plan) *DryRunClient {
+	return &DryRunClient{
+		client: client,
+		plan:   plan,
+	}
+}
+
+// GetNodes calls the decorated client
+func (c *DryRunClient) GetNodes() ([]Node, error) {
+	return c.client.GetNodes()
+}
+
+// GetPods calls the decorated client
+func (c *DryRunClient) GetPods() ([]Pod, error) {
+	return c.client.GetPods()
+}
+
+// CordonNode records the call in the dry run plan
+func (c *DryRunClient) CordonNode(name string) error {
+
+	c.plan.Record(dryRunService, "CordonNode", map[string]string{"node": name})
+	return nil
+}
+
+// UncordonNode records the call in the dry run plan
+func (c *DryRunClient) UncordonNode(name string) error {
+
+	c.plan.Record(dryRunService, "UncordonNode", map[string]string{"node": name})
+	return nil
+}
+
+// EvictPod records the call in the dry run plan
+func (c *DryRunClient) EvictPod(namespace, name string) error {
+
+	c.plan.Record(dryRunService, "EvictPod", map[string]string{"namespace": namespace, "pod": name})
+	return nil
+}
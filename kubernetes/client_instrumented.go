@@ -0,0 +1,66 @@
+package kubernetes
+
+import (
+	"time"
+
+	"github.com/alanbover/deathnode/metrics"
+)
+
+const metricsService = "kubernetes"
+
+// InstrumentedClient decorates a ClientInterface, recording the latency and errors of every call
+type InstrumentedClient struct {
+	client ClientInterface
+}
+
+// NewInstrumentedClient returns a new InstrumentedClient
+func NewInstrumentedClient(client ClientInterface) *InstrumentedClient {
+	return &InstrumentedClient{
+		client: client,
+	}
+}
+
+// GetNodes calls the decorated client, recording metrics
+func (c *InstrumentedClient) GetNodes() ([]Node, error) {
+	start := time.Now()
+	nodes, err := c.client.GetNodes()
+	metrics.ObserveAPIRequest(metricsService, "GetNodes", start, err)
+	return nodes, err
+}
+
+// GetPods calls the decorated client, recording metrics
+func (c *InstrumentedClient) GetPods() ([]Pod, error) {
+	start := time.Now()
+	pods, err := c.client.GetPods()
+	metrics.ObserveAPIRequest(metricsService, "GetPods", start, err)
+	return pods, err
+}
+
+// CordonNode calls the decorated client, recording metrics
+func (c *InstrumentedClient) CordonNode(name string) error {
+	start := time.Now()
+	err := c.client.CordonNode(name)
+	metrics.ObserveAPIRequest(metricsService, "CordonNode", start, err)
+	return err
+}
+
+// UncordonNode calls the decorated client, recording metrics
+func (c *InstrumentedClient) UncordonNode(name string) error {
+	start := time.Now()
+	err := c.client.UncordonNode(name)
+	metrics.ObserveAPIRequest(metricsService, "UncordonNode", start, err)
+	return err
+}
+
+// EvictPod calls the decorated client, recording metrics. Evictions blocked by a PodDisruptionBudget
+// are not errors of the API
+func (c *InstrumentedClient) EvictPod(namespace, name string) error {
+	start := time.Now()
+	err := c.client.EvictPod(namespace, name)
+	if err == ErrEvictionBlocked {
+		metrics.ObserveAPIRequest(metricsService, "EvictPod", start, nil)
+	} else {
+		metrics.ObserveAPIRequest(metricsService, "EvictPod", start, err)
+	}
+	return err
+}
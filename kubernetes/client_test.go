@@ -0,0 +1,102 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClient(t *testing.T) {
+
+	Convey("When using a kubernetes client against an api server", t, func() {
+		pods := []Pod{
+			NewPod("default", "web-1", "node1", nil),
+			NewPod("default", "web-2", "node1", nil),
+			NewPod("batch", "job-1", "node2", nil),
+		}
+		pods[2].Status.Phase = "Succeeded"
+		fake := NewFakeAPIServer([]Node{NewNode("node1", "10.0.0.2"), NewNode("node2", "10.0.0.3")}, pods)
+		defer fake.Close()
+		client, err := NewClient(fake.URL, "", "")
+		So(err, ShouldBeNil)
+
+		Convey("lists should return all the pages", func() {
+			pageSize = 1
+			defer func() { pageSize = 500 }()
+
+			nodes, err := client.GetNodes()
+			So(err, ShouldBeNil)
+			So(len(nodes), ShouldEqual, 2)
+			So(nodes[1].InternalIP(), ShouldEqual, "10.0.0.3")
+			So(len(fake.Requests()), ShouldEqual, 2)
+		})
+		Convey("finished pods should not be returned", func() {
+			pods, err := client.GetPods()
+			So(err, ShouldBeNil)
+			So(len(pods), ShouldEqual, 2)
+		})
+		Convey("cordoning a node should annotate it, and uncordoning should remove the annotation", func() {
+			So(client.CordonNode("node1"), ShouldBeNil)
+			node, _ := fake.Node("node1")
+			So(node.Spec.Unschedulable, ShouldBeTrue)
+			So(node.IsCordonedByDeathnode(), ShouldBeTrue)
+
+			So(client.UncordonNode("node1"), ShouldBeNil)
+			node, _ = fake.Node("node1")
+			So(node.Spec.Unschedulable, ShouldBeFalse)
+			So(node.IsCordonedByDeathnode(), ShouldBeFalse)
+		})
+		Convey("cordoning an unknown node should fail", func() {
+			So(client.CordonNode("node3"), ShouldNotBeNil)
+		})
+		Convey("evictions should respect the PodDisruptionBudgets", func() {
+			fake.BlockEviction("default", "web-2")
+			So(client.EvictPod("default", "web-1"), ShouldBeNil)
+			So(client.EvictPod("default", "web-2"), ShouldEqual, ErrEvictionBlocked)
+			So(len(fake.Pods()), ShouldEqual, 2)
+		})
+		Convey("evicting a pod already gone should succeed", func() {
+			So(client.EvictPod("default", "web-3"), ShouldBeNil)
+		})
+	})
+}
+
+func TestClientAuthentication(t *testing.T) {
+
+	Convey("When the kubernetes client has a token file", t, func() {
+		var authorization string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+			w.Write([]byte(`{"items": []}`))
+		}))
+		defer server.Close()
+
+		dir, _ := ioutil.TempDir("", "deathnode")
+		defer os.RemoveAll(dir)
+		tokenFile := filepath.Join(dir, "token")
+		ioutil.WriteFile(tokenFile, []byte("token1\n"), 0600)
+		client, _ := NewClient(server.URL, tokenFile, "")
+
+		Convey("it should send the token, reading it again on every request", func() {
+			client.GetNodes()
+			So(authorization, ShouldEqual, "Bearer token1")
+			ioutil.WriteFile(tokenFile, []byte("token2"), 0600)
+			client.GetNodes()
+			So(authorization, ShouldEqual, "Bearer token2")
+		})
+	})
+
+	Convey("When the kubernetes client has no url outside a cluster", t, func() {
+		os.Unsetenv("KUBERNETES_SERVICE_HOST")
+		_, err := NewClient("", "", "")
+
+		Convey("it should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
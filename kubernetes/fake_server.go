@@ -0,0 +1,226 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FakeAPIServer is an in memory kubernetes api server, implementing the calls used by the Client. It's
+// used to test the kubernetes integration without a cluster. Evicted pods are deleted at once, unless
+// their eviction is blocked
+type FakeAPIServer struct {
+	URL      string
+	server   *httptest.Server
+	mutex    sync.Mutex
+	nodes    []Node
+	pods     []Pod
+	blocked  map[string]bool
+	requests []string
+}
+
+// NewFakeAPIServer starts a FakeAPIServer with the given nodes and pods
+func NewFakeAPIServer(nodes []Node, pods []Pod) *FakeAPIServer {
+
+	fake := &FakeAPIServer{
+		nodes:   nodes,
+		pods:    pods,
+		blocked: map[string]bool{},
+	}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.handle))
+	fake.URL = fake.server.URL
+	return fake
+}
+
+// NewNode returns a node with the given name and internal IP address
+func NewNode(name, ipAddress string) Node {
+
+	return Node{
+		Metadata: ObjectMeta{Name: name},
+		Status: NodeStatus{
+			Addresses: []NodeAddress{
+				{Type: "InternalIP", Address: ipAddress},
+				{Type: "Hostname", Address: name},
+			},
+		},
+	}
+}
+
+// NewPod returns a running pod in the given node
+func NewPod(namespace, name, nodeName string, labels map[string]string) Pod {
+
+	return Pod{
+		Metadata: ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec:     PodSpec{NodeName: nodeName},
+		Status:   PodStatus{Phase: "Running"},
+	}
+}
+
+// Close shuts down the server
+func (f *FakeAPIServer) Close() {
+	f.server.Close()
+}
+
+// BlockEviction makes the evictions of the pod fail as if they violated it's PodDisruptionBudget
+func (f *FakeAPIServer) BlockEviction(namespace, name string) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.blocked[namespace+"/"+name] = true
+}
+
+// Requests returns the requests received, as "METHOD path"
+func (f *FakeAPIServer) Requests() []string {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string{}, f.requests...)
+}
+
+// Node returns the current state of a node, and false if it doesn't exist
+func (f *FakeAPIServer) Node(name string) (Node, bool) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, node := range f.nodes {
+		if node.Metadata.Name == name {
+			return node, true
+		}
+	}
+	return Node{}, false
+}
+
+// Pods returns the pods not evicted yet
+func (f *FakeAPIServer) Pods() []Pod {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]Pod{}, f.pods...)
+}
+
+func (f *FakeAPIServer) handle(w http.ResponseWriter, r *http.Request) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/v1/nodes":
+		items, continueToken := page(len(f.nodes), r)
+		writeJSON(w, http.StatusOK, &NodeList{
+			Metadata: ListMeta{Continue: continueToken},
+			Items:    f.nodes[items[0]:items[1]],
+		})
+	case r.Method == "GET" && r.URL.Path == "/api/v1/pods":
+		pods := []Pod{}
+		for _, pod := range f.pods {
+			if matchesPhaseSelector(pod, r.URL.Query().Get("fieldSelector")) {
+				pods = append(pods, pod)
+			}
+		}
+		items, continueToken := page(len(pods), r)
+		writeJSON(w, http.StatusOK, &PodList{
+			Metadata: ListMeta{Continue: continueToken},
+			Items:    pods[items[0]:items[1]],
+		})
+	case r.Method == "PATCH" && len(parts) == 4 && parts[2] == "nodes":
+		f.patchNode(w, r, parts[3])
+	case r.Method == "POST" && len(parts) == 7 && parts[4] == "pods" && parts[6] == "eviction":
+		f.evictPod(w, parts[3], parts[5])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *FakeAPIServer) patchNode(w http.ResponseWriter, r *http.Request, name string) {
+
+	var patch struct {
+		Spec struct {
+			Unschedulable *bool `json:"unschedulable"`
+		} `json:"spec"`
+		Metadata struct {
+			Annotations map[string]*string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if r.Header.Get("Content-Type") != "application/merge-patch+json" || json.NewDecoder(r.Body).Decode(&patch) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for i := range f.nodes {
+		node := &f.nodes[i]
+		if node.Metadata.Name != name {
+			continue
+		}
+		if patch.Spec.Unschedulable != nil {
+			node.Spec.Unschedulable = *patch.Spec.Unschedulable
+		}
+		for key, value := range patch.Metadata.Annotations {
+			if value == nil {
+				delete(node.Metadata.Annotations, key)
+				continue
+			}
+			if node.Metadata.Annotations == nil {
+				node.Metadata.Annotations = map[string]string{}
+			}
+			node.Metadata.Annotations[key] = *value
+		}
+		writeJSON(w, http.StatusOK, node)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func (f *FakeAPIServer) evictPod(w http.ResponseWriter, namespace, name string) {
+
+	for i, pod := range f.pods {
+		if pod.Metadata.Namespace != namespace || pod.Metadata.Name != name {
+			continue
+		}
+		if f.blocked[namespace+"/"+name] {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		f.pods = append(f.pods[:i], f.pods[i+1:]...)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// page returns the range of the items to return for the limit and continue parameters, and the continue
+// token of the next page, if any
+func page(total int, r *http.Request) ([2]int, string) {
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("continue"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || start+limit >= total {
+		if start > total {
+			start = total
+		}
+		return [2]int{start, total}, ""
+	}
+	return [2]int{start, start + limit}, strconv.Itoa(start + limit)
+}
+
+// matchesPhaseSelector supports field selectors excluding phases, like status.phase!=Succeeded
+func matchesPhaseSelector(pod Pod, fieldSelector string) bool {
+
+	for _, selector := range strings.Split(fieldSelector, ",") {
+		if strings.HasPrefix(selector, "status.phase!=") && strings.TrimPrefix(selector, "status.phase!=") == pod.Status.Phase {
+			return false
+		}
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, response interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
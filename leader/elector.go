@@ -0,0 +1,150 @@
+package leader
+
+// Elects a leader between deathnode replicas, using a lease stored in a shared lock
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alanbover/deathnode/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	leaderGauge          = metrics.NewGauge("deathnode_leader", "Whether this deathnode replica is the leader (1) or not (0)")
+	leaderChangesCounter = metrics.NewCounter("deathnode_leader_changes_total", "Number of times this deathnode replica acquired or lost the leadership")
+)
+
+// Lock is a lease shared by all deathnode replicas
+type Lock interface {
+	// TryAcquire acquires the lease for the holder, or renews it if it's already the holder. Returns
+	// false if the lease is held by other holder and it's not expired. The fencing token returned grows
+	// every time the lease is acquired by a different holder
+	TryAcquire(holder string, leaseDuration time.Duration) (int64, bool, error)
+	// Release frees the lease, if it's held by the holder
+	Release(holder string) error
+}
+
+// Elector periodically tries to acquire or renew the lease. The replica is the leader while the lease
+// is held and not expired. It's safe for concurrent use
+type Elector struct {
+	lock          Lock
+	id            string
+	leaseDuration time.Duration
+	mutex         sync.RWMutex
+	leaseExpiry   time.Time
+	fencingToken  int64
+	wasLeader     bool
+}
+
+// NewElector returns a new Elector, identified by id
+func NewElector(lock Lock, id string, leaseDuration time.Duration) *Elector {
+
+	return &Elector{
+		lock:          lock,
+		id:            id,
+		leaseDuration: leaseDuration,
+	}
+}
+
+// IsLeader returns true if the lease is held and not expired. An expired lease is never considered
+// held, even if it could not be renewed because of an error
+func (e *Elector) IsLeader() bool {
+
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return time.Now().Before(e.leaseExpiry)
+}
+
+// FencingToken returns the fencing token of the lease held, or 0 if it's not held. Writes tagged with it
+// can be rejected once a newer leader wrote with a greater token
+func (e *Elector) FencingToken() int64 {
+
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	if !time.Now().Before(e.leaseExpiry) {
+		return 0
+	}
+	return e.fencingToken
+}
+
+// Run tries to acquire or renew the lease three times per lease duration, until the context is
+// cancelled. Then, the lease is released if held
+func (e *Elector) Run(ctx context.Context) {
+
+	ticker := time.NewTicker(e.leaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		e.tryAcquire()
+
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Elector) tryAcquire() {
+
+	start := time.Now()
+	token, acquired, err := e.lock.TryAcquire(e.id, e.leaseDuration)
+
+	e.mutex.Lock()
+	if err != nil {
+		log.Warnf("Unable to acquire or renew leadership lease as %s: %v", e.id, err)
+	} else if acquired {
+		// The lease duration is counted from the request, so it never expires later than in the lock
+		e.leaseExpiry = start.Add(e.leaseDuration)
+		e.fencingToken = token
+	} else {
+		e.leaseExpiry = time.Time{}
+	}
+	e.mutex.Unlock()
+
+	e.updateLeadership()
+}
+
+func (e *Elector) release() {
+
+	if !e.IsLeader() {
+		return
+	}
+
+	err := e.lock.Release(e.id)
+	if err != nil {
+		log.Warnf("Unable to release leadership lease as %s: %v", e.id, err)
+	}
+
+	e.mutex.Lock()
+	e.leaseExpiry = time.Time{}
+	e.mutex.Unlock()
+
+	e.updateLeadership()
+}
+
+// updateLeadership logs and records leadership changes
+func (e *Elector) updateLeadership() {
+
+	isLeader := e.IsLeader()
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if isLeader == e.wasLeader {
+		return
+	}
+	e.wasLeader = isLeader
+
+	if isLeader {
+		log.Infof("Leadership acquired as %s", e.id)
+		leaderGauge.Set(1)
+	} else {
+		log.Infof("Leadership lost as %s", e.id)
+		leaderGauge.Set(0)
+	}
+	leaderChangesCounter.Inc()
+}
@@ -0,0 +1,119 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// lockMock returns the recorded responses for TryAcquire, in order, with the token
+type lockMock struct {
+	responses []bool
+	token     int64
+	err       error
+	released  bool
+}
+
+func (l *lockMock) TryAcquire(holder string, leaseDuration time.Duration) (int64, bool, error) {
+	if l.err != nil {
+		return 0, false, l.err
+	}
+	response := l.responses[0]
+	l.responses = l.responses[1:]
+	return l.token, response, nil
+}
+
+func (l *lockMock) Release(holder string) error {
+	l.released = true
+	return nil
+}
+
+func TestElector(t *testing.T) {
+
+	Convey("When electing a leader", t, func() {
+		lock := &lockMock{responses: []bool{true, false}, token: 7}
+		elector := NewElector(lock, "replica1", time.Minute)
+
+		Convey("it should not be the leader before acquiring the lease", func() {
+			So(elector.IsLeader(), ShouldBeFalse)
+			So(elector.FencingToken(), ShouldEqual, 0)
+		})
+		Convey("it should be the leader after acquiring the lease", func() {
+			changes := leaderChangesCounter.Value()
+			elector.tryAcquire()
+			So(elector.IsLeader(), ShouldBeTrue)
+			So(elector.FencingToken(), ShouldEqual, 7)
+			So(leaderGauge.Value(), ShouldEqual, 1)
+			So(leaderChangesCounter.Value(), ShouldEqual, changes+1)
+
+			Convey("and it should stop being the leader if other replica holds the lease", func() {
+				elector.tryAcquire()
+				So(elector.IsLeader(), ShouldBeFalse)
+				So(elector.FencingToken(), ShouldEqual, 0)
+				So(leaderGauge.Value(), ShouldEqual, 0)
+			})
+			Convey("and it should keep being the leader on errors, until the lease expires", func() {
+				lock.err = errors.New("lock unavailable")
+				elector.tryAcquire()
+				So(elector.IsLeader(), ShouldBeTrue)
+				elector.leaseExpiry = time.Now().Add(-time.Second)
+				elector.tryAcquire()
+				So(elector.IsLeader(), ShouldBeFalse)
+			})
+		})
+		Convey("it should release the lease when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			elector.Run(ctx)
+			So(lock.released, ShouldBeTrue)
+			So(elector.IsLeader(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestFileLock(t *testing.T) {
+
+	Convey("When using a file lock", t, func() {
+		dir, _ := ioutil.TempDir("", "deathnode")
+		defer os.RemoveAll(dir)
+		lock := NewFileLock(filepath.Join(dir, "deathnode.lock"))
+
+		Convey("the first replica should acquire the lease", func() {
+			token, acquired, err := lock.TryAcquire("replica1", time.Minute)
+			So(err, ShouldBeNil)
+			So(acquired, ShouldBeTrue)
+			So(token, ShouldEqual, 1)
+
+			Convey("and renew it, keeping the fencing token", func() {
+				token, acquired, _ := lock.TryAcquire("replica1", time.Minute)
+				So(acquired, ShouldBeTrue)
+				So(token, ShouldEqual, 1)
+			})
+			Convey("while other replicas can't acquire it", func() {
+				_, acquired, _ := lock.TryAcquire("replica2", time.Minute)
+				So(acquired, ShouldBeFalse)
+			})
+			Convey("until it's released, increasing the fencing token for the next holder", func() {
+				So(lock.Release("replica2"), ShouldBeNil)
+				_, acquired, _ := lock.TryAcquire("replica2", time.Minute)
+				So(acquired, ShouldBeFalse)
+				So(lock.Release("replica1"), ShouldBeNil)
+				token, acquired, _ = lock.TryAcquire("replica2", time.Minute)
+				So(acquired, ShouldBeTrue)
+				So(token, ShouldEqual, 2)
+			})
+		})
+		Convey("an expired lease should be acquired by other replicas, with a greater fencing token", func() {
+			lock.TryAcquire("replica1", -time.Second)
+			token, acquired, _ := lock.TryAcquire("replica2", time.Minute)
+			So(acquired, ShouldBeTrue)
+			So(token, ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,110 @@
+package leader
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"time"
+)
+
+// FileLock is a Lock stored in a file of a shared filesystem, meant for local testing. The file is
+// locked with flock while it's read and updated, so it requires a filesystem supporting it
+type FileLock struct {
+	path string
+}
+
+type fileLease struct {
+	Holder       string    `json:"holder"`
+	Expires      time.Time `json:"expires"`
+	FencingToken int64     `json:"fencingToken"`
+}
+
+// NewFileLock returns a FileLock stored in path
+func NewFileLock(path string) *FileLock {
+	return &FileLock{
+		path: path,
+	}
+}
+
+// TryAcquire acquires or renews the lease, if it's free, expired or already held by the holder. The
+// fencing token is increased when the holder changes
+func (l *FileLock) TryAcquire(holder string, leaseDuration time.Duration) (int64, bool, error) {
+
+	acquired := false
+	var token int64
+	err := l.update(func(lease *fileLease) bool {
+		if lease.Holder != "" && lease.Holder != holder && time.Now().Before(lease.Expires) {
+			return false
+		}
+		if lease.Holder != holder {
+			lease.FencingToken++
+		}
+		lease.Holder = holder
+		lease.Expires = time.Now().Add(leaseDuration)
+		acquired = true
+		token = lease.FencingToken
+		return true
+	})
+
+	return token, acquired, err
+}
+
+// Release frees the lease, if it's held by the holder. The fencing token is kept, so it keeps growing
+func (l *FileLock) Release(holder string) error {
+
+	return l.update(func(lease *fileLease) bool {
+		if lease.Holder != holder {
+			return false
+		}
+		*lease = fileLease{FencingToken: lease.FencingToken}
+		return true
+	})
+}
+
+// update reads the lease while holding an exclusive flock on the file, writing it back if f returns true
+func (l *FileLock) update(f func(*fileLease) bool) error {
+
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	lease := &fileLease{}
+	if len(content) > 0 {
+		err = json.Unmarshal(content, lease)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !f(lease) {
+		return nil
+	}
+
+	content, err = json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	err = file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(content, 0)
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
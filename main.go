@@ -1,100 +1,855 @@
 package main
 
+import "context"
 import "time"
 import "flag"
+import "fmt"
+import "io/ioutil"
+import "net/http"
+import "os"
+import "os/signal"
+import "strings"
+import "syscall"
 
 import (
+	"github.com/alanbover/deathnode/audit"
 	"github.com/alanbover/deathnode/aws"
+	"github.com/alanbover/deathnode/config"
+	"github.com/alanbover/deathnode/dryrun"
+	"github.com/alanbover/deathnode/ecs"
+	"github.com/alanbover/deathnode/leader"
+	"github.com/alanbover/deathnode/marathon"
+	"github.com/alanbover/deathnode/metrics"
 	"github.com/alanbover/deathnode/monitor"
+	"github.com/alanbover/deathnode/nomad"
+	"github.com/alanbover/deathnode/notifier"
 	"github.com/alanbover/deathnode/deathnode"
+	"github.com/alanbover/deathnode/kubernetes"
 	"github.com/alanbover/deathnode/mesos"
+	"github.com/alanbover/deathnode/store"
 	log "github.com/sirupsen/logrus"
 )
 
 type arrayFlags []string
 
-var accessKey, secretKey, region, iamRole, iamSession, mesosURL, constraintsType, recommenderType, deathNodeMark string
+// configFileCheckInterval is how often the configuration file is checked for changes
+const configFileCheckInterval = 10 * time.Second
+
+var configFile, accessKey, secretKey, region, iamRole, iamSession, mesosURL, constraintsType, recommenderType, deathNodeMark string
+var scheduler, kubernetesURL, kubernetesTokenFile, kubernetesCAFile, nomadURL, nomadToken string
+var nomadDrainDeadline time.Duration
+var ecsCluster, ecsEndpoint string
+var marathonURL, marathonKillMode string
+var marathonMaxConcurrentKills int
+var awsEndpoint, ec2Endpoint, autoscalingEndpoint, elbEndpoint, stsEndpoint, dynamodbEndpoint string
+var httpAddress, leaderElection, leaderLockTable, leaderLockName, leaderLockFile, leaderID string
+var stateStore, stateFile, stateTable, stateName string
+var auditFile, auditWebhookURL string
+var auditWebhookTimeout time.Duration
+var notifyWebhookURL, notifyWebhookTemplate, notifyWebhookSecret string
+var operatorToken string
+var notifyWebhookTimeout, notifyStuckAfter time.Duration
+var notifyWebhookRetries int
+var snsTopicARN, snsEndpoint string
+var cloudWatchNamespace, cloudWatchEndpoint string
+var cloudWatchInterval time.Duration
+var leaderLeaseDuration time.Duration
+var awsProfile, iamExternalID, mfaSerial, mfaTokenCode, webIdentityTokenFile string
+var iamSessionDuration time.Duration
 var autoscalingGroupPrefixes, protectedFrameworks arrayFlags
-var pollingSeconds, delayDeleteSeconds int
-var debug bool
+var pollingSeconds, delayDeleteSeconds, drainTimeoutSeconds, maxConcurrentDrains, healthCheckIntervals int
+var debug, dryRun, awsDisableSSL, awsForcePathStyle, deregisterFromLBs, operatorCommands, auditStdout bool
+
+// version is set at build time with -ldflags "-X main.version=<version>"
+var version = "dev"
 
 func main() {
 
 	initFlags()
-	enforceFlags()
+	deathNodeConfig := loadConfig()
 
 	log.SetLevel(log.InfoLevel)
 	if debug {
 		log.SetLevel(log.DebugLevel)
 	}
+	log.Infof("Starting deathnode %s", version)
+
+	// Operator commands change the instances, so they are never accepted without authentication
+	if operatorCommands && operatorToken == "" {
+		log.Fatal("-operatorCommands requires -operatorToken, to authenticate the operator commands")
+	}
 
-	// Create the monitors for autoscaling groups
-	awsConn, err := aws.NewClient(accessKey, secretKey, region, iamRole, iamSession)
+	id, err := replicaID()
+	if err != nil {
+		log.Fatal("Error getting the replica identity: ", err)
+	}
+
+	// On dry run mode, mutating calls to AWS and the scheduler are recorded instead of executed
+	var plan *dryrun.Plan
+	if dryRun {
+		log.Info("Running in dry run mode. No changes will be done in AWS or the scheduler")
+		plan = dryrun.NewPlan()
+	}
+
+	tokenProvider, err := mfaTokenProvider()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create the monitors for autoscaling groups, with one AWS connection per account and region
+	awsConfig := &aws.ClientConfig{
+		AccessKey:            accessKey,
+		SecretKey:            secretKey,
+		Region:               region,
+		Profile:              awsProfile,
+		IAMRole:              iamRole,
+		IAMSession:           iamSession,
+		ExternalID:           iamExternalID,
+		MFASerial:            mfaSerial,
+		MFATokenProvider:     tokenProvider,
+		WebIdentityTokenFile: webIdentityTokenFile,
+		SessionDuration:      iamSessionDuration,
+		Endpoints: aws.Endpoints{
+			Default:        awsEndpoint,
+			EC2:            ec2Endpoint,
+			Autoscaling:    autoscalingEndpoint,
+			ELB:            elbEndpoint,
+			STS:            stsEndpoint,
+			DynamoDB:       dynamodbEndpoint,
+			SNS:            snsEndpoint,
+			CloudWatch:     cloudWatchEndpoint,
+			ECS:            ecsEndpoint,
+			DisableSSL:     awsDisableSSL,
+			ForcePathStyle: awsForcePathStyle,
+		},
+	}
+	awsConnections := map[string]aws.ClientInterface{}
+	autoscalingGroupSelectors, err := newAutoscalingGroupSelectors(deathNodeConfig, plan, awsConfig, awsConnections)
 	if err != nil {
 		log.Fatal("Error connecting to AWS: ", err)
 	}
-	autoscalingGroups, _ := monitor.NewAutoscalingGroupMonitors(awsConn, autoscalingGroupPrefixes, deathNodeMark)
+	autoscalingGroups, err := monitor.NewAutoscalingGroupMonitorsFromSelectors(autoscalingGroupSelectors, deathNodeConfig.DeathNodeMark)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create the monitor of the scheduler the instances are drained from
+	schedulerMonitor, err := newScheduler(deathNodeConfig, plan, awsConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Create deathnoteWatcher. The defaults are used only for autoscaling groups without policy
+	defaults := deathNodeConfig.Defaults
+	notebook := deathnode.NewNotebook(autoscalingGroups, schedulerMonitor, defaults.DelayDeleteSeconds,
+		deathNodeConfig.DeathNodeMark, deathNodeConfig.DeregisterFromLoadBalancers)
+	deathNodeWatcher := deathnode.NewWatcher(notebook, schedulerMonitor, autoscalingGroups, defaults.ConstraintsType, defaults.RecommenderType)
+	if dryRun {
+		deathNodeWatcher.SetDryRunPlan(plan)
+	}
+
+	// Persist the drains and delays, unless running in dry run mode
+	var persistentStore store.Store
+	if stateStore != "" && !dryRun {
+		persistentStore, err = newStateStore(awsConfig)
+		if err != nil {
+			log.Fatal("Error setting up the state store: ", err)
+		}
+		err = notebook.SetStore(persistentStore)
+		if err != nil {
+			log.Fatal("Error loading the state: ", err)
+		}
+	}
+
+	// Stop gracefully on SIGTERM/SIGINT, finishing the step in progress
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		log.Infof("Received signal %s. Shutting down...", sig)
+		cancel()
+	}()
+
+	// Record every destructive action in the audit log
+	auditLogger, err := newAuditLogger(ctx, id)
+	if err != nil {
+		log.Fatal("Error setting up the audit log: ", err)
+	}
+	notebook.SetAuditLogger(auditLogger)
+
+	// Notify the drain lifecycle events to a webhook and SNS, unless running in dry run mode
+	if !dryRun {
+		eventNotifier, err := newNotifier(ctx, awsConfig)
+		if err != nil {
+			log.Fatal("Error setting up the notifications: ", err)
+		}
+		notebook.SetNotifier(eventNotifier, notifyStuckAfter)
+	}
+
+	// Push the drain metrics to CloudWatch, unless running in dry run mode
+	cloudWatchDone := make(chan struct{})
+	close(cloudWatchDone)
+	if cloudWatchNamespace != "" && !dryRun {
+		publisher, err := aws.NewCloudWatchPublisher(awsConfig, cloudWatchNamespace, cloudWatchInterval)
+		if err != nil {
+			log.Fatal("Error setting up CloudWatch metrics: ", err)
+		}
+		notebook.SetMetricsPublisher(publisher)
+		cloudWatchDone = make(chan struct{})
+		go func() {
+			publisher.Run(ctx)
+			close(cloudWatchDone)
+		}()
+	}
+
+	// Expose the metrics, the status and the health checks through HTTP
+	pollingInterval := time.Second * time.Duration(deathNodeConfig.PollingSeconds)
+	if httpAddress != "" {
+		healthCheckMaxDelay := pollingInterval * time.Duration(healthCheckIntervals)
+		http.Handle("/metrics", metrics.DefaultRegistry)
+		http.Handle("/status", deathnode.NewStatusHandler(deathNodeWatcher))
+		http.Handle("/healthz", deathnode.NewLivenessHandler(deathNodeWatcher, healthCheckMaxDelay))
+		http.Handle("/readyz", deathnode.NewReadinessHandler(deathNodeWatcher, healthCheckMaxDelay))
+		if operatorCommands {
+			http.Handle("/instances/", deathnode.NewOperatorHandler(deathNodeWatcher, operatorToken))
+		}
+		go func() {
+			log.Infof("Listening on %s", httpAddress)
+			log.Fatal(http.ListenAndServe(httpAddress, nil))
+		}()
+	}
+
+	// Run the checks only while being the leader, if leader election is enabled. The elector has it's own
+	// context, so the lease is kept until the check in progress finishes
+	electorCtx, stopElector := context.WithCancel(context.Background())
+	electorDone := make(chan struct{})
+	close(electorDone)
+	if leaderElection != "" {
+		elector, err := newLeaderElector(awsConfig, id)
+		if err != nil {
+			log.Fatal("Error setting up leader election: ", err)
+		}
+		deathNodeWatcher.SetLeadership(elector)
+		// Reject the state saves of this replica once a newer leader saved it
+		if dynamoDBStore, ok := persistentStore.(*aws.DynamoDBStore); ok {
+			dynamoDBStore.SetFencing(elector)
+		}
+		electorDone = make(chan struct{})
+		go func() {
+			elector.Run(electorCtx)
+			close(electorDone)
+		}()
+	}
+
+	// Reload the configuration file on SIGHUP or when it changes
+	if configFile != "" {
+		reloads := make(chan string, 1)
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		go func() {
+			for range hangups {
+				requestReload(reloads, "SIGHUP received")
+			}
+		}()
+		go watchConfigFile(ctx, configFile, reloads)
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case reason := <-reloads:
+					log.Infof("Reloading configuration file %s: %s", configFile, reason)
+					err := reloadConfig(deathNodeWatcher, deathNodeConfig, plan, awsConfig, awsConnections)
+					if err != nil {
+						log.Errorf("Unable to reload configuration. Keeping the current one: %v", err)
+					}
+				}
+			}
+		}()
+	}
+
+	deathNodeWatcher.Loop(ctx, pollingInterval)
+	stopElector()
+
+	// Wait for the leadership lease to be released, and the pending metrics to be pushed
+	<-electorDone
+	<-cloudWatchDone
+}
+
+// replicaID returns the identity of this replica, set with the leaderId flag or built from the hostname
+// and pid
+func replicaID() (string, error) {
+
+	if leaderID != "" {
+		return leaderID, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid()), nil
+}
+
+// newLeaderElector returns the leader elector for the lock selected with the leaderElection flag
+func newLeaderElector(awsConfig *aws.ClientConfig, id string) (*leader.Elector, error) {
+
+	var lock leader.Lock
+	switch leaderElection {
+	case "dynamodb":
+		if leaderLockTable == "" {
+			return nil, fmt.Errorf("leaderLockTable flag is required for dynamodb leader election")
+		}
+		dynamoDBLock, err := aws.NewDynamoDBLock(awsConfig, leaderLockTable, leaderLockName)
+		if err != nil {
+			return nil, err
+		}
+		lock = dynamoDBLock
+	case "file":
+		if leaderLockFile == "" {
+			return nil, fmt.Errorf("leaderLockFile flag is required for file leader election")
+		}
+		lock = leader.NewFileLock(leaderLockFile)
+	default:
+		return nil, fmt.Errorf("unknown leader election type %s", leaderElection)
+	}
+
+	log.Infof("Leader election enabled using %s lock, as %s", leaderElection, id)
+	return leader.NewElector(lock, id, leaderLeaseDuration), nil
+}
+
+// newAuditLogger returns an audit logger writing to the outputs selected with the audit flags. Records
+// are stamped with the replica identity, as only the leader takes actions
+func newAuditLogger(ctx context.Context, id string) (*audit.Logger, error) {
+
+	outputs := []audit.Output{}
+	if auditStdout {
+		outputs = append(outputs, audit.NewWriterOutput(os.Stdout))
+	}
+	if auditFile != "" {
+		fileOutput, err := audit.NewFileOutput(auditFile)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, fileOutput)
+	}
+	if auditWebhookURL != "" {
+		webhookOutput := audit.NewWebhookOutput(auditWebhookURL, auditWebhookTimeout)
+		go webhookOutput.Run(ctx)
+		outputs = append(outputs, webhookOutput)
+	}
+
+	return audit.NewLogger(version, id, dryRun, outputs...), nil
+}
+
+// webhookEvents are the events notified to the webhook, as they need operators attention
+var webhookEvents = []string{notifier.EventDrainStarted, notifier.EventDrainStuck, notifier.EventDrainFailed,
+	notifier.EventInstanceTerminated}
+
+// newNotifier returns a notifier sending the events to the webhook and SNS topic selected with the
+// flags, if any, in background until the context is cancelled
+func newNotifier(ctx context.Context, awsConfig *aws.ClientConfig) (notifier.Notifier, error) {
+
+	notifiers := notifier.Multi{}
+	if notifyWebhookURL != "" {
+		webhook, err := newNotifyWebhook()
+		if err != nil {
+			return nil, err
+		}
+		queue := notifier.NewQueue("webhook", webhook)
+		go queue.Run(ctx)
+		notifiers = append(notifiers, notifier.NewFilter(queue, webhookEvents...))
+	}
+	if snsTopicARN != "" {
+		publisher, err := aws.NewSNSPublisher(awsConfig, snsTopicARN)
+		if err != nil {
+			return nil, err
+		}
+		queue := notifier.NewQueue("sns", publisher)
+		go queue.Run(ctx)
+		notifiers = append(notifiers, notifier.NewFilter(queue, aws.SNSEventTypes()...))
+	}
+	return notifiers, nil
+}
+
+// newNotifyWebhook returns a webhook notifier configured with the notify flags. The body template is
+// read from the notifyWebhookTemplate file, if set
+func newNotifyWebhook() (*notifier.Webhook, error) {
+
+	bodyTemplate := ""
+	if notifyWebhookTemplate != "" {
+		content, err := ioutil.ReadFile(notifyWebhookTemplate)
+		if err != nil {
+			return nil, err
+		}
+		bodyTemplate = string(content)
+	}
+	return notifier.NewWebhook(notifyWebhookURL, bodyTemplate, notifyWebhookSecret, notifyWebhookTimeout, notifyWebhookRetries)
+}
+
+// newScheduler returns the monitor of the scheduler set in the configuration. On dry run mode, it's
+// mutating calls are recorded in the plan
+func newScheduler(deathNodeConfig *config.Config, plan *dryrun.Plan, awsConfig *aws.ClientConfig) (monitor.Scheduler, error) {
+
+	switch deathNodeConfig.Scheduler {
+	case config.SchedulerMesos:
+		var mesosConn mesos.ClientInterface = mesos.NewInstrumentedClient(&mesos.Client{
+			MasterURL: deathNodeConfig.MesosURL,
+		})
+		if dryRun {
+			mesosConn = mesos.NewDryRunClient(mesosConn, plan)
+		}
+		mesosMonitor := monitor.NewMesosMonitor(mesosConn, deathNodeConfig.ProtectedFrameworks())
+		if deathNodeConfig.MarathonURL == "" {
+			return mesosMonitor, nil
+		}
+		if marathonKillMode != marathon.KillModeWipe && marathonKillMode != marathon.KillModeScale {
+			return nil, fmt.Errorf("unknown marathon kill mode %s", marathonKillMode)
+		}
+		if marathonMaxConcurrentKills <= 0 {
+			return nil, fmt.Errorf("marathonMaxConcurrentKills must be greater than 0, found %d", marathonMaxConcurrentKills)
+		}
+		var marathonConn marathon.ClientInterface = marathon.NewInstrumentedClient(marathon.NewClient(deathNodeConfig.MarathonURL))
+		if dryRun {
+			marathonConn = marathon.NewDryRunClient(marathonConn, plan)
+		}
+		return monitor.NewMarathonDrainer(mesosMonitor, marathonConn, marathonKillMode, marathonMaxConcurrentKills), nil
+	case config.SchedulerKubernetes:
+		client, err := kubernetes.NewClient(deathNodeConfig.KubernetesURL, kubernetesTokenFile, kubernetesCAFile)
+		if err != nil {
+			return nil, err
+		}
+		var kubernetesConn kubernetes.ClientInterface = kubernetes.NewInstrumentedClient(client)
+		if dryRun {
+			kubernetesConn = kubernetes.NewDryRunClient(kubernetesConn, plan)
+		}
+		return monitor.NewKubernetesMonitor(kubernetesConn, deathNodeConfig.ProtectedFrameworks()), nil
+	case config.SchedulerNomad:
+		var nomadConn nomad.ClientInterface = nomad.NewInstrumentedClient(nomad.NewClient(deathNodeConfig.NomadURL, nomadToken))
+		if dryRun {
+			nomadConn = nomad.NewDryRunClient(nomadConn, plan)
+		}
+		return monitor.NewNomadMonitor(nomadConn, deathNodeConfig.ProtectedFrameworks(), nomadDrainDeadline), nil
+	case config.SchedulerECS:
+		client, err := aws.NewECSClient(awsConfig, deathNodeConfig.ECSCluster)
+		if err != nil {
+			return nil, err
+		}
+		var ecsConn ecs.ClientInterface = ecs.NewInstrumentedClient(client)
+		if dryRun {
+			ecsConn = ecs.NewDryRunClient(ecsConn, plan)
+		}
+		return monitor.NewECSMonitor(ecsConn, deathNodeConfig.ProtectedFrameworks()), nil
+	default:
+		return nil, fmt.Errorf("unknown scheduler %s", deathNodeConfig.Scheduler)
+	}
+}
+
+// newStateStore returns the store selected with the stateStore flag
+func newStateStore(awsConfig *aws.ClientConfig) (store.Store, error) {
+
+	switch stateStore {
+	case "dynamodb":
+		if stateTable == "" {
+			return nil, fmt.Errorf("stateTable flag is required for dynamodb state store")
+		}
+		return aws.NewDynamoDBStore(awsConfig, stateTable, stateName)
+	case "file":
+		if stateFile == "" {
+			return nil, fmt.Errorf("stateFile flag is required for file state store")
+		}
+		return store.NewFileStore(stateFile), nil
+	default:
+		return nil, fmt.Errorf("unknown state store type %s", stateStore)
+	}
+}
+
+// mfaTokenProvider returns the provider of the MFA token codes used for assuming iamRole, if any. As the
+// role session is renewed while deathnode runs, a new token code is asked for on stdin every time, so
+// stdin must be a terminal
+func mfaTokenProvider() (aws.TokenProvider, error) {
+
+	if mfaSerial == "" {
+		return nil, nil
+	}
+
+	tokenProvider, err := aws.StdinTokenProvider(mfaTokenCode)
+	if err != nil {
+		return nil, fmt.Errorf("-mfaSerial requires an interactive terminal: %v", err)
+	}
+	return tokenProvider, nil
+}
+
+// loadConfig reads the configuration. Exits if it's not valid
+func loadConfig() *config.Config {
+
+	deathNodeConfig, err := readConfig()
+	if err != nil {
+		flag.Usage()
+		log.Fatal(err)
+	}
+
+	return deathNodeConfig
+}
+
+// readConfig reads the configuration file, if any, overriding it's values with the flags set in the
+// command line, and validates the result
+func readConfig() (*config.Config, error) {
+
+	deathNodeConfig := config.New()
+	if configFile != "" {
+		var err error
+		deathNodeConfig, err = config.Load(configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyFlags(deathNodeConfig); err != nil {
+		return nil, err
+	}
+
+	if err := deathNodeConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+	for _, autoscalingGroup := range deathNodeConfig.AutoscalingGroups {
+		if err := deathnode.ValidatePolicy(autoscalingGroup.Policy); err != nil {
+			return nil, fmt.Errorf("invalid configuration: autoscalingGroup %s: %v", autoscalingGroup, err)
+		}
+	}
+
+	return deathNodeConfig, nil
+}
+
+// reloadConfig reads the configuration again and applies it to the watcher. The settings that can't be
+// changed without a restart are ignored, with a warning
+func reloadConfig(deathNodeWatcher *deathnode.Watcher, current *config.Config, plan *dryrun.Plan, awsConfig *aws.ClientConfig, awsConnections map[string]aws.ClientInterface) error {
+
+	deathNodeConfig, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	if deathNodeConfig.Scheduler != current.Scheduler {
+		log.Warnf("Changing scheduler requires a restart. Keeping %s", current.Scheduler)
+	}
+	if deathNodeConfig.MesosURL != current.MesosURL {
+		log.Warnf("Changing mesosUrl requires a restart. Keeping %s", current.MesosURL)
+	}
+	if deathNodeConfig.MarathonURL != current.MarathonURL {
+		log.Warnf("Changing marathonUrl requires a restart. Keeping %s", current.MarathonURL)
+	}
+	if deathNodeConfig.KubernetesURL != current.KubernetesURL {
+		log.Warnf("Changing kubernetesUrl requires a restart. Keeping %s", current.KubernetesURL)
+	}
+	if deathNodeConfig.NomadURL != current.NomadURL {
+		log.Warnf("Changing nomadUrl requires a restart. Keeping %s", current.NomadURL)
+	}
+	if deathNodeConfig.ECSCluster != current.ECSCluster {
+		log.Warnf("Changing ecsCluster requires a restart. Keeping %s", current.ECSCluster)
+	}
+	if deathNodeConfig.DeathNodeMark != current.DeathNodeMark {
+		log.Warnf("Changing deathNodeMark requires a restart. Keeping %s", current.DeathNodeMark)
+	}
+	if deathNodeConfig.PollingSeconds != current.PollingSeconds {
+		log.Warnf("Changing polling requires a restart. Keeping %d seconds", current.PollingSeconds)
+	}
+
+	selectors, err := newAutoscalingGroupSelectors(deathNodeConfig, plan, awsConfig, awsConnections)
+	if err != nil {
+		return err
+	}
+
+	err = deathNodeWatcher.Reload(selectors, deathNodeConfig)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Configuration reloaded. Monitoring %d autoscaling group prefixes", len(deathNodeConfig.AutoscalingGroups))
+	return nil
+}
+
+// requestReload queues a configuration reload, unless there is already one pending
+func requestReload(reloads chan string, reason string) {
+
+	select {
+	case reloads <- reason:
+	default:
+	}
+}
+
+// watchConfigFile requests a configuration reload every time the file modification time or size changes,
+// until the context is cancelled
+func watchConfigFile(ctx context.Context, path string, reloads chan string) {
 
-	// Create the Mesos monitor
-	mesosConn := &mesos.Client{
-		MasterURL: mesosURL,
+	lastInfo, err := os.Stat(path)
+	if err != nil {
+		log.Warnf("Unable to watch configuration file %s: %v", path, err)
 	}
-	mesosMonitor := monitor.NewMesosMonitor(mesosConn, protectedFrameworks)
 
-	// Create deathnoteWatcher
-	notebook := deathnode.NewNotebook(autoscalingGroups, awsConn, mesosMonitor, delayDeleteSeconds, deathNodeMark)
-	deathNodeWatcher := deathnode.NewWatcher(notebook, mesosMonitor, autoscalingGroups, constraintsType, recommenderType)
+	ticker := time.NewTicker(configFileCheckInterval)
+	defer ticker.Stop()
 
-	ticker := time.NewTicker(time.Second * time.Duration(pollingSeconds))
 	for {
-		go deathNodeWatcher.Run()
-		<-ticker.C
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Debugf("Unable to check configuration file %s: %v", path, err)
+			continue
+		}
+		if lastInfo == nil || !info.ModTime().Equal(lastInfo.ModTime()) || info.Size() != lastInfo.Size() {
+			if lastInfo != nil {
+				requestReload(reloads, "file changed")
+			}
+			lastInfo = info
+		}
 	}
 }
 
+// applyFlags overrides the configuration with the flags set in the command line. Policy flags apply to
+// all the autoscaling groups. If any autoscalingGroupName flag is set, they replace the autoscaling
+// groups from the configuration file, using the default policy
+func applyFlags(deathNodeConfig *config.Config) error {
+
+	policies := append(deathNodeConfig.Policies(), deathNodeConfig.Defaults)
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "scheduler":
+			deathNodeConfig.Scheduler = scheduler
+		case "mesosUrl":
+			deathNodeConfig.MesosURL = mesosURL
+		case "marathonUrl":
+			deathNodeConfig.MarathonURL = marathonURL
+		case "kubernetesUrl":
+			deathNodeConfig.KubernetesURL = kubernetesURL
+		case "nomadUrl":
+			deathNodeConfig.NomadURL = nomadURL
+		case "ecsCluster":
+			deathNodeConfig.ECSCluster = ecsCluster
+		case "deathNodeMark":
+			deathNodeConfig.DeathNodeMark = deathNodeMark
+		case "polling":
+			deathNodeConfig.PollingSeconds = pollingSeconds
+		case "deregisterFromLoadBalancers":
+			deathNodeConfig.DeregisterFromLoadBalancers = deregisterFromLBs
+		}
+		for _, policy := range policies {
+			switch f.Name {
+			case "protectedFrameworks":
+				policy.ProtectedFrameworks = protectedFrameworks
+			case "constraintsType":
+				policy.ConstraintsType = constraintsType
+			case "recommenderType":
+				policy.RecommenderType = recommenderType
+			case "delayDelete":
+				policy.DelayDeleteSeconds = delayDeleteSeconds
+			case "drainTimeout":
+				policy.DrainTimeoutSeconds = drainTimeoutSeconds
+			case "maxConcurrentDrains":
+				policy.MaxConcurrentDrains = maxConcurrentDrains
+			}
+		}
+	})
+
+	if len(autoscalingGroupPrefixes) > 0 {
+		deathNodeConfig.AutoscalingGroups = []*config.AutoscalingGroup{}
+		for _, autoscalingGroupPrefix := range autoscalingGroupPrefixes {
+			autoscalingGroup, err := parseAutoscalingGroupFlag(autoscalingGroupPrefix)
+			if err != nil {
+				return err
+			}
+			deathNodeConfig.AddAutoscalingGroup(autoscalingGroup)
+		}
+	}
+
+	return nil
+}
+
+// parseAutoscalingGroupFlag parses an autoscalingGroupName flag, which have the format
+// prefix[,region=<region>][,iamRole=<role>]
+func parseAutoscalingGroupFlag(value string) (*config.AutoscalingGroup, error) {
+
+	parameters := strings.Split(value, ",")
+	autoscalingGroup := &config.AutoscalingGroup{
+		Prefix: parameters[0],
+	}
+	for _, parameter := range parameters[1:] {
+		keyValue := strings.SplitN(parameter, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("invalid autoscalingGroupName parameter %s", parameter)
+		}
+		switch keyValue[0] {
+		case "region":
+			autoscalingGroup.Region = keyValue[1]
+		case "iamRole":
+			autoscalingGroup.IAMRole = keyValue[1]
+		default:
+			return nil, fmt.Errorf("unknown autoscalingGroupName parameter %s", keyValue[0])
+		}
+	}
+
+	return autoscalingGroup, nil
+}
+
+// newAutoscalingGroupSelectors creates the selectors for the configured autoscaling groups, with one AWS
+// connection for every distinct region and role. Connections are cached in awsConnections, so they are
+// reused when the configuration is reloaded.
+// If a dry run plan is provided, connections record the mutating calls on it instead of executing them
+func newAutoscalingGroupSelectors(deathNodeConfig *config.Config, plan *dryrun.Plan, defaultConfig *aws.ClientConfig, awsConnections map[string]aws.ClientInterface) ([]monitor.AutoscalingGroupSelector, error) {
+
+	selectors := []monitor.AutoscalingGroupSelector{}
+
+	for _, autoscalingGroup := range deathNodeConfig.AutoscalingGroups {
+		awsConfig := *defaultConfig
+		if autoscalingGroup.Region != "" {
+			awsConfig.Region = autoscalingGroup.Region
+		}
+		if autoscalingGroup.IAMRole != "" {
+			awsConfig.IAMRole = autoscalingGroup.IAMRole
+		}
+
+		connectionKey := awsConfig.Region + "/" + awsConfig.IAMRole
+		awsConnection, ok := awsConnections[connectionKey]
+		if !ok {
+			client, err := aws.NewClient(&awsConfig)
+			if err != nil {
+				return nil, err
+			}
+			awsConnection = aws.NewInstrumentedClient(client)
+			if plan != nil {
+				awsConnection = aws.NewDryRunClient(awsConnection, plan)
+			}
+			awsConnections[connectionKey] = awsConnection
+		}
+
+		selectors = append(selectors, monitor.AutoscalingGroupSelector{
+			Prefix:        autoscalingGroup.Prefix,
+			AWSConnection: awsConnection,
+			Policy:        autoscalingGroup.Policy,
+		})
+	}
+
+	return selectors, nil
+}
 
 func initFlags() {
 
+	flag.StringVar(&configFile, "config", "", "A YAML or JSON configuration file. Flags set in the command line override it's values")
+
 	flag.StringVar(&accessKey, "accessKey", "", "help message for flagname")
 	flag.StringVar(&secretKey, "secretKey", "", "help message for flagname")
 	flag.StringVar(&region, "region", "eu-west-1", "help message for flagname")
 	flag.StringVar(&iamRole, "iamRole", "", "help message for flagname")
 	flag.StringVar(&iamSession, "iamSession", "", "help message for flagname")
+	flag.StringVar(&awsProfile, "awsProfile", "", "The AWS shared config profile to use for credentials")
+	flag.StringVar(&iamExternalID, "iamExternalId", "", "The external ID to use when assuming iamRole")
+	flag.StringVar(&mfaSerial, "mfaSerial", "", "The MFA device serial number required for assuming iamRole. Requires deathnode to run in a terminal")
+	flag.StringVar(&mfaTokenCode, "mfaTokenCode", "", "The MFA token code used for the first iamRole session. Later ones ask for a new code on stdin")
+	flag.StringVar(&webIdentityTokenFile, "webIdentityTokenFile", os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"),
+		"A web identity token file used to assume iamRole (defaults to AWS_WEB_IDENTITY_TOKEN_FILE)")
+	flag.DurationVar(&iamSessionDuration, "iamSessionDuration", time.Hour, "The duration of the assumed iamRole credentials")
+
+	flag.StringVar(&awsEndpoint, "awsEndpoint", "", "Override the endpoint for all AWS services (ex: a local AWS emulator)")
+	flag.StringVar(&ec2Endpoint, "ec2Endpoint", "", "Override the endpoint for AWS EC2 API")
+	flag.StringVar(&autoscalingEndpoint, "autoscalingEndpoint", "", "Override the endpoint for AWS Autoscaling API")
+	flag.StringVar(&elbEndpoint, "elbEndpoint", "", "Override the endpoint for AWS Elastic Load Balancing API")
+	flag.StringVar(&stsEndpoint, "stsEndpoint", "", "Override the endpoint for AWS STS API")
+	flag.StringVar(&dynamodbEndpoint, "dynamodbEndpoint", "", "Override the endpoint for AWS DynamoDB API")
+	flag.StringVar(&snsEndpoint, "snsEndpoint", "", "Override the endpoint for AWS SNS API")
+	flag.StringVar(&cloudWatchEndpoint, "cloudWatchEndpoint", "", "Override the endpoint for AWS CloudWatch API")
+	flag.StringVar(&ecsEndpoint, "ecsEndpoint", "", "Override the endpoint for AWS ECS API")
+	flag.BoolVar(&awsDisableSSL, "awsDisableSSL", false, "Disable SSL when calling AWS API")
+	flag.BoolVar(&awsForcePathStyle, "awsForcePathStyle", false, "Use path-style addressing when calling AWS API")
 
 	flag.BoolVar(&debug, "debug", false, "Enable debug logging")
-	flag.StringVar(&mesosURL, "mesosUrl", "", "The URL for Mesos master")
+	flag.StringVar(&httpAddress, "httpAddress", "", "The address to listen on for HTTP requests (ex: :8080). If empty, no HTTP server is started")
 
-	flag.Var(&autoscalingGroupPrefixes, "autoscalingGroupName", "An autoscalingGroup prefix for monitor")
-	flag.Var(&protectedFrameworks, "protectedFrameworks", "The mesos frameworks to wait for kill the node")
+	flag.StringVar(&leaderElection, "leaderElection", "", "Run only while being the leader between replicas, using a dynamodb or file lock")
+	flag.StringVar(&leaderLockTable, "leaderLockTable", "", "The DynamoDB table storing the leader lock")
+	flag.StringVar(&leaderLockName, "leaderLockName", "deathnode", "The name of the leader lock in the DynamoDB table")
+	flag.StringVar(&leaderLockFile, "leaderLockFile", "", "The file storing the leader lock, in a filesystem shared by all replicas")
+	flag.StringVar(&leaderID, "leaderId", "", "The identity of this replica for leader election (defaults to hostname-pid)")
+	flag.DurationVar(&leaderLeaseDuration, "leaderLeaseDuration", 30*time.Second, "The duration of the leader lease")
 
-	// Move constraints to array, so we apply multiple
-	flag.StringVar(&constraintsType, "constraintsType", "noContraint", "The constrainst implementation to use")
-	flag.StringVar(&recommenderType, "recommenderType", "firstAvailableAgent", "The recommender implementation to use")
+	flag.StringVar(&stateStore, "stateStore", "", "Persist the drains and delays in a dynamodb or file store. If empty, they are kept in memory")
+	flag.StringVar(&stateFile, "stateFile", "", "The JSON file storing the state")
+	flag.StringVar(&stateTable, "stateTable", "", "The DynamoDB table storing the state")
+	flag.StringVar(&stateName, "stateName", "deathnode", "The name of the state item in the DynamoDB table")
 
-	flag.StringVar(&deathNodeMark, "deathNodeMark", "DEATH_NODE_MARK", "The tag to apply for instances to be deleted")
+	flag.BoolVar(&auditStdout, "auditStdout", false, "Write the audit log of destructive actions to stdout, as JSON lines")
+	flag.StringVar(&auditFile, "auditFile", "", "Append the audit log of destructive actions to a file, as JSON lines")
+	flag.StringVar(&auditWebhookURL, "auditWebhookUrl", "", "Post every audit log record to an URL, as JSON")
+	flag.DurationVar(&auditWebhookTimeout, "auditWebhookTimeout", 10*time.Second, "The timeout of the audit webhook requests")
 
-	flag.IntVar(&pollingSeconds, "polling", 60, "Seconds between executions")
-	flag.IntVar(&delayDeleteSeconds, "delayDelete", 0, "Time to wait between kill executions (in seconds)")
+	flag.StringVar(&notifyWebhookURL, "notifyWebhookUrl", "", "Post the drain lifecycle events (started, stuck, failed, terminated) to an URL")
+	flag.StringVar(&notifyWebhookTemplate, "notifyWebhookTemplate", "",
+		"A file with the text/template rendering the JSON body of the notifications. If empty, the event is posted as JSON")
+	flag.StringVar(&notifyWebhookSecret, "notifyWebhookSecret", os.Getenv("DEATHNODE_NOTIFY_WEBHOOK_SECRET"),
+		"Sign the notifications with HMAC-SHA256 in the X-Deathnode-Signature header (defaults to DEATHNODE_NOTIFY_WEBHOOK_SECRET)")
+	flag.DurationVar(&notifyWebhookTimeout, "notifyWebhookTimeout", 10*time.Second, "The timeout of the notification webhook requests")
+	flag.IntVar(&notifyWebhookRetries, "notifyWebhookRetries", 3, "Times a failed notification is retried, doubling the wait between them")
+	flag.DurationVar(&notifyStuckAfter, "notifyStuckAfter", time.Hour,
+		"Notify drains staying in the same state for longer than this. 0 disables the stuck notifications")
 
-	flag.Parse()
-}
+	flag.StringVar(&snsTopicARN, "snsTopicArn", "",
+		"Publish the drain events (instance-marked, maintenance-scheduled, drain-complete, lifecycle-completed, drain-timeout) to an SNS topic")
 
-func enforceFlags() {
+	flag.StringVar(&cloudWatchNamespace, "cloudWatchNamespace", "",
+		"Push the drain metrics of every autoscaling group to CloudWatch under this namespace (ex: Deathnode). If empty, they are not pushed")
+	flag.DurationVar(&cloudWatchInterval, "cloudWatchInterval", time.Minute,
+		"How often the drain metrics are pushed to CloudWatch, aggregating the values recorded meanwhile")
 
-	if mesosURL == "" {
-		flag.Usage()
-		log.Fatal("mesosUrl flag is required")
-	}
+	flag.BoolVar(&dryRun, "dryRun", false, "Log the changes deathnode would do in AWS and the scheduler, without executing them")
+	flag.BoolVar(&operatorCommands, "operatorCommands", false,
+		"Accept commands to mark, unmark, pin and unpin instances under /instances/ in the HTTP server")
+	flag.StringVar(&operatorToken, "operatorToken", os.Getenv("DEATHNODE_OPERATOR_TOKEN"),
+		"The bearer token the operator commands must carry in the Authorization header (defaults to DEATHNODE_OPERATOR_TOKEN)")
+	flag.StringVar(&scheduler, "scheduler", config.DefaultScheduler, "The scheduler to drain the instances from: mesos, kubernetes, nomad or ecs")
+	flag.StringVar(&mesosURL, "mesosUrl", "", "The URL for Mesos master")
+	flag.StringVar(&marathonURL, "marathonUrl", "",
+		"The URL for Marathon. If set, the Marathon tasks of the agents in maintenance are killed so they are rescheduled elsewhere")
+	flag.StringVar(&marathonKillMode, "marathonKillMode", marathon.KillModeWipe,
+		"How the Marathon tasks are killed: wipe (replaced by Marathon) or scale (scaling down their app)")
+	flag.IntVar(&marathonMaxConcurrentKills, "marathonMaxConcurrentKills", 1,
+		"The maximum number of tasks of a Marathon app being replaced at the same time, including the unhealthy ones")
+	flag.StringVar(&kubernetesURL, "kubernetesUrl", "",
+		"The URL for the Kubernetes API server. If empty, the in cluster configuration of the service account is used")
+	flag.StringVar(&kubernetesTokenFile, "kubernetesTokenFile", "", "File with the bearer token to authenticate against the Kubernetes API server")
+	flag.StringVar(&kubernetesCAFile, "kubernetesCAFile", "", "File with the CA certificates of the Kubernetes API server")
+	flag.StringVar(&nomadURL, "nomadUrl", "", "The URL for the Nomad API")
+	flag.StringVar(&nomadToken, "nomadToken", os.Getenv("NOMAD_TOKEN"), "The ACL token for the Nomad API. Defaults to NOMAD_TOKEN")
+	flag.DurationVar(&nomadDrainDeadline, "nomadDrainDeadline", 0,
+		"Time Nomad waits for the allocations of a drained node to migrate before stopping them, protected ones included. If 0, it waits forever")
+	flag.StringVar(&ecsCluster, "ecsCluster", "", "The ECS cluster the container instances of the autoscaling groups are registered in")
 
-	if len(autoscalingGroupPrefixes) < 1 {
-		flag.Usage()
-		log.Fatal("at least one autoscalingGroupName flag is required")
-	}
+	flag.Var(&autoscalingGroupPrefixes, "autoscalingGroupName",
+		"An autoscalingGroup prefix for monitor, optionally with the region and iamRole to use: prefix[,region=<region>][,iamRole=<role>]")
+	flag.Var(&protectedFrameworks, "protectedFrameworks",
+		"The mesos frameworks, the kubernetes namespaces and key=value pod labels, the nomad jobs and namespaces, or the ECS services, to wait for kill the node")
 
-	if len(protectedFrameworks) < 1 {
-		flag.Usage()
-		log.Fatal("at least one registeredFramework flag is required")
-	}
+	// Move constraints to array, so we apply multiple
+	flag.StringVar(&constraintsType, "constraintsType", config.DefaultConstraintsType, "The constrainst implementation to use")
+	flag.StringVar(&recommenderType, "recommenderType", config.DefaultRecommenderType, "The recommender implementation to use")
+
+	flag.StringVar(&deathNodeMark, "deathNodeMark", config.DefaultDeathNodeMark, "The tag to apply for instances to be deleted")
+
+	flag.IntVar(&pollingSeconds, "polling", config.DefaultPollingSeconds, "Seconds between executions")
+	flag.IntVar(&healthCheckIntervals, "healthCheckIntervals", 3,
+		"Polling intervals without progress before /healthz and /readyz report deathnode as unhealthy")
+	flag.BoolVar(&deregisterFromLBs, "deregisterFromLoadBalancers", false,
+		"Deregister instances from their autoscaling group load balancers, waiting for connection draining before destroying them")
+	flag.IntVar(&delayDeleteSeconds, "delayDelete", 0, "Time to wait between kill executions (in seconds)")
+	flag.IntVar(&drainTimeoutSeconds, "drainTimeout", 0,
+		"Time to wait for protected frameworks tasks before destroying an instance anyway (in seconds). 0 waits forever")
+	flag.IntVar(&maxConcurrentDrains, "maxConcurrentDrains", 0,
+		"Maximum number of instances of an autoscaling group being drained at the same time. 0 means no limit")
+
+	flag.Parse()
 }
 
 func (i *arrayFlags) String() string {
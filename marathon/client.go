@@ -0,0 +1,142 @@
+package marathon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Ways of killing the tasks of the agents in maintenance, so their apps reschedule them elsewhere
+const (
+	// KillModeWipe kills the task and expunges it, so it's replaced even if it's resident
+	KillModeWipe = "wipe"
+	// KillModeScale kills the task and decreases the instances of it's app
+	KillModeScale = "scale"
+)
+
+// ClientInterface is an interface for marathon api clients
+type ClientInterface interface {
+	GetApps() ([]App, error)
+	KillTask(appID, taskID, mode string) error
+}
+
+// Client implements a client for the marathon api. Credentials in the URL are sent with basic auth
+type Client struct {
+	url    string
+	client *http.Client
+}
+
+// AppsResponse is part of the marathon apps response API endpoint
+type AppsResponse struct {
+	Apps []App `json:"apps"`
+}
+
+// App is part of the marathon apps response API endpoint, with it's tasks embedded
+type App struct {
+	ID           string        `json:"id"`
+	Instances    int           `json:"instances"`
+	TasksRunning int           `json:"tasksRunning"`
+	TasksHealthy int           `json:"tasksHealthy"`
+	HealthChecks []HealthCheck `json:"healthChecks"`
+	Deployments  []Deployment  `json:"deployments"`
+	Tasks        []Task        `json:"tasks"`
+}
+
+// HealthCheck is part of the marathon apps response API endpoint
+type HealthCheck struct {
+	Protocol string `json:"protocol"`
+}
+
+// Deployment is part of the marathon apps response API endpoint
+type Deployment struct {
+	ID string `json:"id"`
+}
+
+// Task is part of the marathon apps response API endpoint
+type Task struct {
+	ID      string `json:"id"`
+	AppID   string `json:"appId"`
+	Host    string `json:"host"`
+	SlaveID string `json:"slaveId"`
+	State   string `json:"state"`
+}
+
+// Healthy returns the number of tasks of the app able to serve: the healthy ones if the app has health
+// checks, or the running ones otherwise
+func (a *App) Healthy() int {
+
+	if len(a.HealthChecks) > 0 {
+		return a.TasksHealthy
+	}
+	return a.TasksRunning
+}
+
+// IsDeploying returns true if the app has any deployment in progress
+func (a *App) IsDeploying() bool {
+	return len(a.Deployments) > 0
+}
+
+// NewClient returns a Client for the marathon api at apiURL
+func NewClient(apiURL string) *Client {
+
+	return &Client{
+		url: strings.TrimSuffix(apiURL, "/"),
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// GetApps returns the apps of marathon, with their tasks
+func (c *Client) GetApps() ([]App, error) {
+
+	var apps AppsResponse
+	err := c.apiCall("GET", "/v2/apps?embed=apps.tasks&embed=apps.deployments", &apps)
+	return apps.Apps, err
+}
+
+// KillTask kills the task of the app, scaling the app down or wiping the task depending on the mode
+func (c *Client) KillTask(appID, taskID, mode string) error {
+
+	var query string
+	switch mode {
+	case KillModeWipe:
+		query = "wipe=true"
+	case KillModeScale:
+		query = "scale=true"
+	default:
+		return fmt.Errorf("unknown marathon kill mode %s", mode)
+	}
+
+	path := fmt.Sprintf("/v2/apps/%s/tasks/%s?%s", strings.Trim(appID, "/"), url.PathEscape(taskID), query)
+	return c.apiCall("DELETE", path, nil)
+}
+
+// apiCall decodes the response, if any
+func (c *Client) apiCall(method, path string, response interface{}) error {
+
+	req, err := http.NewRequest(method, c.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("marathon API returned %s for %s %s", resp.Status, method, path)
+	}
+	if response != nil {
+		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+			return fmt.Errorf("unable to decode marathon API response: %v", err)
+		}
+	}
+	return nil
+}
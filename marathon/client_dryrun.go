@@ -0,0 +1,34 @@
+package marathon
+
+import (
+	"github.com/alanbover/deathnode/dryrun"
+)
+
+const dryRunService = "marathon"
+
+// DryRunClient decorates a ClientInterface, executing the read only calls and recording the mutating
+// ones in a dry run plan instead of executing them
+type DryRunClient struct {
+	client ClientInterface
+	plan   *dryrun.Plan
+}
+
+// NewDryRunClient returns a new DryRunClient
+func NewDryRunClient(client ClientInterface, plan *dryrun.Plan) *DryRunClient {
+	return &DryRunClient{
+		client: client,
+		plan:   plan,
+	}
+}
+
+// GetApps calls the decorated client
+func (c *DryRunClient) GetApps() ([]App, error) {
+	return c.client.GetApps()
+}
+
+// KillTask records the call in the dry run plan
+func (c *DryRunClient) KillTask(appID, taskID, mode string) error {
+
+	c.plan.Record(dryRunService, "KillTask", map[string]string{"app": appID, "task": taskID, "mode": mode})
+	return nil
+}
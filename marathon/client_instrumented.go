@@ -0,0 +1,37 @@
+package marathon
+
+import (
+	"time"
+
+	"github.com/alanbover/deathnode/metrics"
+)
+
+const metricsService = "marathon"
+
+// InstrumentedClient decorates a ClientInterface, recording the latency and errors of every call
+type InstrumentedClient struct {
+	client ClientInterface
+}
+
+// NewInstrumentedClient returns a new InstrumentedClient
+func NewInstrumentedClient(client ClientInterface) *InstrumentedClient {
+	return &InstrumentedClient{
+		client: client,
+	}
+}
+
+// GetApps calls the decorated client, recording metrics
+func (c *InstrumentedClient) GetApps() ([]App, error) {
+	start := time.Now()
+	apps, err := c.client.GetApps()
+	metrics.ObserveAPIRequest(metricsService, "GetApps", start, err)
+	return apps, err
+}
+
+// KillTask calls the decorated client, recording metrics
+func (c *InstrumentedClient) KillTask(appID, taskID, mode string) error {
+	start := time.Now()
+	err := c.client.KillTask(appID, taskID, mode)
+	metrics.ObserveAPIRequest(metricsService, "KillTask", start, err)
+	return err
+}
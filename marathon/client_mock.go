@@ -0,0 +1,83 @@
+package marathon
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ClientMock implements marathon.ClientInterface for testing purposes. Killed tasks are removed from
+// their apps, until ReplaceTasks starts new ones
+type ClientMock struct {
+	Apps     []App
+	Requests map[string][]string
+	Errors   map[string]error
+	mutex    sync.Mutex
+}
+
+// GetApps mocked for testing purposes
+func (c *ClientMock) GetApps() ([]App, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err, ok := c.Errors["GetApps"]; ok {
+		return nil, err
+	}
+
+	apps := []App{}
+	for _, app := range c.Apps {
+		app.Tasks = append([]Task{}, app.Tasks...)
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+// KillTask mocked for testing purposes
+func (c *ClientMock) KillTask(appID, taskID, mode string) error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err, ok := c.Errors["KillTask"]; ok {
+		return err
+	}
+	if c.Requests == nil {
+		c.Requests = map[string][]string{}
+	}
+	c.Requests[appID] = append(c.Requests[appID], taskID)
+
+	for i := range c.Apps {
+		app := &c.Apps[i]
+		for j, task := range app.Tasks {
+			if app.ID == appID && task.ID == taskID {
+				app.Tasks = append(app.Tasks[:j], app.Tasks[j+1:]...)
+				app.TasksRunning--
+				app.TasksHealthy--
+				if mode == KillModeScale {
+					app.Instances--
+				}
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// ReplaceTasks starts the tasks missing from every app in the given agent, healthy
+func (c *ClientMock) ReplaceTasks(slaveID string) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i := range c.Apps {
+		app := &c.Apps[i]
+		for len(app.Tasks) < app.Instances {
+			app.Tasks = append(app.Tasks, Task{
+				ID:      fmt.Sprintf("%s.replacement%d", app.ID, len(app.Tasks)),
+				AppID:   app.ID,
+				SlaveID: slaveID,
+				State:   "TASK_RUNNING",
+			})
+			app.TasksRunning++
+			app.TasksHealthy++
+		}
+	}
+}
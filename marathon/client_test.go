@@ -0,0 +1,53 @@
+package marathon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClient(t *testing.T) {
+
+	Convey("When using a marathon client against an api server", t, func() {
+		var request *http.Request
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request = r
+			switch r.URL.Path {
+			case "/v2/apps":
+				w.Write([]byte(`{"apps": [{"id": "/web", "instances": 2, "tasksRunning": 2, "tasksHealthy": 1,
+					"healthChecks": [{"protocol": "HTTP"}], "deployments": [],
+					"tasks": [{"id": "web.1", "appId": "/web", "slaveId": "mesosslave1", "state": "TASK_RUNNING"}]}]}`))
+			case "/v2/apps/group/web/tasks/web.1":
+				w.Write([]byte(`{"deploymentId": "1"}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+		client := NewClient(server.URL + "/")
+
+		Convey("apps should be returned with their tasks", func() {
+			apps, err := client.GetApps()
+			So(err, ShouldBeNil)
+			So(request.URL.Query()["embed"], ShouldContain, "apps.tasks")
+			So(apps[0].Tasks[0].SlaveID, ShouldEqual, "mesosslave1")
+			So(apps[0].Healthy(), ShouldEqual, 1)
+			So(apps[0].IsDeploying(), ShouldBeFalse)
+		})
+		Convey("killing a task should wipe it or scale it's app", func() {
+			So(client.KillTask("/group/web", "web.1", KillModeWipe), ShouldBeNil)
+			So(request.Method, ShouldEqual, "DELETE")
+			So(request.URL.Query().Get("wipe"), ShouldEqual, "true")
+			So(client.KillTask("/group/web", "web.1", KillModeScale), ShouldBeNil)
+			So(request.URL.Query().Get("scale"), ShouldEqual, "true")
+		})
+		Convey("unknown kill modes should fail", func() {
+			So(client.KillTask("/group/web", "web.1", "restart"), ShouldNotBeNil)
+		})
+		Convey("API errors should be returned", func() {
+			So(client.KillTask("/other", "web.1", KillModeWipe), ShouldNotBeNil)
+		})
+	})
+}
@@ -108,9 +108,9 @@ func (c *Client) SetHostsInMaintenance(hosts map[string]string) error {
 func (c *Client) GetMesosTasks() (*TasksResponse, error) {
 
 	var tasks TasksResponse
-	c.getMesosTasksRecursive(&tasks, 0)
+	err := c.getMesosTasksRecursive(&tasks, 0)
 
-	return &tasks, nil
+	return &tasks, err
 }
 
 func (c *Client) getMesosTasksRecursive(tasksResponse *TasksResponse, offset int) error {
@@ -125,7 +125,7 @@ func (c *Client) getMesosTasksRecursive(tasksResponse *TasksResponse, offset int
 	tasksResponse.Tasks = append(tasksResponse.Tasks, tasks.Tasks...)
 
 	if len(tasks.Tasks) == 100 {
-		c.getMesosTasksRecursive(tasksResponse, offset+100)
+		return c.getMesosTasksRecursive(tasksResponse, offset+100)
 	}
 
 	return nil
@@ -137,9 +137,9 @@ func (c *Client) GetMesosFrameworks() (*FrameworksResponse, error) {
 	url := fmt.Sprintf(c.MasterURL + "/master/frameworks")
 
 	var frameworks FrameworksResponse
-	mesosGetAPICall(url, &frameworks)
+	err := mesosGetAPICall(url, &frameworks)
 
-	return &frameworks, nil
+	return &frameworks, err
 }
 
 // GetMesosAgents returns the Mesos Agents registered in the Mesos cluster
@@ -148,9 +148,9 @@ func (c *Client) GetMesosAgents() (*SlavesResponse, error) {
 	url := fmt.Sprintf(c.MasterURL + "/master/slaves")
 
 	var slaves SlavesResponse
-	mesosGetAPICall(url, &slaves)
+	err := mesosGetAPICall(url, &slaves)
 
-	return &slaves, nil
+	return &slaves, err
 }
 
 func genMaintenanceCallPayload(hosts map[string]string) []byte {
@@ -0,0 +1,49 @@
+package mesos
+
+import (
+	"github.com/alanbover/deathnode/dryrun"
+)
+
+const dryRunService = "mesos"
+
+// DryRunClient decorates a ClientInterface, executing the read only calls and recording the mutating
+// ones in a dry run plan instead of executing them
+type DryRunClient struct {
+	client ClientInterface
+	plan   *dryrun.Plan
+}
+
+// NewDryRunClient returns a new DryRunClient
+func NewDryRunClient(client ClientInterface, plan *dryrun.Plan) *DryRunClient {
+	return &DryRunClient{
+		client: client,
+		plan:   plan,
+	}
+}
+
+// GetMesosTasks calls the decorated client
+func (c *DryRunClient) GetMesosTasks() (*TasksResponse, error) {
+	return c.client.GetMesosTasks()
+}
+
+// GetMesosFrameworks calls the decorated client
+func (c *DryRunClient) GetMesosFrameworks() (*FrameworksResponse, error) {
+	return c.client.GetMesosFrameworks()
+}
+
+// GetMesosAgents calls the decorated client
+func (c *DryRunClient) GetMesosAgents() (*SlavesResponse, error) {
+	return c.client.GetMesosAgents()
+}
+
+// SetHostsInMaintenance records the call in the dry run plan
+func (c *DryRunClient) SetHostsInMaintenance(hosts map[string]string) error {
+
+	arguments := map[string]string{}
+	for hostname, ip := range hosts {
+		arguments[hostname] = ip
+	}
+
+	c.plan.Record(dryRunService, "SetHostsInMaintenance", arguments)
+	return nil
+}
@@ -0,0 +1,53 @@
+package mesos
+
+import (
+	"time"
+
+	"github.com/alanbover/deathnode/metrics"
+)
+
+const metricsService = "mesos"
+
+// InstrumentedClient decorates a ClientInterface, recording the latency and errors of every call
+type InstrumentedClient struct {
+	client ClientInterface
+}
+
+// NewInstrumentedClient returns a new InstrumentedClient
+func NewInstrumentedClient(client ClientInterface) *InstrumentedClient {
+	return &InstrumentedClient{
+		client: client,
+	}
+}
+
+// GetMesosTasks calls the decorated client, recording metrics
+func (c *InstrumentedClient) GetMesosTasks() (*TasksResponse, error) {
+	start := time.Now()
+	tasks, err := c.client.GetMesosTasks()
+	metrics.ObserveAPIRequest(metricsService, "GetMesosTasks", start, err)
+	return tasks, err
+}
+
+// GetMesosFrameworks calls the decorated client, recording metrics
+func (c *InstrumentedClient) GetMesosFrameworks() (*FrameworksResponse, error) {
+	start := time.Now()
+	frameworks, err := c.client.GetMesosFrameworks()
+	metrics.ObserveAPIRequest(metricsService, "GetMesosFrameworks", start, err)
+	return frameworks, err
+}
+
+// GetMesosAgents calls the decorated client, recording metrics
+func (c *InstrumentedClient) GetMesosAgents() (*SlavesResponse, error) {
+	start := time.Now()
+	agents, err := c.client.GetMesosAgents()
+	metrics.ObserveAPIRequest(metricsService, "GetMesosAgents", start, err)
+	return agents, err
+}
+
+// SetHostsInMaintenance calls the decorated client, recording metrics
+func (c *InstrumentedClient) SetHostsInMaintenance(hosts map[string]string) error {
+	start := time.Now()
+	err := c.client.SetHostsInMaintenance(hosts)
+	metrics.ObserveAPIRequest(metricsService, "SetHostsInMaintenance", start, err)
+	return err
+}
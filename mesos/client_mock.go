@@ -5,29 +5,41 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sync"
 )
 
 // ClientMock implements mesos.ClientInterface for testing purposes
 type ClientMock struct {
 	Records  map[string]*[]string
 	Requests map[string]*[]string
+	Errors   map[string]error
+	mutex    sync.Mutex
 }
 
 // GetMesosTasks mocked for testing purposes
 func (c *ClientMock) GetMesosTasks() (*TasksResponse, error) {
-	mockResponse, _ := c.replay(&TasksResponse{}, "GetMesosTasks")
+	mockResponse, err := c.replay(&TasksResponse{}, "GetMesosTasks")
+	if err != nil {
+		return nil, err
+	}
 	return mockResponse.(*TasksResponse), nil
 }
 
 // GetMesosFrameworks mocked for testing purposes
 func (c *ClientMock) GetMesosFrameworks() (*FrameworksResponse, error) {
-	mockResponse, _ := c.replay(&FrameworksResponse{}, "GetMesosFrameworks")
+	mockResponse, err := c.replay(&FrameworksResponse{}, "GetMesosFrameworks")
+	if err != nil {
+		return nil, err
+	}
 	return mockResponse.(*FrameworksResponse), nil
 }
 
 // GetMesosAgents mocked for testing purposes
 func (c *ClientMock) GetMesosAgents() (*SlavesResponse, error) {
-	mockResponse, _ := c.replay(&SlavesResponse{}, "GetMesosSlaves")
+	mockResponse, err := c.replay(&SlavesResponse{}, "GetMesosSlaves")
+	if err != nil {
+		return nil, err
+	}
 	return mockResponse.(*SlavesResponse), nil
 }
 
@@ -38,9 +50,14 @@ func (c *ClientMock) GenMaintenanceCallPayload(hosts map[string]string) []byte {
 
 // SetHostsInMaintenance mocked for testing purposes
 func (c *ClientMock) SetHostsInMaintenance(hosts map[string]string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	if c.Requests == nil {
 		c.Requests = map[string]*[]string{}
 	}
+	if err, ok := c.Errors["SetHostInMaintenance"]; ok {
+		return err
+	}
 
 	hostsCallArguments := []string{}
 	for _, host := range hosts {
@@ -54,6 +71,14 @@ func (c *ClientMock) SetHostsInMaintenance(hosts map[string]string) error {
 
 func (c *ClientMock) replay(mockResponse interface{}, templateFileName string) (interface{}, error) {
 
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Errors are returned instead of the records, without consuming them
+	if err, ok := c.Errors[templateFileName]; ok {
+		return nil, err
+	}
+
 	records, ok := c.Records[templateFileName]
 	if !ok {
 		fmt.Printf("AWS Mock %v method called but not defined\n", templateFileName)
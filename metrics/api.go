@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"time"
+)
+
+var (
+	apiRequestDuration = NewHistogram("deathnode_api_request_duration_seconds",
+		"Latency of the requests to AWS and Mesos APIs", nil, "service", "operation")
+	apiRequestErrors = NewCounter("deathnode_api_request_errors_total",
+		"Number of failed requests to AWS and Mesos APIs", "service", "operation")
+)
+
+// ObserveAPIRequest records the latency of a request to an external API started at start, and whether
+// it failed
+func ObserveAPIRequest(service, operation string, start time.Time, err error) {
+
+	apiRequestDuration.Observe(time.Since(start).Seconds(), service, operation)
+	if err != nil {
+		apiRequestErrors.Inc(service, operation)
+	}
+}
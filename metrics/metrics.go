@@ -0,0 +1,421 @@
+package metrics
+
+// Minimal implementation of Prometheus metrics, exposed using the Prometheus text format
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector is a metric that can be written in the Prometheus text format
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds a set of metrics. It's safe for concurrent use
+type Registry struct {
+	mutex      sync.RWMutex
+	collectors map[string]collector
+}
+
+// DefaultRegistry is the registry where metrics are registered by default
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: map[string]collector{},
+	}
+}
+
+func (r *Registry) register(c collector) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.collectors[c.name()]; ok {
+		panic(fmt.Sprintf("metric %s already registered", c.name()))
+	}
+	r.collectors[c.name()] = c
+}
+
+// WriteText writes all the metrics of the registry, sorted by name
+func (r *Registry) WriteText(w io.Writer) {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	names := []string{}
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		r.collectors[name].write(w)
+	}
+}
+
+// ServeHTTP exposes the metrics of the registry
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteText(w)
+}
+
+// vector holds the values of a metric for every combination of label values
+type vector struct {
+	metricName string
+	help       string
+	metricType string
+	labelNames []string
+	mutex      sync.RWMutex
+	values     map[string]float64
+	labels     map[string][]string
+}
+
+func newVector(metricName, help, metricType string, labelNames []string) *vector {
+	return &vector{
+		metricName: metricName,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		values:     map[string]float64{},
+		labels:     map[string][]string{},
+	}
+}
+
+func (v *vector) name() string {
+	return v.metricName
+}
+
+func (v *vector) key(labelValues []string) string {
+
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, found %d", v.metricName, len(v.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+func (v *vector) update(labelValues []string, f func(float64) float64) {
+
+	key := v.key(labelValues)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.values[key] = f(v.values[key])
+	v.labels[key] = labelValues
+}
+
+func (v *vector) get(labelValues []string) float64 {
+
+	key := v.key(labelValues)
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	return v.values[key]
+}
+
+func (v *vector) write(w io.Writer) {
+
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.metricName, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.metricName, v.metricType)
+
+	keys := []string{}
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if len(keys) == 0 && len(v.labelNames) == 0 {
+		fmt.Fprintf(w, "%s 0\n", v.metricName)
+	}
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", v.metricName, formatLabels(v.labelNames, v.labels[key]), formatValue(v.values[key]))
+	}
+}
+
+func formatLabels(labelNames, labelValues []string) string {
+
+	if len(labelNames) == 0 {
+		return ""
+	}
+
+	labels := []string{}
+	for i, labelName := range labelNames {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", labelName, labelValueEscaper.Replace(labelValues[i])))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// labelValueEscaper escapes the label values as the Prometheus text format expects: only backslashes,
+// double quotes and line feeds are escaped
+var labelValueEscaper = strings.NewReplacer("\\", `\\`, "\"", `\"`, "\n", `\n`)
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Gauge is a metric whose value can go up and down
+type Gauge struct {
+	*vector
+}
+
+// NewGauge returns a Gauge registered in the DefaultRegistry
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	return DefaultRegistry.NewGauge(name, help, labelNames...)
+}
+
+// NewGauge returns a Gauge registered in the registry
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+
+	gauge := &Gauge{newVector(name, help, "gauge", labelNames)}
+	r.register(gauge)
+	return gauge
+}
+
+// Set sets the value of the gauge for the label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.update(labelValues, func(float64) float64 { return value })
+}
+
+// Value returns the value of the gauge for the label values
+func (g *Gauge) Value(labelValues ...string) float64 {
+	return g.get(labelValues)
+}
+
+// Counter is a metric whose value only goes up
+type Counter struct {
+	*vector
+}
+
+// NewCounter returns a Counter registered in the DefaultRegistry
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return DefaultRegistry.NewCounter(name, help, labelNames...)
+}
+
+// NewCounter returns a Counter registered in the registry
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+
+	counter := &Counter{newVector(name, help, "counter", labelNames)}
+	r.register(counter)
+	return counter
+}
+
+// Inc increments the counter by one for the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the label values
+func (c *Counter) Add(value float64, labelValues ...string) {
+
+	if value < 0 {
+		panic(fmt.Sprintf("counter %s can't be decreased", c.metricName))
+	}
+	c.update(labelValues, func(current float64) float64 { return current + value })
+}
+
+// Value returns the value of the counter for the label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	return c.get(labelValues)
+}
+
+// Reset removes the values of the gauge for all label values
+func (g *Gauge) Reset() {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.values = map[string]float64{}
+	g.labels = map[string][]string{}
+}
+
+// GaugeValues are values of a Gauge for some label values, set all at once with Gauge.Replace
+type GaugeValues struct {
+	gauge  *Gauge
+	values map[string]float64
+	labels map[string][]string
+}
+
+// NewValues returns empty values for the gauge
+func (g *Gauge) NewValues() *GaugeValues {
+	return &GaugeValues{
+		gauge:  g,
+		values: map[string]float64{},
+		labels: map[string][]string{},
+	}
+}
+
+// Set sets the value for the label values
+func (v *GaugeValues) Set(value float64, labelValues ...string) {
+
+	key := v.gauge.key(labelValues)
+	v.values[key] = value
+	v.labels[key] = labelValues
+}
+
+// Replace replaces all the values of the gauge at once. The label values missing are removed, without
+// exposing the gauge empty meanwhile, as Reset followed by Set would
+func (g *Gauge) Replace(values *GaugeValues) {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.values = values.values
+	g.labels = values.labels
+}
+
+// GaugeFunc is a gauge without labels whose value is computed when the metrics are collected
+type GaugeFunc struct {
+	metricName string
+	help       string
+	function   func() float64
+}
+
+// NewGaugeFunc returns a GaugeFunc registered in the DefaultRegistry
+func NewGaugeFunc(name, help string, function func() float64) *GaugeFunc {
+	return DefaultRegistry.NewGaugeFunc(name, help, function)
+}
+
+// NewGaugeFunc returns a GaugeFunc registered in the registry
+func (r *Registry) NewGaugeFunc(name, help string, function func() float64) *GaugeFunc {
+
+	gauge := &GaugeFunc{
+		metricName: name,
+		help:       help,
+		function:   function,
+	}
+	r.register(gauge)
+	return gauge
+}
+
+func (g *GaugeFunc) name() string {
+	return g.metricName
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+
+	fmt.Fprintf(w, "# HELP %s %s\n", g.metricName, g.help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.metricName)
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatValue(g.function()))
+}
+
+// DefaultBuckets are the histogram buckets used when none are provided, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations in configurable buckets, for every combination of label values
+type Histogram struct {
+	metricName string
+	help       string
+	labelNames []string
+	buckets    []float64
+	mutex      sync.RWMutex
+	series     map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// NewHistogram returns a Histogram registered in the DefaultRegistry
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets, labelNames...)
+}
+
+// NewHistogram returns a Histogram registered in the registry. Buckets must be sorted
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	histogram := &Histogram{
+		metricName: name,
+		help:       help,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     map[string]*histogramSeries{},
+	}
+	r.register(histogram)
+	return histogram
+}
+
+// Observe adds an observation to the histogram for the label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+
+	if len(labelValues) != len(h.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, found %d", h.metricName, len(h.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{
+			labelValues: labelValues,
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = series
+	}
+
+	for i, bucket := range h.buckets {
+		if value <= bucket {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+// Count returns the number of observations of the histogram for the label values
+func (h *Histogram) Count(labelValues ...string) uint64 {
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	series, ok := h.series[strings.Join(labelValues, "\xff")]
+	if !ok {
+		return 0
+	}
+	return series.count
+}
+
+func (h *Histogram) name() string {
+	return h.metricName
+}
+
+func (h *Histogram) write(w io.Writer) {
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", h.metricName, h.help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", h.metricName)
+
+	keys := []string{}
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketLabelNames := append(append([]string{}, h.labelNames...), "le")
+	for _, key := range keys {
+		series := h.series[key]
+		for i, bucket := range h.buckets {
+			labels := formatLabels(bucketLabelNames, append(append([]string{}, series.labelValues...), formatValue(bucket)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labels, series.counts[i])
+		}
+		labels := formatLabels(bucketLabelNames, append(append([]string{}, series.labelValues...), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labels, series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, formatLabels(h.labelNames, series.labelValues), formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, formatLabels(h.labelNames, series.labelValues), series.count)
+	}
+}
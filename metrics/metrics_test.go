@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+
+	Convey("When updating metrics", t, func() {
+		registry := NewRegistry()
+		testGauge := registry.NewGauge("deathnode_test_gauge", "A gauge for testing")
+		testCounter := registry.NewCounter("deathnode_test_counter_total", "A counter for testing", "autoscaling_group")
+		testGauge.Set(1)
+		testCounter.Inc("some-Autoscaling-Group")
+		testCounter.Add(2, "some-Autoscaling-Group")
+		testCounter.Inc("other-Autoscaling-Group")
+
+		Convey("their values should be returned", func() {
+			So(testGauge.Value(), ShouldEqual, 1)
+			So(testCounter.Value("some-Autoscaling-Group"), ShouldEqual, 3)
+		})
+		Convey("they should be written in the Prometheus text format", func() {
+			buffer := &bytes.Buffer{}
+			registry.WriteText(buffer)
+			So(buffer.String(), ShouldContainSubstring, "# TYPE deathnode_test_gauge gauge\ndeathnode_test_gauge 1\n")
+			So(buffer.String(), ShouldContainSubstring, "# TYPE deathnode_test_counter_total counter\n"+
+				"deathnode_test_counter_total{autoscaling_group=\"other-Autoscaling-Group\"} 1\n"+
+				"deathnode_test_counter_total{autoscaling_group=\"some-Autoscaling-Group\"} 3\n")
+		})
+		Convey("they should be exposed through HTTP", func() {
+			recorder := httptest.NewRecorder()
+			registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+			So(recorder.Code, ShouldEqual, 200)
+			So(recorder.Body.String(), ShouldContainSubstring, "deathnode_test_gauge 1")
+		})
+		Convey("histograms should count observations in every bucket", func() {
+			histogram := registry.NewHistogram("deathnode_test_seconds", "A histogram for testing", []float64{1, 10}, "operation")
+			histogram.Observe(0.5, "DescribeAGByName")
+			histogram.Observe(5, "DescribeAGByName")
+			histogram.Observe(50, "DescribeAGByName")
+			So(histogram.Count("DescribeAGByName"), ShouldEqual, 3)
+
+			buffer := &bytes.Buffer{}
+			registry.WriteText(buffer)
+			So(buffer.String(), ShouldContainSubstring, "# TYPE deathnode_test_seconds histogram\n"+
+				"deathnode_test_seconds_bucket{operation=\"DescribeAGByName\",le=\"1\"} 1\n"+
+				"deathnode_test_seconds_bucket{operation=\"DescribeAGByName\",le=\"10\"} 2\n"+
+				"deathnode_test_seconds_bucket{operation=\"DescribeAGByName\",le=\"+Inf\"} 3\n"+
+				"deathnode_test_seconds_sum{operation=\"DescribeAGByName\"} 55.5\n"+
+				"deathnode_test_seconds_count{operation=\"DescribeAGByName\"} 3\n")
+		})
+		Convey("gauge functions should be computed when collected", func() {
+			registry.NewGaugeFunc("deathnode_test_gauge_func", "A gauge function for testing", func() float64 { return 42 })
+			buffer := &bytes.Buffer{}
+			registry.WriteText(buffer)
+			So(buffer.String(), ShouldContainSubstring, "deathnode_test_gauge_func 42\n")
+		})
+		Convey("gauges should be reset", func() {
+			testGauge.Reset()
+			buffer := &bytes.Buffer{}
+			registry.WriteText(buffer)
+			So(buffer.String(), ShouldContainSubstring, "deathnode_test_gauge 0\n")
+		})
+		Convey("gauges should be replaced at once", func() {
+			labelledGauge := registry.NewGauge("deathnode_test_labelled_gauge", "A labelled gauge for testing", "autoscaling_group")
+			labelledGauge.Set(1, "some-Autoscaling-Group")
+			values := labelledGauge.NewValues()
+			values.Set(2, "other-Autoscaling-Group")
+			So(labelledGauge.Value("some-Autoscaling-Group"), ShouldEqual, 1)
+			labelledGauge.Replace(values)
+			So(labelledGauge.Value("some-Autoscaling-Group"), ShouldEqual, 0)
+			So(labelledGauge.Value("other-Autoscaling-Group"), ShouldEqual, 2)
+		})
+		Convey("label values should be escaped as the Prometheus text format expects", func() {
+			testCounter.Inc("a \\ \"group\"\nwith ünicode\t")
+			buffer := &bytes.Buffer{}
+			registry.WriteText(buffer)
+			So(buffer.String(), ShouldContainSubstring,
+				"deathnode_test_counter_total{autoscaling_group=\"a \\\\ \\\"group\\\"\\nwith ünicode\t\"} 1\n")
+		})
+		Convey("metrics should not be registered twice", func() {
+			So(func() { registry.NewGauge("deathnode_test_gauge", "A gauge for testing") }, ShouldPanic)
+		})
+		Convey("counters should not be decreased", func() {
+			So(func() { testCounter.Add(-1, "some-Autoscaling-Group") }, ShouldPanic)
+		})
+		Convey("the number of label values should match the label names", func() {
+			So(func() { testCounter.Inc() }, ShouldPanic)
+		})
+	})
+}
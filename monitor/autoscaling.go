@@ -3,69 +3,235 @@ package monitor
 import (
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/alanbover/deathnode/aws"
+	"github.com/alanbover/deathnode/config"
 	log "github.com/sirupsen/logrus"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 )
 
-// AutoscalingGroupsMonitor holds a map of [ASGprefix][ASGname]AutoscalingGroupMonitor
+// AutoscalingGroupsMonitor holds the autoscaling group selectors to monitor, each one of them caching
+// the AutoscalingGroupMonitors found for it's prefix. It's safe for concurrent use
 type AutoscalingGroupsMonitor struct {
-	monitors      map[string]map[string]*AutoscalingGroupMonitor
-	awsConnection aws.ClientInterface
+	mutex         sync.RWMutex
+	selectors     []*autoscalingGroupSelector
 	deathNodeMark string
 }
 
-// AutoscalingGroupMonitor monitors an AWS autoscaling group, caching it's data
+// AutoscalingGroupSelector ties an autoscaling group prefix to the AWS connection (account and region)
+// used to monitor it, and optionally to the policy to apply to it's autoscaling groups
+type AutoscalingGroupSelector struct {
+	Prefix        string
+	AWSConnection aws.ClientInterface
+	Policy        *config.Policy
+}
+
+// autoscalingGroupSelector holds a map of [ASGname]AutoscalingGroupMonitor for an AutoscalingGroupSelector
+// A retired selector is one removed from the configuration that still has instances being drained. It's
+// monitored until they are removed, but no new instances are marked on it
+type autoscalingGroupSelector struct {
+	prefix        string
+	awsConnection aws.ClientInterface
+	policy        *config.Policy
+	monitors      map[string]*AutoscalingGroupMonitor
+	retired       bool
+}
+
+// AutoscalingGroupMonitor monitors an AWS autoscaling group, caching it's data. It's safe for concurrent use
 type AutoscalingGroupMonitor struct {
+	mutex         sync.RWMutex
 	autoscaling   *autoscalingGroup
 	awsConnection aws.ClientInterface
 	deathNodeMark string
+	policy        *config.Policy
 }
 
+// autoscalingGroup caches the data of an AWS autoscaling group. instanceRefresh is the last status of it's
+// instance refreshes, checked at instanceRefreshCheckTime
 type autoscalingGroup struct {
-	autoscalingGroupName string
-	desiredCapacity      int64
-	instanceMonitors     map[string]*InstanceMonitor
+	autoscalingGroupName     string
+	desiredCapacity          int64
+	instanceMonitors         map[string]*InstanceMonitor
+	loadBalancers            *loadBalancers
+	instanceRefresh          bool
+	instanceRefreshCheckTime time.Time
+	stale                    bool
+}
+
+// loadBalancers holds the classic load balancers and target groups attached to an autoscalingGroup. It's
+// shared with the autoscalingGroup instances, so they can be deregistered from them
+type loadBalancers struct {
+	mutex             sync.RWMutex
+	loadBalancerNames []*string
+	targetGroupARNs   []*string
+}
+
+func (l *loadBalancers) get() ([]*string, []*string) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.loadBalancerNames, l.targetGroupARNs
+}
+
+func (l *loadBalancers) set(loadBalancerNames, targetGroupARNs []*string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.loadBalancerNames = loadBalancerNames
+	l.targetGroupARNs = targetGroupARNs
 }
 
 var lifeCycleTimeout int64 = 900
 
-// NewAutoscalingGroupMonitors returns an AutoscalingGroups object
+// instanceRefreshCacheTTL is how long the status of the instance refreshes of an autoscaling group is
+// cached, so DescribeInstanceRefreshes is not called on every refresh
+var instanceRefreshCacheTTL = 2 * time.Minute
+
+// LifecycleHeartbeatInterval is how often the lifecycle action of the instances being drained is extended.
+// It's half the lifecycle hook timeout, so a failed heartbeat is retried before the timeout expires
+var LifecycleHeartbeatInterval = time.Duration(lifeCycleTimeout/2) * time.Second
+
+// warmPoolLifecycleStatePrefix is the prefix of the lifecycle states of the instances in a warm pool.
+// Those instances are not part of the autoscalingGroup capacity, so they are not monitored
+const warmPoolLifecycleStatePrefix = "Warmed:"
+
+// NewAutoscalingGroupMonitors returns an AutoscalingGroups object, monitoring all autoscaling group
+// prefixes using the same AWS connection
 func NewAutoscalingGroupMonitors(awsConnection aws.ClientInterface, autoscalingGroupNameList []string, deathNodeMark string) (*AutoscalingGroupsMonitor, error) {
 
-	monitors := map[string]map[string]*AutoscalingGroupMonitor{}
+	selectors := []AutoscalingGroupSelector{}
 	for _, autoscalingGroupName := range autoscalingGroupNameList {
-		monitors[autoscalingGroupName] = map[string]*AutoscalingGroupMonitor{}
+		selectors = append(selectors, AutoscalingGroupSelector{
+			Prefix:        autoscalingGroupName,
+			AWSConnection: awsConnection,
+		})
 	}
 
+	return NewAutoscalingGroupMonitorsFromSelectors(selectors, deathNodeMark)
+}
+
+// NewAutoscalingGroupMonitorsFromSelectors returns an AutoscalingGroups object, monitoring every selector
+// with it's own AWS connection
+func NewAutoscalingGroupMonitorsFromSelectors(selectorList []AutoscalingGroupSelector, deathNodeMark string) (*AutoscalingGroupsMonitor, error) {
+
 	autoscalingGroups := &AutoscalingGroupsMonitor{
-		monitors:      monitors,
-		awsConnection: awsConnection,
+		selectors:     []*autoscalingGroupSelector{},
 		deathNodeMark: deathNodeMark,
 	}
 
+	err := autoscalingGroups.SetSelectors(selectorList)
+	if err != nil {
+		return nil, err
+	}
+
 	return autoscalingGroups, nil
 }
 
+// SetSelectors replaces the autoscaling group selectors to monitor. Selectors with the same prefix and AWS
+// connection as an existing one keep it's cached autoscaling groups, updating their policy. Removed
+// selectors are retired: they keep being monitored until their instances being drained are removed
+func (a *AutoscalingGroupsMonitor) SetSelectors(selectorList []AutoscalingGroupSelector) error {
+
+	for _, selector := range selectorList {
+		if selector.AWSConnection == nil {
+			return fmt.Errorf("No AWS connection provided for autoscalingGroupPrefix %s", selector.Prefix)
+		}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	selectors := []*autoscalingGroupSelector{}
+	kept := map[*autoscalingGroupSelector]bool{}
+	for _, selector := range selectorList {
+		existing := a.findSelector(selector.Prefix, selector.AWSConnection)
+		if existing == nil {
+			selectors = append(selectors, &autoscalingGroupSelector{
+				prefix:        selector.Prefix,
+				awsConnection: selector.AWSConnection,
+				policy:        selector.Policy,
+				monitors:      map[string]*AutoscalingGroupMonitor{},
+			})
+			continue
+		}
+		if existing.retired {
+			log.Infof("Autoscaling group prefix %s is monitored again", existing.prefix)
+		}
+		existing.retired = false
+		existing.setPolicy(selector.Policy)
+		kept[existing] = true
+		selectors = append(selectors, existing)
+	}
+
+	for _, selector := range a.selectors {
+		if !kept[selector] && selector.hasInstancesMarkedToBeRemoved() {
+			if !selector.retired {
+				log.Infof("Autoscaling group prefix %s removed. Monitoring it until it's instances being drained are removed", selector.prefix)
+			}
+			selector.retired = true
+			selectors = append(selectors, selector)
+		} else if !kept[selector] {
+			log.Infof("Autoscaling group prefix %s removed. Stop monitoring it", selector.prefix)
+		}
+	}
+
+	a.selectors = selectors
+	return nil
+}
+
+// findSelector expects the caller to hold the AutoscalingGroupsMonitor lock
+func (a *AutoscalingGroupsMonitor) findSelector(prefix string, awsConnection aws.ClientInterface) *autoscalingGroupSelector {
+
+	for _, selector := range a.selectors {
+		if selector.prefix == prefix && selector.awsConnection == awsConnection {
+			return selector
+		}
+	}
+	return nil
+}
+
+func (s *autoscalingGroupSelector) setPolicy(policy *config.Policy) {
+
+	s.policy = policy
+	for _, autoscalingMonitor := range s.monitors {
+		autoscalingMonitor.setPolicy(policy)
+	}
+}
+
+func (s *autoscalingGroupSelector) hasInstancesMarkedToBeRemoved() bool {
+
+	for _, autoscalingMonitor := range s.monitors {
+		if autoscalingMonitor.NumInstancesMarkedToBeRemoved() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // NewAutoscalingGroupMonitor returns a "empty" AutoscalingGroupMonitor object
-func newAutoscalingGroupMonitor(awsConnection aws.ClientInterface, autoscalingGroupName, deathNodeMark string) (*AutoscalingGroupMonitor, error) {
+func newAutoscalingGroupMonitor(awsConnection aws.ClientInterface, autoscalingGroupName, deathNodeMark string, policy *config.Policy) (*AutoscalingGroupMonitor, error) {
 
 	return &AutoscalingGroupMonitor{
 		autoscaling: &autoscalingGroup{
 			autoscalingGroupName: autoscalingGroupName,
 			desiredCapacity:      0,
 			instanceMonitors:     map[string]*InstanceMonitor{},
+			loadBalancers:        &loadBalancers{},
 		},
 		awsConnection: awsConnection,
 		deathNodeMark: deathNodeMark,
+		policy:        policy,
 	}, nil
 }
 
 // GetInstanceByID returns the instanceMonitor related with the instanceId
 func (a *AutoscalingGroupsMonitor) GetInstanceByID(instanceID string) (*InstanceMonitor, error) {
 
-	for _, autoscalingPrefix := range a.monitors {
-		for _, autoscalingMonitor := range autoscalingPrefix {
-			if instance, ok := autoscalingMonitor.autoscaling.instanceMonitors[instanceID]; ok {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	for _, selector := range a.selectors {
+		for _, autoscalingMonitor := range selector.monitors {
+			if instance, ok := autoscalingMonitor.getInstanceByID(instanceID); ok {
 				return instance, nil
 			}
 		}
@@ -73,83 +239,214 @@ func (a *AutoscalingGroupsMonitor) GetInstanceByID(instanceID string) (*Instance
 	return nil, fmt.Errorf("InstanceId %s not found", instanceID)
 }
 
-// Refresh updates autoscalingGroups caching all AWS autoscaling groups given the N prefixes
-// provided when AutoscalingGroups was created
+// GetAutoscalingGroupByInstanceID returns the AutoscalingGroupMonitor the instanceId belongs to
+func (a *AutoscalingGroupsMonitor) GetAutoscalingGroupByInstanceID(instanceID string) (*AutoscalingGroupMonitor, error) {
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	for _, selector := range a.selectors {
+		for _, autoscalingMonitor := range selector.monitors {
+			if _, ok := autoscalingMonitor.getInstanceByID(instanceID); ok {
+				return autoscalingMonitor, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("InstanceId %s not found", instanceID)
+}
+
+// GetAWSConnections returns the distinct AWS connections used by the autoscaling group selectors
+func (a *AutoscalingGroupsMonitor) GetAWSConnections() []aws.ClientInterface {
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	connections := []aws.ClientInterface{}
+	for _, selector := range a.selectors {
+		found := false
+		for _, connection := range connections {
+			if connection == selector.awsConnection {
+				found = true
+				break
+			}
+		}
+		if !found {
+			connections = append(connections, selector.awsConnection)
+		}
+	}
+
+	return connections
+}
+
+// Refresh updates autoscalingGroups caching all AWS autoscaling groups given the N selectors
+// provided when AutoscalingGroups was created. A selector failing to refresh doesn't stop the others:
+// it's autoscaling groups keep their last state, flagged as stale, and an error is returned once all
+// the selectors are refreshed. AWS is called without holding the lock, and the autoscaling groups found
+// are swapped in at the end
 func (a *AutoscalingGroupsMonitor) Refresh() error {
 
-	for autoscalingGroupPrefix := range a.monitors {
+	a.mutex.RLock()
+	snapshots := []*selectorSnapshot{}
+	for _, selector := range a.selectors {
+		snapshots = append(snapshots, selector.snapshot())
+	}
+	a.mutex.RUnlock()
 
-		response, err := a.awsConnection.DescribeAGByName(autoscalingGroupPrefix)
+	failedPrefixes := []string{}
+	refreshed := map[*autoscalingGroupSelector]map[string]*AutoscalingGroupMonitor{}
+	for _, snapshot := range snapshots {
+		monitors, err := snapshot.refresh(a.deathNodeMark)
 		if err != nil {
-			return err
+			log.Errorf("Unable to refresh autoscaling groups with prefix %s: %v. Keeping their last state", snapshot.selector.prefix, err)
+			snapshot.setStale()
+			failedPrefixes = append(failedPrefixes, snapshot.selector.prefix)
+			continue
 		}
+		refreshed[snapshot.selector] = monitors
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
 
-		if len(response) == 0 {
-			log.Warnf("No autoscaling groups found under autoscalingGroupPrefix %s", autoscalingGroupPrefix)
+	// The policy may have been replaced meanwhile, so it's set again on the autoscaling groups found
+	for selector, monitors := range refreshed {
+		selector.monitors = monitors
+		selector.setPolicy(selector.policy)
+	}
+
+	// Stop monitoring the retired selectors once their instances being drained are gone
+	selectors := []*autoscalingGroupSelector{}
+	for _, selector := range a.selectors {
+		if selector.retired && !selector.isStale() && !selector.hasInstancesMarkedToBeRemoved() {
+			log.Infof("Autoscaling group prefix %s has no instances being drained. Stop monitoring it", selector.prefix)
+			continue
 		}
+		selectors = append(selectors, selector)
+	}
+	a.selectors = selectors
 
-		for _, autoscalingGroupResponse := range response {
-			_, ok := a.monitors[autoscalingGroupPrefix][*autoscalingGroupResponse.AutoScalingGroupName]
-			if ok {
-				a.monitors[autoscalingGroupPrefix][*autoscalingGroupResponse.AutoScalingGroupName].refresh(autoscalingGroupResponse)
-			} else {
-				log.Infof("Found new autoscalingGroup to monitor: %s", *autoscalingGroupResponse.AutoScalingGroupName)
-				autoscalingGroupMonitor, _ := newAutoscalingGroupMonitor(a.awsConnection, *autoscalingGroupResponse.AutoScalingGroupName, a.deathNodeMark)
-
-				ok, _ := a.awsConnection.HasLifeCycleHook(autoscalingGroupResponse.AutoScalingGroupName)
-				if !ok {
-					log.Infof("Setting lifecyclehook for autoscaling %s", *autoscalingGroupResponse.AutoScalingGroupName)
-					err := a.awsConnection.PutLifeCycleHook(autoscalingGroupResponse.AutoScalingGroupName, &lifeCycleTimeout)
-					if err != nil {
-						log.Warnf("Error putting lifecyclehook to autoscaling %s: %s", *autoscalingGroupResponse.AutoScalingGroupName, err)
-					}
-				} else {
-					log.Infof("Autoscaling %s already have set lifecyclehook. Ignoring it...", *autoscalingGroupResponse.AutoScalingGroupName)
-				}
+	if len(failedPrefixes) > 0 {
+		return fmt.Errorf("unable to refresh autoscaling groups with prefixes %s", strings.Join(failedPrefixes, ", "))
+	}
+	return nil
+}
 
-				a.monitors[autoscalingGroupPrefix][*autoscalingGroupResponse.AutoScalingGroupName] = autoscalingGroupMonitor
-				autoscalingGroupMonitor.refresh(autoscalingGroupResponse)
-			}
+// selectorSnapshot is a copy of the autoscaling groups of a selector, refreshed without holding the
+// AutoscalingGroupsMonitor lock
+// monitors: map[ASGname]AutoscalingGroupMonitor
+type selectorSnapshot struct {
+	selector *autoscalingGroupSelector
+	policy   *config.Policy
+	monitors map[string]*AutoscalingGroupMonitor
+}
+
+// snapshot expects the caller to hold the AutoscalingGroupsMonitor lock
+func (s *autoscalingGroupSelector) snapshot() *selectorSnapshot {
+
+	monitors := map[string]*AutoscalingGroupMonitor{}
+	for autoscalingGroupName, autoscalingGroupMonitor := range s.monitors {
+		monitors[autoscalingGroupName] = autoscalingGroupMonitor
+	}
+	return &selectorSnapshot{
+		selector: s,
+		policy:   s.policy,
+		monitors: monitors,
+	}
+}
+
+// setStale flags the autoscaling groups of the snapshot as stale, as they couldn't be refreshed
+func (s *selectorSnapshot) setStale() {
+
+	for _, autoscalingGroupMonitor := range s.monitors {
+		autoscalingGroupMonitor.setStale()
+	}
+}
+
+// isStale returns true if any autoscaling group of the selector couldn't be refreshed. It expects the
+// caller to hold the AutoscalingGroupsMonitor lock
+func (s *autoscalingGroupSelector) isStale() bool {
+
+	for _, autoscalingGroupMonitor := range s.monitors {
+		if autoscalingGroupMonitor.IsStale() {
+			return true
 		}
+	}
+	return false
+}
+
+// refresh returns the autoscaling groups of the selector found in AWS, keeping the monitors of the ones
+// already cached
+func (s *selectorSnapshot) refresh(deathNodeMark string) (map[string]*AutoscalingGroupMonitor, error) {
+
+	prefix, awsConnection := s.selector.prefix, s.selector.awsConnection
+	response, err := awsConnection.DescribeAGByName(prefix)
+	if err != nil {
+		return nil, err
+	}
 
-		var found bool
-		for autoscalingGroupName := range a.monitors[autoscalingGroupPrefix] {
-			found = false
-			for _, autoscalingGroupResponse := range response {
-				if autoscalingGroupName == *autoscalingGroupResponse.AutoScalingGroupName {
-					found = true
-					break
+	if len(response) == 0 {
+		log.Warnf("No autoscaling groups found under autoscalingGroupPrefix %s", prefix)
+	}
+
+	monitors := map[string]*AutoscalingGroupMonitor{}
+	for _, autoscalingGroupResponse := range response {
+		autoscalingGroupMonitor, ok := s.monitors[*autoscalingGroupResponse.AutoScalingGroupName]
+		if !ok {
+			log.Infof("Found new autoscalingGroup to monitor: %s", *autoscalingGroupResponse.AutoScalingGroupName)
+			autoscalingGroupMonitor, _ = newAutoscalingGroupMonitor(awsConnection, *autoscalingGroupResponse.AutoScalingGroupName, deathNodeMark, s.policy)
+
+			ok, _ := awsConnection.HasLifeCycleHook(autoscalingGroupResponse.AutoScalingGroupName)
+			if !ok {
+				log.Infof("Setting lifecyclehook for autoscaling %s", *autoscalingGroupResponse.AutoScalingGroupName)
+				err := awsConnection.PutLifeCycleHook(autoscalingGroupResponse.AutoScalingGroupName, &lifeCycleTimeout)
+				if err != nil {
+					log.Warnf("Error putting lifecyclehook to autoscaling %s: %s", *autoscalingGroupResponse.AutoScalingGroupName, err)
 				}
-			}
-			if !found {
-				log.Infof("Autoscaling group %s removed. Deleting it", autoscalingGroupName)
-				delete(a.monitors[autoscalingGroupPrefix], autoscalingGroupName)
+			} else {
+				log.Infof("Autoscaling %s already have set lifecyclehook. Ignoring it...", *autoscalingGroupResponse.AutoScalingGroupName)
 			}
 		}
+		autoscalingGroupMonitor.refresh(autoscalingGroupResponse)
+		monitors[*autoscalingGroupResponse.AutoScalingGroupName] = autoscalingGroupMonitor
 	}
 
-	return nil
+	for autoscalingGroupName := range s.monitors {
+		if _, ok := monitors[autoscalingGroupName]; !ok {
+			log.Infof("Autoscaling group %s removed. Deleting it", autoscalingGroupName)
+		}
+	}
+
+	return monitors, nil
 }
 
-// GetAllMonitors returns all AutoscalingGroupMonitors cached in AutoscalingGroups
+// GetAllMonitors returns all AutoscalingGroupMonitors cached in AutoscalingGroups, except the ones
+// from retired selectors
 func (a *AutoscalingGroupsMonitor) GetAllMonitors() []*AutoscalingGroupMonitor {
 
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
 	var monitors = []*AutoscalingGroupMonitor{}
 
-	for autoscalingGroupPrefix := range a.monitors {
-		for autoscalingGroupName := range a.monitors[autoscalingGroupPrefix] {
-			monitors = append(monitors, a.monitors[autoscalingGroupPrefix][autoscalingGroupName])
+	for _, selector := range a.selectors {
+		if selector.retired {
+			continue
+		}
+		for autoscalingGroupName := range selector.monitors {
+			monitors = append(monitors, selector.monitors[autoscalingGroupName])
 		}
 	}
 
 	return monitors
 }
 
-// Refresh updates the cached autoscalingGroup, updating it's values and it's instances
+// Refresh updates the cached autoscalingGroup, updating it's values and it's instances. AWS is called
+// without holding the lock, and the instances found are swapped in at the end
 func (a *AutoscalingGroupMonitor) refresh(autoscalingGroup *autoscaling.Group) error {
 
+	autoscalingGroupName := *autoscalingGroup.AutoScalingGroupName
 	if !*autoscalingGroup.NewInstancesProtectedFromScaleIn {
-		log.Infof("Setting autoscaling %s and it's instances scaleInProtection flag", *autoscalingGroup.AutoScalingGroupName)
+		log.Infof("Setting autoscaling %s and it's instances scaleInProtection flag", autoscalingGroupName)
 		instancesToProtect := []*string{}
 
 		for _, instance := range autoscalingGroup.Instances {
@@ -161,48 +458,84 @@ func (a *AutoscalingGroupMonitor) refresh(autoscalingGroup *autoscaling.Group) e
 			return err
 		}
 	} else {
-		log.Debugf("Autoscaling %s already has scaleInProtection set. Ignoring it...", *autoscalingGroup.AutoScalingGroupName)
+		log.Debugf("Autoscaling %s already has scaleInProtection set. Ignoring it...", autoscalingGroupName)
 	}
 
-	a.autoscaling.desiredCapacity = *autoscalingGroup.DesiredCapacity
-
+	instances := []*autoscaling.Instance{}
 	for _, instance := range autoscalingGroup.Instances {
-		_, ok := a.autoscaling.instanceMonitors[*instance.InstanceId]
+		if strings.HasPrefix(*instance.LifecycleState, warmPoolLifecycleStatePrefix) {
+			log.Debugf("Instance %s from autoscaling %s is in a warm pool. Ignoring it...", *instance.InstanceId, autoscalingGroupName)
+			continue
+		}
+		instances = append(instances, instance)
+	}
+
+	a.mutex.RLock()
+	currentInstanceMonitors := map[string]*InstanceMonitor{}
+	for instanceID, instanceMonitor := range a.autoscaling.instanceMonitors {
+		currentInstanceMonitors[instanceID] = instanceMonitor
+	}
+	instanceRefresh, instanceRefreshCheckTime := a.autoscaling.instanceRefresh, a.autoscaling.instanceRefreshCheckTime
+	a.mutex.RUnlock()
+
+	instanceMonitors := map[string]*InstanceMonitor{}
+	for _, instance := range instances {
+		instanceMonitor, ok := currentInstanceMonitors[*instance.InstanceId]
 		if !ok {
-			log.Debugf("Found new instance to monitor in autoscaling %s: %s", a.autoscaling.autoscalingGroupName, *instance.InstanceId)
-			instanceMonitor, err := newInstanceMonitor(a.awsConnection, a.autoscaling.autoscalingGroupName,
+			log.Debugf("Found new instance to monitor in autoscaling %s: %s", autoscalingGroupName, *instance.InstanceId)
+			var err error
+			instanceMonitor, err = newInstanceMonitor(a.awsConnection, autoscalingGroupName,
 				*instance.InstanceId, a.deathNodeMark, *instance.LifecycleState, true)
 			if err != nil {
 				log.Error(err)
 				continue
 			}
-			a.autoscaling.instanceMonitors[*instance.InstanceId] = instanceMonitor
+			instanceMonitor.loadBalancers = a.autoscaling.loadBalancers
 		} else {
-			a.autoscaling.instanceMonitors[*instance.InstanceId].setLifecycleState(*instance.LifecycleState)
+			instanceMonitor.setLifecycleState(*instance.LifecycleState)
 		}
+		instanceMonitors[*instance.InstanceId] = instanceMonitor
 	}
 
-	var found bool
-	for instanceID := range a.autoscaling.instanceMonitors {
-		found = false
-		for _, instance := range autoscalingGroup.Instances {
-			if *instance.InstanceId == instanceID {
-				found = true
-				break
-			}
+	for instanceID := range currentInstanceMonitors {
+		if _, ok := instanceMonitors[instanceID]; !ok {
+			log.Debugf("Instance %s has disappeared from ASG %s. Stop monitoring it", instanceID, autoscalingGroupName)
 		}
-		if !found {
-			log.Debugf("Instance %s has disappeared from ASG %s. Stop monitoring it", instanceID, a.autoscaling.autoscalingGroupName)
-			delete(a.autoscaling.instanceMonitors, instanceID)
+	}
+
+	// Instance refreshes only matter while there are instances being terminated without the deathnode
+	// mark, and their status is cached for instanceRefreshCacheTTL
+	if len(instancesTargetedByRefresh(instanceMonitors)) > 0 && time.Since(instanceRefreshCheckTime) >= instanceRefreshCacheTTL {
+		active, err := a.awsConnection.HasActiveInstanceRefresh(autoscalingGroup.AutoScalingGroupName)
+		if err != nil {
+			log.Warnf("Unable to check instance refreshes for autoscaling %s: %s", autoscalingGroupName, err)
+		} else {
+			instanceRefresh, instanceRefreshCheckTime = active, time.Now()
 		}
 	}
 
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.autoscaling.stale = false
+	a.autoscaling.desiredCapacity = *autoscalingGroup.DesiredCapacity
+	a.autoscaling.loadBalancers.set(autoscalingGroup.LoadBalancerNames, autoscalingGroup.TargetGroupARNs)
+	if instanceRefresh != a.autoscaling.instanceRefresh {
+		log.Infof("Autoscaling %s instance refresh active: %v", autoscalingGroupName, instanceRefresh)
+		a.autoscaling.instanceRefresh = instanceRefresh
+	}
+	a.autoscaling.instanceRefreshCheckTime = instanceRefreshCheckTime
+	a.autoscaling.instanceMonitors = instanceMonitors
+
 	return nil
 }
 
 // NumUndesiredInstances return the number of instances to be removed from the AutoscalingGroup
 func (a *AutoscalingGroupMonitor) NumUndesiredInstances() int {
 
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
 	if len(a.autoscaling.instanceMonitors)-len(a.getInstancesMarkedToBeRemoved()) > int(a.autoscaling.desiredCapacity) {
 		return len(a.autoscaling.instanceMonitors) - int(a.autoscaling.desiredCapacity)
 	}
@@ -210,6 +543,78 @@ func (a *AutoscalingGroupMonitor) NumUndesiredInstances() int {
 	return 0
 }
 
+// NumInstancesMarkedToBeRemoved returns the number of instances of the AutoscalingGroup with the deathnode mark
+func (a *AutoscalingGroupMonitor) NumInstancesMarkedToBeRemoved() int {
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return len(a.getInstancesMarkedToBeRemoved())
+}
+
+// GetPolicy returns the policy of the AutoscalingGroup, or nil if it was monitored without one
+func (a *AutoscalingGroupMonitor) GetPolicy() *config.Policy {
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.policy
+}
+
+func (a *AutoscalingGroupMonitor) setPolicy(policy *config.Policy) {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.policy = policy
+}
+
+// IsStale returns true if the last refresh of the AutoscalingGroup failed, so it's data may be outdated
+func (a *AutoscalingGroupMonitor) IsStale() bool {
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.autoscaling.stale
+}
+
+func (a *AutoscalingGroupMonitor) setStale() {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.autoscaling.stale = true
+}
+
+// GetAutoscalingGroupName returns the name of the AutoscalingGroup being monitored
+func (a *AutoscalingGroupMonitor) GetAutoscalingGroupName() string {
+	return a.autoscaling.autoscalingGroupName
+}
+
+// GetInstancesTargetedByRefresh returns the instances being terminated by an active instance refresh.
+// Only the instances without the deathnode mark are returned, as the marked ones are already drained
+func (a *AutoscalingGroupMonitor) GetInstancesTargetedByRefresh() []*InstanceMonitor {
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if !a.autoscaling.instanceRefresh {
+		return []*InstanceMonitor{}
+	}
+	return instancesTargetedByRefresh(a.autoscaling.instanceMonitors)
+}
+
+// instancesTargetedByRefresh returns the instances being terminated that don't have the deathnode mark,
+// as an active instance refresh would be replacing them
+func instancesTargetedByRefresh(instanceMonitors map[string]*InstanceMonitor) []*InstanceMonitor {
+
+	instances := []*InstanceMonitor{}
+	for _, instanceMonitor := range instanceMonitors {
+		if instanceMonitor.IsMarkedToBeRemoved() {
+			continue
+		}
+		if strings.HasPrefix(instanceMonitor.GetLifecycleState(), "Terminating") {
+			instances = append(instances, instanceMonitor)
+		}
+	}
+	return instances
+}
+
 // GetInstancesMarkedToBeRemoved return the instances in AutoscalingGroupMonitor cache that
 // do have the deathnode mark
 func (a *AutoscalingGroupMonitor) getInstancesMarkedToBeRemoved() []*InstanceMonitor {
@@ -219,14 +624,47 @@ func (a *AutoscalingGroupMonitor) getInstancesMarkedToBeRemoved() []*InstanceMon
 // GetInstances return the instances in AutoscalingGroupMonitor cache that
 // doesn't have the deathnode mark
 func (a *AutoscalingGroupMonitor) GetInstances() []*InstanceMonitor {
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
 	return a.getInstances(false)
 }
 
+// GetAllInstances return all the instances in AutoscalingGroupMonitor cache
+func (a *AutoscalingGroupMonitor) GetAllInstances() []*InstanceMonitor {
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	instances := []*InstanceMonitor{}
+	for _, instanceMonitor := range a.autoscaling.instanceMonitors {
+		instances = append(instances, instanceMonitor)
+	}
+	return instances
+}
+
+// GetDesiredCapacity returns the desired capacity of the AutoscalingGroup
+func (a *AutoscalingGroupMonitor) GetDesiredCapacity() int64 {
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.autoscaling.desiredCapacity
+}
+
+func (a *AutoscalingGroupMonitor) getInstanceByID(instanceID string) (*InstanceMonitor, bool) {
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	instance, ok := a.autoscaling.instanceMonitors[instanceID]
+	return instance, ok
+}
+
+// getInstances expects the caller to hold the AutoscalingGroupMonitor lock
 func (a *AutoscalingGroupMonitor) getInstances(markedToBeRemoved bool) []*InstanceMonitor {
 
 	instances := []*InstanceMonitor{}
 	for _, instanceMonitor := range a.autoscaling.instanceMonitors {
-		if instanceMonitor.instance.isMarkedToBeRemoved == markedToBeRemoved {
+		if instanceMonitor.IsMarkedToBeRemoved() == markedToBeRemoved {
 			instances = append(instances, instanceMonitor)
 		}
 	}
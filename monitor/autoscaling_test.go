@@ -1,8 +1,13 @@
 package monitor
 
 import (
+	"errors"
+	"sync"
 	"testing"
+	"time"
 	"github.com/alanbover/deathnode/aws"
+	"github.com/alanbover/deathnode/config"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -197,6 +202,346 @@ func TestGetInstanceById(t *testing.T) {
 	})
 }
 
+func TestAutoscalingGroupSelectors(t *testing.T) {
+
+	Convey("When monitoring autoscaling groups from two different AWS connections", t, func() {
+		awsConn1 := &aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {"node1", "node2", "node3"},
+				"DescribeAGByName":     {"default"},
+			},
+		}
+		awsConn2 := &aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {"node1", "node2", "node3"},
+				"DescribeAGByName":     {"default"},
+			},
+		}
+		monitors, _ := NewAutoscalingGroupMonitorsFromSelectors([]AutoscalingGroupSelector{
+			{Prefix: "some-Autoscaling-Group", AWSConnection: awsConn1},
+			{Prefix: "some-Autoscaling-Group", AWSConnection: awsConn2},
+		}, "DEATH_NODE_MARK")
+		monitors.Refresh()
+
+		Convey("autoscalingGroups from both connections should be monitored", func() {
+			So(len(monitors.GetAllMonitors()), ShouldEqual, 2)
+		})
+		Convey("every autoscalingGroup should be queried with it's own connection", func() {
+			So(len(*awsConn1.Records["DescribeAGByName"]), ShouldEqual, 0)
+			So(len(*awsConn2.Records["DescribeAGByName"]), ShouldEqual, 0)
+		})
+		Convey("both connections should be returned", func() {
+			So(len(monitors.GetAWSConnections()), ShouldEqual, 2)
+		})
+	})
+	Convey("When monitoring two autoscaling group prefixes with the same AWS connection", t, func() {
+		awsConn := &aws.ConnectionMock{}
+		monitors, _ := NewAutoscalingGroupMonitors(awsConn, []string{"prefix1", "prefix2"}, "DEATH_NODE_MARK")
+
+		Convey("the connection should be returned only once", func() {
+			So(len(monitors.GetAWSConnections()), ShouldEqual, 1)
+		})
+	})
+	Convey("When creating a selector without AWS connection", t, func() {
+		_, err := NewAutoscalingGroupMonitorsFromSelectors([]AutoscalingGroupSelector{
+			{Prefix: "some-Autoscaling-Group"},
+		}, "DEATH_NODE_MARK")
+
+		Convey("it should return an error", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+// failingConnectionMock fails to describe the autoscaling groups while err is set
+type failingConnectionMock struct {
+	*aws.ConnectionMock
+	err error
+}
+
+func (c *failingConnectionMock) DescribeAGByName(autoscalingGroupName string) ([]*autoscaling.Group, error) {
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.ConnectionMock.DescribeAGByName(autoscalingGroupName)
+}
+
+func TestRefreshFailingSelector(t *testing.T) {
+
+	Convey("When one of the autoscaling group selectors can't be refreshed", t, func() {
+		awsConn1 := &aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {"node1", "node2", "node3"},
+				"DescribeAGByName":     {"default", "default", "default"},
+			},
+		}
+		awsConn2 := &failingConnectionMock{ConnectionMock: &aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {"node1", "node2", "node3"},
+				"DescribeAGByName":     {"default", "default"},
+			},
+		}}
+		monitors, _ := NewAutoscalingGroupMonitorsFromSelectors([]AutoscalingGroupSelector{
+			{Prefix: "prefix1", AWSConnection: awsConn1},
+			{Prefix: "prefix2", AWSConnection: awsConn2},
+		}, "DEATH_NODE_MARK")
+		monitors.Refresh()
+		awsConn2.err = errors.New("aws unavailable")
+		err := monitors.Refresh()
+
+		Convey("the error should be returned once the other selectors are refreshed", func() {
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "prefix2")
+			So(len(*awsConn1.Records["DescribeAGByName"]), ShouldEqual, 1)
+		})
+		Convey("it's autoscaling groups should be kept, flagged as stale", func() {
+			So(len(monitors.GetAllMonitors()), ShouldEqual, 2)
+			for _, autoscalingMonitor := range monitors.GetAllMonitors() {
+				So(autoscalingMonitor.IsStale(), ShouldEqual, autoscalingMonitor.awsConnection == awsConn2)
+			}
+		})
+		Convey("they should not be stale once refreshed again", func() {
+			awsConn2.err = nil
+			So(monitors.Refresh(), ShouldBeNil)
+			for _, autoscalingMonitor := range monitors.GetAllMonitors() {
+				So(autoscalingMonitor.IsStale(), ShouldBeFalse)
+			}
+		})
+	})
+}
+
+// callbackConnectionMock calls onDescribe before describing the autoscaling groups
+type callbackConnectionMock struct {
+	*aws.ConnectionMock
+	onDescribe func()
+}
+
+func (c *callbackConnectionMock) DescribeAGByName(autoscalingGroupName string) ([]*autoscaling.Group, error) {
+
+	c.onDescribe()
+	return c.ConnectionMock.DescribeAGByName(autoscalingGroupName)
+}
+
+func TestRefreshWithoutLock(t *testing.T) {
+
+	Convey("When refreshing the autoscaling groups", t, func() {
+		awsConn := &callbackConnectionMock{ConnectionMock: &aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {"node1", "node2", "node3"},
+				"DescribeAGByName":     {"default", "default"},
+			},
+		}}
+		monitors, _ := NewAutoscalingGroupMonitors(awsConn, []string{"some-Autoscaling-Group"}, "DEATH_NODE_MARK")
+		awsConn.onDescribe = func() {}
+		monitors.Refresh()
+
+		Convey("the autoscaling groups should be readable while AWS is called", func() {
+			done := make(chan int)
+			awsConn.onDescribe = func() {
+				go func() { done <- len(monitors.GetAllMonitors()) }()
+				select {
+				case found := <-done:
+					So(found, ShouldEqual, 1)
+				case <-time.After(time.Second):
+					t.Error("the autoscaling groups are locked while AWS is called")
+				}
+			}
+			So(monitors.Refresh(), ShouldBeNil)
+			So(len(monitors.GetAllMonitors()), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestSetSelectors(t *testing.T) {
+
+	Convey("When replacing the selectors of a monitored autoscaling group", t, func() {
+		awsConn := &aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {"node1", "node2", "node3"},
+				"DescribeAGByName":     {"default"},
+			},
+		}
+		policy := &config.Policy{Name: "some-Autoscaling-Group"}
+		monitors, _ := NewAutoscalingGroupMonitorsFromSelectors([]AutoscalingGroupSelector{
+			{Prefix: "some-Autoscaling-Group", AWSConnection: awsConn, Policy: policy},
+		}, "DEATH_NODE_MARK")
+		monitors.Refresh()
+		autoscalingMonitor := monitors.GetAllMonitors()[0]
+
+		Convey("if the selector is kept, it's autoscaling groups should be kept with the new policy", func() {
+			newPolicy := &config.Policy{Name: "some-Autoscaling-Group", DelayDeleteSeconds: 300}
+			err := monitors.SetSelectors([]AutoscalingGroupSelector{
+				{Prefix: "some-Autoscaling-Group", AWSConnection: awsConn, Policy: newPolicy},
+			})
+			So(err, ShouldBeNil)
+			So(len(monitors.GetAllMonitors()), ShouldEqual, 1)
+			So(monitors.GetAllMonitors()[0], ShouldEqual, autoscalingMonitor)
+			So(autoscalingMonitor.GetPolicy(), ShouldEqual, newPolicy)
+		})
+		Convey("if the selector is removed without instances being drained, it should stop being monitored", func() {
+			monitors.SetSelectors([]AutoscalingGroupSelector{
+				{Prefix: "other-Autoscaling-Group", AWSConnection: awsConn},
+			})
+			So(len(monitors.GetAllMonitors()), ShouldEqual, 0)
+			_, err := monitors.GetInstanceByID("i-34719eb8")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("if the selector is removed with instances being drained", func() {
+			instance, _ := monitors.GetInstanceByID("i-34719eb8")
+			instance.MarkToBeRemoved()
+			monitors.SetSelectors([]AutoscalingGroupSelector{
+				{Prefix: "other-Autoscaling-Group", AWSConnection: awsConn},
+			})
+			Convey("no new instances should be marked on it", func() {
+				So(len(monitors.GetAllMonitors()), ShouldEqual, 0)
+			})
+			Convey("it's instances should still be tracked", func() {
+				instanceMonitor, err := monitors.GetInstanceByID("i-34719eb8")
+				So(err, ShouldBeNil)
+				So(instanceMonitor.IsMarkedToBeRemoved(), ShouldBeTrue)
+			})
+			Convey("it should be monitored again if it's added back", func() {
+				monitors.SetSelectors([]AutoscalingGroupSelector{
+					{Prefix: "some-Autoscaling-Group", AWSConnection: awsConn, Policy: policy},
+				})
+				So(len(monitors.GetAllMonitors()), ShouldEqual, 1)
+				So(monitors.GetAllMonitors()[0], ShouldEqual, autoscalingMonitor)
+			})
+		})
+	})
+}
+
+func TestWarmPoolAndInstanceRefresh(t *testing.T) {
+
+	Convey("When an autoscaling group has instances in a warm pool", t, func() {
+		monitor := newTestMonitor(&aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {"default", "default", "default"},
+				"DescribeAGByName":     {"warm_pool"},
+			},
+		})
+		Convey("they should not be monitored", func() {
+			So(len(monitor.autoscaling.instanceMonitors), ShouldEqual, 3)
+			So(monitor.autoscaling.instanceMonitors, ShouldNotContainKey, "i-1d3e4f5a")
+		})
+		Convey("they should not be counted as undesired instances", func() {
+			So(monitor.NumUndesiredInstances(), ShouldEqual, 0)
+		})
+	})
+	Convey("When an autoscaling group is terminating an instance", t, func() {
+		Convey("if there is no active instance refresh", func() {
+			monitor := newTestMonitor(&aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById": {"node1", "node2", "node3"},
+					"DescribeAGByName":     {"one_undesired_host_one_terminating"},
+				},
+			})
+			Convey("no instances should be targeted by a refresh", func() {
+				So(len(monitor.GetInstancesTargetedByRefresh()), ShouldEqual, 0)
+			})
+		})
+		Convey("if there is an active instance refresh", func() {
+			monitor := newTestMonitor(&aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById":     {"node1", "node2", "node3"},
+					"DescribeAGByName":         {"one_undesired_host_one_terminating"},
+					"HasActiveInstanceRefresh": {"true"},
+				},
+			})
+			Convey("the terminating instance should be targeted by the refresh", func() {
+				instances := monitor.GetInstancesTargetedByRefresh()
+				So(len(instances), ShouldEqual, 1)
+				So(*instances[0].GetInstanceID(), ShouldEqual, "i-34719eb8")
+			})
+			Convey("but not once it's marked to be removed", func() {
+				monitor.GetInstancesTargetedByRefresh()[0].MarkToBeRemoved()
+				So(len(monitor.GetInstancesTargetedByRefresh()), ShouldEqual, 0)
+			})
+		})
+		Convey("the instance refreshes should be cached", func() {
+			awsConn := &aws.ConnectionMock{
+				Records: map[string]*[]string{
+					"DescribeInstanceById":     {"node1", "node2", "node3"},
+					"DescribeAGByName":         {"one_undesired_host_one_terminating", "one_undesired_host_one_terminating"},
+					"HasActiveInstanceRefresh": {"true", "false"},
+				},
+			}
+			monitors := newTestAutoscalingMonitors(awsConn)
+			monitors.Refresh()
+			So(len(*awsConn.Records["HasActiveInstanceRefresh"]), ShouldEqual, 1)
+			So(len(monitors.GetAllMonitors()[0].GetInstancesTargetedByRefresh()), ShouldEqual, 1)
+		})
+	})
+	Convey("When an autoscaling group is not terminating any instance", t, func() {
+		awsConn := &aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById":     {"default", "default", "default"},
+				"DescribeAGByName":         {"default"},
+				"HasActiveInstanceRefresh": {"true"},
+			},
+		}
+		newTestMonitor(awsConn)
+
+		Convey("it's instance refreshes should not be checked", func() {
+			So(len(*awsConn.Records["HasActiveInstanceRefresh"]), ShouldEqual, 1)
+		})
+	})
+}
+func TestConcurrentAccess(t *testing.T) {
+
+	Convey("When autoscaling groups are refreshed while being read from other goroutines", t, func() {
+		refreshes := 20
+		describeAGByNameRecords := []string{}
+		for i := 0; i <= refreshes; i++ {
+			describeAGByNameRecords = append(describeAGByNameRecords, "default")
+		}
+		monitors := newTestAutoscalingMonitors(&aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {"default", "default", "default"},
+				"DescribeAGByName":     &describeAGByNameRecords,
+			},
+		})
+
+		var wg sync.WaitGroup
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < refreshes; i++ {
+				monitors.Refresh()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < refreshes; i++ {
+				for _, monitor := range monitors.GetAllMonitors() {
+					monitor.NumUndesiredInstances()
+					for _, instance := range monitor.GetInstances() {
+						instance.GetLifecycleState()
+						instance.IsProtected()
+					}
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < refreshes; i++ {
+				instance, err := monitors.GetInstanceByID("i-34719eb8")
+				if err == nil {
+					instance.MarkToBeRemoved()
+				}
+			}
+		}()
+		wg.Wait()
+
+		Convey("all refreshes should have been done", func() {
+			So(len(describeAGByNameRecords), ShouldEqual, 0)
+		})
+		Convey("the instances should remain consistent", func() {
+			So(len(monitors.GetAllMonitors()), ShouldEqual, 1)
+			So(len(monitors.GetAllMonitors()[0].GetInstances()), ShouldEqual, 2)
+		})
+	})
+}
 
 func newTestMonitor(awsConn *aws.ConnectionMock) *AutoscalingGroupMonitor {
 
@@ -0,0 +1,177 @@
+package monitor
+
+// The drain of an instance marked to be removed, as a state machine. Every step the Notebook takes moves
+// the drain to the next state, so it's clear where each instance is stuck and since when
+
+import (
+	"fmt"
+	"time"
+)
+
+// DrainState is the step of the drain an instance is in
+type DrainState string
+
+// States of a drain, in order. Cancelled and Terminated are final, while Failed drains keep retrying the
+// step they failed at
+const (
+	// DrainMarked instances have the deathnode mark, but can't be removed by the autoscaling group yet
+	DrainMarked DrainState = "Marked"
+	// DrainUnprotected instances have no instance protection, so they are the first ones removed on scale in
+	DrainUnprotected DrainState = "Unprotected"
+	// DrainInMaintenance instances are in the Mesos maintenance schedule, waiting for their protected tasks
+	// to finish and for AWS to start the termination lifecycle
+	DrainInMaintenance DrainState = "InMaintenance"
+	// DrainDraining instances are being deregistered from their load balancers
+	DrainDraining DrainState = "Draining"
+	// DrainDrained instances are ready to be terminated
+	DrainDrained DrainState = "Drained"
+	// DrainLifecycleCompleted instances have their termination lifecycle action completed
+	DrainLifecycleCompleted DrainState = "LifecycleCompleted"
+	// DrainTerminated instances are not running anymore
+	DrainTerminated DrainState = "Terminated"
+	// DrainFailed instances failed the same step MaxDrainAttempts times in a row. The step is still retried
+	DrainFailed DrainState = "Failed"
+	// DrainCancelled instances had their deathnode mark removed before being terminated
+	DrainCancelled DrainState = "Cancelled"
+)
+
+// MaxDrainAttempts is the number of failed attempts in a row after which a drain is moved to Failed
+const MaxDrainAttempts = 5
+
+// DrainRetryBackoff is the time to wait before retrying a failed step, doubled on every failed attempt
+// up to MaxDrainRetryBackoff
+var DrainRetryBackoff = 30 * time.Second
+
+// MaxDrainRetryBackoff is the maximum time to wait before retrying a failed step
+const MaxDrainRetryBackoff = 15 * time.Minute
+
+// drainTransitions: map[from][]to
+// An instance can be terminated at any time, as AWS completes the lifecycle action by itself once the
+// lifecycle hook times out. Failed drains move on to the state following the step they failed at, once
+// it succeeds
+var drainTransitions = map[DrainState][]DrainState{
+	DrainMarked:             {DrainUnprotected, DrainFailed, DrainCancelled, DrainTerminated},
+	DrainUnprotected:        {DrainInMaintenance, DrainFailed, DrainCancelled, DrainTerminated},
+	DrainInMaintenance:      {DrainDraining, DrainFailed, DrainCancelled, DrainTerminated},
+	DrainDraining:           {DrainDrained, DrainFailed, DrainCancelled, DrainTerminated},
+	DrainDrained:            {DrainLifecycleCompleted, DrainFailed, DrainCancelled, DrainTerminated},
+	DrainLifecycleCompleted: {DrainTerminated},
+	DrainFailed:             {DrainUnprotected, DrainInMaintenance, DrainDraining, DrainDrained, DrainLifecycleCompleted, DrainCancelled, DrainTerminated},
+}
+
+// Drain is the state of the drain of an instance. Attempts are the failed attempts in a row of the
+// current step, which is not retried before RetryAt. FailedStep is the step Failed drains failed at.
+// Heartbeats are the lifecycle action heartbeats sent to AWS while the instance is drained
+// transitions: map[DrainState]time the state was entered
+type Drain struct {
+	State         DrainState
+	Transitions   map[DrainState]time.Time
+	Attempts      int
+	LastError     string
+	FailedStep    DrainState
+	RetryAt       time.Time
+	Heartbeats    int
+	LastHeartbeat time.Time
+}
+
+// NewDrain returns a drain in the Marked state. The mark time is unknown if it's zero
+func NewDrain(markTime time.Time) Drain {
+
+	drain := Drain{
+		State:       DrainMarked,
+		Transitions: map[DrainState]time.Time{},
+	}
+	if !markTime.IsZero() {
+		drain.Transitions[DrainMarked] = markTime
+	}
+	return drain
+}
+
+// CanTransition returns true if a drain in the from state can be moved to the to state
+func CanTransition(from, to DrainState) bool {
+
+	for _, state := range drainTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinished returns true if the drain can't be moved to other states, as it's cancelled or terminated
+func (d *Drain) IsFinished() bool {
+	return len(drainTransitions[d.State]) == 0
+}
+
+// Step returns the step of the drain to run next. It's the state of the drain, or the step Failed drains
+// failed at
+func (d *Drain) Step() DrainState {
+
+	if d.State == DrainFailed {
+		return d.FailedStep
+	}
+	return d.State
+}
+
+// Transition moves the drain to a new state, resetting the failed attempts of the previous one unless
+// it's moved to Failed. Failed drains can only be moved to the states following the step they failed at
+func (d *Drain) Transition(to DrainState) error {
+
+	if !CanTransition(d.State, to) || (d.State == DrainFailed && !CanTransition(d.FailedStep, to)) {
+		return fmt.Errorf("drain can't be moved from %s to %s", d.State, to)
+	}
+
+	if to == DrainFailed {
+		d.FailedStep = d.State
+	} else {
+		d.FailedStep = ""
+		d.Attempts = 0
+		d.LastError = ""
+		d.RetryAt = time.Time{}
+	}
+	d.State = to
+	d.Transitions[to] = time.Now()
+	return nil
+}
+
+// RecordFailure counts a failed attempt of the current step, delaying the next one. Returns true if the
+// step reached MaxDrainAttempts, moving the drain to the Failed state
+func (d *Drain) RecordFailure(err error) bool {
+
+	d.Attempts++
+	d.LastError = err.Error()
+	d.RetryAt = time.Now().Add(retryBackoff(d.Attempts))
+	if d.State == DrainFailed || d.Attempts < MaxDrainAttempts {
+		return false
+	}
+	return d.Transition(DrainFailed) == nil
+}
+
+// IsWaitingRetry returns true if the current step failed, and it's not time to retry it yet
+func (d *Drain) IsWaitingRetry() bool {
+	return time.Now().Before(d.RetryAt)
+}
+
+// retryBackoff returns the time to wait after the given failed attempts in a row
+func retryBackoff(attempts int) time.Duration {
+
+	backoff := DrainRetryBackoff
+	for attempt := 1; attempt < attempts && backoff < MaxDrainRetryBackoff; attempt++ {
+		backoff *= 2
+	}
+	if backoff > MaxDrainRetryBackoff {
+		return MaxDrainRetryBackoff
+	}
+	return backoff
+}
+
+// copy returns a Drain that doesn't share the transitions map
+func (d *Drain) copy() Drain {
+
+	drain := *d
+	drain.Transitions = map[DrainState]time.Time{}
+	for state, transitionTime := range d.Transitions {
+		drain.Transitions[state] = transitionTime
+	}
+	return drain
+}
@@ -0,0 +1,84 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDrain(t *testing.T) {
+
+	Convey("When creating a new drain", t, func() {
+		markTime := time.Unix(12345678, 0)
+		drain := NewDrain(markTime)
+
+		Convey("it should be in Marked state since the mark time", func() {
+			So(drain.State, ShouldEqual, DrainMarked)
+			So(drain.Transitions[DrainMarked], ShouldResemble, markTime)
+			So(drain.IsFinished(), ShouldBeFalse)
+		})
+		Convey("it should be moved through all the steps until terminated", func() {
+			for _, state := range []DrainState{DrainUnprotected, DrainInMaintenance, DrainDraining, DrainDrained,
+				DrainLifecycleCompleted, DrainTerminated} {
+				So(drain.Transition(state), ShouldBeNil)
+				So(drain.State, ShouldEqual, state)
+				So(drain.Transitions, ShouldContainKey, state)
+			}
+			So(drain.IsFinished(), ShouldBeTrue)
+		})
+		Convey("it should not skip steps", func() {
+			So(drain.Transition(DrainDrained), ShouldNotBeNil)
+			So(drain.State, ShouldEqual, DrainMarked)
+		})
+		Convey("it should not be cancelled once the lifecycle action is completed", func() {
+			So(CanTransition(DrainDrained, DrainCancelled), ShouldBeTrue)
+			So(CanTransition(DrainLifecycleCompleted, DrainCancelled), ShouldBeFalse)
+		})
+		Convey("and a step fails", func() {
+			failed := drain.RecordFailure(errors.New("some error"))
+			Convey("it should be retried after the backoff", func() {
+				So(failed, ShouldBeFalse)
+				So(drain.Attempts, ShouldEqual, 1)
+				So(drain.LastError, ShouldEqual, "some error")
+				So(drain.IsWaitingRetry(), ShouldBeTrue)
+				So(drain.RetryAt, ShouldHappenWithin, time.Second, time.Now().Add(DrainRetryBackoff))
+			})
+			Convey("the failed attempts should be reset once the step succeeds", func() {
+				drain.Transition(DrainUnprotected)
+				So(drain.Attempts, ShouldEqual, 0)
+				So(drain.LastError, ShouldBeEmpty)
+				So(drain.IsWaitingRetry(), ShouldBeFalse)
+			})
+			Convey("it should fail after MaxDrainAttempts, and keep retrying the step it failed at", func() {
+				for attempt := 2; attempt < MaxDrainAttempts; attempt++ {
+					So(drain.RecordFailure(errors.New("some error")), ShouldBeFalse)
+				}
+				So(drain.RecordFailure(errors.New("some error")), ShouldBeTrue)
+				So(drain.RecordFailure(errors.New("some error")), ShouldBeFalse)
+				So(drain.State, ShouldEqual, DrainFailed)
+				So(drain.Step(), ShouldEqual, DrainMarked)
+				So(drain.Attempts, ShouldEqual, MaxDrainAttempts+1)
+				So(drain.IsFinished(), ShouldBeFalse)
+				So(drain.Transition(DrainInMaintenance), ShouldNotBeNil)
+				So(drain.Transition(DrainUnprotected), ShouldBeNil)
+				So(drain.Step(), ShouldEqual, DrainUnprotected)
+				So(drain.Attempts, ShouldEqual, 0)
+			})
+			Convey("it should be cancelled once failed", func() {
+				for attempt := 2; attempt <= MaxDrainAttempts; attempt++ {
+					drain.RecordFailure(errors.New("some error"))
+				}
+				So(CanTransition(DrainFailed, DrainCancelled), ShouldBeTrue)
+				So(drain.Transition(DrainCancelled), ShouldBeNil)
+				So(drain.IsFinished(), ShouldBeTrue)
+			})
+		})
+		Convey("the retry backoff should double up to the maximum", func() {
+			So(retryBackoff(1), ShouldEqual, DrainRetryBackoff)
+			So(retryBackoff(3), ShouldEqual, 4*DrainRetryBackoff)
+			So(retryBackoff(100), ShouldEqual, MaxDrainRetryBackoff)
+		})
+	})
+}
@@ -0,0 +1,185 @@
+package monitor
+
+// Monitors an ECS cluster. Container instances in maintenance are moved to DRAINING, so ECS replaces the
+// tasks of their services in other container instances. Deathnode waits for the tasks of the protected
+// services to be gone
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/alanbover/deathnode/ecs"
+	log "github.com/sirupsen/logrus"
+)
+
+// ECSMonitor monitors the ECS cluster, creating a cache to reduce the number of calls against it. The
+// cache is never modified: every refresh swaps it with a new one, so it's safe for concurrent use.
+// Protected tasks are the ones of a protected service
+type ECSMonitor struct {
+	ecsConn        ecs.ClientInterface
+	cacheMutex     sync.RWMutex
+	ecsCache       *ecsCache
+	protectedMutex sync.RWMutex
+	protected      []string
+}
+
+// ecsCache stores the objects of the ECS api in a way that is directly accesible
+// containerInstances: map[privateIPAddress]ContainerInstance
+// tasks: map[containerInstanceARN][]Task
+type ecsCache struct {
+	containerInstances map[string]ecs.ContainerInstance
+	tasks              map[string][]ecs.Task
+	protected          []string
+}
+
+// NewECSMonitor returns a new ECSMonitor object
+func NewECSMonitor(ecsConn ecs.ClientInterface, protected []string) *ECSMonitor {
+
+	return &ECSMonitor{
+		ecsConn: ecsConn,
+		ecsCache: &ecsCache{
+			containerInstances: map[string]ecs.ContainerInstance{},
+			tasks:              map[string][]ecs.Task{},
+		},
+		protected: protected,
+	}
+}
+
+// Refresh updates the ECS cache. If any of the ECS calls fails, the previous cache is kept
+func (m *ECSMonitor) Refresh() error {
+
+	m.protectedMutex.RLock()
+	protected := m.protected
+	m.protectedMutex.RUnlock()
+
+	containerInstances, err := m.ecsConn.GetContainerInstances()
+	if err != nil {
+		return err
+	}
+	tasks, err := m.ecsConn.GetTasks(protected)
+	if err != nil {
+		return err
+	}
+
+	cache := &ecsCache{
+		containerInstances: map[string]ecs.ContainerInstance{},
+		tasks:              map[string][]ecs.Task{},
+		protected:          protected,
+	}
+	for _, containerInstance := range containerInstances {
+		if containerInstance.PrivateIPAddress != "" {
+			cache.containerInstances[containerInstance.PrivateIPAddress] = containerInstance
+		}
+	}
+	for _, task := range tasks {
+		if task.IsActive() && task.ContainerInstanceARN != "" {
+			cache.tasks[task.ContainerInstanceARN] = append(cache.tasks[task.ContainerInstanceARN], task)
+		}
+	}
+
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	m.ecsCache = cache
+	return nil
+}
+
+func (m *ECSMonitor) getCache() *ecsCache {
+
+	m.cacheMutex.RLock()
+	defer m.cacheMutex.RUnlock()
+	return m.ecsCache
+}
+
+// SetProtected replaces the services protected by the ECSMonitor. They are used after the next refresh
+func (m *ECSMonitor) SetProtected(protected []string) {
+
+	m.protectedMutex.Lock()
+	defer m.protectedMutex.Unlock()
+	m.protected = protected
+}
+
+// SetAgentsInMaintenance moves the active container instances with the given IP addresses to DRAINING.
+// The ones drained by deathnode that are no longer in maintenance are made ACTIVE again. Container
+// instances drained by someone else are left as they are
+func (m *ECSMonitor) SetAgentsInMaintenance(hosts map[string]string) error {
+
+	cache := m.getCache()
+	inMaintenance := map[string]bool{}
+	toDrain := []string{}
+	for _, ipAddress := range hosts {
+		containerInstance, ok := cache.containerInstances[ipAddress]
+		if !ok {
+			continue
+		}
+		inMaintenance[containerInstance.ARN] = true
+		if containerInstance.Status == ecs.StatusActive {
+			toDrain = append(toDrain, containerInstance.ARN)
+		}
+	}
+
+	toActivate := []string{}
+	for _, containerInstance := range cache.containerInstances {
+		if containerInstance.IsDrainedByDeathnode() && !inMaintenance[containerInstance.ARN] {
+			toActivate = append(toActivate, containerInstance.ARN)
+		}
+	}
+
+	if len(toDrain) > 0 {
+		if err := m.ecsConn.DrainContainerInstances(toDrain); err != nil {
+			log.Errorf("Unable to drain container instances %v: %v", toDrain, err)
+			return err
+		}
+	}
+	if len(toActivate) > 0 {
+		if err := m.ecsConn.ActivateContainerInstances(toActivate); err != nil {
+			log.Errorf("Unable to activate container instances %v: %v", toActivate, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAgent returns the container instance with the given IP address, and false if it's not found. The
+// EC2 instance ID is used as it's hostname
+func (m *ECSMonitor) GetAgent(ipAddress string) (Agent, bool) {
+
+	containerInstance, ok := m.getCache().containerInstances[ipAddress]
+	return Agent{ID: containerInstance.ARN, Hostname: containerInstance.EC2InstanceID}, ok
+}
+
+// GetTasks returns all the tasks running in the container instance with the given IP address
+func (m *ECSMonitor) GetTasks(ipAddress string) []Task {
+
+	cache := m.getCache()
+	tasks := []Task{}
+	for _, task := range cache.tasks[cache.containerInstances[ipAddress].ARN] {
+		tasks = append(tasks, toECSTask(task, task.Service != ""))
+	}
+	return tasks
+}
+
+// GetBlockingTasks returns the tasks running in the container instance from any of the given services,
+// or from any of the protected services if nil. Only the services protected by the ECSMonitor are taken
+// into account
+func (m *ECSMonitor) GetBlockingTasks(ipAddress string, services []string) []Task {
+
+	cache := m.getCache()
+	tasks := []Task{}
+	for _, task := range cache.tasks[cache.containerInstances[ipAddress].ARN] {
+		if task.Service != "" && (services == nil || containsString(services, task.Service)) {
+			tasks = append(tasks, toECSTask(task, true))
+		}
+	}
+	return tasks
+}
+
+// toECSTask returns the task named after it's task definition, owned by it's service if it's protected
+func toECSTask(task ecs.Task, protected bool) Task {
+
+	return Task{
+		Name:      task.TaskDefinitionARN[strings.LastIndex(task.TaskDefinitionARN, "/")+1:],
+		OwnerID:   task.Service,
+		OwnerName: task.Service,
+		Protected: protected,
+	}
+}
@@ -0,0 +1,83 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alanbover/deathnode/ecs"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestECSMonitor(t *testing.T) {
+
+	Convey("When creating a new ECS monitor", t, func() {
+		ecsConn := &ecs.ClientMock{
+			ContainerInstances: []ecs.ContainerInstance{
+				{ARN: "arn1", EC2InstanceID: "i-1", PrivateIPAddress: "10.0.0.2", Status: ecs.StatusActive, RunningTasksCount: 3},
+				{ARN: "arn2", EC2InstanceID: "i-2", PrivateIPAddress: "10.0.0.3", Status: ecs.StatusDraining},
+			},
+			Tasks: []ecs.Task{
+				{ARN: "task1", ContainerInstanceARN: "arn1", TaskDefinitionARN: "arn:aws:ecs:eu-west-1:1:task-definition/api:3", LastStatus: "RUNNING", Service: "api"},
+				{ARN: "task2", ContainerInstanceARN: "arn1", TaskDefinitionARN: "arn:aws:ecs:eu-west-1:1:task-definition/worker:1", LastStatus: "RUNNING", Service: "worker"},
+				{ARN: "task3", ContainerInstanceARN: "arn1", TaskDefinitionARN: "arn:aws:ecs:eu-west-1:1:task-definition/cron:7", LastStatus: "PENDING"},
+				{ARN: "task4", ContainerInstanceARN: "arn1", TaskDefinitionARN: "arn:aws:ecs:eu-west-1:1:task-definition/api:2", LastStatus: "STOPPED", Service: "api"},
+			},
+		}
+		monitor := NewECSMonitor(ecsConn, []string{"api"})
+		So(monitor.Refresh(), ShouldBeNil)
+
+		Convey("GetAgent returns the container instance with the IP address, if it's found", func() {
+			agent, ok := monitor.GetAgent("10.0.0.2")
+			So(ok, ShouldBeTrue)
+			So(agent.ID, ShouldEqual, "arn1")
+			So(agent.Hostname, ShouldEqual, "i-1")
+			_, ok = monitor.GetAgent("10.0.0.99")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("GetTasks returns the tasks that didn't stop", func() {
+			tasks := monitor.GetTasks("10.0.0.2")
+			So(len(tasks), ShouldEqual, 3)
+			So(tasks[0].Name, ShouldEqual, "api:3")
+			So(tasks[0].Protected, ShouldBeTrue)
+			So(tasks[1].Protected, ShouldBeFalse)
+		})
+		Convey("GetBlockingTasks returns the tasks of the protected services", func() {
+			So(monitor.GetBlockingTasks("10.0.0.2", nil), ShouldHaveLength, 1)
+			So(monitor.GetBlockingTasks("10.0.0.2", []string{"worker"}), ShouldBeEmpty)
+			So(monitor.GetBlockingTasks("10.0.0.3", nil), ShouldBeEmpty)
+		})
+		Convey("setting a container instance in maintenance moves it to DRAINING", func() {
+			So(monitor.SetAgentsInMaintenance(map[string]string{"i-1": "10.0.0.2", "i-2": "10.0.0.3"}), ShouldBeNil)
+			So(ecsConn.Requests["DrainContainerInstances"], ShouldResemble, []string{"arn1"})
+
+			Convey("the protected tasks desired to be stopped block it until they stop", func() {
+				monitor.Refresh()
+				So(ecsConn.Tasks[0].DesiredStatus, ShouldEqual, "STOPPED")
+				So(monitor.GetBlockingTasks("10.0.0.2", nil), ShouldHaveLength, 1)
+				ecsConn.StopTasks()
+				monitor.Refresh()
+				So(monitor.GetBlockingTasks("10.0.0.2", nil), ShouldBeEmpty)
+			})
+
+			Convey("and ACTIVE again once it's no longer in maintenance, leaving the ones drained by someone else", func() {
+				monitor.Refresh()
+				So(monitor.SetAgentsInMaintenance(map[string]string{}), ShouldBeNil)
+				So(ecsConn.Requests["ActivateContainerInstances"], ShouldResemble, []string{"arn1"})
+				monitor.Refresh()
+				So(monitor.SetAgentsInMaintenance(map[string]string{}), ShouldBeNil)
+				So(ecsConn.Requests["ActivateContainerInstances"], ShouldHaveLength, 1)
+			})
+			Convey("and ACTIVE again by a new monitor, as after a restart", func() {
+				restarted := NewECSMonitor(ecsConn, []string{"api"})
+				So(restarted.Refresh(), ShouldBeNil)
+				So(restarted.SetAgentsInMaintenance(map[string]string{}), ShouldBeNil)
+				So(ecsConn.Requests["ActivateContainerInstances"], ShouldResemble, []string{"arn1"})
+				So(ecsConn.ContainerInstances[0].Status, ShouldEqual, ecs.StatusActive)
+			})
+		})
+		Convey("errors changing the state are returned", func() {
+			ecsConn.Errors = map[string]error{"DrainContainerInstances": errors.New("ecs unavailable")}
+			So(monitor.SetAgentsInMaintenance(map[string]string{"i-1": "10.0.0.2"}), ShouldNotBeNil)
+		})
+	})
+}
@@ -4,9 +4,14 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/alanbover/deathnode/aws"
+	"strconv"
+	"sync"
 	"time"
 )
 
+// PinMark is the tag of the instances that must never be removed by deathnode
+const PinMark = "DEATH_NODE_PIN"
+
 type instance struct {
 	autoscalingGroupID  string
 	launchConfiguration string
@@ -15,13 +20,19 @@ type instance struct {
 	lifecycleState      string
 	isProtected         bool
 	isMarkedToBeRemoved bool
+	markTime            time.Time
+	isPinned            bool
+	isDeregistered      bool
+	drain               *Drain
 }
 
-// InstanceMonitor monitors an AWS instance
+// InstanceMonitor monitors an AWS instance. It's safe for concurrent use
 type InstanceMonitor struct {
+	mutex         sync.RWMutex
 	instance      *instance
 	awsConnection aws.ClientInterface
 	deathNodeMark string
+	loadBalancers *loadBalancers
 }
 
 func newInstanceMonitor(conn aws.ClientInterface, autoscalingGroupID, instanceID, deathNodeMark, lifecycleState string, isProtected bool) (*InstanceMonitor, error) {
@@ -32,15 +43,23 @@ func newInstanceMonitor(conn aws.ClientInterface, autoscalingGroupID, instanceID
 		return &InstanceMonitor{}, err
 	}
 
+	awsInstance := &instance{
+		autoscalingGroupID:  autoscalingGroupID,
+		ipAddress:           *response.PrivateIpAddress,
+		instanceID:          instanceID,
+		isMarkedToBeRemoved: hasTag(response.Tags, deathNodeMark),
+		markTime:            getMarkTime(response.Tags, deathNodeMark),
+		isPinned:            hasTag(response.Tags, PinMark),
+		lifecycleState:      lifecycleState,
+		isProtected:         isProtected,
+	}
+	if awsInstance.isMarkedToBeRemoved {
+		drain := NewDrain(awsInstance.markTime)
+		awsInstance.drain = &drain
+	}
+
 	return &InstanceMonitor{
-		instance: &instance{
-			autoscalingGroupID:   autoscalingGroupID,
-			ipAddress:            *response.PrivateIpAddress,
-			instanceID:           instanceID,
-			isMarkedToBeRemoved:  isMarkedToBeRemoved(response.Tags, deathNodeMark),
-			lifecycleState:       lifecycleState,
-			isProtected:	      isProtected,
-		},
+		instance:      awsInstance,
 		awsConnection: conn,
 		deathNodeMark: deathNodeMark,
 	}, nil
@@ -53,6 +72,8 @@ func (a *InstanceMonitor) GetIP() string {
 
 // GetLifecycleState returns the lifeCycleState of the instance in the ASG
 func (a *InstanceMonitor) GetLifecycleState() string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
 	return a.instance.lifecycleState
 }
 
@@ -68,6 +89,8 @@ func (a *InstanceMonitor) GetAutoscalingGroupID() *string {
 
 // RemoveInstanceProtection removes the instance protection for the autoscaling
 func (a* InstanceMonitor) RemoveInstanceProtection() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
 	err := a.awsConnection.RemoveASGInstanceProtection(&a.instance.autoscalingGroupID, []*string{&a.instance.instanceID})
 	if err != nil {
 		return err
@@ -76,8 +99,68 @@ func (a* InstanceMonitor) RemoveInstanceProtection() error {
 	return nil
 }
 
+// CompleteLifecycleAction completes the termination lifecycle action for the instance, using the same
+// AWS connection the instance is monitored with
+func (a *InstanceMonitor) CompleteLifecycleAction() error {
+	return a.awsConnection.CompleteLifecycleAction(&a.instance.autoscalingGroupID, &a.instance.instanceID)
+}
+
+// RecordLifecycleActionHeartbeat extends the timeout of the termination lifecycle action for the instance,
+// counting the heartbeat in it's drain
+func (a *InstanceMonitor) RecordLifecycleActionHeartbeat() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	err := a.awsConnection.RecordLifecycleActionHeartbeat(&a.instance.autoscalingGroupID, &a.instance.instanceID)
+	if err != nil {
+		return err
+	}
+	if a.instance.drain != nil {
+		a.instance.drain.Heartbeats++
+		a.instance.drain.LastHeartbeat = time.Now()
+	}
+	return nil
+}
+
+// DeregisterFromLoadBalancers removes the instance from all load balancers and target groups attached
+// to it's autoscaling group
+func (a *InstanceMonitor) DeregisterFromLoadBalancers() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.loadBalancers == nil {
+		a.instance.isDeregistered = true
+		return nil
+	}
+	loadBalancerNames, targetGroupARNs := a.loadBalancers.get()
+	err := a.awsConnection.DeregisterInstanceFromLoadBalancers(loadBalancerNames, targetGroupARNs, &a.instance.instanceID)
+	if err != nil {
+		return err
+	}
+	a.instance.isDeregistered = true
+	return nil
+}
+
+// IsDeregisteredFromLoadBalancers returns true if the instance has already been deregistered from it's
+// load balancers and target groups
+func (a *InstanceMonitor) IsDeregisteredFromLoadBalancers() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.instance.isDeregistered
+}
+
+// IsDrainedFromLoadBalancers returns true if connection draining has finished in all the load balancers
+// and target groups of the instance
+func (a *InstanceMonitor) IsDrainedFromLoadBalancers() (bool, error) {
+	if a.loadBalancers == nil {
+		return true, nil
+	}
+	loadBalancerNames, targetGroupARNs := a.loadBalancers.get()
+	return a.awsConnection.IsInstanceDrainedFromLoadBalancers(loadBalancerNames, targetGroupARNs, &a.instance.instanceID)
+}
+
 // IsProtected returns true if the instance has the flag instanceProtection in the ASG
 func (a *InstanceMonitor) IsProtected() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
 	return a.instance.isProtected
 }
 
@@ -85,22 +168,177 @@ func (a *InstanceMonitor) IsProtected() bool {
 // Key: valueOf(DEATH_NODE_TAG_MARK)
 // Value: Current timestamp (epoch)
 func (a *InstanceMonitor) MarkToBeRemoved() error {
-	err := a.awsConnection.SetInstanceTag(a.deathNodeMark, getEpochAsString(), a.instance.instanceID)
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	now := time.Now()
+	err := a.awsConnection.SetInstanceTag(a.deathNodeMark, strconv.FormatInt(now.Unix(), 10), a.instance.instanceID)
 	a.instance.isMarkedToBeRemoved = true
+	a.instance.markTime = time.Unix(now.Unix(), 0)
+	drain := NewDrain(a.instance.markTime)
+	a.instance.drain = &drain
 	return err
 }
 
+// RemoveMark protects the instance from scale in again and removes it's deathnode mark, cancelling it's
+// drain. The mark is removed last, so an instance is never left unmarked without protection: if any
+// call fails, the instance keeps the mark and the drain, and RemoveMark can be retried
+func (a *InstanceMonitor) RemoveMark() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.instance.drain != nil && !CanTransition(a.instance.drain.State, DrainCancelled) {
+		return fmt.Errorf("drain of instance %s can't be cancelled in %s state", a.instance.instanceID, a.instance.drain.State)
+	}
+	err := a.awsConnection.SetASGInstanceProtection(&a.instance.autoscalingGroupID, []*string{&a.instance.instanceID})
+	if err != nil {
+		return err
+	}
+	a.instance.isProtected = true
+	err = a.awsConnection.RemoveInstanceTag(a.deathNodeMark, a.instance.instanceID)
+	if err != nil {
+		return err
+	}
+	a.instance.isMarkedToBeRemoved = false
+	a.instance.markTime = time.Time{}
+	if a.instance.drain != nil {
+		a.instance.drain.Transition(DrainCancelled)
+	}
+	return nil
+}
+
+// Pin sets the PinMark tag for the instance, so it's never chosen to be removed
+func (a *InstanceMonitor) Pin() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	err := a.awsConnection.SetInstanceTag(PinMark, strconv.FormatInt(time.Now().Unix(), 10), a.instance.instanceID)
+	if err != nil {
+		return err
+	}
+	a.instance.isPinned = true
+	return nil
+}
+
+// Unpin removes the PinMark tag from the instance
+func (a *InstanceMonitor) Unpin() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	err := a.awsConnection.RemoveInstanceTag(PinMark, a.instance.instanceID)
+	if err != nil {
+		return err
+	}
+	a.instance.isPinned = false
+	return nil
+}
+
+// IsPinned returns true if the instance has the PinMark tag
+func (a *InstanceMonitor) IsPinned() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.instance.isPinned
+}
+
+// GetMarkTime returns the time the instance was marked to be removed, or zero if it's not marked or
+// the deathnode mark value is not a timestamp
+func (a *InstanceMonitor) GetMarkTime() time.Time {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.instance.markTime
+}
+
+// IsMarkedToBeRemoved returns true if the instance has the deathnode mark
+func (a *InstanceMonitor) IsMarkedToBeRemoved() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.instance.isMarkedToBeRemoved
+}
+
+// GetDrain returns a copy of the drain of the instance. Returns false if the instance has no drain, as
+// it was never marked to be removed
+func (a *InstanceMonitor) GetDrain() (Drain, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.instance.drain == nil {
+		return Drain{}, false
+	}
+	return a.instance.drain.copy(), true
+}
+
+// GetDrainState returns the state of the drain of the instance, or an empty state if it has no drain
+func (a *InstanceMonitor) GetDrainState() DrainState {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.instance.drain == nil {
+		return ""
+	}
+	return a.instance.drain.State
+}
+
+// GetDrainStep returns the step of the drain of the instance to run next, or an empty state if it has no
+// drain
+func (a *InstanceMonitor) GetDrainStep() DrainState {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.instance.drain == nil {
+		return ""
+	}
+	return a.instance.drain.Step()
+}
+
+// TrackDrain replaces the drain of the instance, marking it to be removed. It's used for the instances
+// found with the deathnode mark, as it may have been set by other replica or tool
+func (a *InstanceMonitor) TrackDrain(drain Drain) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	drain = drain.copy()
+	a.instance.drain = &drain
+	a.instance.isMarkedToBeRemoved = true
+	if a.instance.markTime.IsZero() {
+		a.instance.markTime = drain.Transitions[DrainMarked]
+	}
+}
+
+// TransitionDrain moves the drain of the instance to a new state
+func (a *InstanceMonitor) TransitionDrain(to DrainState) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.instance.drain == nil {
+		return fmt.Errorf("instance %s has no drain", a.instance.instanceID)
+	}
+	return a.instance.drain.Transition(to)
+}
+
+// RecordDrainFailure counts a failed attempt of the current step of the drain. Returns true if the
+// step reached MaxDrainAttempts, moving the drain to the Failed state
+func (a *InstanceMonitor) RecordDrainFailure(err error) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.instance.drain == nil {
+		return false
+	}
+	return a.instance.drain.RecordFailure(err)
+}
+
 func (a *InstanceMonitor) setLifecycleState(lifecycleState string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
 	a.instance.lifecycleState = lifecycleState
 }
 
-func getEpochAsString() string {
-	return fmt.Sprintf("%v", time.Now().Unix())
+func getMarkTime(tags []*ec2.Tag, deathNodeMark string) time.Time {
+	for _, tag := range tags {
+		if deathNodeMark == *tag.Key {
+			markTimestamp, err := strconv.ParseInt(*tag.Value, 10, 64)
+			if err != nil {
+				return time.Time{}
+			}
+			return time.Unix(markTimestamp, 0)
+		}
+	}
+	return time.Time{}
 }
 
-func isMarkedToBeRemoved(tags []*ec2.Tag, deathNodeMark string) bool {
+func hasTag(tags []*ec2.Tag, key string) bool {
 	for _, tag := range tags {
-		if deathNodeMark == *tag.Key {
+		if key == *tag.Key {
 			return true
 		}
 	}
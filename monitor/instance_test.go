@@ -1,6 +1,7 @@
 package monitor
 
 import (
+	"errors"
 	"testing"
 	"github.com/alanbover/deathnode/aws"
 	. "github.com/smartystreets/goconvey/convey"
@@ -24,9 +25,13 @@ func TestNewInstanceMonitor(t *testing.T) {
 		})
 		Convey("it shouldn't be marked to be removed", func() {
 			So(monitor.instance.isMarkedToBeRemoved, ShouldBeFalse)
+			So(monitor.GetDrainState(), ShouldBeEmpty)
 		})
 		Convey("and MarkToBeRemoved is called", func() {
 			monitor.MarkToBeRemoved()
+			Convey("a drain should be started", func() {
+				So(monitor.GetDrainState(), ShouldEqual, DrainMarked)
+			})
 			Convey("SetInstanceTag should be called with correct parameters", func() {
 				callArguments := conn.Requests["SetInstanceTag"]
 				So(callArguments[0][0], ShouldEqual, "DEATH_NODE_MARK")
@@ -54,6 +59,89 @@ func TestInstanceMarkToBeRemoved(t *testing.T) {
 		Convey("and isMarkToBeRemoved is called", func() {
 			So(monitor.instance.isMarkedToBeRemoved, ShouldBeTrue)
 		})
+		Convey("the mark time should be the deathnode mark value", func() {
+			So(monitor.GetMarkTime().Unix(), ShouldEqual, 12345678)
+		})
+		Convey("it's drain should be in Marked state", func() {
+			So(monitor.GetDrainState(), ShouldEqual, DrainMarked)
+		})
+		Convey("and RemoveMark is called once the lifecycle action is completed, it should fail", func() {
+			for _, state := range []DrainState{DrainUnprotected, DrainInMaintenance, DrainDraining, DrainDrained, DrainLifecycleCompleted} {
+				monitor.TransitionDrain(state)
+			}
+			So(monitor.RemoveMark(), ShouldNotBeNil)
+			So(monitor.IsMarkedToBeRemoved(), ShouldBeTrue)
+			So(conn.Requests["RemoveInstanceTag"], ShouldBeNil)
+		})
+		Convey("and RemoveMark is called", func() {
+			monitor.RemoveMark()
+			Convey("instance should not be marked and should be protected", func() {
+				So(monitor.IsMarkedToBeRemoved(), ShouldBeFalse)
+				So(monitor.GetMarkTime().IsZero(), ShouldBeTrue)
+				So(monitor.IsProtected(), ShouldBeTrue)
+			})
+			Convey("it's drain should be cancelled", func() {
+				So(monitor.GetDrainState(), ShouldEqual, DrainCancelled)
+			})
+			Convey("the deathnode mark tag should have been removed", func() {
+				So(conn.Requests["RemoveInstanceTag"], ShouldResemble, [][]string{{"DEATH_NODE_MARK", "i-249b35ae"}})
+			})
+		})
+	})
+}
+
+// unprotectableConnectionMock fails to protect the instances from scale in
+type unprotectableConnectionMock struct {
+	*aws.ConnectionMock
+}
+
+func (c *unprotectableConnectionMock) SetASGInstanceProtection(autoscalingGroupName *string, instanceIDs []*string) error {
+	return errors.New("aws unavailable")
+}
+
+func TestInstanceRemoveMarkFailure(t *testing.T) {
+
+	Convey("When the protection of an instance marked to be removed can't be restored", t, func() {
+		conn := &unprotectableConnectionMock{&aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {"node_with_tag"},
+			},
+		}}
+		monitor, _ := newInstanceMonitor(conn, "autoscalingid", "i-249b35ae", "DEATH_NODE_MARK", "InService", false)
+		monitor.TransitionDrain(DrainUnprotected)
+		err := monitor.RemoveMark()
+
+		Convey("RemoveMark should fail, keeping the mark and the drain", func() {
+			So(err, ShouldNotBeNil)
+			So(monitor.IsMarkedToBeRemoved(), ShouldBeTrue)
+			So(monitor.GetDrainState(), ShouldEqual, DrainUnprotected)
+			So(conn.Requests["RemoveInstanceTag"], ShouldBeNil)
+		})
+	})
+}
+
+func TestInstancePin(t *testing.T) {
+
+	Convey("When creating a new instanceMonitor", t, func() {
+		conn := &aws.ConnectionMock{
+			Records: map[string]*[]string{
+				"DescribeInstanceById": {"default"},
+			},
+		}
+		monitor, _ := newInstanceMonitor(conn, "autoscalingid", "i-249b35ae", "DEATH_NODE_MARK", "InService", true)
+		Convey("it should not be pinned", func() {
+			So(monitor.IsPinned(), ShouldBeFalse)
+		})
+		Convey("and Pin is called, it should be pinned", func() {
+			monitor.Pin()
+			So(monitor.IsPinned(), ShouldBeTrue)
+			So(conn.Requests["SetInstanceTag"][0][0], ShouldEqual, PinMark)
+			Convey("and Unpin is called, it should not be pinned", func() {
+				monitor.Unpin()
+				So(monitor.IsPinned(), ShouldBeFalse)
+				So(conn.Requests["RemoveInstanceTag"][0][0], ShouldEqual, PinMark)
+			})
+		})
 	})
 }
 
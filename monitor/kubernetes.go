@@ -0,0 +1,239 @@
+package monitor
+
+// Monitors a kubernetes cluster. Nodes in maintenance are cordoned and their pods evicted, respecting
+// their PodDisruptionBudgets. The protected pods are not evicted: deathnode waits for them to finish
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/alanbover/deathnode/kubernetes"
+	log "github.com/sirupsen/logrus"
+)
+
+// KubernetesMonitor monitors the kubernetes cluster, creating a cache to reduce the number of calls
+// against it. The cache is never modified: every refresh swaps it with a new one, so it's safe for
+// concurrent use. Protected pods are the ones in a protected namespace, or with a protected label, given
+// as key=value
+type KubernetesMonitor struct {
+	kubernetesConn  kubernetes.ClientInterface
+	cacheMutex      sync.RWMutex
+	kubernetesCache *kubernetesCache
+	protectedMutex  sync.RWMutex
+	protected       []string
+}
+
+// kubernetesCache stores the objects of the kubernetes api in a way that is directly accesible
+// nodes: map[internalIPAddress]Node
+// pods: map[nodeName][]Pod
+type kubernetesCache struct {
+	nodes     map[string]kubernetes.Node
+	pods      map[string][]kubernetes.Pod
+	protected []string
+}
+
+// NewKubernetesMonitor returns a new KubernetesMonitor object
+func NewKubernetesMonitor(kubernetesConn kubernetes.ClientInterface, protected []string) *KubernetesMonitor {
+
+	return &KubernetesMonitor{
+		kubernetesConn: kubernetesConn,
+		kubernetesCache: &kubernetesCache{
+			nodes: map[string]kubernetes.Node{},
+			pods:  map[string][]kubernetes.Pod{},
+		},
+		protected: protected,
+	}
+}
+
+// Refresh updates the kubernetes cache. If any of the kubernetes calls fails, the previous cache is kept
+func (m *KubernetesMonitor) Refresh() error {
+
+	nodes, err := m.kubernetesConn.GetNodes()
+	if err != nil {
+		return err
+	}
+	pods, err := m.kubernetesConn.GetPods()
+	if err != nil {
+		return err
+	}
+
+	m.protectedMutex.RLock()
+	protected := m.protected
+	m.protectedMutex.RUnlock()
+
+	cache := &kubernetesCache{
+		nodes:     map[string]kubernetes.Node{},
+		pods:      map[string][]kubernetes.Pod{},
+		protected: protected,
+	}
+	for _, node := range nodes {
+		if ipAddress := node.InternalIP(); ipAddress != "" {
+			cache.nodes[ipAddress] = node
+		}
+	}
+	for _, pod := range pods {
+		if pod.IsActive() && pod.Spec.NodeName != "" {
+			cache.pods[pod.Spec.NodeName] = append(cache.pods[pod.Spec.NodeName], pod)
+		}
+	}
+
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	m.kubernetesCache = cache
+	return nil
+}
+
+func (m *KubernetesMonitor) getCache() *kubernetesCache {
+
+	m.cacheMutex.RLock()
+	defer m.cacheMutex.RUnlock()
+	return m.kubernetesCache
+}
+
+// SetProtected replaces the namespaces and labels protected by the KubernetesMonitor. They are used
+// after the next refresh
+func (m *KubernetesMonitor) SetProtected(protected []string) {
+
+	m.protectedMutex.Lock()
+	defer m.protectedMutex.Unlock()
+	m.protected = protected
+}
+
+// SetAgentsInMaintenance cordons the nodes with the given IP addresses and evicts their pods, except the
+// protected ones and the ones that would be scheduled again in the node. Evictions are retried on every
+// call until the pods are gone, so the ones blocked by a PodDisruptionBudget are evicted once it allows
+// it. Nodes cordoned by deathnode that are no longer in maintenance are uncordoned. Nodes already
+// cordoned by someone else are left cordoned
+func (m *KubernetesMonitor) SetAgentsInMaintenance(hosts map[string]string) error {
+
+	cache := m.getCache()
+	inMaintenance := map[string]bool{}
+	var firstErr error
+
+	for _, ipAddress := range hosts {
+		node, ok := cache.nodes[ipAddress]
+		if !ok {
+			continue
+		}
+		inMaintenance[node.Metadata.Name] = true
+		if !node.Spec.Unschedulable {
+			if err := m.kubernetesConn.CordonNode(node.Metadata.Name); err != nil {
+				log.Errorf("Unable to cordon node %s: %v", node.Metadata.Name, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+		}
+		m.evictPods(cache, node.Metadata.Name)
+	}
+
+	for _, node := range cache.nodes {
+		if node.IsCordonedByDeathnode() && !inMaintenance[node.Metadata.Name] {
+			if err := m.kubernetesConn.UncordonNode(node.Metadata.Name); err != nil {
+				log.Errorf("Unable to uncordon node %s: %v", node.Metadata.Name, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// evictPods evicts the evictable pods of the node that are not protected. Failed evictions are retried
+// on the next call
+func (m *KubernetesMonitor) evictPods(cache *kubernetesCache, nodeName string) {
+
+	for _, pod := range cache.pods[nodeName] {
+		if !pod.IsEvictable() || isProtectedPod(pod, cache.protected) {
+			continue
+		}
+		err := m.kubernetesConn.EvictPod(pod.Metadata.Namespace, pod.Metadata.Name)
+		if err == kubernetes.ErrEvictionBlocked {
+			log.Debugf("Eviction of pod %s/%s blocked by it's PodDisruptionBudget", pod.Metadata.Namespace, pod.Metadata.Name)
+		} else if err != nil {
+			log.Warnf("Unable to evict pod %s/%s: %v", pod.Metadata.Namespace, pod.Metadata.Name, err)
+		}
+	}
+}
+
+// GetAgent returns the kubernetes node with the given IP address, and false if it's not found. The node
+// name is used as it's ID
+func (m *KubernetesMonitor) GetAgent(ipAddress string) (Agent, bool) {
+
+	node, ok := m.getCache().nodes[ipAddress]
+	return Agent{ID: node.Metadata.Name, Hostname: node.Metadata.Name}, ok
+}
+
+// GetTasks returns all the pods running in the kubernetes node with the given IP address
+func (m *KubernetesMonitor) GetTasks(ipAddress string) []Task {
+
+	cache := m.getCache()
+	tasks := []Task{}
+	for _, pod := range cache.pods[cache.nodes[ipAddress].Metadata.Name] {
+		tasks = append(tasks, toPodTask(pod, isProtectedPod(pod, cache.protected)))
+	}
+	return tasks
+}
+
+// GetBlockingTasks returns the pods in the kubernetes node with the given IP address protected by any of
+// the given namespaces and labels, or by any of the protected ones if nil, and the pods still waiting to
+// be evicted. Only the namespaces and labels protected by the KubernetesMonitor are taken into account
+func (m *KubernetesMonitor) GetBlockingTasks(ipAddress string, protected []string) []Task {
+
+	cache := m.getCache()
+	if protected == nil {
+		protected = cache.protected
+	} else {
+		protected = intersectStrings(protected, cache.protected)
+	}
+
+	tasks := []Task{}
+	for _, pod := range cache.pods[cache.nodes[ipAddress].Metadata.Name] {
+		if isProtectedPod(pod, protected) {
+			tasks = append(tasks, toPodTask(pod, true))
+		} else if pod.IsEvictable() && !isProtectedPod(pod, cache.protected) {
+			tasks = append(tasks, toPodTask(pod, false))
+		}
+	}
+	return tasks
+}
+
+// isProtectedPod returns true if the pod is in any of the protected namespaces, or has any of the
+// protected labels
+func isProtectedPod(pod kubernetes.Pod, protected []string) bool {
+
+	for _, value := range protected {
+		if parts := strings.SplitN(value, "=", 2); len(parts) == 2 {
+			if labelValue, ok := pod.Metadata.Labels[parts[0]]; ok && labelValue == parts[1] {
+				return true
+			}
+		} else if pod.Metadata.Namespace == value {
+			return true
+		}
+	}
+	return false
+}
+
+func toPodTask(pod kubernetes.Pod, protected bool) Task {
+
+	return Task{
+		Name:      pod.Metadata.Name,
+		OwnerID:   pod.Metadata.Namespace,
+		OwnerName: pod.Metadata.Namespace,
+		Protected: protected,
+	}
+}
+
+func intersectStrings(values, others []string) []string {
+
+	intersection := []string{}
+	for _, value := range values {
+		if containsString(others, value) {
+			intersection = append(intersection, value)
+		}
+	}
+	return intersection
+}
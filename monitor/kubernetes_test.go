@@ -0,0 +1,86 @@
+package monitor
+
+import (
+	"testing"
+
+	"github.com/alanbover/deathnode/kubernetes"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKubernetesMonitor(t *testing.T) {
+
+	Convey("When creating a new kubernetes monitor", t, func() {
+		daemon := kubernetes.NewPod("kube-system", "daemon-1", "node1", nil)
+		daemon.Metadata.OwnerReferences = []kubernetes.OwnerReference{{Kind: "DaemonSet", Name: "daemon"}}
+		fake := kubernetes.NewFakeAPIServer(
+			[]kubernetes.Node{
+				kubernetes.NewNode("node1", "10.0.0.2"),
+				kubernetes.NewNode("node2", "10.0.0.3"),
+			},
+			[]kubernetes.Pod{
+				kubernetes.NewPod("batch", "job-1", "node1", nil),
+				kubernetes.NewPod("default", "web-1", "node1", map[string]string{"app": "web"}),
+				kubernetes.NewPod("default", "db-1", "node1", map[string]string{"deathnode/protected": "true"}),
+				kubernetes.NewPod("default", "web-2", "node2", map[string]string{"app": "web"}),
+				daemon,
+			})
+		defer fake.Close()
+		client, _ := kubernetes.NewClient(fake.URL, "", "")
+		monitor := NewKubernetesMonitor(client, []string{"batch", "deathnode/protected=true"})
+		So(monitor.Refresh(), ShouldBeNil)
+
+		Convey("GetAgent returns the node with the IP address, if it's found", func() {
+			agent, ok := monitor.GetAgent("10.0.0.2")
+			So(ok, ShouldBeTrue)
+			So(agent.Hostname, ShouldEqual, "node1")
+			_, ok = monitor.GetAgent("10.0.0.99")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("GetTasks returns all the pods of the node", func() {
+			So(len(monitor.GetTasks("10.0.0.2")), ShouldEqual, 4)
+		})
+		Convey("GetBlockingTasks returns the protected pods, and the ones waiting to be evicted", func() {
+			So(len(monitor.GetBlockingTasks("10.0.0.2", nil)), ShouldEqual, 3)
+			tasks := monitor.GetBlockingTasks("10.0.0.2", []string{"batch"})
+			So(len(tasks), ShouldEqual, 2)
+			So(tasks[0].Name, ShouldEqual, "job-1")
+			So(tasks[0].Protected, ShouldBeTrue)
+			So(tasks[1].Name, ShouldEqual, "web-1")
+			So(tasks[1].Protected, ShouldBeFalse)
+		})
+		Convey("setting a node in maintenance cordons it, and evicts the pods not protected", func() {
+			So(monitor.SetAgentsInMaintenance(map[string]string{"node1": "10.0.0.2"}), ShouldBeNil)
+			node, _ := fake.Node("node1")
+			So(node.Spec.Unschedulable, ShouldBeTrue)
+			So(len(fake.Pods()), ShouldEqual, 4)
+			So(monitor.Refresh(), ShouldBeNil)
+			tasks := monitor.GetBlockingTasks("10.0.0.2", nil)
+			So(len(tasks), ShouldEqual, 2)
+			So(tasks[0].Protected && tasks[1].Protected, ShouldBeTrue)
+
+			Convey("and uncordons it once it's no longer in maintenance", func() {
+				So(monitor.SetAgentsInMaintenance(map[string]string{}), ShouldBeNil)
+				node, _ := fake.Node("node1")
+				So(node.Spec.Unschedulable, ShouldBeFalse)
+			})
+		})
+		Convey("pods whose eviction is blocked by a PodDisruptionBudget keep blocking the node", func() {
+			fake.BlockEviction("default", "web-1")
+			So(monitor.SetAgentsInMaintenance(map[string]string{"node1": "10.0.0.2"}), ShouldBeNil)
+			So(monitor.Refresh(), ShouldBeNil)
+			So(len(monitor.GetBlockingTasks("10.0.0.2", nil)), ShouldEqual, 3)
+		})
+		Convey("nodes cordoned by someone else are not uncordoned", func() {
+			other := kubernetes.NewNode("node3", "10.0.0.4")
+			other.Spec.Unschedulable = true
+			otherFake := kubernetes.NewFakeAPIServer([]kubernetes.Node{other}, nil)
+			defer otherFake.Close()
+			client, _ := kubernetes.NewClient(otherFake.URL, "", "")
+			monitor := NewKubernetesMonitor(client, []string{"batch"})
+			monitor.Refresh()
+			So(monitor.SetAgentsInMaintenance(map[string]string{}), ShouldBeNil)
+			So(monitor.SetAgentsInMaintenance(map[string]string{"node3": "10.0.0.4"}), ShouldBeNil)
+			So(otherFake.Requests(), ShouldNotContain, "PATCH /api/v1/nodes/node3")
+		})
+	})
+}
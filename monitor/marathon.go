@@ -0,0 +1,143 @@
+package monitor
+
+// Drains the marathon apps of the mesos agents in maintenance. Marathon ignores the maintenance schedule,
+// so the tasks of it's apps are killed, a few at a time, to make marathon reschedule them elsewhere
+
+import (
+	"sync"
+
+	"github.com/alanbover/deathnode/marathon"
+	log "github.com/sirupsen/logrus"
+)
+
+// MarathonDrainer decorates the monitor of the mesos cluster. Once the agents are set in maintenance,
+// it kills the marathon tasks running on them. An app is not killed more than maxConcurrentKills tasks
+// until it's replacements are healthy, nor while it's being deployed
+type MarathonDrainer struct {
+	Scheduler
+	marathonConn       marathon.ClientInterface
+	killMode           string
+	maxConcurrentKills int
+	appsMutex          sync.RWMutex
+	apps               []marathon.App
+	killMutex          sync.Mutex
+	killed             map[string]string
+}
+
+// NewMarathonDrainer returns a new MarathonDrainer object, killing the tasks with the given kill mode
+func NewMarathonDrainer(scheduler Scheduler, marathonConn marathon.ClientInterface, killMode string, maxConcurrentKills int) *MarathonDrainer {
+
+	return &MarathonDrainer{
+		Scheduler:          scheduler,
+		marathonConn:       marathonConn,
+		killMode:           killMode,
+		maxConcurrentKills: maxConcurrentKills,
+		apps:               []marathon.App{},
+		killed:             map[string]string{},
+	}
+}
+
+// Refresh updates the decorated scheduler and the marathon apps. If marathon can't be reached, the
+// previous apps are kept, so the mesos drains don't depend on it
+func (d *MarathonDrainer) Refresh() error {
+
+	if err := d.Scheduler.Refresh(); err != nil {
+		return err
+	}
+
+	apps, err := d.marathonConn.GetApps()
+	if err != nil {
+		log.Warnf("Unable to refresh the marathon apps: %v", err)
+		return nil
+	}
+
+	tasks := map[string]bool{}
+	for _, app := range apps {
+		for _, task := range app.Tasks {
+			tasks[task.ID] = true
+		}
+	}
+
+	d.killMutex.Lock()
+	for taskID := range d.killed {
+		if !tasks[taskID] {
+			delete(d.killed, taskID)
+		}
+	}
+	d.killMutex.Unlock()
+
+	d.appsMutex.Lock()
+	defer d.appsMutex.Unlock()
+	d.apps = apps
+	return nil
+}
+
+func (d *MarathonDrainer) getApps() []marathon.App {
+
+	d.appsMutex.RLock()
+	defer d.appsMutex.RUnlock()
+	return d.apps
+}
+
+// SetAgentsInMaintenance sets the agents in maintenance through the decorated scheduler, and kills the
+// marathon tasks running on them. Kills failing are logged and retried on the next call
+func (d *MarathonDrainer) SetAgentsInMaintenance(hosts map[string]string) error {
+
+	if err := d.Scheduler.SetAgentsInMaintenance(hosts); err != nil {
+		return err
+	}
+
+	agents := map[string]bool{}
+	for _, ipAddress := range hosts {
+		if agent, ok := d.GetAgent(ipAddress); ok {
+			agents[agent.ID] = true
+		}
+	}
+
+	d.killMutex.Lock()
+	defer d.killMutex.Unlock()
+	for _, app := range d.getApps() {
+		d.killAppTasks(app, agents)
+	}
+	return nil
+}
+
+// killAppTasks kills the tasks of the app running on the agents, as long as the tasks being replaced
+// don't exceed the maximum concurrent kills
+func (d *MarathonDrainer) killAppTasks(app marathon.App, agents map[string]bool) {
+
+	if app.IsDeploying() {
+		log.Debugf("Marathon app %s is being deployed. Waiting to kill it's tasks", app.ID)
+		return
+	}
+
+	inFlight := app.Instances - app.Healthy()
+	if inFlight < 0 {
+		inFlight = 0
+	}
+	for _, appID := range d.killed {
+		if appID == app.ID {
+			inFlight++
+		}
+	}
+
+	for _, task := range app.Tasks {
+		if !agents[task.SlaveID] {
+			continue
+		}
+		if _, ok := d.killed[task.ID]; ok {
+			continue
+		}
+		if inFlight >= d.maxConcurrentKills {
+			log.Debugf("Marathon app %s has %d tasks being replaced. Waiting to kill task %s", app.ID, inFlight, task.ID)
+			return
+		}
+		if err := d.marathonConn.KillTask(app.ID, task.ID, d.killMode); err != nil {
+			log.Errorf("Unable to kill marathon task %s of app %s: %v", task.ID, app.ID, err)
+			return
+		}
+		log.Infof("Killed marathon task %s of app %s from agent %s", task.ID, app.ID, task.SlaveID)
+		d.killed[task.ID] = app.ID
+		inFlight++
+	}
+}
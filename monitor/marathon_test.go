@@ -0,0 +1,84 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alanbover/deathnode/marathon"
+	"github.com/alanbover/deathnode/mesos"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMarathonDrainer(t *testing.T) {
+
+	Convey("When creating a new marathon drainer", t, func() {
+		marathonConn := &marathon.ClientMock{
+			Apps: []marathon.App{
+				{ID: "/web", Instances: 3, TasksRunning: 3, TasksHealthy: 3, HealthChecks: []marathon.HealthCheck{{Protocol: "HTTP"}},
+					Tasks: []marathon.Task{
+						{ID: "web.1", AppID: "/web", SlaveID: "mesosslave1"},
+						{ID: "web.2", AppID: "/web", SlaveID: "mesosslave1"},
+						{ID: "web.3", AppID: "/web", SlaveID: "mesosslave2"},
+					}},
+				{ID: "/worker", Instances: 1, TasksRunning: 1,
+					Tasks: []marathon.Task{{ID: "worker.1", AppID: "/worker", SlaveID: "mesosslave1"}}},
+				{ID: "/deploying", Instances: 1, TasksRunning: 1, Deployments: []marathon.Deployment{{ID: "1"}},
+					Tasks: []marathon.Task{{ID: "deploying.1", AppID: "/deploying", SlaveID: "mesosslave1"}}},
+			},
+		}
+		drainer := NewMarathonDrainer(createTestMarathonMesosMonitor(), marathonConn, marathon.KillModeWipe, 1)
+		So(drainer.Refresh(), ShouldBeNil)
+		hosts := map[string]string{"mesosslave1hostname": "10.0.0.2"}
+
+		Convey("setting agents in maintenance kills one task per app running on them", func() {
+			So(drainer.SetAgentsInMaintenance(hosts), ShouldBeNil)
+			So(marathonConn.Requests["/web"], ShouldResemble, []string{"web.1"})
+			So(marathonConn.Requests["/worker"], ShouldResemble, []string{"worker.1"})
+
+			Convey("not killing more until the replacements are healthy", func() {
+				So(drainer.SetAgentsInMaintenance(hosts), ShouldBeNil)
+				drainer.Refresh()
+				So(drainer.SetAgentsInMaintenance(hosts), ShouldBeNil)
+				So(marathonConn.Requests["/web"], ShouldHaveLength, 1)
+
+				marathonConn.ReplaceTasks("mesosslave3")
+				drainer.Refresh()
+				So(drainer.SetAgentsInMaintenance(hosts), ShouldBeNil)
+				So(marathonConn.Requests["/web"], ShouldResemble, []string{"web.1", "web.2"})
+			})
+		})
+		Convey("apps being deployed are not killed", func() {
+			So(drainer.SetAgentsInMaintenance(hosts), ShouldBeNil)
+			So(marathonConn.Requests, ShouldNotContainKey, "/deploying")
+		})
+		Convey("the maximum concurrent kills is per app", func() {
+			drainer = NewMarathonDrainer(createTestMarathonMesosMonitor(), marathonConn, marathon.KillModeWipe, 2)
+			drainer.Refresh()
+			So(drainer.SetAgentsInMaintenance(hosts), ShouldBeNil)
+			So(marathonConn.Requests["/web"], ShouldResemble, []string{"web.1", "web.2"})
+		})
+		Convey("if marathon can't be reached, the mesos drains go on", func() {
+			marathonConn.Errors = map[string]error{"GetApps": errors.New("marathon unavailable"), "KillTask": errors.New("marathon unavailable")}
+			So(drainer.Refresh(), ShouldBeNil)
+			So(drainer.SetAgentsInMaintenance(hosts), ShouldBeNil)
+			So(marathonConn.Requests, ShouldBeEmpty)
+		})
+	})
+}
+
+// createTestMarathonMesosMonitor returns a mesos monitor able to refresh as many times as the drainer
+// tests need
+func createTestMarathonMesosMonitor() *MesosMonitor {
+
+	records := func() *[]string {
+		return &[]string{"default", "default", "default", "default"}
+	}
+	mesosConn := &mesos.ClientMock{
+		Records: map[string]*[]string{
+			"GetMesosFrameworks": records(),
+			"GetMesosSlaves":     records(),
+			"GetMesosTasks":      records(),
+		},
+	}
+	return NewMesosMonitor(mesosConn, []string{"frameworkName1"})
+}
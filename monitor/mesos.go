@@ -5,13 +5,17 @@ package monitor
 
 import (
 	"strings"
+	"sync"
 	"github.com/alanbover/deathnode/mesos"
 )
 
-// MesosMonitor monitors the mesos cluster, creating a cache to reduce the number of calls against it
+// MesosMonitor monitors the mesos cluster, creating a cache to reduce the number of calls against it.
+// The cache is never modified: every refresh swaps it with a new one, so it's safe for concurrent use
 type MesosMonitor struct {
 	mesosConn           mesos.ClientInterface
+	cacheMutex          sync.RWMutex
 	mesosCache          *mesosCache
+	frameworksMutex     sync.RWMutex
 	protectedFrameworks []string
 }
 
@@ -39,37 +43,81 @@ func NewMesosMonitor(mesosConn mesos.ClientInterface, protectedFrameworks []stri
 	}
 }
 
-// Refresh updates the mesos cache
-func (m *MesosMonitor) Refresh() {
+// Refresh updates the mesos cache. If any of the mesos calls fails, the previous cache is kept
+func (m *MesosMonitor) Refresh() error {
 
-	m.mesosCache.tasks = m.getTasks()
-	m.mesosCache.frameworks = m.getProtectedFrameworks()
-	m.mesosCache.slaves = m.getSlaves()
+	tasks, err := m.getTasks()
+	if err != nil {
+		return err
+	}
+	frameworks, err := m.getProtectedFrameworks()
+	if err != nil {
+		return err
+	}
+	slaves, err := m.getSlaves()
+	if err != nil {
+		return err
+	}
+
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	m.mesosCache = &mesosCache{
+		tasks:      tasks,
+		frameworks: frameworks,
+		slaves:     slaves,
+	}
+	return nil
 }
 
-func (m *MesosMonitor) getProtectedFrameworks() map[string]mesos.Framework {
+func (m *MesosMonitor) getCache() *mesosCache {
+
+	m.cacheMutex.RLock()
+	defer m.cacheMutex.RUnlock()
+	return m.mesosCache
+}
+
+// SetProtected replaces the names of the frameworks protected by the MesosMonitor. They are used after
+// the next refresh
+func (m *MesosMonitor) SetProtected(protectedFrameworks []string) {
+
+	m.frameworksMutex.Lock()
+	defer m.frameworksMutex.Unlock()
+	m.protectedFrameworks = protectedFrameworks
+}
+
+func (m *MesosMonitor) getProtectedFrameworks() (map[string]mesos.Framework, error) {
+
+	m.frameworksMutex.RLock()
+	protectedFrameworks := m.protectedFrameworks
+	m.frameworksMutex.RUnlock()
 
 	frameworksMap := map[string]mesos.Framework{}
-	frameworksResponse, _ := m.mesosConn.GetMesosFrameworks()
+	frameworksResponse, err := m.mesosConn.GetMesosFrameworks()
+	if err != nil {
+		return nil, err
+	}
 	for _, framework := range frameworksResponse.Frameworks {
-		for _, protectedFramework := range m.protectedFrameworks {
+		for _, protectedFramework := range protectedFrameworks {
 			if protectedFramework == framework.Name {
 				frameworksMap[framework.ID] = framework
 			}
 		}
 	}
-	return frameworksMap
+	return frameworksMap, nil
 }
 
-func (m *MesosMonitor) getSlaves() map[string]mesos.Slave {
+func (m *MesosMonitor) getSlaves() (map[string]mesos.Slave, error) {
 
 	slavesMap := map[string]mesos.Slave{}
-	slavesResponse, _ := m.mesosConn.GetMesosAgents()
+	slavesResponse, err := m.mesosConn.GetMesosAgents()
+	if err != nil {
+		return nil, err
+	}
 	for _, slave := range slavesResponse.Slaves {
 		ipAddress := m.getAgentIPAddressFromPID(slave.Pid)
 		slavesMap[ipAddress] = slave
 	}
-	return slavesMap
+	return slavesMap, nil
 }
 
 func (m *MesosMonitor) getAgentIPAddressFromPID(pid string) string {
@@ -78,35 +126,86 @@ func (m *MesosMonitor) getAgentIPAddressFromPID(pid string) string {
 	return strings.Split(tmp, ":")[0]
 }
 
-func (m *MesosMonitor) getTasks() map[string][]mesos.Task {
+func (m *MesosMonitor) getTasks() (map[string][]mesos.Task, error) {
 
 	tasksMap := map[string][]mesos.Task{}
-	tasksResponse, _ := m.mesosConn.GetMesosTasks()
+	tasksResponse, err := m.mesosConn.GetMesosTasks()
+	if err != nil {
+		return nil, err
+	}
 	for _, task := range tasksResponse.Tasks {
 		if task.State == "TASK_RUNNING" {
 			tasksMap[task.SlaveID] = append(tasksMap[task.SlaveID], task)
 		}
 	}
-	return tasksMap
+	return tasksMap, nil
 }
 
-// SetMesosAgentsInMaintenance sets a list of mesos agents in Maintenance mode
-func (m *MesosMonitor) SetMesosAgentsInMaintenance(hosts map[string]string) error {
+// SetAgentsInMaintenance sets a list of mesos agents in Maintenance mode
+func (m *MesosMonitor) SetAgentsInMaintenance(hosts map[string]string) error {
 	return m.mesosConn.SetHostsInMaintenance(hosts)
 }
 
+// GetAgent returns the mesos agent with the given IP address, and false if it's not found
+func (m *MesosMonitor) GetAgent(ipAddress string) (Agent, bool) {
+
+	slave, ok := m.getCache().slaves[ipAddress]
+	return Agent{ID: slave.ID, Hostname: slave.Hostname}, ok
+}
+
+// GetTasks returns all the tasks running in the mesos agent with the given IP address
+func (m *MesosMonitor) GetTasks(ipAddress string) []Task {
+
+	cache := m.getCache()
+	tasks := []Task{}
+	for _, task := range cache.tasks[cache.slaves[ipAddress].ID] {
+		tasks = append(tasks, cache.toTask(task))
+	}
+	return tasks
+}
+
 // HasProtectedFrameworksTasks returns true if the mesos agent has any tasks running from any of the
 // protected frameworks.
 func (m *MesosMonitor) HasProtectedFrameworksTasks(ipAddress string) bool {
+	return len(m.GetBlockingTasks(ipAddress, nil)) > 0
+}
+
+// GetBlockingTasks returns the tasks running in the mesos agent from any of the given frameworks, or
+// from any of the protected frameworks if nil. Only the frameworks protected by the MesosMonitor are
+// taken into account
+func (m *MesosMonitor) GetBlockingTasks(ipAddress string, frameworkNames []string) []Task {
 
-	slaveID := m.mesosCache.slaves[ipAddress].ID
-	slaveTasks := m.mesosCache.tasks[slaveID]
+	cache := m.getCache()
+	slaveID := cache.slaves[ipAddress].ID
+	slaveTasks := cache.tasks[slaveID]
+	tasks := []Task{}
 	for _, task := range slaveTasks {
-		_, ok := m.mesosCache.frameworks[task.FrameworkID]
-		if ok {
-			return true
+		framework, ok := cache.frameworks[task.FrameworkID]
+		if ok && (frameworkNames == nil || containsString(frameworkNames, framework.Name)) {
+			tasks = append(tasks, cache.toTask(task))
 		}
 	}
 
+	return tasks
+}
+
+// toTask returns the task with the name of it's framework, if it's protected
+func (c *mesosCache) toTask(task mesos.Task) Task {
+
+	framework, protected := c.frameworks[task.FrameworkID]
+	return Task{
+		Name:      task.Name,
+		OwnerID:   task.FrameworkID,
+		OwnerName: framework.Name,
+		Protected: protected,
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
 	return false
 }
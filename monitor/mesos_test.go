@@ -1,6 +1,8 @@
 package monitor
 
 import (
+	"errors"
+	"sync"
 	"testing"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/alanbover/deathnode/mesos"
@@ -14,13 +16,29 @@ func TestGetMesosFrameworks(t *testing.T) {
 		monitor := createTestMesosMonitor("frameworkName1")
 
 		Convey("getProtectedFrameworks should return only the ones that match the protected frameworks", func() {
-			frameworks := monitor.getProtectedFrameworks()
+			frameworks, _ := monitor.getProtectedFrameworks()
 			So(len(frameworks), ShouldEqual, 1)
 			So(frameworks, ShouldContainKey, "frameworkId1")
 		})
 	})
 }
 
+func TestMesosRefreshError(t *testing.T) {
+
+	Convey("When creating a new mesos monitor", t, func() {
+		monitor := createTestMesosMonitor("frameworkName1")
+		monitor.Refresh()
+
+		Convey("if mesos can't be reached, refresh should fail keeping the previous state", func() {
+			monitor.mesosConn.(*mesos.ClientMock).Errors = map[string]error{
+				"GetMesosTasks": errors.New("mesos unavailable"),
+			}
+			So(monitor.Refresh(), ShouldNotBeNil)
+			So(monitor.HasProtectedFrameworksTasks("10.0.0.2"), ShouldBeTrue)
+		})
+	})
+}
+
 func TestHasProtectedFrameworksTasks(t *testing.T) {
 
 	Convey("When creating a new mesos monitor", t, func() {
@@ -35,6 +53,27 @@ func TestHasProtectedFrameworksTasks(t *testing.T) {
 				So(monitor.HasProtectedFrameworksTasks("10.0.0.4"), ShouldBeFalse)
 			})
 		})
+		Convey("GetTasks returns the tasks from all the frameworks", func() {
+			tasks := monitor.GetTasks("10.0.0.4")
+			So(len(tasks), ShouldEqual, 1)
+			So(tasks[0].Name, ShouldEqual, "task3")
+			So(tasks[0].OwnerID, ShouldEqual, "frameworkId3")
+			So(tasks[0].Protected, ShouldBeFalse)
+		})
+		Convey("GetBlockingTasks returns the tasks from the protected frameworks, with their names", func() {
+			tasks := monitor.GetBlockingTasks("10.0.0.2", nil)
+			So(len(tasks), ShouldBeGreaterThan, 0)
+			So(tasks[0].OwnerName, ShouldEqual, "frameworkName1")
+			So(tasks[0].Protected, ShouldBeTrue)
+			So(monitor.GetBlockingTasks("10.0.0.2", []string{"frameworkName2"}), ShouldBeEmpty)
+		})
+		Convey("GetAgent returns the agent with the IP address, if it's found", func() {
+			agent, ok := monitor.GetAgent("10.0.0.2")
+			So(ok, ShouldBeTrue)
+			So(agent.Hostname, ShouldEqual, "mesosslave1hostname")
+			_, ok = monitor.GetAgent("10.0.0.99")
+			So(ok, ShouldBeFalse)
+		})
 	})
 }
 
@@ -63,6 +102,47 @@ func TestSetMesosAgentsInMaintenance(t *testing.T) {
 	})
 }
 
+func TestConcurrentMesosRefresh(t *testing.T) {
+
+	Convey("When the mesos monitor is refreshed while being read from other goroutines", t, func() {
+		refreshes := 20
+		records := func() *[]string {
+			values := []string{}
+			for i := 0; i < refreshes; i++ {
+				values = append(values, "default")
+			}
+			return &values
+		}
+		monitor := NewMesosMonitor(&mesos.ClientMock{
+			Records: map[string]*[]string{
+				"GetMesosFrameworks": records(),
+				"GetMesosSlaves":     records(),
+				"GetMesosTasks":      records(),
+			},
+		}, []string{"frameworkName1"})
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < refreshes; i++ {
+				monitor.Refresh()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < refreshes; i++ {
+				monitor.HasProtectedFrameworksTasks("10.0.0.2")
+			}
+		}()
+		wg.Wait()
+
+		Convey("HasProtectedFrameworksTasks should return the refreshed values", func() {
+			So(monitor.HasProtectedFrameworksTasks("10.0.0.2"), ShouldBeTrue)
+		})
+	})
+}
+
 func createTestMesosMonitor(protectedFramework string) *MesosMonitor {
 	mesosConn := &mesos.ClientMock{
 		Records: map[string]*[]string{
@@ -0,0 +1,205 @@
+package monitor
+
+// Monitors a nomad cluster. Nodes in maintenance are drained, and the drain status of the node tells when
+// their allocations are gone. Deathnode waits for the protected allocations to finish
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alanbover/deathnode/nomad"
+	log "github.com/sirupsen/logrus"
+)
+
+// NomadMonitor monitors the nomad cluster, creating a cache to reduce the number of calls against it.
+// The cache is never modified: every refresh swaps it with a new one, so it's safe for concurrent use.
+// Protected allocations are the ones of a protected job or namespace
+type NomadMonitor struct {
+	nomadConn      nomad.ClientInterface
+	drainDeadline  time.Duration
+	cacheMutex     sync.RWMutex
+	nomadCache     *nomadCache
+	protectedMutex sync.RWMutex
+	protected      []string
+}
+
+// nomadCache stores the objects of the nomad api in a way that is directly accesible
+// nodes: map[ipAddress]Node
+// allocations: map[nodeID][]Allocation
+type nomadCache struct {
+	nodes       map[string]nomad.Node
+	allocations map[string][]nomad.Allocation
+	protected   []string
+}
+
+// NewNomadMonitor returns a new NomadMonitor object. Allocations not migrated from the drained nodes
+// before drainDeadline, protected ones included, are stopped by nomad. If 0, there is no deadline
+func NewNomadMonitor(nomadConn nomad.ClientInterface, protected []string, drainDeadline time.Duration) *NomadMonitor {
+
+	return &NomadMonitor{
+		nomadConn:     nomadConn,
+		drainDeadline: drainDeadline,
+		nomadCache: &nomadCache{
+			nodes:       map[string]nomad.Node{},
+			allocations: map[string][]nomad.Allocation{},
+		},
+		protected: protected,
+	}
+}
+
+// Refresh updates the nomad cache. If any of the nomad calls fails, the previous cache is kept
+func (m *NomadMonitor) Refresh() error {
+
+	nodes, err := m.nomadConn.GetNodes()
+	if err != nil {
+		return err
+	}
+	allocations, err := m.nomadConn.GetAllocations()
+	if err != nil {
+		return err
+	}
+
+	m.protectedMutex.RLock()
+	protected := m.protected
+	m.protectedMutex.RUnlock()
+
+	cache := &nomadCache{
+		nodes:       map[string]nomad.Node{},
+		allocations: map[string][]nomad.Allocation{},
+		protected:   protected,
+	}
+	for _, node := range nodes {
+		cache.nodes[node.Address] = node
+	}
+	for _, allocation := range allocations {
+		if allocation.IsActive() {
+			cache.allocations[allocation.NodeID] = append(cache.allocations[allocation.NodeID], allocation)
+		}
+	}
+
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	m.nomadCache = cache
+	return nil
+}
+
+func (m *NomadMonitor) getCache() *nomadCache {
+
+	m.cacheMutex.RLock()
+	defer m.cacheMutex.RUnlock()
+	return m.nomadCache
+}
+
+// SetProtected replaces the jobs and namespaces protected by the NomadMonitor. They are used after the
+// next refresh
+func (m *NomadMonitor) SetProtected(protected []string) {
+
+	m.protectedMutex.Lock()
+	defer m.protectedMutex.Unlock()
+	m.protected = protected
+}
+
+// SetAgentsInMaintenance drains the nodes with the given IP addresses. The drains started by deathnode
+// on nodes no longer in maintenance are cancelled, making them eligible again. Nodes already drained or
+// ineligible by someone else are left as they are
+func (m *NomadMonitor) SetAgentsInMaintenance(hosts map[string]string) error {
+
+	cache := m.getCache()
+	inMaintenance := map[string]bool{}
+	var firstErr error
+
+	for _, ipAddress := range hosts {
+		node, ok := cache.nodes[ipAddress]
+		if !ok {
+			continue
+		}
+		inMaintenance[node.ID] = true
+		if node.IsDrainedByDeathnode() {
+			continue
+		}
+		if node.Drain || node.SchedulingEligibility == "ineligible" {
+			log.Debugf("Nomad node %s is already drained or ineligible. Leaving it as it is", node.Name)
+			continue
+		}
+		if err := m.nomadConn.DrainNode(node.ID, m.drainDeadline); err != nil {
+			log.Errorf("Unable to drain nomad node %s: %v", node.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	for _, node := range cache.nodes {
+		if node.IsDrainedByDeathnode() && !inMaintenance[node.ID] && node.Status != "down" {
+			if err := m.nomadConn.CancelDrain(node.ID); err != nil {
+				log.Errorf("Unable to cancel the drain of nomad node %s: %v", node.Name, err)
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// GetAgent returns the nomad node with the given IP address, and false if it's not found
+func (m *NomadMonitor) GetAgent(ipAddress string) (Agent, bool) {
+
+	node, ok := m.getCache().nodes[ipAddress]
+	return Agent{ID: node.ID, Hostname: node.Name}, ok
+}
+
+// GetTasks returns all the allocations running in the nomad node with the given IP address
+func (m *NomadMonitor) GetTasks(ipAddress string) []Task {
+
+	cache := m.getCache()
+	tasks := []Task{}
+	for _, allocation := range cache.allocations[cache.nodes[ipAddress].ID] {
+		tasks = append(tasks, toAllocationTask(allocation, isProtectedAllocation(allocation, cache.protected)))
+	}
+	return tasks
+}
+
+// GetBlockingTasks returns the allocations in the nomad node with the given IP address protected by any
+// of the given jobs and namespaces, or by any of the protected ones if nil. Until the drain of the node
+// is complete, the allocations being migrated block it too. Only the jobs and namespaces protected by the
+// NomadMonitor are taken into account
+func (m *NomadMonitor) GetBlockingTasks(ipAddress string, protected []string) []Task {
+
+	cache := m.getCache()
+	node, ok := cache.nodes[ipAddress]
+	if !ok {
+		return []Task{}
+	}
+	if protected == nil {
+		protected = cache.protected
+	} else {
+		protected = intersectStrings(protected, cache.protected)
+	}
+
+	tasks := []Task{}
+	for _, allocation := range cache.allocations[node.ID] {
+		if isProtectedAllocation(allocation, protected) {
+			tasks = append(tasks, toAllocationTask(allocation, true))
+		} else if !node.IsDrainComplete() && allocation.JobType != "system" && !isProtectedAllocation(allocation, cache.protected) {
+			tasks = append(tasks, toAllocationTask(allocation, false))
+		}
+	}
+	return tasks
+}
+
+// isProtectedAllocation returns true if the allocation belongs to any of the protected jobs or namespaces
+func isProtectedAllocation(allocation nomad.Allocation, protected []string) bool {
+	return containsString(protected, allocation.JobID) || containsString(protected, allocation.Namespace)
+}
+
+func toAllocationTask(allocation nomad.Allocation, protected bool) Task {
+
+	return Task{
+		Name:      allocation.Name,
+		OwnerID:   allocation.JobID,
+		OwnerName: allocation.Namespace + "/" + allocation.JobID,
+		Protected: protected,
+	}
+}
@@ -0,0 +1,77 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alanbover/deathnode/nomad"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNomadMonitor(t *testing.T) {
+
+	Convey("When creating a new nomad monitor", t, func() {
+		nomadConn := &nomad.ClientMock{
+			Nodes: []nomad.Node{
+				{ID: "node1", Name: "nomadclient1", Address: "10.0.0.2", Status: "ready", SchedulingEligibility: "eligible"},
+				{ID: "node2", Name: "nomadclient2", Address: "10.0.0.3", Status: "ready", SchedulingEligibility: "ineligible"},
+			},
+			Allocations: []nomad.Allocation{
+				{Name: "batch.report[0]", Namespace: "default", NodeID: "node1", JobID: "report", JobType: "batch", ClientStatus: "running"},
+				{Name: "web.web[0]", Namespace: "default", NodeID: "node1", JobID: "web", JobType: "service", ClientStatus: "running"},
+				{Name: "logs.logs[0]", Namespace: "default", NodeID: "node1", JobID: "logs", JobType: "system", ClientStatus: "running"},
+				{Name: "etl.etl[0]", Namespace: "data", NodeID: "node1", JobID: "etl", JobType: "batch", ClientStatus: "complete"},
+			},
+		}
+		monitor := NewNomadMonitor(nomadConn, []string{"report", "data"}, time.Hour)
+		So(monitor.Refresh(), ShouldBeNil)
+
+		Convey("GetAgent returns the node with the IP address, if it's found", func() {
+			agent, ok := monitor.GetAgent("10.0.0.2")
+			So(ok, ShouldBeTrue)
+			So(agent.ID, ShouldEqual, "node1")
+			So(agent.Hostname, ShouldEqual, "nomadclient1")
+			_, ok = monitor.GetAgent("10.0.0.99")
+			So(ok, ShouldBeFalse)
+		})
+		Convey("GetTasks returns the allocations still running", func() {
+			tasks := monitor.GetTasks("10.0.0.2")
+			So(len(tasks), ShouldEqual, 3)
+			So(tasks[0].Protected, ShouldBeTrue)
+			So(tasks[0].OwnerName, ShouldEqual, "default/report")
+		})
+		Convey("until the node is drained, the allocations being migrated block it", func() {
+			tasks := monitor.GetBlockingTasks("10.0.0.2", nil)
+			So(len(tasks), ShouldEqual, 2)
+			So(tasks[0].Name, ShouldEqual, "batch.report[0]")
+			So(tasks[1].Name, ShouldEqual, "web.web[0]")
+			So(monitor.GetBlockingTasks("10.0.0.2", []string{"data"}), ShouldHaveLength, 1)
+		})
+		Convey("setting a node in maintenance drains it", func() {
+			So(monitor.SetAgentsInMaintenance(map[string]string{"nomadclient1": "10.0.0.2", "nomadclient2": "10.0.0.3"}), ShouldBeNil)
+			So(nomadConn.Requests["DrainNode"], ShouldResemble, []string{"node1"})
+
+			Convey("only once", func() {
+				monitor.Refresh()
+				So(monitor.SetAgentsInMaintenance(map[string]string{"nomadclient1": "10.0.0.2"}), ShouldBeNil)
+				So(nomadConn.Requests["DrainNode"], ShouldHaveLength, 1)
+			})
+			Convey("and once the drain is complete, it no longer blocks the node", func() {
+				nomadConn.CompleteDrains()
+				monitor.Refresh()
+				So(monitor.GetBlockingTasks("10.0.0.2", nil), ShouldBeEmpty)
+			})
+			Convey("and the drain is cancelled once it's no longer in maintenance", func() {
+				monitor.Refresh()
+				So(monitor.SetAgentsInMaintenance(map[string]string{}), ShouldBeNil)
+				So(nomadConn.Requests["CancelDrain"], ShouldResemble, []string{"node1"})
+				So(nomadConn.Nodes[0].SchedulingEligibility, ShouldEqual, "eligible")
+			})
+		})
+		Convey("drain errors are returned", func() {
+			nomadConn.Errors = map[string]error{"DrainNode": errors.New("nomad unavailable")}
+			So(monitor.SetAgentsInMaintenance(map[string]string{"nomadclient1": "10.0.0.2"}), ShouldNotBeNil)
+		})
+	})
+}
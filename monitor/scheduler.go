@@ -0,0 +1,38 @@
+package monitor
+
+// The scheduler running the workloads on the instances of the autoscaling groups. Deathnode sets the
+// instances marked to be removed in maintenance on it, and waits for their protected tasks to finish
+
+// Scheduler monitors the cluster of a workload scheduler, like Mesos or Kubernetes. The instances are
+// identified by their private IP address. Implementations must be safe for concurrent use
+type Scheduler interface {
+	// Refresh updates the cached state of the cluster. If it fails, the previous state is kept
+	Refresh() error
+	// SetProtected replaces what is protected, used after the next refresh. Each scheduler gives it
+	// it's own meaning, like the Mesos frameworks names
+	SetProtected(protected []string)
+	// SetAgentsInMaintenance replaces the agents in maintenance, given as map[hostname]IP address
+	SetAgentsInMaintenance(hosts map[string]string) error
+	// GetAgent returns the agent with the given IP address, and false if it's not found
+	GetAgent(ipAddress string) (Agent, bool)
+	// GetTasks returns all the tasks running in the agent with the given IP address
+	GetTasks(ipAddress string) []Task
+	// GetBlockingTasks returns the tasks in the agent that block it's removal, among the given
+	// protected ones, or among all of them if nil
+	GetBlockingTasks(ipAddress string, protected []string) []Task
+}
+
+// Agent is the node of the scheduler running on an instance
+type Agent struct {
+	ID       string
+	Hostname string
+}
+
+// Task is a workload running on an agent. The owner is what the task belongs to, like a Mesos framework
+// or a Kubernetes namespace
+type Task struct {
+	Name      string
+	OwnerID   string
+	OwnerName string
+	Protected bool
+}
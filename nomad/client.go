@@ -0,0 +1,184 @@
+package nomad
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DrainMetaKey is set in the metadata of the drains started by deathnode, so only those are cancelled
+const DrainMetaKey = "deathnode"
+
+// Statuses of the last drain of a node
+const (
+	DrainStatusDraining = "draining"
+	DrainStatusComplete = "complete"
+	DrainStatusCanceled = "canceled"
+)
+
+// ClientInterface is an interface for nomad api clients
+type ClientInterface interface {
+	GetNodes() ([]Node, error)
+	GetAllocations() ([]Allocation, error)
+	DrainNode(nodeID string, deadline time.Duration) error
+	CancelDrain(nodeID string) error
+}
+
+// Client implements a client for the nomad api, authenticated with an ACL token if it's not empty
+type Client struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// Node is part of the nomad nodes list response
+type Node struct {
+	ID                    string         `json:"ID"`
+	Name                  string         `json:"Name"`
+	Address               string         `json:"Address"`
+	Status                string         `json:"Status"`
+	Drain                 bool           `json:"Drain"`
+	SchedulingEligibility string         `json:"SchedulingEligibility"`
+	LastDrain             *DrainMetadata `json:"LastDrain"`
+}
+
+// DrainMetadata is part of the nomad nodes list response
+type DrainMetadata struct {
+	Status string            `json:"Status"`
+	Meta   map[string]string `json:"Meta"`
+}
+
+// Allocation is part of the nomad allocations list response
+type Allocation struct {
+	ID           string `json:"ID"`
+	Name         string `json:"Name"`
+	Namespace    string `json:"Namespace"`
+	NodeID       string `json:"NodeID"`
+	JobID        string `json:"JobID"`
+	JobType      string `json:"JobType"`
+	ClientStatus string `json:"ClientStatus"`
+}
+
+// DrainRequest is the payload of the node drain API call
+type DrainRequest struct {
+	DrainSpec    *DrainSpec        `json:"DrainSpec"`
+	MarkEligible bool              `json:"MarkEligible"`
+	Meta         map[string]string `json:"Meta,omitempty"`
+}
+
+// DrainSpec is part of the payload of the node drain API call
+type DrainSpec struct {
+	Deadline         int64 `json:"Deadline"`
+	IgnoreSystemJobs bool  `json:"IgnoreSystemJobs"`
+}
+
+// IsDrainedByDeathnode returns true if the last drain of the node was started by deathnode, and it's
+// not cancelled
+func (n *Node) IsDrainedByDeathnode() bool {
+
+	return n.LastDrain != nil && n.LastDrain.Meta[DrainMetaKey] == "true" && n.LastDrain.Status != DrainStatusCanceled
+}
+
+// IsDrainComplete returns true if the last drain of the node finished
+func (n *Node) IsDrainComplete() bool {
+	return !n.Drain && n.LastDrain != nil && n.LastDrain.Status == DrainStatusComplete
+}
+
+// IsActive returns true if the allocation is pending or running
+func (a *Allocation) IsActive() bool {
+	return a.ClientStatus == "pending" || a.ClientStatus == "running"
+}
+
+// NewClient returns a Client for the nomad api at apiURL
+func NewClient(apiURL, token string) *Client {
+
+	return &Client{
+		url:   strings.TrimSuffix(apiURL, "/"),
+		token: token,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// GetNodes returns the client nodes of the nomad cluster
+func (c *Client) GetNodes() ([]Node, error) {
+
+	nodes := []Node{}
+	err := c.apiCall("GET", "/v1/nodes", nil, &nodes)
+	return nodes, err
+}
+
+// GetAllocations returns the allocations of all the namespaces
+func (c *Client) GetAllocations() ([]Allocation, error) {
+
+	allocations := []Allocation{}
+	err := c.apiCall("GET", "/v1/allocations?namespace="+url.QueryEscape("*"), nil, &allocations)
+	return allocations, err
+}
+
+// DrainNode enables the drain of the node, marking it as started by deathnode. The allocations of
+// system jobs are kept, as they would be placed again in the node. Allocations not migrated before the
+// deadline are stopped. If the deadline is 0, there is no deadline
+func (c *Client) DrainNode(nodeID string, deadline time.Duration) error {
+
+	drainRequest := &DrainRequest{
+		DrainSpec: &DrainSpec{
+			Deadline:         int64(deadline),
+			IgnoreSystemJobs: true,
+		},
+		Meta: map[string]string{DrainMetaKey: "true"},
+	}
+	return c.apiCall("POST", "/v1/node/"+url.PathEscape(nodeID)+"/drain", drainRequest, nil)
+}
+
+// CancelDrain disables the drain of the node, making it eligible for scheduling again
+func (c *Client) CancelDrain(nodeID string) error {
+
+	drainRequest := &DrainRequest{
+		MarkEligible: true,
+	}
+	return c.apiCall("POST", "/v1/node/"+url.PathEscape(nodeID)+"/drain", drainRequest, nil)
+}
+
+// apiCall sends the payload, if any, encoded as JSON, and decodes the response, if any
+func (c *Client) apiCall(method, path string, payload, response interface{}) error {
+
+	body := &bytes.Buffer{}
+	if payload != nil {
+		if err := json.NewEncoder(body).Encode(payload); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.url+path, body)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("X-Nomad-Token", c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("nomad API returned %s for %s %s", resp.Status, method, path)
+	}
+	if response != nil {
+		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+			return fmt.Errorf("unable to decode nomad API response: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package nomad
+
+import (
+	"time"
+
+	"github.com/alanbover/deathnode/dryrun"
+)
+
+const dryRunService = "nomad"
+
+// DryRunClient decorates a ClientInterface, executing the read only calls and recording the mutating
+// ones in a dry run plan instead of executing them
+type DryRunClient struct {
+	client ClientInterface
+	plan   *dryrun.Plan
+}
+
+// NewDryRunClient returns a new DryRunClient
+func NewDryRunClient(client ClientInterface, plan *dryrun.Plan) *DryRunClient {
+	return &DryRunClient{
+		client: client,
+		plan:   plan,
+	}
+}
+
+// GetNodes calls the decorated client
+func (c *DryRunClient) GetNodes() ([]Node, error) {
+	return c.client.GetNodes()
+}
+
+// GetAllocations calls the decorated client
+func (c *DryRunClient) GetAllocations() ([]Allocation, error) {
+	return c.client.GetAllocations()
+}
+
+// DrainNode records the call in the dry run plan
+func (c *DryRunClient) DrainNode(nodeID string, deadline time.Duration) error {
+
+	c.plan.Record(dryRunService, "DrainNode", map[string]string{"node": nodeID, "deadline": deadline.String()})
+	return nil
+}
+
+// CancelDrain records the call in the dry run plan
+func (c *DryRunClient) CancelDrain(nodeID string) error {
+
+	c.plan.Record(dryRunService, "CancelDrain", map[string]string{"node": nodeID})
+	return nil
+}
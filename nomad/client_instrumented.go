@@ -0,0 +1,53 @@
+package nomad
+
+import (
+	"time"
+
+	"github.com/alanbover/deathnode/metrics"
+)
+
+const metricsService = "nomad"
+
+// InstrumentedClient decorates a ClientInterface, recording the latency and errors of every call
+type InstrumentedClient struct {
+	client ClientInterface
+}
+
+// NewInstrumentedClient returns a new InstrumentedClient
+func NewInstrumentedClient(client ClientInterface) *InstrumentedClient {
+	return &InstrumentedClient{
+		client: client,
+	}
+}
+
+// GetNodes calls the decorated client, recording metrics
+func (c *InstrumentedClient) GetNodes() ([]Node, error) {
+	start := time.Now()
+	nodes, err := c.client.GetNodes()
+	metrics.ObserveAPIRequest(metricsService, "GetNodes", start, err)
+	return nodes, err
+}
+
+// GetAllocations calls the decorated client, recording metrics
+func (c *InstrumentedClient) GetAllocations() ([]Allocation, error) {
+	start := time.Now()
+	allocations, err := c.client.GetAllocations()
+	metrics.ObserveAPIRequest(metricsService, "GetAllocations", start, err)
+	return allocations, err
+}
+
+// DrainNode calls the decorated client, recording metrics
+func (c *InstrumentedClient) DrainNode(nodeID string, deadline time.Duration) error {
+	start := time.Now()
+	err := c.client.DrainNode(nodeID, deadline)
+	metrics.ObserveAPIRequest(metricsService, "DrainNode", start, err)
+	return err
+}
+
+// CancelDrain calls the decorated client, recording metrics
+func (c *InstrumentedClient) CancelDrain(nodeID string) error {
+	start := time.Now()
+	err := c.client.CancelDrain(nodeID)
+	metrics.ObserveAPIRequest(metricsService, "CancelDrain", start, err)
+	return err
+}
@@ -0,0 +1,115 @@
+package nomad
+
+import (
+	"sync"
+	"time"
+)
+
+// ClientMock implements nomad.ClientInterface for testing purposes. Drains enabled are reflected in the
+// nodes returned, completing at once if the node has no allocations left
+type ClientMock struct {
+	Nodes       []Node
+	Allocations []Allocation
+	Requests    map[string][]string
+	Errors      map[string]error
+	mutex       sync.Mutex
+}
+
+// GetNodes mocked for testing purposes
+func (c *ClientMock) GetNodes() ([]Node, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err, ok := c.Errors["GetNodes"]; ok {
+		return nil, err
+	}
+	return append([]Node{}, c.Nodes...), nil
+}
+
+// GetAllocations mocked for testing purposes
+func (c *ClientMock) GetAllocations() ([]Allocation, error) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err, ok := c.Errors["GetAllocations"]; ok {
+		return nil, err
+	}
+	return append([]Allocation{}, c.Allocations...), nil
+}
+
+// DrainNode mocked for testing purposes
+func (c *ClientMock) DrainNode(nodeID string, deadline time.Duration) error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.record("DrainNode", nodeID); err != nil {
+		return err
+	}
+
+	for i := range c.Nodes {
+		if c.Nodes[i].ID == nodeID {
+			c.Nodes[i].Drain = true
+			c.Nodes[i].SchedulingEligibility = "ineligible"
+			c.Nodes[i].LastDrain = &DrainMetadata{
+				Status: DrainStatusDraining,
+				Meta:   map[string]string{DrainMetaKey: "true"},
+			}
+		}
+	}
+	return nil
+}
+
+// CancelDrain mocked for testing purposes
+func (c *ClientMock) CancelDrain(nodeID string) error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.record("CancelDrain", nodeID); err != nil {
+		return err
+	}
+
+	for i := range c.Nodes {
+		if c.Nodes[i].ID == nodeID {
+			c.Nodes[i].Drain = false
+			c.Nodes[i].SchedulingEligibility = "eligible"
+			if c.Nodes[i].LastDrain != nil {
+				c.Nodes[i].LastDrain.Status = DrainStatusCanceled
+			}
+		}
+	}
+	return nil
+}
+
+// CompleteDrains finishes the drains in progress, stopping the allocations of the nodes except the ones
+// of system jobs
+func (c *ClientMock) CompleteDrains() {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i := range c.Nodes {
+		node := &c.Nodes[i]
+		if !node.Drain {
+			continue
+		}
+		node.Drain = false
+		node.LastDrain.Status = DrainStatusComplete
+		for j := range c.Allocations {
+			if c.Allocations[j].NodeID == node.ID && c.Allocations[j].JobType != "system" {
+				c.Allocations[j].ClientStatus = "complete"
+			}
+		}
+	}
+}
+
+func (c *ClientMock) record(operation, nodeID string) error {
+
+	if err, ok := c.Errors[operation]; ok {
+		return err
+	}
+	if c.Requests == nil {
+		c.Requests = map[string][]string{}
+	}
+	c.Requests[operation] = append(c.Requests[operation], nodeID)
+	return nil
+}
@@ -0,0 +1,57 @@
+package nomad
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClient(t *testing.T) {
+
+	Convey("When using a nomad client against an api server", t, func() {
+		var request *http.Request
+		var drainRequest DrainRequest
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request = r
+			switch r.URL.Path {
+			case "/v1/nodes":
+				w.Write([]byte(`[{"ID": "node1", "Address": "10.0.0.2", "Drain": false,
+					"LastDrain": {"Status": "complete", "Meta": {"deathnode": "true"}}}]`))
+			case "/v1/node/node1/drain":
+				json.NewDecoder(r.Body).Decode(&drainRequest)
+				w.Write([]byte(`{}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+		client := NewClient(server.URL+"/", "secret")
+
+		Convey("nodes should be returned with their last drain, sending the token", func() {
+			nodes, err := client.GetNodes()
+			So(err, ShouldBeNil)
+			So(request.Header.Get("X-Nomad-Token"), ShouldEqual, "secret")
+			So(nodes[0].IsDrainedByDeathnode(), ShouldBeTrue)
+			So(nodes[0].IsDrainComplete(), ShouldBeTrue)
+		})
+		Convey("draining a node should set the deadline and the deathnode metadata", func() {
+			So(client.DrainNode("node1", time.Hour), ShouldBeNil)
+			So(drainRequest.DrainSpec.Deadline, ShouldEqual, int64(time.Hour))
+			So(drainRequest.DrainSpec.IgnoreSystemJobs, ShouldBeTrue)
+			So(drainRequest.Meta[DrainMetaKey], ShouldEqual, "true")
+		})
+		Convey("cancelling a drain should make the node eligible again", func() {
+			So(client.CancelDrain("node1"), ShouldBeNil)
+			So(drainRequest.DrainSpec, ShouldBeNil)
+			So(drainRequest.MarkEligible, ShouldBeTrue)
+		})
+		Convey("API errors should be returned", func() {
+			_, err := client.GetAllocations()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
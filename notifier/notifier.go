@@ -0,0 +1,118 @@
+package notifier
+
+// Notifies the lifecycle events of the drains, so operators and other automation know when they start,
+// progress, get stuck or finish
+
+import (
+	"fmt"
+	"time"
+)
+
+// Events notified
+const (
+	// EventDrainStarted is sent when an instance is marked to be removed
+	EventDrainStarted = "drainStarted"
+	// EventDrainStuck is sent once per drain state, when the drain stays in it longer than the threshold
+	EventDrainStuck = "drainStuck"
+	// EventDrainFailed is sent when a step of the drain fails too many times
+	EventDrainFailed = "drainFailed"
+	// EventInstanceTerminated is sent when a drained instance is gone
+	EventInstanceTerminated = "instanceTerminated"
+	// EventMaintenanceScheduled is sent when the Mesos agent of the instance is set in maintenance
+	EventMaintenanceScheduled = "maintenanceScheduled"
+	// EventDrainTimeout is sent when the drain timeout expires with protected tasks still running
+	EventDrainTimeout = "drainTimeout"
+	// EventDrainComplete is sent when the instance is drained, and it's lifecycle action can be completed
+	EventDrainComplete = "drainComplete"
+	// EventLifecycleCompleted is sent when the lifecycle action is completed, so AWS terminates the instance
+	EventLifecycleCompleted = "lifecycleCompleted"
+)
+
+// Event is a change in the drain of an instance. Since is the time the drain entered it's current state,
+// and is zero if it's unknown. Agent is nil if the Mesos agent of the instance is not known
+type Event struct {
+	Type             string    `json:"type"`
+	Time             time.Time `json:"time"`
+	InstanceID       string    `json:"instanceId"`
+	AutoscalingGroup string    `json:"autoscalingGroup"`
+	RequestedBy      string    `json:"requestedBy,omitempty"`
+	DrainState       string    `json:"drainState"`
+	Since            time.Time `json:"since,omitempty"`
+	Error            string    `json:"error,omitempty"`
+	Agent            *Agent    `json:"agent,omitempty"`
+}
+
+// Agent is the Mesos agent running on the instance
+type Agent struct {
+	ID       string `json:"id"`
+	Hostname string `json:"hostname"`
+	IP       string `json:"ip"`
+}
+
+// Notifier sends events somewhere. Notify must not block the caller
+type Notifier interface {
+	Notify(event *Event)
+}
+
+// Message returns a human readable description of the event
+func (e *Event) Message() string {
+
+	switch e.Type {
+	case EventDrainStarted:
+		return fmt.Sprintf("Drain of instance %s in %s started, requested by %s", e.InstanceID, e.AutoscalingGroup, e.RequestedBy)
+	case EventDrainStuck:
+		return fmt.Sprintf("Drain of instance %s in %s stuck at %s since %s", e.InstanceID, e.AutoscalingGroup, e.DrainState,
+			e.Since.Format(time.RFC3339))
+	case EventDrainFailed:
+		return fmt.Sprintf("Drain of instance %s in %s failed: %s", e.InstanceID, e.AutoscalingGroup, e.Error)
+	case EventInstanceTerminated:
+		return fmt.Sprintf("Instance %s in %s terminated", e.InstanceID, e.AutoscalingGroup)
+	case EventMaintenanceScheduled:
+		return fmt.Sprintf("Mesos agent of instance %s in %s set in maintenance", e.InstanceID, e.AutoscalingGroup)
+	case EventDrainTimeout:
+		return fmt.Sprintf("Drain of instance %s in %s timed out with protected tasks running", e.InstanceID, e.AutoscalingGroup)
+	case EventDrainComplete:
+		return fmt.Sprintf("Drain of instance %s in %s completed", e.InstanceID, e.AutoscalingGroup)
+	case EventLifecycleCompleted:
+		return fmt.Sprintf("Lifecycle action of instance %s in %s completed", e.InstanceID, e.AutoscalingGroup)
+	}
+	return fmt.Sprintf("Drain of instance %s in %s: %s", e.InstanceID, e.AutoscalingGroup, e.Type)
+}
+
+// Multi notifies the events to all it's notifiers
+type Multi []Notifier
+
+// Notify notifies the event to every notifier
+func (m Multi) Notify(event *Event) {
+
+	for _, notifier := range m {
+		notifier.Notify(event)
+	}
+}
+
+// Filter notifies only some types of events
+type Filter struct {
+	notifier   Notifier
+	eventTypes map[string]bool
+}
+
+// NewFilter returns a Filter notifying the events of eventTypes to notifier
+func NewFilter(notifier Notifier, eventTypes ...string) *Filter {
+
+	filter := &Filter{
+		notifier:   notifier,
+		eventTypes: map[string]bool{},
+	}
+	for _, eventType := range eventTypes {
+		filter.eventTypes[eventType] = true
+	}
+	return filter
+}
+
+// Notify notifies the event if it's type is accepted
+func (f *Filter) Notify(event *Event) {
+
+	if f.eventTypes[event.Type] {
+		f.notifier.Notify(event)
+	}
+}
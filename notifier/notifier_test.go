@@ -0,0 +1,55 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type recorder struct {
+	events []*Event
+}
+
+func (r *recorder) Notify(event *Event) {
+	r.events = append(r.events, event)
+}
+
+func TestFilter(t *testing.T) {
+
+	Convey("When notifying events to several filtered notifiers", t, func() {
+		started, all := &recorder{}, &recorder{}
+		notifier := Multi{NewFilter(started, EventDrainStarted), all}
+		notifier.Notify(&Event{Type: EventDrainStarted})
+		notifier.Notify(&Event{Type: EventDrainComplete})
+
+		Convey("every notifier should get only the event types accepted", func() {
+			So(len(started.events), ShouldEqual, 1)
+			So(started.events[0].Type, ShouldEqual, EventDrainStarted)
+			So(len(all.events), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestQueue(t *testing.T) {
+
+	Convey("When notifying an event through a queue, it should be sent by Run", t, func() {
+		receiver, server := newReceiver(http.StatusOK)
+		defer server.Close()
+		webhook, _ := NewWebhook(server.URL, "", "", time.Second, 0)
+		queue := NewQueue("webhook", webhook)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go queue.Run(ctx)
+
+		queue.Notify(testEvent())
+		select {
+		case <-receiver.done:
+		case <-time.After(5 * time.Second):
+		}
+		requests, _, _ := receiver.received()
+		So(requests, ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,63 @@
+package notifier
+
+import (
+	"context"
+
+	"github.com/alanbover/deathnode/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const queueSize = 100
+
+var notificationsCounter = metrics.NewCounter("deathnode_notifications_total",
+	"Number of notifications sent, failed or dropped", "notifier", "event", "result")
+
+// Sender delivers an event, returning an error if it couldn't be delivered
+type Sender interface {
+	Send(ctx context.Context, event *Event) error
+}
+
+// Queue notifies the events to a Sender in background, so notifying never blocks the deathnode checks
+type Queue struct {
+	name   string
+	sender Sender
+	events chan *Event
+}
+
+// NewQueue returns a Queue for the sender. The name is used in the logs and metrics
+func NewQueue(name string, sender Sender) *Queue {
+	return &Queue{
+		name:   name,
+		sender: sender,
+		events: make(chan *Event, queueSize),
+	}
+}
+
+// Notify queues the event. If the queue is full, as the sender is failing, the event is dropped
+func (q *Queue) Notify(event *Event) {
+
+	select {
+	case q.events <- event:
+	default:
+		log.Errorf("%s notification queue full. Dropping %s event of instance %s", q.name, event.Type, event.InstanceID)
+		notificationsCounter.Inc(q.name, event.Type, "dropped")
+	}
+}
+
+// Run sends the queued events until the context is cancelled
+func (q *Queue) Run(ctx context.Context) {
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-q.events:
+			if err := q.sender.Send(ctx, event); err != nil {
+				log.Errorf("Unable to notify %s event of instance %s to %s: %v", event.Type, event.InstanceID, q.name, err)
+				notificationsCounter.Inc(q.name, event.Type, "failed")
+				continue
+			}
+			notificationsCounter.Inc(q.name, event.Type, "sent")
+		}
+	}
+}
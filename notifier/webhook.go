@@ -0,0 +1,136 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultTemplate posts the event as JSON
+const DefaultTemplate = "{{json .}}"
+
+// SignatureHeader carries the hex encoded HMAC-SHA256 of the body, signed with the webhook secret
+const SignatureHeader = "X-Deathnode-Signature"
+
+// Webhook posts the events to an URL, with a body rendered from a text/template. The templates can use
+// the json function to encode values, and the Message method of the event for a human readable text,
+// like {"text": {{json .Message}}}. It's used behind a Queue, so notifying never blocks the deathnode
+// checks
+type Webhook struct {
+	url           string
+	secret        []byte
+	template      *template.Template
+	client        *http.Client
+	retries       int
+	retryInterval time.Duration
+}
+
+// NewWebhook returns a Webhook posting the events to url. Requests taking longer than timeout fail, and
+// failed requests are retried up to retries times, doubling the wait between them. If secret is not
+// empty, the body is signed with it
+func NewWebhook(url, bodyTemplate, secret string, timeout time.Duration, retries int) (*Webhook, error) {
+
+	if bodyTemplate == "" {
+		bodyTemplate = DefaultTemplate
+	}
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{"json": toJSON}).Parse(bodyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook template: %v", err)
+	}
+
+	return &Webhook{
+		url:      url,
+		secret:   []byte(secret),
+		template: tmpl,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+		retries:       retries,
+		retryInterval: time.Second,
+	}, nil
+}
+
+// Send posts the event, retrying while the webhook fails with a network error, a 5xx or a 429 status
+func (w *Webhook) Send(ctx context.Context, event *Event) error {
+
+	body, err := w.render(event)
+	if err != nil {
+		return err
+	}
+
+	wait := w.retryInterval
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(body, event.Type)
+		if err == nil || !retry || attempt >= w.retries {
+			return err
+		}
+		log.Warnf("Unable to notify %s event of instance %s, retrying in %v: %v", event.Type, event.InstanceID, wait, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (w *Webhook) render(event *Event) ([]byte, error) {
+
+	body := &bytes.Buffer{}
+	if err := w.template.Execute(body, event); err != nil {
+		return nil, fmt.Errorf("unable to render webhook template: %v", err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return nil, fmt.Errorf("webhook template rendered invalid JSON: %s", body.String())
+	}
+	return body.Bytes(), nil
+}
+
+// post returns whether the request should be retried when it fails
+func (w *Webhook) post(body []byte, eventType string) (bool, error) {
+
+	request, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Deathnode-Event", eventType)
+	if len(w.secret) > 0 {
+		request.Header.Set(SignatureHeader, "sha256="+Sign(w.secret, body))
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("notification webhook returned %s", response.Status)
+	}
+	return false, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the body, so receivers can verify the notifications
+func Sign(secret, body []byte) string {
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func toJSON(value interface{}) (string, error) {
+
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
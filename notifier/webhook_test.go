@@ -0,0 +1,142 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type receiver struct {
+	mutex     sync.Mutex
+	statuses  []int
+	requests  int
+	body      []byte
+	signature string
+	done      chan struct{}
+}
+
+func newReceiver(statuses ...int) (*receiver, *httptest.Server) {
+
+	r := &receiver{
+		statuses: statuses,
+		done:     make(chan struct{}, 10),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.body, _ = ioutil.ReadAll(req.Body)
+		r.signature = req.Header.Get(SignatureHeader)
+		status := http.StatusOK
+		if r.requests < len(r.statuses) {
+			status = r.statuses[r.requests]
+		}
+		r.requests++
+		w.WriteHeader(status)
+		r.done <- struct{}{}
+	}))
+	return r, server
+}
+
+func (r *receiver) received() (int, string, string) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.requests, string(r.body), r.signature
+}
+
+func testEvent() *Event {
+	return &Event{
+		Type:             EventDrainStarted,
+		Time:             time.Now(),
+		InstanceID:       "i-34719eb8",
+		AutoscalingGroup: "some-Autoscaling-Group",
+		RequestedBy:      "deathnode",
+		DrainState:       "Marked",
+	}
+}
+
+func TestWebhook(t *testing.T) {
+
+	Convey("When sending an event to a webhook", t, func() {
+		receiver, server := newReceiver()
+		defer server.Close()
+
+		Convey("with the default template, the event should be posted as JSON", func() {
+			webhook, err := NewWebhook(server.URL, "", "", time.Second, 0)
+			So(err, ShouldBeNil)
+			So(webhook.Send(context.Background(), testEvent()), ShouldBeNil)
+
+			_, body, signature := receiver.received()
+			event := &Event{}
+			So(json.Unmarshal([]byte(body), event), ShouldBeNil)
+			So(event.Type, ShouldEqual, EventDrainStarted)
+			So(event.InstanceID, ShouldEqual, "i-34719eb8")
+			So(signature, ShouldEqual, "")
+		})
+		Convey("with a template, the body should be rendered from it", func() {
+			webhook, err := NewWebhook(server.URL, `{"text": {{json .Message}}}`, "", time.Second, 0)
+			So(err, ShouldBeNil)
+			So(webhook.Send(context.Background(), testEvent()), ShouldBeNil)
+			_, body, _ := receiver.received()
+			So(body, ShouldEqual,
+				`{"text": "Drain of instance i-34719eb8 in some-Autoscaling-Group started, requested by deathnode"}`)
+		})
+		Convey("with a secret, the body should be signed", func() {
+			webhook, _ := NewWebhook(server.URL, "", "secret", time.Second, 0)
+			So(webhook.Send(context.Background(), testEvent()), ShouldBeNil)
+			_, body, signature := receiver.received()
+			So(signature, ShouldEqual, "sha256="+Sign([]byte("secret"), []byte(body)))
+		})
+		Convey("with a template rendering invalid JSON, it should fail without posting", func() {
+			webhook, _ := NewWebhook(server.URL, `{"text": {{.Message}}}`, "", time.Second, 0)
+			So(webhook.Send(context.Background(), testEvent()), ShouldNotBeNil)
+			requests, _, _ := receiver.received()
+			So(requests, ShouldEqual, 0)
+		})
+	})
+
+	Convey("When creating a webhook with an invalid template, it should fail", t, func() {
+		_, err := NewWebhook("http://localhost", "{{json .", "", time.Second, 0)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("When the webhook fails", t, func() {
+		Convey("with a server error, it should be retried", func() {
+			receiver, server := newReceiver(http.StatusInternalServerError, http.StatusBadGateway)
+			defer server.Close()
+			webhook, _ := NewWebhook(server.URL, "", "", time.Second, 3)
+			webhook.retryInterval = time.Millisecond
+
+			So(webhook.Send(context.Background(), testEvent()), ShouldBeNil)
+			requests, _, _ := receiver.received()
+			So(requests, ShouldEqual, 3)
+		})
+		Convey("with a server error more times than retries, it should fail", func() {
+			receiver, server := newReceiver(http.StatusInternalServerError, http.StatusInternalServerError)
+			defer server.Close()
+			webhook, _ := NewWebhook(server.URL, "", "", time.Second, 1)
+			webhook.retryInterval = time.Millisecond
+
+			So(webhook.Send(context.Background(), testEvent()), ShouldNotBeNil)
+			requests, _, _ := receiver.received()
+			So(requests, ShouldEqual, 2)
+		})
+		Convey("with a client error, it should not be retried", func() {
+			receiver, server := newReceiver(http.StatusBadRequest)
+			defer server.Close()
+			webhook, _ := NewWebhook(server.URL, "", "", time.Second, 3)
+			webhook.retryInterval = time.Millisecond
+
+			So(webhook.Send(context.Background(), testEvent()), ShouldNotBeNil)
+			requests, _, _ := receiver.received()
+			So(requests, ShouldEqual, 1)
+		})
+	})
+}
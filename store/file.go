@@ -0,0 +1,60 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileStore saves the State as a JSON file. The file is replaced atomically, so it's never left
+// half written
+type FileStore struct {
+	path string
+}
+
+// NewFileStore returns a FileStore saving the State in path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		path: path,
+	}
+}
+
+// Load reads the State from the file, returning an empty one if the file doesn't exist
+func (s *FileStore) Load() (*State, error) {
+
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return New(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Unmarshal(content)
+}
+
+// Save writes the State to a temporary file, renaming it to the file path
+func (s *FileStore) Save(state *State) error {
+
+	content, err := Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path)
+}
@@ -0,0 +1,130 @@
+package store
+
+// Persists the state of deathnode, so drains and delays survive restarts and leadership changes
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Who requested an instance to be drained
+const (
+	RequestedByDeathnode       = "deathnode"
+	RequestedByInstanceRefresh = "instanceRefresh"
+	RequestedByOperator        = "operator"
+	// RequestedByUnknown is used for instances found with the deathnode mark but without a drain
+	RequestedByUnknown = "unknown"
+)
+
+// Drain is the state of an instance marked to be removed. State is empty for the drains saved before
+// they were tracked as a state machine. StuckNotified is the state the drain was notified as stuck in
+// transitions: map[state]time the state was entered
+type Drain struct {
+	InstanceID       string               `json:"instanceId"`
+	AutoscalingGroup string               `json:"autoscalingGroup"`
+	MarkTime         time.Time            `json:"markTime"`
+	RequestedBy      string               `json:"requestedBy"`
+	State            string               `json:"state,omitempty"`
+	Transitions      map[string]time.Time `json:"transitions,omitempty"`
+	Attempts         int                  `json:"attempts,omitempty"`
+	LastError        string               `json:"lastError,omitempty"`
+	FailedStep       string               `json:"failedStep,omitempty"`
+	RetryAt          *time.Time           `json:"retryAt,omitempty"`
+	Heartbeats       int                  `json:"heartbeats,omitempty"`
+	LastHeartbeat    *time.Time           `json:"lastHeartbeat,omitempty"`
+	StuckNotified    string               `json:"stuckNotified,omitempty"`
+}
+
+// State is the state of deathnode persisted in a Store
+// drains: map[instanceID]*Drain
+// lastDeleteTimestamps: map[policyName]time.Time
+type State struct {
+	Drains               map[string]*Drain    `json:"drains"`
+	LastDeleteTimestamps map[string]time.Time `json:"lastDeleteTimestamps"`
+}
+
+// New returns an empty State
+func New() *State {
+	return &State{
+		Drains:               map[string]*Drain{},
+		LastDeleteTimestamps: map[string]time.Time{},
+	}
+}
+
+// Marshal returns the State encoded as JSON
+func Marshal(state *State) ([]byte, error) {
+	return json.Marshal(state)
+}
+
+// Unmarshal returns the State encoded as JSON in content
+func Unmarshal(content []byte) (*State, error) {
+
+	state := New()
+	err := json.Unmarshal(content, state)
+	if err != nil {
+		return nil, err
+	}
+	if state.Drains == nil {
+		state.Drains = map[string]*Drain{}
+	}
+	if state.LastDeleteTimestamps == nil {
+		state.LastDeleteTimestamps = map[string]time.Time{}
+	}
+	return state, nil
+}
+
+// Store persists the State. Only the leader replica saves it
+type Store interface {
+	// Load returns the saved State, or an empty one if it was never saved
+	Load() (*State, error)
+	// Save replaces the saved State
+	Save(state *State) error
+}
+
+// ErrLeadershipLost is returned when saving the State of a replica that lost the leadership, as it was
+// already saved by a newer leader
+var ErrLeadershipLost = errors.New("leadership lost: the state was saved by a newer leader")
+
+// FencingTokenSource returns the fencing token of the leadership lease held by the replica, or 0 if it's
+// not held. The token grows with every new leader
+type FencingTokenSource interface {
+	FencingToken() int64
+}
+
+// MemoryStore keeps the State in memory, so it's lost on restarts. It's safe for concurrent use
+type MemoryStore struct {
+	mutex   sync.Mutex
+	content []byte
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Load returns a copy of the saved State
+func (s *MemoryStore) Load() (*State, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.content == nil {
+		return New(), nil
+	}
+	return Unmarshal(s.content)
+}
+
+// Save stores a copy of the State
+func (s *MemoryStore) Save(state *State) error {
+
+	content, err := Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.content = content
+	return nil
+}
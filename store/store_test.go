@@ -0,0 +1,74 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStores(t *testing.T) {
+
+	dir, _ := ioutil.TempDir("", "deathnode")
+	defer os.RemoveAll(dir)
+
+	stores := map[string]Store{
+		"memory": NewMemoryStore(),
+		"file":   NewFileStore(filepath.Join(dir, "state.json")),
+	}
+
+	for name, store := range stores {
+		Convey("When using a "+name+" store", t, func() {
+
+			Convey("an empty state should be loaded if it was never saved", func() {
+				state, err := store.Load()
+				So(err, ShouldBeNil)
+				So(state.Drains, ShouldBeEmpty)
+				So(state.LastDeleteTimestamps, ShouldBeEmpty)
+			})
+			Convey("the saved state should be loaded", func() {
+				markTime := time.Unix(1500000000, 0)
+				state := New()
+				state.Drains["i-34719eb8"] = &Drain{
+					InstanceID:       "i-34719eb8",
+					AutoscalingGroup: "some-Autoscaling-Group",
+					MarkTime:         markTime,
+					RequestedBy:      RequestedByOperator,
+				}
+				state.LastDeleteTimestamps["default"] = markTime
+				So(store.Save(state), ShouldBeNil)
+
+				loadedState, err := store.Load()
+				So(err, ShouldBeNil)
+				So(loadedState.Drains["i-34719eb8"].RequestedBy, ShouldEqual, RequestedByOperator)
+				So(loadedState.Drains["i-34719eb8"].MarkTime.Equal(markTime), ShouldBeTrue)
+				So(loadedState.LastDeleteTimestamps["default"].Equal(markTime), ShouldBeTrue)
+
+				Convey("and changes to the loaded state should not be saved until Save is called", func() {
+					delete(loadedState.Drains, "i-34719eb8")
+					reloadedState, _ := store.Load()
+					So(reloadedState.Drains, ShouldContainKey, "i-34719eb8")
+				})
+			})
+		})
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+
+	Convey("When unmarshalling a state without drains", t, func() {
+		state, err := Unmarshal([]byte(`{}`))
+		So(err, ShouldBeNil)
+		Convey("it should be possible to add drains", func() {
+			So(state.Drains, ShouldNotBeNil)
+			So(state.LastDeleteTimestamps, ShouldNotBeNil)
+		})
+	})
+	Convey("When unmarshalling an invalid state", t, func() {
+		_, err := Unmarshal([]byte(`{`))
+		So(err, ShouldNotBeNil)
+	})
+}